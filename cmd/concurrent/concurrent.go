@@ -447,7 +447,7 @@ func setActionVerdict(key int, chain ...string) *nftableslib.RuleAction {
 	ra, err := nftableslib.SetVerdict(key, chain...)
 	if err != nil {
 		fmt.Printf("failed to SetVerdict with error: %+v\n", err)
-		return nil
+		os.Exit(1)
 	}
 	return ra
 }
@@ -0,0 +1,533 @@
+package mock
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+// FakeConn implements nftableslib.NetNS keeping tables, chains, rules, sets and objects in memory, it allows
+// to unit test rule construction without root privileges or a kernel, example:
+// conn := mock.NewFakeConn(); ti := nftableslib.InitNFTables(conn)
+// Like the kernel, changes are queued and applied as a single batch by Flush, rules get handles when the batch
+// is applied and a batch failing to apply leaves the state unchanged. Rules returned by GetRule carry the
+// expressions as they were built by the library, they are not round tripped through netlink.
+type FakeConn struct {
+	sync.Mutex
+	state   *fakeState
+	pending []fakeOp
+	ops     []string
+	flushes int
+}
+
+type fakeOp struct {
+	desc  string
+	apply func(*fakeState) error
+}
+
+type fakeSet struct {
+	set      *nftables.Set
+	elements []nftables.SetElement
+}
+
+type fakeState struct {
+	handle uint64
+	tables []*nftables.Table
+	chains []*nftables.Chain
+	rules  map[string][]*nftables.Rule
+	sets   []*fakeSet
+	objs   []nftables.Obj
+}
+
+// NewFakeConn returns an empty in-memory connection
+func NewFakeConn() *FakeConn {
+	return &FakeConn{
+		state: &fakeState{
+			rules: map[string][]*nftables.Rule{},
+		},
+	}
+}
+
+func tableKey(t *nftables.Table) string {
+	return fmt.Sprintf("%d:%s", t.Family, t.Name)
+}
+
+func chainKey(t *nftables.Table, chain string) string {
+	return tableKey(t) + ":" + chain
+}
+
+func sameTable(a, b *nftables.Table) bool {
+	return a != nil && b != nil && a.Name == b.Name && a.Family == b.Family
+}
+
+func (s *fakeState) clone() *fakeState {
+	n := &fakeState{
+		handle: s.handle,
+		tables: append([]*nftables.Table{}, s.tables...),
+		chains: append([]*nftables.Chain{}, s.chains...),
+		rules:  make(map[string][]*nftables.Rule, len(s.rules)),
+		sets:   make([]*fakeSet, len(s.sets)),
+		objs:   append([]nftables.Obj{}, s.objs...),
+	}
+	for k, r := range s.rules {
+		n.rules[k] = append([]*nftables.Rule{}, r...)
+	}
+	for i, set := range s.sets {
+		n.sets[i] = &fakeSet{set: set.set, elements: append([]nftables.SetElement{}, set.elements...)}
+	}
+
+	return n
+}
+
+func (s *fakeState) table(t *nftables.Table) int {
+	for i, tbl := range s.tables {
+		if sameTable(tbl, t) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *fakeState) chain(c *nftables.Chain) int {
+	for i, ch := range s.chains {
+		if ch.Name == c.Name && sameTable(ch.Table, c.Table) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *fakeState) set(t *nftables.Table, name string) int {
+	for i, set := range s.sets {
+		if set.set.Name == name && sameTable(set.set.Table, t) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *fakeState) rule(key string, handle uint64) int {
+	for i, r := range s.rules[key] {
+		if r.Handle == handle {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *fakeState) delTable(t *nftables.Table) {
+	chains := s.chains[:0]
+	for _, ch := range s.chains {
+		if sameTable(ch.Table, t) {
+			delete(s.rules, chainKey(t, ch.Name))
+			continue
+		}
+		chains = append(chains, ch)
+	}
+	s.chains = chains
+	sets := s.sets[:0]
+	for _, set := range s.sets {
+		if !sameTable(set.set.Table, t) {
+			sets = append(sets, set)
+		}
+	}
+	s.sets = sets
+	objs := s.objs[:0]
+	for _, o := range s.objs {
+		if c, ok := o.(*nftables.CounterObj); !ok || !sameTable(c.Table, t) {
+			objs = append(objs, o)
+		}
+	}
+	s.objs = objs
+	if i := s.table(t); i != -1 {
+		s.tables = append(s.tables[:i], s.tables[i+1:]...)
+	}
+}
+
+// addRule places the rule according to its Handle and Position, insert selects whether Position identifies
+// the rule to insert before or to add after.
+func (s *fakeState) addRule(r *nftables.Rule, insert bool) error {
+	if s.chain(r.Chain) == -1 {
+		return fmt.Errorf("chain %s does not exist in table %s: %w", r.Chain.Name, r.Table.Name, unix.ENOENT)
+	}
+	key := chainKey(r.Table, r.Chain.Name)
+	n := *r
+	if r.Handle != 0 {
+		i := s.rule(key, r.Handle)
+		if i == -1 {
+			return fmt.Errorf("rule with handle %d does not exist: %w", r.Handle, unix.ENOENT)
+		}
+		s.rules[key][i] = &n
+		return nil
+	}
+	s.handle++
+	n.Handle = s.handle
+	rules := s.rules[key]
+	i := len(rules)
+	if insert {
+		i = 0
+	}
+	if r.Position != 0 {
+		if i = s.rule(key, r.Position); i == -1 {
+			return fmt.Errorf("rule with handle %d does not exist: %w", r.Position, unix.ENOENT)
+		}
+		if !insert {
+			i++
+		}
+	}
+	rules = append(rules, nil)
+	copy(rules[i+1:], rules[i:])
+	rules[i] = &n
+	s.rules[key] = rules
+
+	return nil
+}
+
+func (f *FakeConn) queue(desc string, apply func(*fakeState) error) {
+	f.Lock()
+	defer f.Unlock()
+	f.pending = append(f.pending, fakeOp{desc: desc, apply: apply})
+}
+
+// Flush applies queued changes as a single batch
+func (f *FakeConn) Flush() error {
+	f.Lock()
+	defer f.Unlock()
+	f.flushes++
+	pending := f.pending
+	f.pending = nil
+	state := f.state.clone()
+	for _, op := range pending {
+		if err := op.apply(state); err != nil {
+			return fmt.Errorf("%s: %w", op.desc, err)
+		}
+	}
+	f.state = state
+	for _, op := range pending {
+		f.ops = append(f.ops, op.desc)
+	}
+
+	return nil
+}
+
+// Ops returns descriptions of applied operations in the order they were applied, example: "add rule filter input"
+func (f *FakeConn) Ops() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string{}, f.ops...)
+}
+
+// Flushes returns the number of batches sent by Flush, including failed ones
+func (f *FakeConn) Flushes() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.flushes
+}
+
+// FlushRuleset removes all tables
+func (f *FakeConn) FlushRuleset() {
+	f.queue("flush ruleset", func(s *fakeState) error {
+		*s = fakeState{handle: s.handle, rules: map[string][]*nftables.Rule{}}
+		return nil
+	})
+}
+
+// AddTable adds the table, an existing table is kept
+func (f *FakeConn) AddTable(t *nftables.Table) *nftables.Table {
+	f.queue("add table "+t.Name, func(s *fakeState) error {
+		if s.table(t) == -1 {
+			s.tables = append(s.tables, t)
+		}
+		return nil
+	})
+	return t
+}
+
+// DelTable removes the table with its chains, rules, sets and objects
+func (f *FakeConn) DelTable(t *nftables.Table) {
+	f.queue("delete table "+t.Name, func(s *fakeState) error {
+		if s.table(t) == -1 {
+			return fmt.Errorf("table %s does not exist: %w", t.Name, unix.ENOENT)
+		}
+		s.delTable(t)
+		return nil
+	})
+}
+
+// FlushTable removes rules of all chains of the table
+func (f *FakeConn) FlushTable(t *nftables.Table) {
+	f.queue("flush table "+t.Name, func(s *fakeState) error {
+		if s.table(t) == -1 {
+			return fmt.Errorf("table %s does not exist: %w", t.Name, unix.ENOENT)
+		}
+		for _, ch := range s.chains {
+			if sameTable(ch.Table, t) {
+				delete(s.rules, chainKey(t, ch.Name))
+			}
+		}
+		return nil
+	})
+}
+
+// ListTables returns tables
+func (f *FakeConn) ListTables() ([]*nftables.Table, error) {
+	f.Lock()
+	defer f.Unlock()
+	return append([]*nftables.Table{}, f.state.tables...), nil
+}
+
+// AddChain adds the chain, attributes of an existing chain are updated
+func (f *FakeConn) AddChain(c *nftables.Chain) *nftables.Chain {
+	f.queue("add chain "+c.Table.Name+" "+c.Name, func(s *fakeState) error {
+		if s.table(c.Table) == -1 {
+			return fmt.Errorf("table %s does not exist: %w", c.Table.Name, unix.ENOENT)
+		}
+		if i := s.chain(c); i != -1 {
+			s.chains[i] = c
+			return nil
+		}
+		s.chains = append(s.chains, c)
+		return nil
+	})
+	return c
+}
+
+// DelChain removes the chain, a chain with rules cannot be removed
+func (f *FakeConn) DelChain(c *nftables.Chain) {
+	f.queue("delete chain "+c.Table.Name+" "+c.Name, func(s *fakeState) error {
+		i := s.chain(c)
+		if i == -1 {
+			return fmt.Errorf("chain %s does not exist: %w", c.Name, unix.ENOENT)
+		}
+		if len(s.rules[chainKey(c.Table, c.Name)]) != 0 {
+			return fmt.Errorf("chain %s is not empty: %w", c.Name, unix.EBUSY)
+		}
+		delete(s.rules, chainKey(c.Table, c.Name))
+		s.chains = append(s.chains[:i], s.chains[i+1:]...)
+		return nil
+	})
+}
+
+// ListChains returns chains of all tables
+func (f *FakeConn) ListChains() ([]*nftables.Chain, error) {
+	f.Lock()
+	defer f.Unlock()
+	return append([]*nftables.Chain{}, f.state.chains...), nil
+}
+
+// AddRule appends the rule, or adds it after the rule identified by Position, a rule with Handle replaces
+// the existing rule.
+func (f *FakeConn) AddRule(r *nftables.Rule) *nftables.Rule {
+	f.queue("add rule "+r.Table.Name+" "+r.Chain.Name, func(s *fakeState) error {
+		return s.addRule(r, false)
+	})
+	return r
+}
+
+// InsertRule prepends the rule, or inserts it before the rule identified by Position, a rule with Handle
+// replaces the existing rule.
+func (f *FakeConn) InsertRule(r *nftables.Rule) *nftables.Rule {
+	f.queue("insert rule "+r.Table.Name+" "+r.Chain.Name, func(s *fakeState) error {
+		return s.addRule(r, true)
+	})
+	return r
+}
+
+// ReplaceRule replaces the rule identified by Handle
+func (f *FakeConn) ReplaceRule(r *nftables.Rule) *nftables.Rule {
+	f.queue("replace rule "+r.Table.Name+" "+r.Chain.Name, func(s *fakeState) error {
+		if r.Handle == 0 {
+			return fmt.Errorf("rule's handle cannot be 0: %w", unix.EINVAL)
+		}
+		return s.addRule(r, false)
+	})
+	return r
+}
+
+// DelRule removes the rule identified by Handle
+func (f *FakeConn) DelRule(r *nftables.Rule) error {
+	if r.Handle == 0 {
+		return fmt.Errorf("rule's handle cannot be 0")
+	}
+	f.queue("delete rule "+r.Table.Name+" "+r.Chain.Name, func(s *fakeState) error {
+		key := chainKey(r.Table, r.Chain.Name)
+		i := s.rule(key, r.Handle)
+		if i == -1 {
+			return fmt.Errorf("rule with handle %d does not exist: %w", r.Handle, unix.ENOENT)
+		}
+		s.rules[key] = append(s.rules[key][:i], s.rules[key][i+1:]...)
+		return nil
+	})
+	return nil
+}
+
+// GetRule returns copies of the chain's rules
+func (f *FakeConn) GetRule(t *nftables.Table, c *nftables.Chain) ([]*nftables.Rule, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.state.chain(&nftables.Chain{Name: c.Name, Table: t}) == -1 {
+		return nil, fmt.Errorf("chain %s does not exist: %w", c.Name, unix.ENOENT)
+	}
+	rules := []*nftables.Rule{}
+	for _, r := range f.state.rules[chainKey(t, c.Name)] {
+		n := *r
+		n.Table = t
+		rules = append(rules, &n)
+	}
+	return rules, nil
+}
+
+// AddSet adds the set with its elements
+func (f *FakeConn) AddSet(set *nftables.Set, elements []nftables.SetElement) error {
+	if set.Table == nil {
+		return fmt.Errorf("set %s does not have a table", set.Name)
+	}
+	f.queue("add set "+set.Table.Name+" "+set.Name, func(s *fakeState) error {
+		if s.table(set.Table) == -1 {
+			return fmt.Errorf("table %s does not exist: %w", set.Table.Name, unix.ENOENT)
+		}
+		if i := s.set(set.Table, set.Name); i != -1 {
+			s.sets[i].elements = append(s.sets[i].elements, elements...)
+			return nil
+		}
+		s.sets = append(s.sets, &fakeSet{set: set, elements: append([]nftables.SetElement{}, elements...)})
+		return nil
+	})
+	return nil
+}
+
+// DelSet removes the set
+func (f *FakeConn) DelSet(set *nftables.Set) {
+	f.queue("delete set "+set.Table.Name+" "+set.Name, func(s *fakeState) error {
+		i := s.set(set.Table, set.Name)
+		if i == -1 {
+			return fmt.Errorf("set %s does not exist: %w", set.Name, unix.ENOENT)
+		}
+		s.sets = append(s.sets[:i], s.sets[i+1:]...)
+		return nil
+	})
+}
+
+// GetSets returns sets of the table
+func (f *FakeConn) GetSets(t *nftables.Table) ([]*nftables.Set, error) {
+	f.Lock()
+	defer f.Unlock()
+	sets := []*nftables.Set{}
+	for _, set := range f.state.sets {
+		if sameTable(set.set.Table, t) {
+			sets = append(sets, set.set)
+		}
+	}
+	return sets, nil
+}
+
+// GetSetByName returns the set of the table
+func (f *FakeConn) GetSetByName(t *nftables.Table, name string) (*nftables.Set, error) {
+	f.Lock()
+	defer f.Unlock()
+	i := f.state.set(t, name)
+	if i == -1 {
+		return nil, fmt.Errorf("set %s does not exist: %w", name, unix.ENOENT)
+	}
+	return f.state.sets[i].set, nil
+}
+
+// GetSetElements returns elements of the set
+func (f *FakeConn) GetSetElements(set *nftables.Set) ([]nftables.SetElement, error) {
+	f.Lock()
+	defer f.Unlock()
+	i := f.state.set(set.Table, set.Name)
+	if i == -1 {
+		return nil, fmt.Errorf("set %s does not exist: %w", set.Name, unix.ENOENT)
+	}
+	return append([]nftables.SetElement{}, f.state.sets[i].elements...), nil
+}
+
+// SetAddElements adds elements to the set
+func (f *FakeConn) SetAddElements(set *nftables.Set, elements []nftables.SetElement) error {
+	f.queue("add element "+set.Table.Name+" "+set.Name, func(s *fakeState) error {
+		i := s.set(set.Table, set.Name)
+		if i == -1 {
+			return fmt.Errorf("set %s does not exist: %w", set.Name, unix.ENOENT)
+		}
+		s.sets[i].elements = append(s.sets[i].elements, elements...)
+		return nil
+	})
+	return nil
+}
+
+// SetDeleteElements removes elements matching the keys from the set
+func (f *FakeConn) SetDeleteElements(set *nftables.Set, elements []nftables.SetElement) error {
+	f.queue("delete element "+set.Table.Name+" "+set.Name, func(s *fakeState) error {
+		i := s.set(set.Table, set.Name)
+		if i == -1 {
+			return fmt.Errorf("set %s does not exist: %w", set.Name, unix.ENOENT)
+		}
+		for _, e := range elements {
+			found := false
+			for j, se := range s.sets[i].elements {
+				if bytes.Equal(se.Key, e.Key) && se.IntervalEnd == e.IntervalEnd {
+					s.sets[i].elements = append(s.sets[i].elements[:j], s.sets[i].elements[j+1:]...)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("element %v does not exist in set %s: %w", e.Key, set.Name, unix.ENOENT)
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+// FlushSet removes all elements of the set
+func (f *FakeConn) FlushSet(set *nftables.Set) {
+	f.queue("flush set "+set.Table.Name+" "+set.Name, func(s *fakeState) error {
+		i := s.set(set.Table, set.Name)
+		if i == -1 {
+			return fmt.Errorf("set %s does not exist: %w", set.Name, unix.ENOENT)
+		}
+		s.sets[i].elements = nil
+		return nil
+	})
+}
+
+// AddObj adds the object, only counter objects are supported by the library
+func (f *FakeConn) AddObj(o nftables.Obj) nftables.Obj {
+	f.queue("add object", func(s *fakeState) error {
+		s.objs = append(s.objs, o)
+		return nil
+	})
+	return o
+}
+
+// DeleteObject removes the counter object with the same table and name
+func (f *FakeConn) DeleteObject(o nftables.Obj) {
+	f.queue("delete object", func(s *fakeState) error {
+		c, ok := o.(*nftables.CounterObj)
+		for i, obj := range s.objs {
+			if oc, ook := obj.(*nftables.CounterObj); obj == o || (ok && ook && oc.Name == c.Name && sameTable(oc.Table, c.Table)) {
+				s.objs = append(s.objs[:i], s.objs[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("object does not exist: %w", unix.ENOENT)
+	})
+}
+
+// GetObjects returns counter objects of the table
+func (f *FakeConn) GetObjects(t *nftables.Table) ([]nftables.Obj, error) {
+	f.Lock()
+	defer f.Unlock()
+	objs := []nftables.Obj{}
+	for _, o := range f.state.objs {
+		if c, ok := o.(*nftables.CounterObj); ok && sameTable(c.Table, t) {
+			objs = append(objs, o)
+		}
+	}
+	return objs, nil
+}
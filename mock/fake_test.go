@@ -0,0 +1,397 @@
+package mock
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/sbezverk/nftableslib"
+	"golang.org/x/sys/unix"
+)
+
+var _ nftableslib.NetNS = &FakeConn{}
+
+func TestFakeConn(t *testing.T) {
+	conn := NewFakeConn()
+	ti := nftableslib.InitNFTables(conn)
+	if err := ti.Tables().CreateImm("filter", nftables.TableFamilyIPv4); err != nil {
+		t.Fatalf("failed to create table with error: %+v", err)
+	}
+	ci, err := ti.Tables().Table("filter", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("failed to get chains interface with error: %+v", err)
+	}
+	if err := ci.Chains().CreateImm("input", nil); err != nil {
+		t.Fatalf("failed to create chain with error: %+v", err)
+	}
+	ri, err := ci.Chains().Chain("input")
+	if err != nil {
+		t.Fatalf("failed to get rules interface with error: %+v", err)
+	}
+	handle, err := ri.Rules().CreateImm(&nftableslib.Rule{
+		L4:     &nftableslib.L4Rule{L4Proto: unix.IPPROTO_TCP, Dst: &nftableslib.Port{List: nftableslib.SetPortList([]int{22})}},
+		Action: setActionVerdict(t, nftableslib.NFT_ACCEPT),
+	})
+	if err != nil {
+		t.Fatalf("failed to create rule with error: %+v", err)
+	}
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	rules, err := conn.GetRule(tbl, &nftables.Chain{Name: "input", Table: tbl})
+	if err != nil {
+		t.Fatalf("failed to get rules with error: %+v", err)
+	}
+	if len(rules) != 1 || rules[0].Handle != handle {
+		t.Fatalf("expected a single rule with handle %d, got %+v", handle, rules)
+	}
+	want := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x00, 0x16}},
+		&expr.Verdict{Kind: expr.VerdictAccept},
+	}
+	if !reflect.DeepEqual(rules[0].Exprs, want) {
+		t.Errorf("rule expressions %+v do not match expected %+v", rules[0].Exprs, want)
+	}
+
+	// A failing batch leaves the state unchanged
+	conn.AddRule(&nftables.Rule{Table: tbl, Chain: &nftables.Chain{Name: "input", Table: tbl}})
+	conn.DelChain(&nftables.Chain{Name: "missing", Table: tbl})
+	if err := conn.Flush(); err == nil {
+		t.Errorf("batch deleting a missing chain supposed to fail but succeeded")
+	}
+	if rules, _ := conn.GetRule(tbl, &nftables.Chain{Name: "input", Table: tbl}); len(rules) != 1 {
+		t.Errorf("failed batch changed the rules, got %d rules", len(rules))
+	}
+
+	if err := ri.Rules().DeleteImm(handle); err != nil {
+		t.Fatalf("failed to delete rule with error: %+v", err)
+	}
+	if err := ci.Chains().DeleteImm("input"); err != nil {
+		t.Fatalf("failed to delete chain with error: %+v", err)
+	}
+	if err := ti.Tables().DeleteImm("filter", nftables.TableFamilyIPv4); err != nil {
+		t.Fatalf("failed to delete table with error: %+v", err)
+	}
+	if tables, _ := conn.ListTables(); len(tables) != 0 {
+		t.Errorf("expected no tables left, got %+v", tables)
+	}
+	wantOps := []string{
+		"add table filter",
+		"add chain filter input",
+		"add rule filter input",
+		"delete rule filter input",
+		"delete chain filter input",
+		"delete table filter",
+	}
+	if ops := conn.Ops(); !reflect.DeepEqual(ops, wantOps) {
+		t.Errorf("applied operations %v do not match expected %v", ops, wantOps)
+	}
+}
+
+func TestProvision(t *testing.T) {
+	conn := NewFakeConn()
+	ti := nftableslib.InitNFTables(conn)
+	def := &nftableslib.TableDef{
+		Name:   "filter",
+		Family: nftables.TableFamilyIPv4,
+		Chains: []*nftableslib.ChainSpec{
+			{
+				Name: "input",
+				Attributes: &nftableslib.ChainAttributes{
+					Type:     nftables.ChainTypeFilter,
+					Hook:     nftables.ChainHookInput,
+					Priority: nftables.ChainPriorityFilter,
+				},
+				Rules: []*nftableslib.Rule{
+					{
+						L4:     &nftableslib.L4Rule{L4Proto: unix.IPPROTO_TCP, Dst: &nftableslib.Port{List: nftableslib.SetPortList([]int{22})}},
+						Action: setActionVerdict(t, nftableslib.NFT_ACCEPT),
+					},
+					{
+						Action: setActionVerdict(t, nftableslib.NFT_DROP),
+					},
+				},
+			},
+			{
+				Name: "helper",
+			},
+		},
+	}
+	result, err := ti.Tables().Provision(def)
+	if err != nil {
+		t.Fatalf("Provision failed with error: %+v", err)
+	}
+	if conn.Flushes() != 1 {
+		t.Errorf("Provision supposed to program the table with a single flush, got %d flushes", conn.Flushes())
+	}
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	rules, err := conn.GetRule(tbl, &nftables.Chain{Name: "input", Table: tbl})
+	if err != nil {
+		t.Fatalf("failed to get rules with error: %+v", err)
+	}
+	if len(rules) != 2 || !reflect.DeepEqual(result.Handles["input"], []uint64{rules[0].Handle, rules[1].Handle}) {
+		t.Errorf("Provision returned handles %+v, programmed rules %+v", result.Handles, rules)
+	}
+	if len(result.Handles["helper"]) != 0 {
+		t.Errorf("Provision returned handles %+v for a chain without rules", result.Handles["helper"])
+	}
+	ci, err := ti.Tables().Table("filter", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("failed to get chains interface with error: %+v", err)
+	}
+	ri, err := ci.Chains().Chain("input")
+	if err != nil {
+		t.Fatalf("failed to get rules interface with error: %+v", err)
+	}
+	if err := ri.Rules().DeleteImm(result.Handles["input"][1]); err != nil {
+		t.Errorf("failed to delete provisioned rule by handle with error: %+v", err)
+	}
+	// Provisioning an existing table fails without changing anything
+	if _, err := ti.Tables().Provision(def); err == nil {
+		t.Errorf("Provision of an existing table supposed to fail but succeeded")
+	}
+	// Invalid rule fails before the host is changed
+	bad := &nftableslib.TableDef{
+		Name:   "nat",
+		Family: nftables.TableFamilyIPv4,
+		Chains: []*nftableslib.ChainSpec{{Name: "post", Rules: []*nftableslib.Rule{{}}}},
+	}
+	flushes := conn.Flushes()
+	if _, err := ti.Tables().Provision(bad); err == nil {
+		t.Errorf("Provision of an invalid rule supposed to fail but succeeded")
+	}
+	if conn.Flushes() != flushes || ti.Tables().Exist("nat", nftables.TableFamilyIPv4) {
+		t.Errorf("failed Provision changed the host or the store")
+	}
+}
+
+// lastExprs returns the type of the last expression of each rule, it identifies rules by their verdict or statement
+func lastExprs(rules []*nftables.Rule) []string {
+	kinds := make([]string, 0, len(rules))
+	for _, r := range rules {
+		switch e := r.Exprs[len(r.Exprs)-1].(type) {
+		case *expr.Verdict:
+			kinds = append(kinds, fmt.Sprintf("verdict %d", e.Kind))
+		case *expr.Reject:
+			kinds = append(kinds, "reject")
+		default:
+			kinds = append(kinds, fmt.Sprintf("%T", e))
+		}
+	}
+
+	return kinds
+}
+
+func TestRateLimitedReject(t *testing.T) {
+	conn := NewFakeConn()
+	ti := nftableslib.InitNFTables(conn)
+	if err := ti.Tables().CreateImm("filter", nftables.TableFamilyIPv4); err != nil {
+		t.Fatalf("failed to create table with error: %+v", err)
+	}
+	ci, err := ti.Tables().Table("filter", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("failed to get chains interface with error: %+v", err)
+	}
+	if err := ci.Chains().CreateImm("input", nil); err != nil {
+		t.Fatalf("failed to create chain with error: %+v", err)
+	}
+	ri, err := ci.Chains().Chain("input")
+	if err != nil {
+		t.Fatalf("failed to get rules interface with error: %+v", err)
+	}
+	reject, err := nftableslib.SetRateLimitedReject(nftableslib.LimitAttributes{Rate: 10, Unit: expr.LimitTimeSecond, Burst: 5}, unix.NFT_REJECT_ICMPX_UNREACH)
+	if err != nil {
+		t.Fatalf("SetRateLimitedReject failed with error: %+v", err)
+	}
+	tcpPort := func(port int) *nftableslib.L4Rule {
+		return &nftableslib.L4Rule{L4Proto: unix.IPPROTO_TCP, Dst: &nftableslib.Port{List: nftableslib.SetPortList([]int{port})}}
+	}
+	ssh, err := ri.Rules().CreateImm(&nftableslib.Rule{L4: tcpPort(22), Action: setActionVerdict(t, nftableslib.NFT_ACCEPT)})
+	if err != nil {
+		t.Fatalf("failed to create rule with error: %+v", err)
+	}
+	handle, err := ri.Rules().CreateImm(&nftableslib.Rule{L4: tcpPort(23), Action: reject})
+	if err != nil {
+		t.Fatalf("failed to create rule with error: %+v", err)
+	}
+	if _, err := ri.Rules().InsertImm(&nftableslib.Rule{L4: tcpPort(24), Action: reject}); err != nil {
+		t.Fatalf("failed to insert rule with error: %+v", err)
+	}
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	rules, err := conn.GetRule(tbl, chain)
+	if err != nil {
+		t.Fatalf("failed to get rules with error: %+v", err)
+	}
+	drop := fmt.Sprintf("verdict %d", expr.VerdictDrop)
+	accept := fmt.Sprintf("verdict %d", expr.VerdictAccept)
+	// Each rate limited reject is followed by the rule dropping packets over the limit
+	if got, want := lastExprs(rules), []string{"reject", drop, accept, "reject", drop}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("chain carries rules %v want: %v", got, want)
+	}
+	// The rule dropping packets over the limit carries the rule's matches only
+	if want := append(rules[3].Exprs[:4:4], &expr.Verdict{Kind: expr.VerdictDrop}); !reflect.DeepEqual(rules[4].Exprs, want) {
+		t.Errorf("rule dropping packets over the limit %+v want: %+v", rules[4].Exprs, want)
+	}
+	if err := ri.Rules().DeleteImm(handle); err != nil {
+		t.Fatalf("failed to delete rule with error: %+v", err)
+	}
+	if rules, err = conn.GetRule(tbl, chain); err != nil {
+		t.Fatalf("failed to get rules with error: %+v", err)
+	}
+	if got, want := lastExprs(rules), []string{"reject", drop, accept}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after delete chain carries rules %v want: %v", got, want)
+	}
+	if err := ri.Rules().Update(&nftableslib.Rule{L4: tcpPort(22), Action: reject}, ssh); err != nil {
+		t.Fatalf("failed to update rule with error: %+v", err)
+	}
+	if rules, err = conn.GetRule(tbl, chain); err != nil {
+		t.Fatalf("failed to get rules with error: %+v", err)
+	}
+	if got, want := lastExprs(rules), []string{"reject", drop, "reject", drop}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after update chain carries rules %v want: %v", got, want)
+	}
+	calls := 0
+	n, err := ri.Rules().DeleteRulesWhere(func(*nftableslib.Rule) bool {
+		calls++
+		return true
+	})
+	if err != nil {
+		t.Fatalf("DeleteRulesWhere failed with error: %+v", err)
+	}
+	if n != 2 || calls != 2 {
+		t.Errorf("DeleteRulesWhere deleted %d rules and evaluated %d, want 2 and 2", n, calls)
+	}
+	if rules, err = conn.GetRule(tbl, chain); err != nil || len(rules) != 0 {
+		t.Errorf("chain supposed to be empty, got %d rules, error: %v", len(rules), err)
+	}
+	if _, err := ri.Rules().Create(&nftableslib.Rule{
+		L4:          tcpPort(25),
+		Probability: &nftableslib.ProbabilitySpec{OneIn: 2},
+		Action:      reject,
+	}); err == nil {
+		t.Errorf("rate limited reject with probability supposed to fail but succeeded")
+	}
+}
+
+func TestApplyRulesetRateLimitedReject(t *testing.T) {
+	conn := NewFakeConn()
+	ti := nftableslib.InitNFTables(conn)
+	reject, err := nftableslib.SetRateLimitedReject(nftableslib.LimitAttributes{Rate: 10, Unit: expr.LimitTimeSecond, Burst: 5}, unix.NFT_REJECT_ICMPX_UNREACH)
+	if err != nil {
+		t.Fatalf("SetRateLimitedReject failed with error: %+v", err)
+	}
+	desired := &nftableslib.Ruleset{
+		Tables: []*nftableslib.TableSpec{
+			{
+				Name:   "filter",
+				Family: nftables.TableFamilyIPv4,
+				Chains: []*nftableslib.ChainSpec{
+					{
+						Name: "input",
+						Rules: []*nftableslib.Rule{
+							{L4: &nftableslib.L4Rule{L4Proto: unix.IPPROTO_TCP, Dst: &nftableslib.Port{List: nftableslib.SetPortList([]int{23})}}, Action: reject},
+							{Action: setActionVerdict(t, nftableslib.NFT_ACCEPT)},
+						},
+					},
+				},
+			},
+		},
+	}
+	if _, err := ti.Tables().ApplyRuleset(desired); err != nil {
+		t.Fatalf("ApplyRuleset failed with error: %+v", err)
+	}
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	rules, err := conn.GetRule(tbl, &nftables.Chain{Name: "input", Table: tbl})
+	if err != nil {
+		t.Fatalf("failed to get rules with error: %+v", err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("expected 3 rules programmed, got %d", len(rules))
+	}
+	// Programmed rule dropping packets over the limit is part of the desired state
+	diff, err := ti.Tables().ApplyRuleset(desired)
+	if err != nil {
+		t.Fatalf("ApplyRuleset failed with error: %+v", err)
+	}
+	if !diff.IsEmpty() {
+		t.Errorf("expected no changes, got diff %+v", diff)
+	}
+}
+
+func TestApplyRulesetInvalidChain(t *testing.T) {
+	conn := NewFakeConn()
+	ti := nftableslib.InitNFTables(conn)
+	filter := &nftableslib.TableSpec{
+		Name:   "filter",
+		Family: nftables.TableFamilyIPv4,
+		Chains: []*nftableslib.ChainSpec{
+			{
+				Name:  "input",
+				Rules: []*nftableslib.Rule{{Action: setActionVerdict(t, nftableslib.NFT_ACCEPT)}},
+			},
+		},
+	}
+	if _, err := ti.Tables().ApplyRuleset(&nftableslib.Ruleset{Tables: []*nftableslib.TableSpec{filter}}); err != nil {
+		t.Fatalf("ApplyRuleset failed with error: %+v", err)
+	}
+	applied := conn.Ops()
+	desired := &nftableslib.Ruleset{
+		Tables: []*nftableslib.TableSpec{
+			{
+				Name:   "filter",
+				Family: nftables.TableFamilyIPv4,
+				Chains: []*nftableslib.ChainSpec{
+					{
+						Name:  "output",
+						Rules: []*nftableslib.Rule{{Action: setActionVerdict(t, nftableslib.NFT_DROP)}},
+					},
+				},
+			},
+			{
+				Name:   "arp",
+				Family: nftables.TableFamilyARP,
+				Chains: []*nftableslib.ChainSpec{
+					{
+						Name: "forward",
+						// arp family has no forward hook
+						Attributes: &nftableslib.ChainAttributes{
+							Type:     nftables.ChainTypeFilter,
+							Hook:     nftables.ChainHookForward,
+							Priority: nftables.ChainPriorityFilter,
+						},
+					},
+				},
+			},
+		},
+	}
+	if _, err := ti.Tables().ApplyRuleset(desired); err == nil {
+		t.Fatalf("ApplyRuleset supposed to fail but succeeded")
+	}
+	if _, err := ti.Tables().Provision(desired.Tables[1]); err == nil {
+		t.Fatalf("Provision supposed to fail but succeeded")
+	}
+	// Nothing is left queued on the connection for the next flush
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("Flush failed with error: %+v", err)
+	}
+	if ops := conn.Ops(); !reflect.DeepEqual(ops, applied) {
+		t.Errorf("failed ApplyRuleset left operations %v behind", ops[len(applied):])
+	}
+	if ti.Tables().Exist("arp", nftables.TableFamilyARP) {
+		t.Errorf("failed ApplyRuleset left table arp in the store")
+	}
+	ci, err := ti.Tables().Table("filter", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("failed to get chains interface with error: %+v", err)
+	}
+	if _, err := ci.Chains().Chain("output"); err == nil {
+		t.Errorf("failed ApplyRuleset left chain output in the store")
+	}
+	if _, err := ci.Chains().Chain("input"); err != nil {
+		t.Errorf("failed ApplyRuleset removed chain input from the store")
+	}
+}
@@ -48,6 +48,10 @@ func (m *Mock) ReplaceRule(r *nftables.Rule) *nftables.Rule {
 func (m *Mock) DelTable(t *nftables.Table) {
 }
 
+// FlushTable not used
+func (m *Mock) FlushTable(t *nftables.Table) {
+}
+
 // AddTable not used
 func (m *Mock) AddTable(t *nftables.Table) *nftables.Table {
 	return t
@@ -67,6 +71,20 @@ func (m *Mock) AddSet(s *nftables.Set, se []nftables.SetElement) error {
 	return nil
 }
 
+// AddObj not used
+func (m *Mock) AddObj(o nftables.Obj) nftables.Obj {
+	return o
+}
+
+// DeleteObject not used
+func (m *Mock) DeleteObject(nftables.Obj) {
+}
+
+// GetObjects not implemented yet
+func (m *Mock) GetObjects(*nftables.Table) ([]nftables.Obj, error) {
+	return nil, nil
+}
+
 // GetRule not implemented yet
 func (m *Mock) GetRule(*nftables.Table, *nftables.Chain) ([]*nftables.Rule, error) {
 	return nil, nil
@@ -114,6 +132,10 @@ func (m *Mock) SetDeleteElements(set *nftables.Set, elements []nftables.SetEleme
 	return nil
 }
 
+// FlushSet not used
+func (m *Mock) FlushSet(set *nftables.Set) {
+}
+
 // InitMockConn initializes mock connection of the nftables family
 func InitMockConn() *Mock {
 	m := &Mock{}
@@ -1,6 +1,8 @@
 package mock
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/google/nftables"
@@ -533,19 +535,16 @@ func TestMock(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to get chain interface for table filter-v4")
 	}
-	chainAttrs := nftableslib.ChainAttributes{
-		Hook:     nftables.ChainHookInput,
-		Type:     nftables.ChainTypeFilter,
-		Priority: nftables.ChainPriorityFilter,
-	}
-	tblV4.Chains().Create("chain-1-v4", &chainAttrs)
+	// Rules with nat, redirect and reject actions are mixed in the same chain, a regular chain
+	// is used as base chains restrict actions by the chain's type and hook.
+	tblV4.Chains().Create("chain-1-v4", nil)
 
 	m.ti.Tables().Create("filter-v6", nftables.TableFamilyIPv6)
 	tblV6, err := m.ti.Tables().Table("filter-v6", nftables.TableFamilyIPv6)
 	if err != nil {
 		t.Fatalf("failed to get chain interface for table filter-v6")
 	}
-	tblV6.Chains().Create("chain-1-v6", &chainAttrs)
+	tblV6.Chains().Create("chain-1-v6", nil)
 
 	for _, tt := range ipv4Tests {
 		ri, err := tblV4.Chains().Chain("chain-1-v4")
@@ -625,3 +624,155 @@ func TestMock(t *testing.T) {
 	t.Logf("Resulting tables: %s", string(nft))
 
 }
+
+func TestCreateStandardFilterChains(t *testing.T) {
+	m := InitMockConn()
+	m.ti.Tables().Create("filter-v4", nftables.TableFamilyIPv4)
+	tbl, err := m.ti.Tables().Table("filter-v4", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("failed to get chains interface for table filter-v4 with error: %+v", err)
+	}
+	if err := tbl.Chains().CreateStandardFilterChains(nftableslib.ChainPolicyDrop); err != nil {
+		t.Fatalf("failed to create standard filter chains with error: %+v", err)
+	}
+	for _, name := range []string{"input", "forward", "output"} {
+		if _, err := tbl.Chains().Chain(name); err != nil {
+			t.Errorf("chain %s was not created, error: %+v", name, err)
+		}
+	}
+	// Repeated call with the same policy must be idempotent
+	if err := tbl.Chains().CreateStandardFilterChains(nftableslib.ChainPolicyDrop); err != nil {
+		t.Errorf("repeated call to create standard filter chains failed with error: %+v", err)
+	}
+}
+
+func TestCreateInetFilter(t *testing.T) {
+	m := InitMockConn()
+	tbl, err := m.ti.Tables().CreateInetFilter("filter")
+	if err != nil {
+		t.Fatalf("failed to create inet filter table with error: %+v", err)
+	}
+	if !m.ti.Tables().Exist("filter", nftables.TableFamilyINet) {
+		t.Fatalf("table filter of inet family does not exist")
+	}
+	if err := tbl.Chains().CreateStandardFilterChains(nftableslib.ChainPolicyDrop); err != nil {
+		t.Fatalf("failed to create standard filter chains with error: %+v", err)
+	}
+	if err := m.Flush(); err != nil {
+		t.Errorf("Failed Flushing Tables with error: %v", err)
+	}
+}
+
+func TestCreateOrUpdateChain(t *testing.T) {
+	m := InitMockConn()
+	m.ti.Tables().Create("filter-v4", nftables.TableFamilyIPv4)
+	tbl, err := m.ti.Tables().Table("filter-v4", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("failed to get chains interface for table filter-v4 with error: %+v", err)
+	}
+	accept := nftableslib.ChainPolicyAccept
+	drop := nftableslib.ChainPolicyDrop
+	attrs := &nftableslib.ChainAttributes{
+		Type:     nftables.ChainTypeFilter,
+		Hook:     nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   &accept,
+	}
+	if err := tbl.Chains().Create("input", attrs); err != nil {
+		t.Fatalf("failed to create chain input with error: %+v", err)
+	}
+	update := attrs.Clone()
+	update.Policy = &drop
+	// Strict Create must fail on policy drift
+	if err := tbl.Chains().Create("input", update); err == nil {
+		t.Errorf("create of chain input with different policy supposed to fail but succeeded")
+	}
+	if err := tbl.Chains().CreateOrUpdate("input", update); err != nil {
+		t.Errorf("failed to update policy of chain input with error: %+v", err)
+	}
+	// After the update, the chain matches desired attributes
+	if err := tbl.Chains().Create("input", update); err != nil {
+		t.Errorf("create of updated chain input failed with error: %+v", err)
+	}
+	update.Hook = nftables.ChainHookOutput
+	if err := tbl.Chains().CreateOrUpdate("input", update); err == nil {
+		t.Errorf("update of hook of chain input supposed to fail but succeeded")
+	}
+	if err := tbl.Chains().CreateOrUpdate("input", nil); err == nil {
+		t.Errorf("conversion of base chain input to regular chain supposed to fail but succeeded")
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	m := InitMockConn()
+	m.ti.Tables().Create("filter-v4", nftables.TableFamilyIPv4)
+	tbl, err := m.ti.Tables().Table("filter-v4", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("failed to get chain interface for table filter-v4")
+	}
+	if err := tbl.Chains().Create("input", nil); err != nil {
+		t.Fatalf("failed to create chain input with error: %+v", err)
+	}
+	ri, err := tbl.Chains().Chain("input")
+	if err != nil {
+		t.Fatalf("failed to get rules interface for chain input with error: %+v", err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	wg.Add(4)
+	// Writers add rules and chains while other goroutines sync and dump the store
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			port := uint16(1000 + i)
+			if _, err := ri.Rules().Create(&nftableslib.Rule{
+				L4: &nftableslib.L4Rule{
+					L4Proto: unix.IPPROTO_TCP,
+					Dst:     &nftableslib.Port{List: []*uint16{&port}},
+				},
+				Action: setActionVerdict(t, nftableslib.NFT_ACCEPT),
+			}); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := tbl.Chains().Create(fmt.Sprintf("chain-%d", i), nil); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if _, err := m.ti.Tables().Dump(); err != nil {
+				errs <- err
+				return
+			}
+			m.ti.Tables().Exist("filter-v4", nftables.TableFamilyIPv4)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tbl.Chains().Exist(fmt.Sprintf("chain-%d", i))
+			if err := ri.Rules().Sync(); err != nil {
+				errs <- err
+				return
+			}
+			if err := m.Flush(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent use failed with error: %+v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package nftableslib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Capabilities describes nftables features supported by the running kernel. Each feature is probed by programming
+// it in a throwaway table which is deleted in the same batch, hence nothing is left on the host. Features are probed
+// on the first use over a separate connection to the network namespace of the library's connection, so messages
+// queued by other callers are not sent with the probe. Builders of rules and objects using a feature the kernel
+// does not support fail with ErrFeatureUnsupported before anything is programmed.
+type Capabilities struct {
+	// Kernel is the release of the running kernel, example: 5.15.0-91-generic
+	Kernel string
+	// Connlimit is true when connlimit expression is supported, AddPerSourceConnLimit requires it.
+	Connlimit bool
+	// TProxy is true when tproxy expression is supported, redirect action with tproxy requires it.
+	TProxy bool
+	// Synproxy is true when synproxy objects are supported, CreateSynproxy requires it.
+	Synproxy bool
+	// OSF is true when osf expression matching the operating system of the sender is supported.
+	OSF bool
+	// FlowOffload is true when flowtables and flow_offload expression are supported.
+	FlowOffload bool
+}
+
+// unameRelease returns the release of the running kernel, it is a variable to allow tests to fake the kernel.
+var unameRelease = func() (string, error) {
+	var u unix.Utsname
+	if err := unix.Uname(&u); err != nil {
+		return "", err
+	}
+	return unix.ByteSliceToString(u.Release[:]), nil
+}
+
+// nftaOSFDreg is NFTA_OSF_DREG attribute of linux/netfilter/nf_tables.h, github.com/google/nftables does not
+// provide osf expression, hence osf is probed by a batch sent by the library.
+const nftaOSFDreg = 0x1
+
+// probeFeature programs the feature in table t and chain ch of the throwaway table over conn
+type probeFeature func(conn *nftables.Conn, t *nftables.Table, ch *nftables.Chain) error
+
+// probeExprs returns a probe programming a rule of exprs
+func probeExprs(exprs ...expr.Any) probeFeature {
+	return func(conn *nftables.Conn, t *nftables.Table, ch *nftables.Chain) error {
+		conn.AddTable(t)
+		conn.AddChain(ch)
+		conn.AddRule(&nftables.Rule{Table: t, Chain: ch, Exprs: exprs})
+		conn.DelTable(t)
+		return conn.Flush()
+	}
+}
+
+func probeSynproxy(conn *nftables.Conn, t *nftables.Table, ch *nftables.Chain) error {
+	conn.AddTable(t)
+	conn.AddObj(&nftables.NamedObj{
+		Table: t,
+		Name:  ch.Name,
+		Type:  nftables.ObjTypeSynProxy,
+		Obj:   &expr.SynProxy{Mss: 1460, Wscale: 7, MssValueSet: true, WscaleValueSet: true},
+	})
+	conn.DelTable(t)
+	return conn.Flush()
+}
+
+// probeFlowOffload programs a flowtable bound to the loopback device, which exists in every network namespace,
+// and a rule offloading flows to it.
+func probeFlowOffload(conn *nftables.Conn, t *nftables.Table, ch *nftables.Chain) error {
+	conn.AddTable(t)
+	conn.AddChain(ch)
+	conn.AddFlowtable(&nftables.Flowtable{
+		Table:    t,
+		Name:     ch.Name,
+		Hooknum:  nftables.FlowtableHookIngress,
+		Priority: nftables.FlowtablePriorityFilter,
+		Devices:  []string{"lo"},
+	})
+	conn.AddRule(&nftables.Rule{Table: t, Chain: ch, Exprs: []expr.Any{&expr.FlowOffload{Name: ch.Name}}})
+	conn.DelTable(t)
+	return conn.Flush()
+}
+
+// probeOSF programs a rule with osf expression loading the operating system's genre into register 1
+func probeOSF(conn *nftables.Conn, t *nftables.Table, ch *nftables.Chain) error {
+	var msgs []netlink.Message
+	add := func(msgType uint16, flags netlink.HeaderFlags, encode func(ae *netlink.AttributeEncoder)) error {
+		ae := netlink.NewAttributeEncoder()
+		ae.ByteOrder = binary.BigEndian
+		encode(ae)
+		data, err := ae.Encode()
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, message(msgType, flags, t.Family, data))
+		return nil
+	}
+	table := func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_TABLE_NAME, t.Name)
+	}
+	if err := add(unix.NFT_MSG_NEWTABLE, netlink.Create, table); err != nil {
+		return err
+	}
+	if err := add(unix.NFT_MSG_NEWCHAIN, netlink.Create, func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_CHAIN_TABLE, t.Name)
+		ae.String(unix.NFTA_CHAIN_NAME, ch.Name)
+	}); err != nil {
+		return err
+	}
+	// [ osf dreg 1 ]
+	if err := add(unix.NFT_MSG_NEWRULE, netlink.Create|netlink.Append, func(ae *netlink.AttributeEncoder) {
+		ae.String(unix.NFTA_RULE_TABLE, t.Name)
+		ae.String(unix.NFTA_RULE_CHAIN, ch.Name)
+		ae.Nested(unix.NFTA_RULE_EXPRESSIONS, func(exprs *netlink.AttributeEncoder) error {
+			exprs.Nested(unix.NFTA_LIST_ELEM, func(e *netlink.AttributeEncoder) error {
+				e.String(unix.NFTA_EXPR_NAME, "osf")
+				e.Nested(unix.NFTA_EXPR_DATA, func(data *netlink.AttributeEncoder) error {
+					data.Uint32(nftaOSFDreg, unix.NFT_REG_1)
+					return nil
+				})
+				return nil
+			})
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+	if err := add(unix.NFT_MSG_DELTABLE, 0, table); err != nil {
+		return err
+	}
+
+	return batch(conn, msgs)
+}
+
+// probeCapabilities probes features of the kernel conn is connected to. The kernel reports an expression, an object
+// or a flowtable type it does not know as ENOENT and an unsupported one as EOPNOTSUPP, the feature is not supported
+// then, other errors fail the probe.
+func probeCapabilities(conn NetNS) (Capabilities, error) {
+	c := kernelConn(conn)
+	if c == nil {
+		return Capabilities{}, fmt.Errorf("capabilities can be probed only through a connection to the kernel")
+	}
+	opt := nftables.WithNetNSFd(c.NetNS)
+	if c.TestDial != nil {
+		opt = nftables.WithTestDial(c.TestDial)
+	}
+	pc, err := nftables.New(opt)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	t := &nftables.Table{Name: "nftableslib-probe-" + getSetName(), Family: nftables.TableFamilyIPv4}
+	ch := &nftables.Chain{Name: "probe", Table: t}
+	var caps Capabilities
+	features := []struct {
+		name      string
+		probe     probeFeature
+		supported *bool
+	}{
+		{name: "connlimit", probe: probeExprs(&expr.Connlimit{Count: 1}), supported: &caps.Connlimit},
+		{name: "tproxy", probe: probeExprs(
+			&expr.Immediate{Register: 1, Data: binaryutil.BigEndian.PutUint16(3128)},
+			&expr.TProxy{Family: byte(t.Family), TableFamily: byte(t.Family), RegPort: 1},
+		), supported: &caps.TProxy},
+		{name: "synproxy", probe: probeSynproxy, supported: &caps.Synproxy},
+		{name: "osf", probe: probeOSF, supported: &caps.OSF},
+		{name: "flow offload", probe: probeFlowOffload, supported: &caps.FlowOffload},
+	}
+	for _, f := range features {
+		err := f.probe(pc, t, ch)
+		switch {
+		case err == nil:
+			*f.supported = true
+		case errors.Is(err, unix.ENOENT), errors.Is(err, unix.EOPNOTSUPP):
+		default:
+			return Capabilities{}, wrapConnErr(fmt.Errorf("failed to probe %s with error: %w", f.name, err))
+		}
+	}
+
+	return caps, nil
+}
+
+// capsProbe probes capabilities on the first use and caches them, the probe is shared by all tables,
+// chains and rules of the connection.
+type capsProbe struct {
+	conn NetNS
+	once sync.Once
+	caps Capabilities
+	err  error
+}
+
+func (p *capsProbe) get() (Capabilities, error) {
+	p.once.Do(func() {
+		var release string
+		if release, p.err = unameRelease(); p.err != nil {
+			return
+		}
+		if p.caps, p.err = probeCapabilities(p.conn); p.err != nil {
+			return
+		}
+		p.caps.Kernel = release
+	})
+
+	return p.caps, p.err
+}
+
+// check returns ErrFeatureUnsupported if the probe found that the running kernel does not support the feature,
+// nil is returned when capabilities are unknown, for example the connection is a mock or the probe failed,
+// the kernel has the final word at Flush then.
+func (p *capsProbe) check(feature string, supported func(Capabilities) bool) error {
+	if p == nil {
+		return nil
+	}
+	caps, err := p.get()
+	if err != nil || supported(caps) {
+		return nil
+	}
+
+	return fmt.Errorf("nftableslib: %s: %w", feature, ErrFeatureUnsupported)
+}
+
+// Capabilities returns nftables features supported by the running kernel, example: to pick a simpler rule on
+// an older kernel. The probe fails when the library's connection is not a connection to the kernel.
+func (nft *nfTables) Capabilities() (Capabilities, error) {
+	return nft.opts.caps.get()
+}
@@ -0,0 +1,144 @@
+package nftableslib
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// probeKernel fakes the kernel rejecting batches which use a feature of reject, features are matched by
+// the name of the expression or by the type of the message, example: NFT_MSG_NEWFLOWTABLE.
+type probeKernel struct {
+	reject  map[string]error
+	batches int
+	// deleted counts batches deleting the throwaway table they created
+	deleted int
+}
+
+func (k *probeKernel) dial(req []netlink.Message) ([]netlink.Message, error) {
+	ack := []netlink.Message{{Header: netlink.Header{Type: netlink.Error}, Data: make([]byte, 4)}}
+	if len(req) < 3 || req[0].Header.Type != netlink.HeaderType(unix.NFNL_MSG_BATCH_BEGIN) {
+		return ack, nil
+	}
+	k.batches++
+	msgs := req[1 : len(req)-1]
+	for _, m := range msgs {
+		for feature, err := range k.reject {
+			switch {
+			case feature == "synproxy" && m.Header.Type&0xff == unix.NFT_MSG_NEWOBJ,
+				feature == "flowtable" && m.Header.Type&0xff == unix.NFT_MSG_NEWFLOWTABLE,
+				bytes.Contains(m.Data, []byte(feature+"\x00")):
+				return nil, err
+			}
+		}
+	}
+	first, last := msgs[0], msgs[len(msgs)-1]
+	if first.Header.Type&0xff == unix.NFT_MSG_NEWTABLE && last.Header.Type&0xff == unix.NFT_MSG_DELTABLE &&
+		bytes.Equal(first.Data, last.Data) {
+		k.deleted++
+	}
+
+	return ack, nil
+}
+
+func TestProbeCapabilities(t *testing.T) {
+	defer func(f func() (string, error)) { unameRelease = f }(unameRelease)
+	unameRelease = func() (string, error) { return "5.15.0-91-generic", nil }
+
+	tests := []struct {
+		name    string
+		reject  map[string]error
+		want    Capabilities
+		success bool
+	}{
+		{
+			name:    "All features supported",
+			want:    Capabilities{Kernel: "5.15.0-91-generic", Connlimit: true, TProxy: true, Synproxy: true, OSF: true, FlowOffload: true},
+			success: true,
+		},
+		{
+			name: "Missing expressions, objects and flowtables",
+			reject: map[string]error{
+				"tproxy":    unix.ENOENT,
+				"osf":       unix.ENOENT,
+				"synproxy":  unix.EOPNOTSUPP,
+				"flowtable": unix.ENOENT,
+			},
+			want:    Capabilities{Kernel: "5.15.0-91-generic", Connlimit: true},
+			success: true,
+		},
+		{
+			name:    "Probe without privileges",
+			reject:  map[string]error{"connlimit": unix.EPERM},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		k := &probeKernel{reject: tt.reject}
+		conn, err := nftables.New(nftables.WithTestDial(k.dial))
+		if err != nil {
+			t.Fatalf("failed to create connection with error: %+v", err)
+		}
+		caps, err := InitNFTables(conn).Capabilities()
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" supposed to fail but succeeded", tt.name)
+			continue
+		}
+		if !tt.success {
+			if !errors.Is(err, ErrInsufficientPrivileges) {
+				t.Errorf("Test \"%s\" failed with error: %+v, want: %v", tt.name, err, ErrInsufficientPrivileges)
+			}
+			continue
+		}
+		if caps != tt.want {
+			t.Errorf("Test \"%s\" failed, got capabilities %+v want: %+v", tt.name, caps, tt.want)
+		}
+		if k.batches != 5 || k.deleted != 5-len(tt.reject) {
+			t.Errorf("Test \"%s\" sent %d probes, %d of them deleted the throwaway table", tt.name, k.batches, k.deleted)
+		}
+	}
+}
+
+func TestUnsupportedFeatures(t *testing.T) {
+	defer func(f func() (string, error)) { unameRelease = f }(unameRelease)
+	unameRelease = func() (string, error) { return "4.14.0-1-amd64", nil }
+
+	k := &probeKernel{reject: map[string]error{
+		"connlimit": unix.ENOENT,
+		"tproxy":    unix.ENOENT,
+		"synproxy":  unix.ENOENT,
+	}}
+	conn, err := nftables.New(nftables.WithTestDial(k.dial))
+	if err != nil {
+		t.Fatalf("failed to create connection with error: %+v", err)
+	}
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	opts := Options{caps: &capsProbe{conn: conn}}
+	ri := newRules(conn, tbl, &nftables.Chain{Name: "prerouting", Table: tbl}, opts)
+	if err := ri.Rules().AddPerSourceConnLimit(3, setActionVerdict(t, NFT_DROP)); !errors.Is(err, ErrFeatureUnsupported) {
+		t.Errorf("AddPerSourceConnLimit returned error: %+v, want: %v", err, ErrFeatureUnsupported)
+	}
+	if _, err := ri.Rules().Create(&Rule{
+		L4:     &L4Rule{L4Proto: unix.IPPROTO_TCP},
+		Action: setActionRedirect(t, 3128, true),
+	}); !errors.Is(err, ErrFeatureUnsupported) {
+		t.Errorf("Create of tproxy rule returned error: %+v, want: %v", err, ErrFeatureUnsupported)
+	}
+	objs := newObjects(conn, tbl, opts).Objects()
+	if err := objs.CreateSynproxy("syn", 1460, 7, 0); !errors.Is(err, ErrFeatureUnsupported) {
+		t.Errorf("CreateSynproxy returned error: %+v, want: %v", err, ErrFeatureUnsupported)
+	}
+	// Capabilities of a mock are unknown, the kernel has the final word at Flush
+	ri = newRules(&applyConn{}, tbl, &nftables.Chain{Name: "ssh", Table: tbl}, Options{caps: &capsProbe{conn: &applyConn{}}})
+	if err := ri.Rules().AddPerSourceConnLimit(3, setActionVerdict(t, NFT_DROP)); err != nil {
+		t.Errorf("AddPerSourceConnLimit failed with error: %+v", err)
+	}
+}
@@ -30,7 +30,12 @@ const (
 	ChainDeleteTimeout = time.Second * 60
 )
 
-// ChainAttributes defines attributes which can be apply to a chain of BASE type
+// ChainHookEgress defines netdev egress hook, github.com/google/nftables defines only ingress hook.
+// Egress hook requires kernel 5.16 or later, its value matches the value of input hook of other families.
+var ChainHookEgress = nftables.ChainHookRef(unix.NF_NETDEV_EGRESS)
+
+// ChainAttributes defines attributes which can be apply to a chain of BASE type, Device binds a base chain
+// of netdev family to a network device, example: eth0.
 type ChainAttributes struct {
 	Type     nftables.ChainType
 	Hook     *nftables.ChainHook
@@ -39,6 +44,31 @@ type ChainAttributes struct {
 	Policy   *ChainPolicy
 }
 
+// Clone returns a deep copy of ChainAttributes
+func (cha *ChainAttributes) Clone() *ChainAttributes {
+	if cha == nil {
+		return nil
+	}
+	n := &ChainAttributes{
+		Type:   cha.Type,
+		Device: cha.Device,
+	}
+	if cha.Hook != nil {
+		h := *cha.Hook
+		n.Hook = &h
+	}
+	if cha.Priority != nil {
+		p := *cha.Priority
+		n.Priority = &p
+	}
+	if cha.Policy != nil {
+		p := *cha.Policy
+		n.Policy = &p
+	}
+
+	return n
+}
+
 // Validate validate attributes passed for a base chain creation
 func (cha *ChainAttributes) Validate() error {
 	if cha.Type == "" {
@@ -49,22 +79,63 @@ func (cha *ChainAttributes) Validate() error {
 	return nil
 }
 
+// validateARPChain checks that the base chain of arp family is a filter chain at input or output hook,
+// the only chains the kernel supports in arp family.
+func validateARPChain(cha *ChainAttributes) error {
+	if cha.Type != nftables.ChainTypeFilter {
+		return fmt.Errorf("only filter chain type is supported")
+	}
+	if cha.Hook == nil || (*cha.Hook != *nftables.ChainHookInput && *cha.Hook != *nftables.ChainHookOutput) {
+		return fmt.Errorf("only input and output hooks are supported")
+	}
+
+	return nil
+}
+
+// validateNetdevChain checks that the base chain of netdev family is a filter chain at ingress or egress hook
+// bound to a device with a priority, which can be any number, example: nftables.ChainPriorityRef(-150).
+func validateNetdevChain(cha *ChainAttributes) error {
+	if cha.Type != nftables.ChainTypeFilter {
+		return fmt.Errorf("only filter chain type is supported")
+	}
+	if cha.Hook == nil || (*cha.Hook != *nftables.ChainHookIngress && *cha.Hook != *ChainHookEgress) {
+		return fmt.Errorf("only ingress and egress hooks are supported")
+	}
+	if cha.Device == "" {
+		return fmt.Errorf("requires device")
+	}
+	if cha.Priority == nil {
+		return fmt.Errorf("requires priority")
+	}
+
+	return nil
+}
+
 // ChainFuncs defines funcations to operate with chains
 type ChainFuncs interface {
 	Chain(name string) (RulesInterface, error)
 	Create(name string, attributes *ChainAttributes) error
 	CreateImm(name string, attributes *ChainAttributes) error
+	CreateOrUpdate(name string, attributes *ChainAttributes) error
+	CreateStandardFilterChains(policy ChainPolicy) error
 	Delete(name string) error
 	DeleteImm(name string) error
 	Exist(name string) bool
 	Sync() error
+	SyncChain(name string) error
+	ReferencedBy(name string) ([]RuleRef, error)
+	ChainUseCount(name string) int
 	Dump() ([]byte, error)
 	Get() ([]string, error)
+	ChainAttributes(name string) (*ChainAttributes, error)
 }
 
 type nfChains struct {
 	conn  NetNS
+	opts  Options
 	table *nftables.Table
+	// sets is the store of the table's sets, it is nil for chains created outside of the table
+	sets *nfSets
 	sync.Mutex
 	chains map[string]*nfChain
 }
@@ -112,9 +183,13 @@ func isEqualChain(ch *nfChain, attributes *ChainAttributes) bool {
 	}
 	// Attributes must match
 	if attributes != nil {
-		if attributes.Hook != ch.chain.Hooknum ||
+		if !isEqualHook(attributes.Hook, ch.chain.Hooknum) ||
 			attributes.Type != ch.chain.Type ||
-			attributes.Priority != ch.chain.Priority {
+			!isEqualPriority(attributes.Priority, ch.chain.Priority) {
+			return false
+		}
+		// Devices of chains synced from the host are not known
+		if ch.chain.Device != "" && attributes.Device != ch.chain.Device {
 			return false
 		}
 		if attributes.Policy != nil {
@@ -130,6 +205,47 @@ func isEqualChain(ch *nfChain, attributes *ChainAttributes) bool {
 	return true
 }
 
+// checkNetdevPriority checks that no other chain of the table is bound to the same device at the same hook
+// with the same priority, the priority is the only way to order netdev chains of a device.
+func checkNetdevPriority(chains map[string]*nfChain, name string, cha *ChainAttributes) error {
+	for n, ch := range chains {
+		if n == name || ch.chain == nil || ch.chain.Priority == nil || ch.chain.Device != cha.Device {
+			continue
+		}
+		if isEqualHook(cha.Hook, ch.chain.Hooknum) && *cha.Priority == *ch.chain.Priority {
+			return fmt.Errorf("chain %s is already bound to device %s with priority %d", n, cha.Device, *cha.Priority)
+		}
+	}
+
+	return nil
+}
+
+// validateChain checks attributes of a new chain of the table against chains of the table, it does not
+// change anything, example: validating all chains of a ruleset before any of them is created.
+func validateChain(t *nftables.Table, chains map[string]*nfChain, name string, attributes *ChainAttributes) error {
+	if attributes == nil {
+		return nil
+	}
+	if err := attributes.Validate(); err != nil {
+		return err
+	}
+	if t.Family == nftables.TableFamilyNetdev {
+		if err := validateNetdevChain(attributes); err != nil {
+			return fmt.Errorf("nftableslib: netdev chain %s: %w", name, err)
+		}
+		if err := checkNetdevPriority(chains, name, attributes); err != nil {
+			return fmt.Errorf("nftableslib: netdev chain %s: %w", name, err)
+		}
+	}
+	if t.Family == nftables.TableFamilyARP {
+		if err := validateARPChain(attributes); err != nil {
+			return fmt.Errorf("nftableslib: arp chain %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
 func (nfc *nfChains) create(name string, attributes *ChainAttributes) error {
 	if ch, ok := nfc.chains[name]; ok {
 		if isEqualChain(ch, attributes) {
@@ -138,12 +254,12 @@ func (nfc *nfChains) create(name string, attributes *ChainAttributes) error {
 		return fmt.Errorf("nftableslib: chain %s already exist in table %s", name, nfc.table.Name)
 	}
 
+	if err := validateChain(nfc.table, nfc.chains, name, attributes); err != nil {
+		return err
+	}
 	var baseChain bool
 	var c *nftables.Chain
 	if attributes != nil {
-		if err := attributes.Validate(); err != nil {
-			return err
-		}
 		baseChain = true
 		policy := nftables.ChainPolicyAccept
 		if attributes.Policy != nil {
@@ -156,6 +272,7 @@ func (nfc *nfChains) create(name string, attributes *ChainAttributes) error {
 			Table:    nfc.table,
 			Type:     attributes.Type,
 			Policy:   &policy,
+			Device:   attributes.Device,
 		})
 	} else {
 		baseChain = false
@@ -164,11 +281,12 @@ func (nfc *nfChains) create(name string, attributes *ChainAttributes) error {
 			Table: nfc.table,
 		})
 	}
-	nfc.chains[name] = &nfChain{
+	ch := &nfChain{
 		chain:          c,
 		baseChain:      baseChain,
-		RulesInterface: newRules(nfc.conn, nfc.table, c),
+		RulesInterface: nfc.newRules(c),
 	}
+	nfc.chains[name] = ch
 
 	return nil
 }
@@ -187,9 +305,100 @@ func (nfc *nfChains) CreateImm(name string, attributes *ChainAttributes) error {
 		return err
 	}
 	// Flush notifies netlink to proceed with prgramming of a chain
-	if err := nfc.conn.Flush(); err != nil {
+	return nfc.conn.Flush()
+}
+
+// CreateOrUpdate creates a chain, if the chain already exists with different attributes, instead of
+// failing, the chain is updated to match the desired attributes. Only the policy of a base chain can be
+// changed in place, type, hook and priority cannot be changed without deleting the chain.
+func (nfc *nfChains) CreateOrUpdate(name string, attributes *ChainAttributes) error {
+	nfc.Lock()
+	defer nfc.Unlock()
+	ch, ok := nfc.chains[name]
+	if !ok || isEqualChain(ch, attributes) {
+		return nfc.create(name, attributes)
+	}
+
+	return nfc.update(ch, attributes)
+}
+
+func (nfc *nfChains) update(ch *nfChain, attributes *ChainAttributes) error {
+	if err := nfc.checkUpdate(ch, attributes); err != nil {
+		return err
+	}
+	policy := nftables.ChainPolicyAccept
+	if attributes.Policy != nil {
+		policy = nftables.ChainPolicy(*attributes.Policy)
+	}
+	// Adding already existing chain without NLM_F_EXCL flag updates the chain's policy
+	c := *ch.chain
+	c.Policy = &policy
+	nfc.conn.AddChain(&c)
+	ch.chain.Policy = &policy
+
+	return nil
+}
+
+// checkUpdate checks that only the policy of the base chain is changed by the attributes
+func (nfc *nfChains) checkUpdate(ch *nfChain, attributes *ChainAttributes) error {
+	if ch.chain == nil {
+		return fmt.Errorf("nftableslib: chain in table %s is not initialized", nfc.table.Name)
+	}
+	if !ch.baseChain || attributes == nil {
+		return fmt.Errorf("nftableslib: chain %s cannot be converted between base and regular chain", ch.chain.Name)
+	}
+	if err := attributes.Validate(); err != nil {
 		return err
 	}
+	if !isEqualHook(attributes.Hook, ch.chain.Hooknum) ||
+		!isEqualPriority(attributes.Priority, ch.chain.Priority) ||
+		attributes.Type != ch.chain.Type {
+		return fmt.Errorf("nftableslib: type, hook or priority of chain %s cannot be changed", ch.chain.Name)
+	}
+
+	return nil
+}
+
+func isEqualHook(h1, h2 *nftables.ChainHook) bool {
+	if h1 == nil || h2 == nil {
+		return h1 == h2
+	}
+
+	return *h1 == *h2
+}
+
+func isEqualPriority(p1, p2 *nftables.ChainPriority) bool {
+	if p1 == nil || p2 == nil {
+		return p1 == p2
+	}
+
+	return *p1 == *p2
+}
+
+// CreateStandardFilterChains creates input, forward and output base chains of filter type,
+// hooked to the corresponding hooks with filter priority and specified policy.
+func (nfc *nfChains) CreateStandardFilterChains(policy ChainPolicy) error {
+	nfc.Lock()
+	defer nfc.Unlock()
+	chains := []struct {
+		name string
+		hook *nftables.ChainHook
+	}{
+		{name: "input", hook: nftables.ChainHookInput},
+		{name: "forward", hook: nftables.ChainHookForward},
+		{name: "output", hook: nftables.ChainHookOutput},
+	}
+	for _, c := range chains {
+		p := policy
+		if err := nfc.create(c.name, &ChainAttributes{
+			Type:     nftables.ChainTypeFilter,
+			Hook:     c.hook,
+			Priority: nftables.ChainPriorityFilter,
+			Policy:   &p,
+		}); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -198,6 +407,9 @@ func (nfc *nfChains) Delete(name string) error {
 	nfc.Lock()
 	defer nfc.Unlock()
 	if ch, ok := nfc.chains[name]; ok {
+		if err := nfc.checkUnused(name); err != nil {
+			return err
+		}
 		nfc.conn.DelChain(ch.chain)
 		delete(nfc.chains, name)
 	} else {
@@ -214,6 +426,9 @@ func (nfc *nfChains) DeleteImm(name string) error {
 	if !ok {
 		return fmt.Errorf("chain %s does not exists", name)
 	}
+	if err := nfc.checkUnused(name); err != nil {
+		return err
+	}
 
 	var err error
 	timeout := time.NewTimer(ChainDeleteTimeout)
@@ -239,6 +454,16 @@ func (nfc *nfChains) DeleteImm(name string) error {
 	}
 }
 
+// checkUnused returns EBUSY error if rules in the library's store still reference the chain,
+// retrying the deletion cannot succeed until the rules are deleted.
+func (nfc *nfChains) checkUnused(name string) error {
+	if n := nfc.useCount(name); n != 0 {
+		return fmt.Errorf("chain %s is referenced by %d rules: %w", name, n, unix.EBUSY)
+	}
+
+	return nil
+}
+
 func (nfc *nfChains) Sync() error {
 	chains, err := nfc.conn.ListChains()
 	if err != nil {
@@ -246,19 +471,8 @@ func (nfc *nfChains) Sync() error {
 	}
 	for _, chain := range chains {
 		if chain.Table.Name == nfc.table.Name && chain.Table.Family == nfc.table.Family {
-			if _, ok := nfc.chains[chain.Name]; !ok {
-				baseChain := false
-				if chain.Type != "" && chain.Hooknum != nftables.ChainHookPrerouting { // unix.NF_INET_PRE_ROUTING = 0
-					baseChain = true
-				}
-				nfc.Lock()
-				nfc.chains[chain.Name] = &nfChain{
-					chain:          chain,
-					baseChain:      baseChain,
-					RulesInterface: newRules(nfc.conn, nfc.table, chain),
-				}
-				nfc.Unlock()
-				if err := nfc.chains[chain.Name].Rules().Sync(); err != nil {
+			if !nfc.inStore(chain.Name) {
+				if err := nfc.syncChain(chain); err != nil {
 					return err
 				}
 			}
@@ -268,6 +482,78 @@ func (nfc *nfChains) Sync() error {
 	return nil
 }
 
+// SyncChain discovers a single chain and loads its rules into the store, unlike Sync
+// it does not load the rules of other chains missing from the store.
+func (nfc *nfChains) SyncChain(name string) error {
+	if nfc.inStore(name) {
+		return nil
+	}
+	chains, err := nfc.conn.ListChains()
+	if err != nil {
+		return err
+	}
+	for _, chain := range chains {
+		if chain.Name == name && chain.Table.Name == nfc.table.Name && chain.Table.Family == nfc.table.Family {
+			return nfc.syncChain(chain)
+		}
+	}
+
+	return fmt.Errorf("chain %s does not exist in table %s", name, nfc.table.Name)
+}
+
+// ChainAttributes returns attributes of the chain as programmed on the host, nil attributes are returned
+// for a regular chain. It allows to detect drift of a base chain's policy.
+func (nfc *nfChains) ChainAttributes(name string) (*ChainAttributes, error) {
+	chains, err := nfc.conn.ListChains()
+	if err != nil {
+		return nil, err
+	}
+	for _, chain := range chains {
+		if chain.Name != name || chain.Table.Name != nfc.table.Name || chain.Table.Family != nfc.table.Family {
+			continue
+		}
+		attrs := getChainAttributes(chain)
+		if attrs != nil && chain.Policy != nil {
+			p := ChainPolicy(*chain.Policy)
+			attrs.Policy = &p
+		}
+		return attrs, nil
+	}
+
+	return nil, fmt.Errorf("chain %s does not exist in table %s", name, nfc.table.Name)
+}
+
+func (nfc *nfChains) syncChain(chain *nftables.Chain) error {
+	baseChain := false
+	if chain.Type != "" && chain.Hooknum != nftables.ChainHookPrerouting { // unix.NF_INET_PRE_ROUTING = 0
+		baseChain = true
+	}
+	nfc.Lock()
+	if _, ok := nfc.chains[chain.Name]; ok {
+		// The chain has been added to the store by another caller
+		nfc.Unlock()
+		return nil
+	}
+	c := &nfChain{
+		chain:          chain,
+		baseChain:      baseChain,
+		RulesInterface: nfc.newRules(chain),
+	}
+	nfc.chains[chain.Name] = c
+	nfc.Unlock()
+
+	return c.Rules().Sync()
+}
+
+// inStore returns true if the store carries the chain
+func (nfc *nfChains) inStore(name string) bool {
+	nfc.Lock()
+	defer nfc.Unlock()
+	_, ok := nfc.chains[name]
+
+	return ok
+}
+
 func (nfc *nfChains) Dump() ([]byte, error) {
 	nfc.Lock()
 	defer nfc.Unlock()
@@ -292,7 +578,7 @@ func (nfc *nfChains) Dump() ([]byte, error) {
 // Exist checks is the chain already defined
 func (nfc *nfChains) Exist(name string) bool {
 	// Check if Chain exists in the store
-	if _, ok := nfc.chains[name]; ok {
+	if nfc.inStore(name) {
 		return true
 	}
 	// It is not in the store, let's double check if it exists on the host
@@ -304,9 +590,7 @@ func (nfc *nfChains) Exist(name string) bool {
 		if chain.Name == name {
 			if nfc.table.Name == chain.Table.Name && nfc.table.Family == chain.Table.Family {
 				// Found a chain is missing from the store, adding it
-				// Sync will load all missing chain,
-				// TODO Consider creating SyncChain(name) function.
-				if err := nfc.Sync(); err == nil {
+				if err := nfc.syncChain(chain); err == nil {
 					return true
 				}
 				break
@@ -326,7 +610,7 @@ func (nfc *nfChains) Get() ([]string, error) {
 	var chainNames []string
 	for _, chain := range chains {
 		if nfc.table.Name == chain.Table.Name && nfc.table.Family == chain.Table.Family {
-			if _, ok := nfc.chains[chain.Name]; !ok {
+			if !nfc.inStore(chain.Name) {
 				// Found chain which is not in the store
 				// triggering Sync() to add it
 				if err := nfc.Sync(); err != nil {
@@ -340,6 +624,54 @@ func (nfc *nfChains) Get() ([]string, error) {
 	return chainNames, nil
 }
 
+// getAllRules returns rules programmed on the host for every chain of the table, including chains
+// not created by the library, the map is keyed by the chain name. Rules of all chains are read
+// by a single dump of the table's rules.
+func (nfc *nfChains) getAllRules() (map[string][]*Rule, error) {
+	hostChains, err := nfc.conn.ListChains()
+	if err != nil {
+		return nil, err
+	}
+	var chains []*nftables.Chain
+	for _, chain := range hostChains {
+		if nfc.table.Name == chain.Table.Name && nfc.table.Family == chain.Table.Family {
+			chains = append(chains, chain)
+		}
+	}
+	hostRules, err := tableRules(nfc.conn, nfc.table, chains)
+	if err != nil {
+		return nil, err
+	}
+	byChain := make(map[string][]*nftables.Rule)
+	for _, chain := range chains {
+		byChain[chain.Name] = nil
+	}
+	for _, rule := range hostRules {
+		byChain[rule.Chain.Name] = append(byChain[rule.Chain.Name], rule)
+	}
+	nfc.Lock()
+	defer nfc.Unlock()
+	rules := make(map[string][]*Rule)
+	for name, r := range byChain {
+		ch, ok := nfc.chains[name]
+		if !ok || ch.RulesInterface == nil {
+			// Rules of a chain missing in the store are not created by the library
+			specs := make([]*Rule, 0, len(r))
+			for _, rule := range r {
+				specs = append(specs, ruleSpec(nil, rule))
+			}
+			rules[name] = specs
+			continue
+		}
+		nfr := ch.RulesInterface.(*nfRules)
+		nfr.Lock()
+		rules[name] = nfr.hostRules(r)
+		nfr.Unlock()
+	}
+
+	return rules, nil
+}
+
 // Ready returns true if the chain is found in the list of programmed chains
 func (nfc *nfChains) Ready(name string) (bool, error) {
 	chains, err := nfc.conn.ListChains()
@@ -357,9 +689,18 @@ func (nfc *nfChains) Ready(name string) (bool, error) {
 	return false, nil
 }
 
-func newChains(conn NetNS, t *nftables.Table) ChainsInterface {
+// newRules returns rules of the chain which can create chains of the table through the store
+func (nfc *nfChains) newRules(c *nftables.Chain) RulesInterface {
+	nfr := newRules(nfc.conn, nfc.table, c, nfc.opts).(*nfRules)
+	nfr.chains = nfc
+
+	return nfr
+}
+
+func newChains(conn NetNS, t *nftables.Table, opts Options) ChainsInterface {
 	return &nfChains{
 		conn:   conn,
+		opts:   opts,
 		table:  t,
 		chains: make(map[string]*nfChain),
 	}
@@ -0,0 +1,104 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+// Purpose identifies the role of a base chain, it maps to the standard priority names of nft
+type Purpose int
+
+// Purposes of base chains
+const (
+	PurposeRaw Purpose = iota
+	PurposeMangle
+	PurposeDstNAT
+	PurposeFilter
+	PurposeSecurity
+	PurposeSrcNAT
+)
+
+var purposeNames = map[Purpose]string{
+	PurposeRaw:      "raw",
+	PurposeMangle:   "mangle",
+	PurposeDstNAT:   "dstnat",
+	PurposeFilter:   "filter",
+	PurposeSecurity: "security",
+	PurposeSrcNAT:   "srcnat",
+}
+
+// Standard priorities of bridge family, they differ from ip, ip6 and inet families. Bridge hooks
+// share numbering with inet hooks.
+var (
+	bridgePriorityDstNAT = nftables.ChainPriorityRef(-300)
+	bridgePriorityFilter = nftables.ChainPriorityRef(-200)
+	bridgePrioritySrcNAT = nftables.ChainPriorityRef(300)
+)
+
+// PriorityFor returns the standard priority of a base chain serving purpose at hook of family, the same
+// priority nft assigns to the standard priority names. An error is returned if purpose is not available
+// at the hook or in the family, for example dstnat is only available at prerouting and output hooks and
+// srcnat at postrouting and input hooks.
+func PriorityFor(purpose Purpose, hook *nftables.ChainHook, family nftables.TableFamily) (*nftables.ChainPriority, error) {
+	p, err := priorityFor(purpose, hook, family)
+	if err != nil {
+		return nil, err
+	}
+	// Returning a copy, so the caller cannot alter priorities shared by all chains
+	return nftables.ChainPriorityRef(*p), nil
+}
+
+func priorityFor(purpose Purpose, hook *nftables.ChainHook, family nftables.TableFamily) (*nftables.ChainPriority, error) {
+	name, ok := purposeNames[purpose]
+	if !ok {
+		return nil, fmt.Errorf("unknown chain purpose %d", purpose)
+	}
+	if hook == nil {
+		return nil, fmt.Errorf("hook cannot be nil")
+	}
+	h := *hook
+	switch family {
+	case nftables.TableFamilyIPv4, nftables.TableFamilyIPv6, nftables.TableFamilyINet:
+		switch purpose {
+		case PurposeRaw:
+			return nftables.ChainPriorityRaw, nil
+		case PurposeMangle:
+			return nftables.ChainPriorityMangle, nil
+		case PurposeDstNAT:
+			if h == unix.NF_INET_PRE_ROUTING || h == unix.NF_INET_LOCAL_OUT {
+				return nftables.ChainPriorityNATDest, nil
+			}
+		case PurposeFilter:
+			return nftables.ChainPriorityFilter, nil
+		case PurposeSecurity:
+			return nftables.ChainPrioritySecurity, nil
+		case PurposeSrcNAT:
+			if h == unix.NF_INET_POST_ROUTING || h == unix.NF_INET_LOCAL_IN {
+				return nftables.ChainPriorityNATSource, nil
+			}
+		}
+	case nftables.TableFamilyBridge:
+		switch purpose {
+		case PurposeDstNAT:
+			if h == unix.NF_INET_PRE_ROUTING {
+				return bridgePriorityDstNAT, nil
+			}
+		case PurposeFilter:
+			return bridgePriorityFilter, nil
+		case PurposeSrcNAT:
+			if h == unix.NF_INET_POST_ROUTING {
+				return bridgePrioritySrcNAT, nil
+			}
+		}
+	case nftables.TableFamilyARP, nftables.TableFamilyNetdev:
+		if purpose == PurposeFilter {
+			return nftables.ChainPriorityFilter, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported table family %d", family)
+	}
+
+	return nil, fmt.Errorf("%s priority is not available at hook %d of table family %s", name, h, familyNames[family])
+}
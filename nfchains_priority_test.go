@@ -0,0 +1,94 @@
+package nftableslib
+
+import (
+	"testing"
+
+	"github.com/google/nftables"
+)
+
+func TestPriorityFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		purpose  Purpose
+		hook     *nftables.ChainHook
+		family   nftables.TableFamily
+		priority nftables.ChainPriority
+		success  bool
+	}{
+		{
+			name:     "ipv4 dstnat at prerouting",
+			purpose:  PurposeDstNAT,
+			hook:     nftables.ChainHookPrerouting,
+			family:   nftables.TableFamilyIPv4,
+			priority: -100,
+			success:  true,
+		},
+		{
+			name:     "inet srcnat at postrouting",
+			purpose:  PurposeSrcNAT,
+			hook:     nftables.ChainHookPostrouting,
+			family:   nftables.TableFamilyINet,
+			priority: 100,
+			success:  true,
+		},
+		{
+			name:     "ipv6 raw at prerouting",
+			purpose:  PurposeRaw,
+			hook:     nftables.ChainHookPrerouting,
+			family:   nftables.TableFamilyIPv6,
+			priority: -300,
+			success:  true,
+		},
+		{
+			name:     "bridge filter at forward",
+			purpose:  PurposeFilter,
+			hook:     nftables.ChainHookForward,
+			family:   nftables.TableFamilyBridge,
+			priority: -200,
+			success:  true,
+		},
+		{
+			name:     "bridge dstnat at prerouting",
+			purpose:  PurposeDstNAT,
+			hook:     nftables.ChainHookPrerouting,
+			family:   nftables.TableFamilyBridge,
+			priority: -300,
+			success:  true,
+		},
+		{
+			name:    "ipv4 dstnat at postrouting",
+			purpose: PurposeDstNAT,
+			hook:    nftables.ChainHookPostrouting,
+			family:  nftables.TableFamilyIPv4,
+			success: false,
+		},
+		{
+			name:    "ipv4 srcnat at prerouting",
+			purpose: PurposeSrcNAT,
+			hook:    nftables.ChainHookPrerouting,
+			family:  nftables.TableFamilyIPv4,
+			success: false,
+		},
+		{
+			name:    "netdev mangle",
+			purpose: PurposeMangle,
+			hook:    nftables.ChainHookIngress,
+			family:  nftables.TableFamilyNetdev,
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		p, err := PriorityFor(tt.purpose, tt.hook, tt.family)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && *p != tt.priority {
+			t.Errorf("Test \"%s\" returned priority %d want: %d", tt.name, *p, tt.priority)
+		}
+	}
+}
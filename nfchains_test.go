@@ -1,9 +1,15 @@
 package nftableslib
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nltest"
+	"golang.org/x/sys/unix"
 )
 
 func TestChains(t *testing.T) {
@@ -63,3 +69,269 @@ func TestChains(t *testing.T) {
 		}
 	}
 }
+
+// listConn returns preconfigured chains and rules, other NetNS methods are not used
+type listConn struct {
+	NetNS
+	chains []*nftables.Chain
+	rules  map[string][]*nftables.Rule
+}
+
+func (l *listConn) ListChains() ([]*nftables.Chain, error) {
+	return l.chains, nil
+}
+
+func (l *listConn) GetRule(_ *nftables.Table, c *nftables.Chain) ([]*nftables.Rule, error) {
+	return l.rules[c.Name], nil
+}
+
+func TestGetAllRules(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	other := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv6}
+	conn := &listConn{
+		chains: []*nftables.Chain{
+			{Name: "input", Table: tbl},
+			{Name: "output", Table: tbl},
+			{Name: "forward", Table: other},
+		},
+		rules: map[string][]*nftables.Rule{
+			"input":   {{Handle: 1}, {Handle: 2, UserData: []byte("ssh")}},
+			"forward": {{Handle: 3}},
+		},
+	}
+	ti := InitNFTables(&applyConn{listConn: *conn})
+	if _, err := ti.Tables().GetAllRules("filter", nftables.TableFamilyIPv4); err == nil {
+		t.Fatalf("GetAllRules of missing table supposed to fail but succeeded")
+	}
+	if err := ti.Tables().Create("filter", nftables.TableFamilyIPv4); err != nil {
+		t.Fatalf("failed to create table with error: %+v", err)
+	}
+	rules, err := ti.Tables().GetAllRules("filter", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("GetAllRules failed with error: %+v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected rules of 2 chains, got rules of %d chains", len(rules))
+	}
+	if len(rules["input"]) != 2 || len(rules["output"]) != 0 {
+		t.Errorf("unexpected rules returned: %+v", rules)
+	}
+	if len(rules["input"]) == 2 && string(rules["input"][1].UserData) != "ssh" {
+		t.Errorf("rule programmed by other means does not carry its user data: %+v", rules["input"][1])
+	}
+	if _, ok := rules["forward"]; ok {
+		t.Errorf("rules of chain of another table must not be returned")
+	}
+	// Rules created by the library are returned as they were specified
+	ci, err := ti.Tables().Table("filter", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("failed to get table with error: %+v", err)
+	}
+	if err := ci.Chains().Create("output", nil); err != nil {
+		t.Fatalf("failed to create chain with error: %+v", err)
+	}
+	ri, err := ci.Chains().Chain("output")
+	if err != nil {
+		t.Fatalf("failed to get chain with error: %+v", err)
+	}
+	spec := &Rule{Counter: &Counter{}, Action: setActionVerdict(t, NFT_ACCEPT)}
+	id, err := ri.Rules().Create(spec)
+	if err != nil {
+		t.Fatalf("failed to create rule with error: %+v", err)
+	}
+	conn.rules["output"] = []*nftables.Rule{{Handle: 4, UserData: withRuleID(nil, id)}}
+	rules, err = ti.Tables().GetAllRules("filter", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("GetAllRules failed with error: %+v", err)
+	}
+	want := spec.Clone()
+	want.Handle = 4
+	if len(rules["output"]) != 1 || !reflect.DeepEqual(rules["output"][0], want) {
+		t.Errorf("rule created by the library is not returned as specified: %+v", rules["output"])
+	}
+}
+
+func TestNetdevChainAttributes(t *testing.T) {
+	tbl := &nftables.Table{Name: "ingress", Family: nftables.TableFamilyNetdev}
+	ci := newChains(&applyConn{}, tbl, Options{})
+	tests := []struct {
+		name    string
+		chain   string
+		attrs   *ChainAttributes
+		success bool
+	}{
+		{
+			name:    "Ingress hook with raw priority",
+			chain:   "chain-1",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookIngress, Priority: nftables.ChainPriorityRef(-150), Device: "eth0"},
+			success: true,
+		},
+		{
+			name:    "Second chain on the same device with another priority",
+			chain:   "chain-2",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookIngress, Priority: nftables.ChainPriorityRef(-140), Device: "eth0"},
+			success: true,
+		},
+		{
+			name:    "Same priority on another device",
+			chain:   "chain-3",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookIngress, Priority: nftables.ChainPriorityRef(-150), Device: "eth1"},
+			success: true,
+		},
+		{
+			name:    "Same priority on the same device",
+			chain:   "chain-10",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookIngress, Priority: nftables.ChainPriorityRef(-140), Device: "eth0"},
+			success: false,
+		},
+		{
+			name:    "Same priority on the same device at another hook",
+			chain:   "chain-11",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: ChainHookEgress, Priority: nftables.ChainPriorityRef(-150), Device: "eth0"},
+			success: true,
+		},
+		{
+			name:    "Egress hook",
+			chain:   "chain-4",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: ChainHookEgress, Priority: nftables.ChainPriorityFilter, Device: "eth0"},
+			success: true,
+		},
+		{
+			name:    "Ingress hook without device",
+			chain:   "chain-5",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookIngress, Priority: nftables.ChainPriorityFilter},
+			success: false,
+		},
+		{
+			name:    "Egress hook without device",
+			chain:   "chain-6",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: ChainHookEgress, Priority: nftables.ChainPriorityFilter},
+			success: false,
+		},
+		{
+			name:    "Postrouting hook",
+			chain:   "chain-7",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookPostrouting, Priority: nftables.ChainPriorityFilter, Device: "eth0"},
+			success: false,
+		},
+		{
+			name:    "Nat chain type",
+			chain:   "chain-8",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeNAT, Hook: ChainHookEgress, Priority: nftables.ChainPriorityFilter, Device: "eth0"},
+			success: false,
+		},
+		{
+			name:    "No priority",
+			chain:   "chain-9",
+			attrs:   &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookIngress, Device: "eth2"},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		err := ci.Chains().Create(tt.chain, tt.attrs)
+		if tt.success && err != nil {
+			t.Errorf("test: %s failed with error: %+v", tt.name, err)
+		}
+		if !tt.success && err == nil {
+			t.Errorf("test: \"%s\" passed validation but supposed to fail", tt.name)
+		}
+	}
+	ch, ok := ci.(*nfChains).chains["chain-1"]
+	if !ok {
+		t.Fatalf("chain-1 was not added to the store")
+	}
+	if ch.chain.Device != "eth0" {
+		t.Errorf("chain-1 is bound to device %q instead of eth0", ch.chain.Device)
+	}
+}
+
+func TestChainAttributes(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	drop := nftables.ChainPolicyDrop
+	conn := &listConn{
+		chains: []*nftables.Chain{
+			{
+				Name:     "input",
+				Table:    tbl,
+				Type:     nftables.ChainTypeFilter,
+				Hooknum:  nftables.ChainHookInput,
+				Priority: nftables.ChainPriorityFilter,
+				Policy:   &drop,
+			},
+			{Name: "regular", Table: tbl},
+		},
+	}
+	ci := newChains(conn, tbl, Options{})
+	attrs, err := ci.Chains().ChainAttributes("input")
+	if err != nil {
+		t.Fatalf("ChainAttributes failed with error: %+v", err)
+	}
+	policy := ChainPolicyDrop
+	expect := &ChainAttributes{
+		Type:     nftables.ChainTypeFilter,
+		Hook:     nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   &policy,
+	}
+	if !reflect.DeepEqual(attrs, expect) {
+		t.Errorf("ChainAttributes returned %+v want: %+v", attrs, expect)
+	}
+	if attrs, err := ci.Chains().ChainAttributes("regular"); err != nil || attrs != nil {
+		t.Errorf("ChainAttributes of regular chain returned %+v and error: %+v", attrs, err)
+	}
+	if _, err := ci.Chains().ChainAttributes("missing"); err == nil {
+		t.Errorf("ChainAttributes of missing chain supposed to fail but succeeded")
+	}
+}
+
+func TestTableRules(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	rule := func(chain string, handle uint64) netlink.Message {
+		return netlink.Message{
+			Header: netlink.Header{
+				Type:  netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | unix.NFT_MSG_NEWRULE),
+				Flags: netlink.Multi,
+			},
+			Data: append([]byte{byte(tbl.Family), unix.NFNETLINK_V0, 0, 0}, nltest.MustMarshalAttributes([]netlink.Attribute{
+				{Type: unix.NFTA_RULE_TABLE, Data: []byte(tbl.Name + "\x00")},
+				{Type: unix.NFTA_RULE_CHAIN, Data: []byte(chain + "\x00")},
+				{Type: unix.NFTA_RULE_HANDLE, Data: binaryutil.BigEndian.PutUint64(handle)},
+			})...),
+		}
+	}
+	requests := 0
+	conn, err := nftables.New(nftables.WithTestDial(func(req []netlink.Message) ([]netlink.Message, error) {
+		requests++
+		if len(req) != 1 || req[0].Header.Type != netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8|unix.NFT_MSG_GETRULE) {
+			return nil, fmt.Errorf("unexpected request %+v", req)
+		}
+		ad, err := netlink.NewAttributeDecoder(req[0].Data[4:])
+		if err != nil {
+			return nil, err
+		}
+		for ad.Next() {
+			if ad.Type() == unix.NFTA_RULE_CHAIN {
+				return nil, fmt.Errorf("rules dump must not be filtered by the chain")
+			}
+		}
+		done := netlink.Message{Header: netlink.Header{Type: netlink.Done, Flags: netlink.Multi}, Data: make([]byte, 4)}
+		return []netlink.Message{rule("input", 1), rule("output", 2), rule("input", 3), done}, nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connection with error: %+v", err)
+	}
+	rules, err := tableRules(conn, tbl, []*nftables.Chain{{Name: "input", Table: tbl}, {Name: "output", Table: tbl}})
+	if err != nil {
+		t.Fatalf("tableRules failed with error: %+v", err)
+	}
+	if requests != 1 {
+		t.Errorf("expected a single rules dump, got %d requests", requests)
+	}
+	var got []string
+	for _, r := range rules {
+		got = append(got, fmt.Sprintf("%s/%d", r.Chain.Name, r.Handle))
+	}
+	if want := []string{"input/1", "output/2", "input/3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected rules %v, got %v", want, got)
+	}
+}
@@ -1,8 +1,17 @@
 package nftableslib
 
-import "github.com/google/nftables"
+import (
+	"errors"
+	"fmt"
+	"sync"
 
-// InitConn initializes netlink connection of the nftables family
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+// InitConn initializes netlink connection of the nftables family, the connection does not communicate
+// with the kernel until the first operation, EPERM errors of the operations performed through
+// InitNFTables are reported as ErrInsufficientPrivileges and EOPNOTSUPP errors as ErrFeatureUnsupported.
 func InitConn(netns ...int) *nftables.Conn {
 	// if netns is not specified, global namespace is used
 	if len(netns) != 0 {
@@ -11,13 +20,167 @@ func InitConn(netns ...int) *nftables.Conn {
 	return &nftables.Conn{}
 }
 
-// InitNFTables initializes netlink connection of the nftables family
-func InitNFTables(conn NetNS) TablesInterface {
+// Options defines connection level options which are applied to all tables, chains and rules
+// created through the connection.
+type Options struct {
+	// RequireNamedSets when set to true, forbids automatic creation of sets for multi-element
+	// lists of addresses and ports, such lists must reference an already existing named set.
+	RequireNamedSets bool
+	// TTLExpired when not nil, is called with the result of the removal of a rule created by CreateWithTTL
+	// once its ttl expires, example: logging a temporary block which is still programmed.
+	TTLExpired func(table, chain string, handle uint64, err error)
+	// caps is the capabilities probe of the connection, it is set by InitNFTables
+	caps *capsProbe
+}
+
+// InitNFTables initializes netlink connection of the nftables family. Tables, Chains, Sets and Rules interfaces
+// returned by it are safe for concurrent use, for example one goroutine can read the store while another one
+// reconciles it. Operations without Imm suffix only queue messages on conn which is shared by the whole tree,
+// they are sent by the next Flush issued by any goroutine, batches of concurrent writers can be mixed up,
+// hence batched changes should be issued by a single writer while others use Imm operations or read.
+func InitNFTables(conn NetNS, opts ...Options) TablesInterface {
 	// if netns is not specified, global namespace is used
 	ts := nfTables{
 		tables: make(map[nftables.TableFamily]map[string]*nfTable),
 	}
-	ts.conn = conn
+	ts.conn = &privConn{NetNS: conn, redial: redialer(conn)}
+	if len(opts) != 0 {
+		ts.opts = opts[0]
+	}
+	ts.opts.caps = &capsProbe{conn: ts.conn}
 
 	return &ts
 }
+
+// isNoBuffers returns true if the error indicates that netlink socket ran out of the receive buffer space.
+// It happens when acknowledgements of a large batch overflow the socket's buffer, the batch itself
+// is still processed by the kernel, hence the result of the operation must be verified by reading it back.
+func isNoBuffers(err error) bool {
+	return errors.Is(err, unix.ENOBUFS)
+}
+
+// ErrInsufficientPrivileges is returned when the kernel rejects an nftables operation with EPERM,
+// programming and listing nftables requires CAP_NET_ADMIN capability in the network namespace of the connection.
+var ErrInsufficientPrivileges = errors.New("insufficient privileges, nftables operations require CAP_NET_ADMIN capability")
+
+// privilegesError wraps EPERM error, errors.Is matches both ErrInsufficientPrivileges and the original error.
+type privilegesError struct {
+	err error
+}
+
+func (e *privilegesError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrInsufficientPrivileges.Error(), e.err)
+}
+
+func (e *privilegesError) Unwrap() error {
+	return e.err
+}
+
+func (e *privilegesError) Is(target error) bool {
+	return target == ErrInsufficientPrivileges
+}
+
+// wrapPermErr wraps EPERM error into ErrInsufficientPrivileges, other errors are returned as is.
+func wrapPermErr(err error) error {
+	if err == nil || !errors.Is(err, unix.EPERM) || errors.Is(err, ErrInsufficientPrivileges) {
+		return err
+	}
+
+	return &privilegesError{err: err}
+}
+
+// ErrFeatureUnsupported is returned when the kernel rejects an nftables operation with EOPNOTSUPP,
+// the running kernel does not support an expression, a hook or an attribute of the operation.
+var ErrFeatureUnsupported = errors.New("feature is not supported by the running kernel")
+
+// featureError wraps EOPNOTSUPP error, errors.Is matches both ErrFeatureUnsupported and the original error.
+type featureError struct {
+	err error
+}
+
+func (e *featureError) Error() string {
+	return fmt.Sprintf("%s: %v", ErrFeatureUnsupported.Error(), e.err)
+}
+
+func (e *featureError) Unwrap() error {
+	return e.err
+}
+
+func (e *featureError) Is(target error) bool {
+	return target == ErrFeatureUnsupported
+}
+
+// wrapFeatureErr wraps EOPNOTSUPP error into ErrFeatureUnsupported, other errors are returned as is.
+func wrapFeatureErr(err error) error {
+	if err == nil || !errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, ErrFeatureUnsupported) {
+		return err
+	}
+
+	return &featureError{err: err}
+}
+
+// wrapConnErr wraps errors of operations communicating with the kernel into the library's errors
+func wrapConnErr(err error) error {
+	return wrapFeatureErr(wrapPermErr(err))
+}
+
+// privConn wraps errors of operations communicating with the kernel, so EPERM caused by
+// the missing CAP_NET_ADMIN capability is reported as ErrInsufficientPrivileges and EOPNOTSUPP
+// caused by a feature missing in the running kernel as ErrFeatureUnsupported. It also records
+// operations queued since the last Flush to recover from ENOBUFS returned by Flush.
+type privConn struct {
+	NetNS
+	sync.Mutex
+	queued []queuedOp
+	// redial dials a new connection with the socket's receive buffer of a given size,
+	// it is nil when the connection cannot be dialed again.
+	redial func(int) (NetNS, error)
+}
+
+func (c *privConn) Flush() error {
+	c.Lock()
+	defer c.Unlock()
+	queued := c.queued
+	c.queued = nil
+	err := c.NetNS.Flush()
+	if isNoBuffers(err) {
+		err = c.recoverFlush(queued, err)
+	}
+
+	return wrapConnErr(err)
+}
+
+func (c *privConn) ListTables() ([]*nftables.Table, error) {
+	tables, err := c.NetNS.ListTables()
+	return tables, wrapConnErr(err)
+}
+
+func (c *privConn) ListChains() ([]*nftables.Chain, error) {
+	chains, err := c.NetNS.ListChains()
+	return chains, wrapConnErr(err)
+}
+
+func (c *privConn) GetRule(t *nftables.Table, ch *nftables.Chain) ([]*nftables.Rule, error) {
+	rules, err := c.NetNS.GetRule(t, ch)
+	return rules, wrapConnErr(err)
+}
+
+func (c *privConn) GetSets(t *nftables.Table) ([]*nftables.Set, error) {
+	sets, err := c.NetNS.GetSets(t)
+	return sets, wrapConnErr(err)
+}
+
+func (c *privConn) GetSetByName(t *nftables.Table, name string) (*nftables.Set, error) {
+	set, err := c.NetNS.GetSetByName(t, name)
+	return set, wrapConnErr(err)
+}
+
+func (c *privConn) GetSetElements(s *nftables.Set) ([]nftables.SetElement, error) {
+	elements, err := c.NetNS.GetSetElements(s)
+	return elements, wrapConnErr(err)
+}
+
+func (c *privConn) GetObjects(t *nftables.Table) ([]nftables.Obj, error) {
+	objs, err := c.NetNS.GetObjects(t)
+	return objs, wrapConnErr(err)
+}
@@ -0,0 +1,246 @@
+package nftableslib
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/userdata"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nltest"
+	"golang.org/x/sys/unix"
+)
+
+// kernelConn returns the connection to the kernel conn carries, nil is returned if conn is not
+// a connection to the kernel, for example a mock.
+func kernelConn(conn NetNS) *nftables.Conn {
+	if pc, ok := conn.(*privConn); ok {
+		conn = pc.NetNS
+	}
+	c, _ := conn.(*nftables.Conn)
+
+	return c
+}
+
+// dial returns a new netlink connection to the network namespace of c
+func dial(c *nftables.Conn) (*netlink.Conn, error) {
+	if c.TestDial != nil {
+		return nltest.Dial(c.TestDial), nil
+	}
+
+	return netlink.Dial(unix.NETLINK_NETFILTER, &netlink.Config{NetNS: c.NetNS})
+}
+
+// dump sends a dump request github.com/google/nftables does not provide over a new netlink connection to the network
+// namespace of c and returns the reply.
+func dump(c *nftables.Conn, msgType uint16, family nftables.TableFamily, attrs []netlink.Attribute) ([]netlink.Message, error) {
+	nl, err := dial(c)
+	if err != nil {
+		return nil, err
+	}
+	defer nl.Close()
+	data, err := netlink.MarshalAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+	// nfgenmsg header carries the family, the version and the resource id
+	hdr := []byte{byte(family), unix.NFNETLINK_V0, 0, 0}
+	req := netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | msgType),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		Data: append(hdr, data...),
+	}
+	if _, err := nl.Send(req); err != nil {
+		return nil, err
+	}
+
+	return nl.Receive()
+}
+
+// message returns a message of msgType of nftables subsystem carrying encoded attributes, example: a message of a batch
+func message(msgType uint16, flags netlink.HeaderFlags, family nftables.TableFamily, data []byte) netlink.Message {
+	return netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | msgType),
+			Flags: netlink.Request | netlink.Acknowledge | flags,
+		},
+		Data: append([]byte{byte(family), unix.NFNETLINK_V0, 0, 0}, data...),
+	}
+}
+
+// batch sends messages github.com/google/nftables cannot build in a single batch over a new netlink connection
+// to the network namespace of c, the kernel programs the batch atomically. Each message is acknowledged, the first
+// error reported by the kernel is returned once replies to all messages are received.
+func batch(c *nftables.Conn, msgs []netlink.Message) error {
+	nl, err := dial(c)
+	if err != nil {
+		return err
+	}
+	defer nl.Close()
+	b := make([]netlink.Message, 0, len(msgs)+2)
+	b = append(b, netlink.Message{
+		Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_MSG_BATCH_BEGIN), Flags: netlink.Request},
+		// Resource id of the batch is the subsystem, it is carried in network byte order
+		Data: []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, unix.NFNL_SUBSYS_NFTABLES},
+	})
+	b = append(b, msgs...)
+	b = append(b, netlink.Message{
+		Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_MSG_BATCH_END), Flags: netlink.Request},
+		Data:   []byte{unix.AF_UNSPEC, unix.NFNETLINK_V0, 0, unix.NFNL_SUBSYS_NFTABLES},
+	})
+	if _, err := nl.SendMessages(b); err != nil {
+		return err
+	}
+	var first error
+	for received := 0; received < len(msgs); {
+		replies, err := nl.Receive()
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			received++
+			continue
+		}
+		if len(replies) == 0 {
+			break
+		}
+		received += len(replies)
+	}
+
+	return first
+}
+
+// setComments returns comments of the table's sets keyed by the set name. github.com/google/nftables sends
+// the comment of a set but does not decode it, hence sets are dumped by the library, comments of sets
+// are used as is when conn is not a connection to the kernel.
+func setComments(conn NetNS, t *nftables.Table, sets []*nftables.Set) (map[string]string, error) {
+	comments := make(map[string]string)
+	c := kernelConn(conn)
+	if c == nil {
+		for _, s := range sets {
+			comments[s.Name] = s.Comment
+		}
+		return comments, nil
+	}
+	msgs, err := dump(c, unix.NFT_MSG_GETSET, t.Family, []netlink.Attribute{
+		{Type: unix.NFTA_SET_TABLE, Data: []byte(t.Name + "\x00")},
+	})
+	if err != nil {
+		return nil, wrapConnErr(fmt.Errorf("failed to dump sets of table %s with error: %w", t.Name, err))
+	}
+	for _, msg := range msgs {
+		if len(msg.Data) < 4 {
+			continue
+		}
+		ad, err := netlink.NewAttributeDecoder(msg.Data[4:])
+		if err != nil {
+			return nil, err
+		}
+		var name, comment string
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.NFTA_SET_NAME:
+				name = ad.String()
+			case unix.NFTA_SET_USERDATA:
+				comment, _ = userdata.GetString(ad.Bytes(), userdata.NFTNL_UDATA_SET_COMMENT)
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+		comments[name] = comment
+	}
+
+	return comments, nil
+}
+
+// tableRules returns rules of all chains of the table read by a single rules dump filtered by the table,
+// hence rules of all chains come from the same generation of the ruleset. github.com/google/nftables always
+// filters rules by the chain, the dump is sent by the library and its reply is decoded by github.com/google/nftables.
+// Rules of each of chains are requested separately when conn is not a connection to the kernel, for example a mock.
+func tableRules(conn NetNS, t *nftables.Table, chains []*nftables.Chain) ([]*nftables.Rule, error) {
+	c := kernelConn(conn)
+	if c == nil {
+		var rules []*nftables.Rule
+		for _, chain := range chains {
+			r, err := conn.GetRule(t, chain)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get rules of chain %s with error: %+v", chain.Name, err)
+			}
+			for _, rule := range r {
+				rule.Chain = chain
+			}
+			rules = append(rules, r...)
+		}
+		return rules, nil
+	}
+	msgs, err := dump(c, unix.NFT_MSG_GETRULE, t.Family, []netlink.Attribute{
+		{Type: unix.NFTA_RULE_TABLE, Data: []byte(t.Name + "\x00")},
+	})
+	if err != nil {
+		return nil, wrapConnErr(fmt.Errorf("failed to dump rules of table %s with error: %w", t.Name, err))
+	}
+	for i := range msgs {
+		// The reply is decoded as a reply of a single message
+		msgs[i].Header.Flags &^= netlink.Multi
+	}
+	decoder, err := nftables.New(nftables.WithTestDial(func(_ []netlink.Message) ([]netlink.Message, error) {
+		return msgs, nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	return decoder.GetRules(t, &nftables.Chain{Table: t})
+}
+
+// nftaTableHandle is NFTA_TABLE_HANDLE attribute of linux/netfilter/nf_tables.h, it is missing in golang.org/x/sys/unix
+const nftaTableHandle = 0x4
+
+// tableHandle identifies a table programmed on the host by its handle
+type tableHandle struct {
+	name   string
+	family nftables.TableFamily
+	handle uint64
+}
+
+// tableHandles returns handles of tables of all families programmed on the host, github.com/google/nftables
+// does not decode NFTA_TABLE_HANDLE, hence tables are dumped by the library.
+func tableHandles(conn NetNS) ([]tableHandle, error) {
+	c := kernelConn(conn)
+	if c == nil {
+		return nil, fmt.Errorf("table handles can be read only through a connection to the kernel")
+	}
+	msgs, err := dump(c, unix.NFT_MSG_GETTABLE, nftables.TableFamilyUnspecified, nil)
+	if err != nil {
+		return nil, wrapConnErr(fmt.Errorf("failed to dump tables with error: %w", err))
+	}
+	handles := make([]tableHandle, 0, len(msgs))
+	for _, msg := range msgs {
+		if len(msg.Data) < 4 {
+			continue
+		}
+		ad, err := netlink.NewAttributeDecoder(msg.Data[4:])
+		if err != nil {
+			return nil, err
+		}
+		ad.ByteOrder = binary.BigEndian
+		th := tableHandle{family: nftables.TableFamily(msg.Data[0])}
+		for ad.Next() {
+			switch ad.Type() {
+			case unix.NFTA_TABLE_NAME:
+				th.name = ad.String()
+			case nftaTableHandle:
+				th.handle = ad.Uint64()
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return nil, err
+		}
+		handles = append(handles, th)
+	}
+
+	return handles, nil
+}
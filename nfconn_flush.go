@@ -0,0 +1,395 @@
+package nftableslib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// flushReadBuffer defines the size of the socket's receive buffer of the connection a batch is sent again
+// over after Flush failed with ENOBUFS.
+const flushReadBuffer = 16 << 20
+
+// queuedOp is an operation queued on the connection since the last Flush
+type queuedOp struct {
+	// queue queues the operation again on conn
+	queue func(conn NetNS) error
+	// done returns true if the host carries the result of the operation, it is nil when
+	// the result of the operation cannot be checked.
+	done func(conn NetNS) (bool, error)
+}
+
+// recoverFlush recovers from ENOBUFS returned by Flush of the batch of queued operations. The kernel programs
+// a batch atomically, ENOBUFS means that acknowledgements of the batch overflowed the socket's receive buffer,
+// hence the result is read back from the host. If the host carries results of all operations which can be
+// checked, the batch was programmed. If a result is missing, the batch was not programmed and it is sent again
+// over a new connection with a larger receive buffer. err is returned if the batch carries no operation
+// which can be checked or the connection cannot be dialed again.
+func (c *privConn) recoverFlush(queued []queuedOp, err error) error {
+	programmed, cerr := batchProgrammed(c.NetNS, queued)
+	if cerr != nil {
+		return err
+	}
+	if programmed {
+		return nil
+	}
+	if c.redial == nil {
+		return err
+	}
+	conn, derr := c.redial(flushReadBuffer)
+	if derr != nil {
+		return err
+	}
+	for _, op := range queued {
+		if qerr := op.queue(conn); qerr != nil {
+			return qerr
+		}
+	}
+
+	return conn.Flush()
+}
+
+// batchProgrammed returns true if the host carries results of all operations of the batch which can be checked,
+// an error is returned if none of the operations can be checked.
+func batchProgrammed(conn NetNS, queued []queuedOp) (bool, error) {
+	checked := false
+	for _, op := range queued {
+		if op.done == nil {
+			continue
+		}
+		done, err := op.done(conn)
+		if err != nil {
+			return false, err
+		}
+		if !done {
+			return false, nil
+		}
+		checked = true
+	}
+	if !checked {
+		return false, fmt.Errorf("none of the operations of the batch can be checked")
+	}
+
+	return true, nil
+}
+
+// redialer returns a function dialing a new connection to the network namespace of conn with the socket's
+// receive buffer of a given size, nil is returned if conn is not a connection to the kernel.
+func redialer(conn NetNS) func(int) (NetNS, error) {
+	c, ok := conn.(*nftables.Conn)
+	if !ok || c.TestDial != nil {
+		return nil
+	}
+	return func(size int) (NetNS, error) {
+		return nftables.New(nftables.WithNetNSFd(c.NetNS), nftables.WithSockOptions(setReadBuffer(size)))
+	}
+}
+
+// setReadBuffer sets the size of the socket's receive buffer, SO_RCVBUFFORCE is not limited by
+// net.core.rmem_max but it requires CAP_NET_ADMIN, SO_RCVBUF is used when it fails.
+func setReadBuffer(size int) nftables.SockOption {
+	return func(nl *netlink.Conn) error {
+		rc, err := nl.SyscallConn()
+		if err != nil {
+			return nl.SetReadBuffer(size)
+		}
+		var serr error
+		if err := rc.Control(func(fd uintptr) {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, size)
+		}); err != nil || serr != nil {
+			return nl.SetReadBuffer(size)
+		}
+
+		return nil
+	}
+}
+
+// queue queues the operation on the connection and records it
+func (c *privConn) queue(op queuedOp) error {
+	if err := op.queue(c.NetNS); err != nil {
+		return err
+	}
+	c.queued = append(c.queued, op)
+
+	return nil
+}
+
+func (c *privConn) FlushRuleset() {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.FlushRuleset(); return nil },
+	})
+}
+
+func (c *privConn) AddTable(t *nftables.Table) *nftables.Table {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.AddTable(t); return nil },
+		done:  func(conn NetNS) (bool, error) { return hasTable(conn, t) },
+	})
+
+	return t
+}
+
+func (c *privConn) DelTable(t *nftables.Table) {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.DelTable(t); return nil },
+		done: func(conn NetNS) (bool, error) {
+			ok, err := hasTable(conn, t)
+			return !ok, err
+		},
+	})
+}
+
+func (c *privConn) FlushTable(t *nftables.Table) {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.FlushTable(t); return nil },
+	})
+}
+
+func (c *privConn) AddChain(ch *nftables.Chain) *nftables.Chain {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.AddChain(ch); return nil },
+		done:  func(conn NetNS) (bool, error) { return hasChain(conn, ch) },
+	})
+
+	return ch
+}
+
+func (c *privConn) DelChain(ch *nftables.Chain) {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.DelChain(ch); return nil },
+		done: func(conn NetNS) (bool, error) {
+			ok, err := hasChain(conn, ch)
+			return !ok, err
+		},
+	})
+}
+
+func (c *privConn) AddRule(r *nftables.Rule) *nftables.Rule {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.AddRule(r); return nil },
+		done:  ruleDone(r, false),
+	})
+
+	return r
+}
+
+func (c *privConn) InsertRule(r *nftables.Rule) *nftables.Rule {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.InsertRule(r); return nil },
+		done:  ruleDone(r, false),
+	})
+
+	return r
+}
+
+func (c *privConn) ReplaceRule(r *nftables.Rule) *nftables.Rule {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.ReplaceRule(r); return nil },
+		done:  ruleDone(r, true),
+	})
+
+	return r
+}
+
+func (c *privConn) DelRule(r *nftables.Rule) error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.queue(queuedOp{
+		queue: func(conn NetNS) error { return conn.DelRule(r) },
+		done: func(conn NetNS) (bool, error) {
+			rules, err := conn.GetRule(r.Table, r.Chain)
+			if err != nil {
+				return false, err
+			}
+			for _, hr := range rules {
+				if hr.Handle == r.Handle {
+					return false, nil
+				}
+			}
+			return true, nil
+		},
+	})
+}
+
+func (c *privConn) AddSet(s *nftables.Set, elements []nftables.SetElement) error {
+	c.Lock()
+	defer c.Unlock()
+	op := queuedOp{
+		queue: func(conn NetNS) error { return conn.AddSet(s, elements) },
+	}
+	// Names of anonymous sets are allocated by the kernel
+	if !s.Anonymous {
+		op.done = func(conn NetNS) (bool, error) { return hasSet(conn, s) }
+	}
+
+	return c.queue(op)
+}
+
+func (c *privConn) DelSet(s *nftables.Set) {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.DelSet(s); return nil },
+		done: func(conn NetNS) (bool, error) {
+			ok, err := hasSet(conn, s)
+			return !ok, err
+		},
+	})
+}
+
+func (c *privConn) FlushSet(s *nftables.Set) {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.FlushSet(s); return nil },
+	})
+}
+
+func (c *privConn) SetAddElements(s *nftables.Set, elements []nftables.SetElement) error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.queue(queuedOp{
+		queue: func(conn NetNS) error { return conn.SetAddElements(s, elements) },
+		done:  elementsDone(s, elements, true),
+	})
+}
+
+func (c *privConn) SetDeleteElements(s *nftables.Set, elements []nftables.SetElement) error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.queue(queuedOp{
+		queue: func(conn NetNS) error { return conn.SetDeleteElements(s, elements) },
+		done:  elementsDone(s, elements, false),
+	})
+}
+
+func (c *privConn) AddObj(o nftables.Obj) nftables.Obj {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.AddObj(o); return nil },
+	})
+
+	return o
+}
+
+func (c *privConn) DeleteObject(o nftables.Obj) {
+	c.Lock()
+	defer c.Unlock()
+	c.queue(queuedOp{
+		queue: func(conn NetNS) error { conn.DeleteObject(o); return nil },
+	})
+}
+
+func hasTable(conn NetNS, t *nftables.Table) (bool, error) {
+	tables, err := conn.ListTables()
+	if err != nil {
+		return false, err
+	}
+	for _, ht := range tables {
+		if ht.Family == t.Family && ht.Name == t.Name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func hasChain(conn NetNS, ch *nftables.Chain) (bool, error) {
+	chains, err := conn.ListChains()
+	if err != nil {
+		return false, err
+	}
+	for _, hc := range chains {
+		if hc.Name == ch.Name && hc.Table.Family == ch.Table.Family && hc.Table.Name == ch.Table.Name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func hasSet(conn NetNS, s *nftables.Set) (bool, error) {
+	_, err := conn.GetSetByName(s.Table, s.Name)
+	if errors.Is(err, unix.ENOENT) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// ruleDone returns a function checking that the chain carries the rule, rules are matched by UserData
+// which carries the rule's id for rules created by the library, replaced rules are also matched by the handle.
+// nil is returned for rules without UserData.
+func ruleDone(r *nftables.Rule, replace bool) func(NetNS) (bool, error) {
+	if len(r.UserData) == 0 {
+		return nil
+	}
+	return func(conn NetNS) (bool, error) {
+		rules, err := conn.GetRule(r.Table, r.Chain)
+		if err != nil {
+			return false, err
+		}
+		for _, hr := range rules {
+			if replace && hr.Handle != r.Handle {
+				continue
+			}
+			if bytes.Equal(hr.UserData, r.UserData) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// elementsDone returns a function checking that the set carries keys of all elements when add is true,
+// or none of them otherwise. Interval ends are not checked.
+func elementsDone(s *nftables.Set, elements []nftables.SetElement, add bool) func(NetNS) (bool, error) {
+	return func(conn NetNS) (bool, error) {
+		hes, err := conn.GetSetElements(s)
+		if err != nil {
+			return false, err
+		}
+		for _, e := range elements {
+			if e.IntervalEnd {
+				continue
+			}
+			found := false
+			for _, he := range hes {
+				if bytes.Equal(he.Key, e.Key) {
+					found = true
+					break
+				}
+			}
+			if found != add {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
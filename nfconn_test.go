@@ -0,0 +1,257 @@
+package nftableslib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestIsNoBuffers(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "Wrapped ENOBUFS",
+			err:  fmt.Errorf("conn.Receive: %w", os.NewSyscallError("recvmsg", unix.ENOBUFS)),
+			want: true,
+		},
+		{
+			name: "Other error",
+			err:  fmt.Errorf("conn.Receive: %w", unix.EINVAL),
+			want: false,
+		},
+		{
+			name: "Nil error",
+			err:  nil,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		if got := isNoBuffers(tt.err); got != tt.want {
+			t.Errorf("Test \"%s\" failed, got: %t want: %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWrapPermErr(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantPriv  bool
+		wantEPERM bool
+	}{
+		{
+			name:      "Wrapped EPERM",
+			err:       fmt.Errorf("conn.Receive: %w", os.NewSyscallError("recvmsg", unix.EPERM)),
+			wantPriv:  true,
+			wantEPERM: true,
+		},
+		{
+			name: "Other error",
+			err:  fmt.Errorf("conn.Receive: %w", unix.EINVAL),
+		},
+	}
+	for _, tt := range tests {
+		err := wrapPermErr(tt.err)
+		if got := errors.Is(err, ErrInsufficientPrivileges); got != tt.wantPriv {
+			t.Errorf("Test \"%s\" failed, ErrInsufficientPrivileges match: %t want: %t", tt.name, got, tt.wantPriv)
+		}
+		if got := errors.Is(err, unix.EPERM); got != tt.wantEPERM {
+			t.Errorf("Test \"%s\" failed, EPERM match: %t want: %t", tt.name, got, tt.wantEPERM)
+		}
+	}
+	if err := wrapPermErr(nil); err != nil {
+		t.Errorf("wrapPermErr of nil error returned %+v", err)
+	}
+}
+
+type epermConn struct {
+	NetNS
+}
+
+func (c *epermConn) Flush() error {
+	return fmt.Errorf("conn.Receive: %w", unix.EPERM)
+}
+
+func (c *epermConn) AddTable(t *nftables.Table) *nftables.Table {
+	return t
+}
+
+func (c *epermConn) AddChain(ch *nftables.Chain) *nftables.Chain {
+	return ch
+}
+
+func TestInsufficientPrivileges(t *testing.T) {
+	ti := InitNFTables(&epermConn{})
+	if err := ti.Tables().Create("filter", nftables.TableFamilyIPv4); err != nil {
+		t.Fatalf("failed to create table with error: %+v", err)
+	}
+	ci, err := ti.Tables().Table("filter", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("failed to get table with error: %+v", err)
+	}
+	err = ci.Chains().CreateImm("regular", nil)
+	if !errors.Is(err, ErrInsufficientPrivileges) {
+		t.Errorf("chain creation without privileges returned error %+v, want ErrInsufficientPrivileges", err)
+	}
+}
+
+func TestWrapFeatureErr(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantFeature bool
+		wantPriv    bool
+	}{
+		{
+			name:        "Wrapped EOPNOTSUPP",
+			err:         fmt.Errorf("conn.Receive: %w", os.NewSyscallError("recvmsg", unix.EOPNOTSUPP)),
+			wantFeature: true,
+		},
+		{
+			name:     "Wrapped EPERM",
+			err:      fmt.Errorf("conn.Receive: %w", unix.EPERM),
+			wantPriv: true,
+		},
+		{
+			name: "Other error",
+			err:  fmt.Errorf("conn.Receive: %w", unix.EINVAL),
+		},
+	}
+	for _, tt := range tests {
+		err := wrapConnErr(tt.err)
+		if got := errors.Is(err, ErrFeatureUnsupported); got != tt.wantFeature {
+			t.Errorf("Test \"%s\" failed, ErrFeatureUnsupported match: %t want: %t", tt.name, got, tt.wantFeature)
+		}
+		if got := errors.Is(err, ErrInsufficientPrivileges); got != tt.wantPriv {
+			t.Errorf("Test \"%s\" failed, ErrInsufficientPrivileges match: %t want: %t", tt.name, got, tt.wantPriv)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Test \"%s\" failed, wrapped error %+v does not match the original error", tt.name, err)
+		}
+	}
+	if err := wrapFeatureErr(nil); err != nil {
+		t.Errorf("wrapFeatureErr of nil error returned %+v", err)
+	}
+}
+
+// nobufsConn programs chains of the host, Flush fails with ENOBUFS, the batch is programmed when commit is true
+type nobufsConn struct {
+	NetNS
+	commit bool
+	queued []*nftables.Chain
+	chains []*nftables.Chain
+}
+
+func (n *nobufsConn) AddChain(c *nftables.Chain) *nftables.Chain {
+	n.queued = append(n.queued, c)
+	return c
+}
+
+func (n *nobufsConn) FlushTable(*nftables.Table) {}
+
+func (n *nobufsConn) ListChains() ([]*nftables.Chain, error) {
+	return n.chains, nil
+}
+
+func (n *nobufsConn) Flush() error {
+	if n.commit {
+		n.chains = append(n.chains, n.queued...)
+	}
+	n.queued = nil
+	return fmt.Errorf("conn.Receive: %w", os.NewSyscallError("recvmsg", unix.ENOBUFS))
+}
+
+// redialedConn records operations sent over the connection dialed again
+type redialedConn struct {
+	NetNS
+	readBuffer int
+	ops        []string
+	flushed    bool
+}
+
+func (r *redialedConn) AddChain(c *nftables.Chain) *nftables.Chain {
+	r.ops = append(r.ops, "add chain "+c.Name)
+	return c
+}
+
+func (r *redialedConn) FlushTable(t *nftables.Table) {
+	r.ops = append(r.ops, "flush table "+t.Name)
+}
+
+func (r *redialedConn) Flush() error {
+	r.flushed = true
+	return nil
+}
+
+func TestFlushNoBuffers(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	tests := []struct {
+		name    string
+		commit  bool
+		checked bool
+		redial  bool
+		success bool
+	}{
+		{
+			name:    "batch was programmed",
+			commit:  true,
+			checked: true,
+			success: true,
+		},
+		{
+			name:    "batch was not programmed",
+			checked: true,
+			redial:  true,
+			success: true,
+		},
+		{
+			name:    "batch cannot be checked",
+			commit:  true,
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		redialed := &redialedConn{}
+		conn := &privConn{
+			NetNS: &nobufsConn{commit: tt.commit},
+			redial: func(size int) (NetNS, error) {
+				redialed.readBuffer = size
+				return redialed, nil
+			},
+		}
+		conn.FlushTable(tbl)
+		if tt.checked {
+			conn.AddChain(&nftables.Chain{Name: "input", Table: tbl})
+		}
+		err := conn.Flush()
+		if tt.success && err != nil {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if !tt.success && !errors.Is(err, unix.ENOBUFS) {
+			t.Errorf("Test \"%s\" supposed to fail with ENOBUFS but got: %+v", tt.name, err)
+			continue
+		}
+		if !tt.redial {
+			if redialed.flushed {
+				t.Errorf("Test \"%s\" sent the batch again", tt.name)
+			}
+			continue
+		}
+		if !redialed.flushed || redialed.readBuffer != flushReadBuffer {
+			t.Errorf("Test \"%s\" did not send the batch again with a larger receive buffer", tt.name)
+		}
+		if want := []string{"flush table filter", "add chain input"}; !reflect.DeepEqual(redialed.ops, want) {
+			t.Errorf("Test \"%s\" sent %v again, want: %v", tt.name, redialed.ops, want)
+		}
+	}
+}
@@ -195,6 +195,26 @@ func getExprForRedirectPort(portToRedirect uint16) []expr.Any {
 	return re
 }
 
+// getExprForPortLoad returns expressions matching transport protocol and loading the port at offset of
+// transport header into register 1. Port's List, Range and SetRef share them, so a rule keeps the same layout
+// whether its ports are inline or reference a named set, only the final comparison or lookup differs.
+func getExprForPortLoad(l4proto uint8, offset uint32) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     []byte{l4proto},
+		},
+		&expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseTransportHeader,
+			Offset:       offset, // Offset for a transport protocol header
+			Len:          2,      // 2 bytes for port
+		},
+	}
+}
+
 func getExprForListPort(l4proto uint8, offset uint32, port []*uint16, op Operator, set *nftables.Set) ([]expr.Any, error) {
 	// Slice port may carry nil pointer element, checking all elements of the slice that it is not the case
 	for i, p := range port {
@@ -205,19 +225,7 @@ func getExprForListPort(l4proto uint8, offset uint32, port []*uint16, op Operato
 	if l4proto == 0 {
 		return nil, fmt.Errorf("l4 protocol is 0")
 	}
-	re := []expr.Any{}
-	re = append(re, &expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1})
-	re = append(re, &expr.Cmp{
-		Op:       expr.CmpOpEq,
-		Register: 1,
-		Data:     []byte{l4proto},
-	})
-	re = append(re, &expr.Payload{
-		DestRegister: 1,
-		Base:         expr.PayloadBaseTransportHeader,
-		Offset:       offset, // Offset for a transport protocol header
-		Len:          2,      // 2 bytes for port
-	})
+	re := getExprForPortLoad(l4proto, offset)
 	excl := false
 	if op == NEQ {
 		excl = true
@@ -290,19 +298,7 @@ func getExprForRangePort(l4proto uint8, offset uint32, port [2]*uint16, op Opera
 	if l4proto == 0 {
 		return nil, fmt.Errorf("l4 protocol is 0")
 	}
-	re := []expr.Any{}
-	re = append(re, &expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1})
-	re = append(re, &expr.Cmp{
-		Op:       expr.CmpOpEq,
-		Register: 1,
-		Data:     []byte{l4proto},
-	})
-	re = append(re, &expr.Payload{
-		DestRegister: 1,
-		Base:         expr.PayloadBaseTransportHeader,
-		Offset:       offset, // Offset for a transport protocol header
-		Len:          2,      // 2 bytes for port
-	})
+	re := getExprForPortLoad(l4proto, offset)
 	if op == NEQ {
 		re = append(re, &expr.Range{
 			Op:       expr.CmpOpNeq,
@@ -377,14 +373,14 @@ func getExprForProtocol(l3proto nftables.TableFamily, proto uint32, op Operator)
 		})
 	}
 
-	if op != EQ {
-		// TODO sbezverk
-		return re, nil
+	cmpOp := expr.CmpOpEq
+	if op == NEQ {
+		cmpOp = expr.CmpOpNeq
 	}
 	// [ cmp eq reg 1 0x00000006 ]
 	protobyte := binaryutil.NativeEndian.PutUint32(proto)
 	re = append(re, &expr.Cmp{
-		Op:       expr.CmpOpEq,
+		Op:       cmpOp,
 		Register: 1,
 		Data:     protobyte[0:1],
 	})
@@ -424,27 +420,336 @@ func getExprForMetaMark(mark *MetaMark) []expr.Any {
 		}
 		// [ meta set mark with reg 1 ]
 		re = append(re, &expr.Meta{Key: expr.MetaKey(unix.NFT_META_MARK), Register: 1, SourceRegister: true})
+	} else if mark.Restore {
+		// Restored mark stays in register 1, hence it is matched without loading it again
+		// [ ct load mark => reg 1 ]
+		// [ meta set mark with reg 1 ]
+		re = append(re, getExprForMetaFromCt(&metaFromCt{metaKey: unix.NFT_META_MARK, ctKey: unix.NFT_CT_MARK})...)
+		re = append(re, getExprForMarkCmp(mark)...)
 	} else {
 		// [ meta load mark => reg 1 ]
 		re = append(re, &expr.Meta{Key: expr.MetaKey(unix.NFT_META_MARK), Register: 1, SourceRegister: false})
+		re = append(re, getExprForMarkCmp(mark)...)
+	}
 
-		if mark.Mask != 0 {
-			// [ (reg 1 & 0x0000beef) ^ 0 => reg 1 ]
-			re = append(re, &expr.Bitwise{
-				SourceRegister: 1,
-				DestRegister:   1,
-				Len:            4,
-				Mask:           binaryutil.NativeEndian.PutUint32(mark.Mask),
-				Xor:            []byte{0x0, 0x0, 0x0, 0x0},
-			})
-		}
-		// [ cmp eq reg 1 0x0000dead ]
-		re = append(re, &expr.Cmp{
+	return re
+}
+
+// getExprForMarkCmp returns expressions comparing the mark loaded into register 1 with MetaMark's value or range
+func getExprForMarkCmp(mark *MetaMark) []expr.Any {
+	maskedMark := mark.Value
+	if mark.Mask != 0 {
+		maskedMark = maskedMark & mark.Mask
+	}
+	re := []expr.Any{}
+	if mark.Mask != 0 {
+		// [ (reg 1 & 0x0000beef) ^ 0 => reg 1 ]
+		re = append(re, &expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(mark.Mask),
+			Xor:            []byte{0x0, 0x0, 0x0, 0x0},
+		})
+	}
+	if mark.isRange() {
+		// Mark is in host byte order, range compares bytes, hence converting to network byte order
+		// [ byteorder reg 1 = hton(reg 1, 4, 4) ]
+		re = append(re, &expr.Byteorder{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Op:             expr.ByteorderHton,
+			Len:            4,
+			Size:           4,
+		})
+		// [ range eq reg 1 0x10000000 0x1f000000 ]
+		re = append(re, &expr.Range{
 			Op:       expr.CmpOpEq,
 			Register: 1,
-			Data:     binaryutil.NativeEndian.PutUint32(maskedMark),
+			FromData: binaryutil.BigEndian.PutUint32(mark.Range[0]),
+			ToData:   binaryutil.BigEndian.PutUint32(mark.Range[1]),
 		})
+		return re
 	}
+	// [ cmp eq reg 1 0x0000dead ]
+	re = append(re, &expr.Cmp{
+		Op:       expr.CmpOpEq,
+		Register: 1,
+		Data:     binaryutil.NativeEndian.PutUint32(maskedMark),
+	})
+
+	return re
+}
+
+func getExprForCtZone(zone uint16) []expr.Any {
+	re := []expr.Any{}
+	// [ immediate reg 1 0x00000005 ]
+	re = append(re, &expr.Immediate{Register: 1, Data: binaryutil.NativeEndian.PutUint16(zone)})
+	// [ ct set zone with reg 1 ]
+	re = append(re, &expr.Ct{Key: unix.NFT_CT_ZONE, Register: 1, SourceRegister: true})
+
+	return re
+}
+
+func getExprForCtLabel(bit uint8) []expr.Any {
+	re := []expr.Any{}
+	// [ immediate reg 1 0x00000020 0x00000000 0x00000000 0x00000000 ]
+	re = append(re, &expr.Immediate{Register: 1, Data: ctLabelMask(bit)})
+	// [ ct set label with reg 1 ]
+	re = append(re, &expr.Ct{Key: unix.NFT_CT_LABELS, Register: 1, SourceRegister: true})
+
+	return re
+}
+
+func getExprForCtTimeout(name string) []expr.Any {
+	// [ objref type 7 name db-timeout ]
+	return []expr.Any{
+		&expr.Objref{
+			Type: ObjTypeCtTimeout,
+			Name: name,
+		},
+	}
+}
+
+func getExprForMetaFromCt(m *metaFromCt) []expr.Any {
+	re := []expr.Any{}
+	// [ ct load mark => reg 1 ]
+	re = append(re, &expr.Ct{Key: expr.CtKey(m.ctKey), Register: 1})
+	// [ meta set mark with reg 1 ]
+	re = append(re, &expr.Meta{Key: expr.MetaKey(m.metaKey), Register: 1, SourceRegister: true})
+
+	return re
+}
+
+// getExprForMeta returns expressions for all keys specified in Meta.
+// Mark and Expr are mutually exclusive, if both are set only Mark is used.
+func getExprForMeta(l3proto nftables.TableFamily, meta *Meta) ([]expr.Any, error) {
+	re := []expr.Any{}
+	switch {
+	case meta.Mark != nil:
+		re = append(re, getExprForMetaMark(meta.Mark)...)
+	case len(meta.Expr) != 0:
+		re = append(re, getExprForMetaExpr(meta.Expr)...)
+	}
+	if meta.PktType != nil {
+		e, err := getExprForMetaPktType(meta.PktType)
+		if err != nil {
+			return nil, err
+		}
+		re = append(re, e...)
+	}
+	if meta.Secmark != nil {
+		re = append(re, getExprForMetaSecmark(meta.Secmark)...)
+	}
+	if meta.IBrName != nil {
+		e, err := getExprForMetaBridgeName(l3proto, expr.MetaKeyBRIIIFNAME, meta.IBrName)
+		if err != nil {
+			return nil, err
+		}
+		re = append(re, e...)
+	}
+	if meta.OBrName != nil {
+		e, err := getExprForMetaBridgeName(l3proto, expr.MetaKeyBRIOIFNAME, meta.OBrName)
+		if err != nil {
+			return nil, err
+		}
+		re = append(re, e...)
+	}
+	if meta.IIfType != nil {
+		e, err := getExprForMetaIfType(expr.MetaKeyIIFTYPE, meta.IIfType)
+		if err != nil {
+			return nil, err
+		}
+		re = append(re, e...)
+	}
+	if meta.OIfType != nil {
+		e, err := getExprForMetaIfType(expr.MetaKeyOIFTYPE, meta.OIfType)
+		if err != nil {
+			return nil, err
+		}
+		re = append(re, e...)
+	}
+	if meta.SDif != nil {
+		e, err := getExprForMetaSDif(meta.SDif)
+		if err != nil {
+			return nil, err
+		}
+		re = append(re, e...)
+	}
+	if meta.Priority != nil {
+		e, err := getExprForMetaPriority(meta.Priority)
+		if err != nil {
+			return nil, err
+		}
+		re = append(re, e...)
+	}
+
+	return re, nil
+}
+
+func getExprForMetaPktType(pkttype *MetaPktType) ([]expr.Any, error) {
+	if err := pkttype.Validate(); err != nil {
+		return nil, err
+	}
+	op := expr.CmpOpEq
+	if pkttype.RelOp == NEQ {
+		op = expr.CmpOpNeq
+	}
+	// [ meta load pkttype => reg 1 ]
+	// [ cmp eq reg 1 0x00000002 ]
+	re := []expr.Any{}
+	re = append(re, &expr.Meta{Key: expr.MetaKeyPKTTYPE, Register: 1})
+	re = append(re, &expr.Cmp{
+		Op:       op,
+		Register: 1,
+		Data:     []byte{pkttype.Value},
+	})
+
+	return re, nil
+}
+
+func getExprForMetaSecmark(secmark *MetaSecmark) []expr.Any {
+	op := expr.CmpOpEq
+	if secmark.RelOp == NEQ {
+		op = expr.CmpOpNeq
+	}
+	// [ meta load secmark => reg 1 ]
+	// [ cmp eq reg 1 0x0000000c ]
+	re := []expr.Any{}
+	re = append(re, &expr.Meta{Key: expr.MetaKeySECMARK, Register: 1})
+	re = append(re, &expr.Cmp{
+		Op:       op,
+		Register: 1,
+		Data:     binaryutil.NativeEndian.PutUint32(secmark.Value),
+	})
+
+	return re
+}
+
+func getExprForMetaBridgeName(l3proto nftables.TableFamily, key expr.MetaKey, b *MetaBridgeName) ([]expr.Any, error) {
+	if l3proto != nftables.TableFamilyBridge {
+		return nil, fmt.Errorf("bridge name match is supported only for bridge table family")
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	op := expr.CmpOpEq
+	if b.RelOp == NEQ {
+		op = expr.CmpOpNeq
+	}
+	// Interface name is compared as a zero padded string of IFNAMSIZ length
+	name := make([]byte, unix.IFNAMSIZ)
+	copy(name, b.Name)
+	// [ meta load bri_iifname => reg 1 ]
+	// [ cmp eq reg 1 0x30726262 0x00000000 0x00000000 0x00000000 ]
+	re := []expr.Any{}
+	re = append(re, &expr.Meta{Key: key, Register: 1})
+	re = append(re, &expr.Cmp{
+		Op:       op,
+		Register: 1,
+		Data:     name,
+	})
+
+	return re, nil
+}
+
+func getExprForMetaIfType(key expr.MetaKey, i *MetaIfType) ([]expr.Any, error) {
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+	op := expr.CmpOpEq
+	if i.RelOp == NEQ {
+		op = expr.CmpOpNeq
+	}
+	// Interface type is loaded as 2 bytes in host byte order
+	// [ meta load iiftype => reg 1 ]
+	// [ cmp eq reg 1 0x00000304 ]
+	re := []expr.Any{}
+	re = append(re, &expr.Meta{Key: key, Register: 1})
+	re = append(re, &expr.Cmp{
+		Op:       op,
+		Register: 1,
+		Data:     binaryutil.NativeEndian.PutUint16(i.Type),
+	})
+
+	return re, nil
+}
+
+func getExprForMetaSDif(s *MetaSDif) ([]expr.Any, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	// [ meta load sdif => reg 1 ]
+	// [ cmp eq reg 1 0x00000005 ]
+	key := metaKeySDIF
+	data := binaryutil.NativeEndian.PutUint32(s.Index)
+	if s.Name != "" {
+		// Interface name is compared as a zero padded string of IFNAMSIZ length
+		// [ meta load sdifname => reg 1 ]
+		// [ cmp eq reg 1 0x30667276 0x00000000 0x00000000 0x00000000 ]
+		key = metaKeySDIFNAME
+		data = make([]byte, unix.IFNAMSIZ)
+		copy(data, s.Name)
+	}
+	re := []expr.Any{}
+	re = append(re, &expr.Meta{Key: key, Register: 1})
+	re = append(re, &expr.Cmp{
+		Op:       cmpOp(s.RelOp),
+		Register: 1,
+		Data:     data,
+	})
+
+	return re, nil
+}
+
+func getExprForMetaPriority(p *MetaPriority) ([]expr.Any, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	// Priority is loaded as 4 bytes in host byte order, major number occupies high 16 bits
+	// [ meta load priority => reg 1 ]
+	// [ cmp eq reg 1 0x00010010 ]
+	re := []expr.Any{}
+	re = append(re, &expr.Meta{Key: expr.MetaKeyPRIORITY, Register: 1})
+	re = append(re, &expr.Cmp{
+		Op:       cmpOp(p.RelOp),
+		Register: 1,
+		Data:     binaryutil.NativeEndian.PutUint32(uint32(p.Major)<<16 | uint32(p.Minor)),
+	})
+
+	return re, nil
+}
+
+func getExprForSetMetaPriority(priority uint32) []expr.Any {
+	re := []expr.Any{}
+	// [ immediate reg 1 0x00010010 ]
+	re = append(re, &expr.Immediate{Register: 1, Data: binaryutil.NativeEndian.PutUint32(priority)})
+	// [ meta set priority with reg 1 ]
+	re = append(re, &expr.Meta{Key: expr.MetaKeyPRIORITY, Register: 1, SourceRegister: true})
+
+	return re
+}
+
+func getExprForSetSecmark(secid uint32) []expr.Any {
+	re := []expr.Any{}
+	// [ immediate reg 1 0x0000000c ]
+	re = append(re, &expr.Immediate{Register: 1, Data: binaryutil.NativeEndian.PutUint32(secid)})
+	// [ meta set secmark with reg 1 ]
+	re = append(re, &expr.Meta{Key: expr.MetaKeySECMARK, Register: 1, SourceRegister: true})
+
+	return re
+}
+
+func getExprForSetTrace(enable bool) []expr.Any {
+	var v byte
+	if enable {
+		v = 1
+	}
+	re := []expr.Any{}
+	// [ immediate reg 1 0x00000001 ]
+	re = append(re, &expr.Immediate{Register: 1, Data: []byte{v}})
+	// [ meta set nftrace with reg 1 ]
+	re = append(re, &expr.Meta{Key: expr.MetaKeyNFTRACE, Register: 1, SourceRegister: true})
 
 	return re
 }
@@ -511,8 +816,27 @@ func getExprForLog(log *Log) []expr.Any {
 	if log == nil {
 		return []expr.Any{}
 	}
+	// Key of expr.Log is a bitmask of NFTA_LOG_* attributes carried by the expression
+	l := &expr.Log{Key: 1 << log.Key}
+	var v uint32
+	for _, b := range log.Value {
+		v = v<<8 | uint32(b)
+	}
+	switch log.Key {
+	case unix.NFTA_LOG_PREFIX:
+		l.Data = log.Value
+	case unix.NFTA_LOG_LEVEL:
+		l.Level = expr.LogLevel(v)
+	case unix.NFTA_LOG_GROUP:
+		l.Group = uint16(v)
+	case unix.NFTA_LOG_SNAPLEN:
+		l.Snaplen = v
+	case unix.NFTA_LOG_QTHRESHOLD:
+		l.QThreshold = uint16(v)
+	}
 	re := []expr.Any{}
-	re = append(re, &expr.Log{Key: log.Key, Data: log.Value})
+	// [ log prefix dropped ]
+	re = append(re, l)
 
 	return re
 }
@@ -527,6 +851,35 @@ func getExprForReject(r *reject) []expr.Any {
 	return re
 }
 
+func getExprForLimit(l *LimitAttributes) []expr.Any {
+	// [ limit rate 10/second burst 5 type packets flags 0x0 ]
+	return []expr.Any{&expr.Limit{
+		Type:  l.Type,
+		Rate:  l.Rate,
+		Unit:  l.Unit,
+		Burst: l.Burst,
+	}}
+}
+
+func getExprForRateLimitedReject(l3proto nftables.TableFamily, r *rateLimitedReject) []expr.Any {
+	re := getExprForLimit(&r.limit)
+	var code uint8
+	switch r.rejectType {
+	case unix.NFT_REJECT_ICMPX_UNREACH:
+		code = unix.NFT_REJECT_ICMPX_PORT_UNREACH
+	case unix.NFT_REJECT_ICMP_UNREACH:
+		// ICMP port unreachable code is 3, ICMPv6 port unreachable code is 4
+		code = 3
+		if l3proto == nftables.TableFamilyIPv6 {
+			code = 4
+		}
+	}
+	// [ reject type 2 code 1 ]
+	re = append(re, &expr.Reject{Type: r.rejectType, Code: code})
+
+	return re
+}
+
 func getExprForFib(f *Fib) []expr.Any {
 	if f == nil {
 		return []expr.Any{}
@@ -591,9 +944,80 @@ func getExprForConntracks(cts []*Conntrack) []expr.Any {
 				Register: 1,
 				Data:     []byte{0x0, 0x0, 0x0, 0x0},
 			})
+		case unix.NFT_CT_ZONE:
+			//	[ ct load zone => reg 1 ]
+			//	[ cmp eq reg 1 0x00000005 ]
+			re = append(re, &expr.Ct{Key: unix.NFT_CT_ZONE, Register: 1})
+			re = append(re, &expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 1,
+				Data:     ct.Value,
+			})
+		case unix.NFT_CT_EXPIRATION:
+			//	[ ct load expiration => reg 1 ]
+			//	[ byteorder reg 1 = hton(reg 1, 4, 4) ]
+			//	[ cmp lt reg 1 0x00002710 ]
+			re = append(re, &expr.Ct{Key: unix.NFT_CT_EXPIRATION, Register: 1})
+			re = append(re, &expr.Byteorder{
+				SourceRegister: 1,
+				DestRegister:   1,
+				Op:             expr.ByteorderHton,
+				Len:            4,
+				Size:           4,
+			})
+			re = append(re, &expr.Cmp{
+				Op:       cmpOp(ct.RelOp),
+				Register: 1,
+				Data:     ct.Value,
+			})
+		case unix.NFT_CT_BYTES, unix.NFT_CT_PKTS:
+			//	[ ct load bytes => reg 1 ]
+			//	[ byteorder reg 1 = hton(reg 1, 8, 8) ]
+			//	[ cmp gt reg 1 0x00000000 0x40420f00 ]
+			re = append(re, &expr.Ct{Key: expr.CtKey(ct.Key), Register: 1})
+			re = append(re, &expr.Byteorder{
+				SourceRegister: 1,
+				DestRegister:   1,
+				Op:             expr.ByteorderHton,
+				Len:            8,
+				Size:           8,
+			})
+			re = append(re, &expr.Cmp{
+				Op:       cmpOp(ct.RelOp),
+				Register: 1,
+				Data:     ct.Value,
+			})
 		case unix.NFT_CT_DIRECTION:
-		case unix.NFT_CT_STATUS:
+			//	[ ct load direction => reg 1 ]
+			//	[ cmp eq reg 1 0x00000001 ]
+			re = append(re, &expr.Ct{Key: unix.NFT_CT_DIRECTION, Register: 1})
+			re = append(re, &expr.Cmp{
+				Op:       cmpOp(ct.RelOp),
+				Register: 1,
+				Data:     ct.Value,
+			})
 		case unix.NFT_CT_LABELS:
+			//	[ ct load label => reg 1 ]
+			//	[ bitwise reg 1 = (reg=1 & 0x00000020 0x00000000 0x00000000 0x00000000 ) ^ 0x00000000 0x00000000 0x00000000 0x00000000 ]
+			//	[ cmp neq reg 1 0x00000000 0x00000000 0x00000000 0x00000000 ]
+			op := expr.CmpOpNeq
+			if ct.RelOp == NEQ {
+				op = expr.CmpOpEq
+			}
+			re = append(re, &expr.Ct{Key: unix.NFT_CT_LABELS, Register: 1})
+			re = append(re, &expr.Bitwise{
+				SourceRegister: 1,
+				DestRegister:   1,
+				Len:            uint32(len(ct.Value)),
+				Mask:           ct.Value,
+				Xor:            make([]byte, len(ct.Value)),
+			})
+			re = append(re, &expr.Cmp{
+				Op:       op,
+				Register: 1,
+				Data:     make([]byte, len(ct.Value)),
+			})
+		case unix.NFT_CT_STATUS:
 		case unix.NFT_CT_EVENTMASK:
 		}
 	}
@@ -608,19 +1032,7 @@ func getExprForPortSet(l4proto uint8, offset uint32, set *SetRef, op Operator) (
 	if l4proto == 0 {
 		return nil, fmt.Errorf("l4 protocol is 0")
 	}
-	re := []expr.Any{}
-	re = append(re, &expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1})
-	re = append(re, &expr.Cmp{
-		Op:       expr.CmpOpEq,
-		Register: 1,
-		Data:     []byte{l4proto},
-	})
-	re = append(re, &expr.Payload{
-		DestRegister: 1,
-		Base:         expr.PayloadBaseTransportHeader,
-		Offset:       offset, // Offset for a transport protocol header
-		Len:          2,      // 2 bytes for port
-	})
+	re := getExprForPortLoad(l4proto, offset)
 	excl := false
 	if op == NEQ {
 		excl = true
@@ -0,0 +1,569 @@
+package nftableslib
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetExprForMetaPktType(t *testing.T) {
+	tests := []struct {
+		name    string
+		pkttype *MetaPktType
+		want    []expr.Any
+		success bool
+	}{
+		{
+			name:    "Multicast",
+			pkttype: &MetaPktType{Value: PacketTypeMulticast},
+			want: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyPKTTYPE, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{PacketTypeMulticast}},
+			},
+			success: true,
+		},
+		{
+			name:    "Not Broadcast",
+			pkttype: &MetaPktType{Value: PacketTypeBroadcast, RelOp: NEQ},
+			want: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyPKTTYPE, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{PacketTypeBroadcast}},
+			},
+			success: true,
+		},
+		{
+			name:    "Unknown packet type",
+			pkttype: &MetaPktType{Value: 0x10},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		got, err := getExprForMetaPktType(tt.pkttype)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Test \"%s\" failed, got: %+v want: %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetExprForMetaFromCt(t *testing.T) {
+	ra, err := SetMetaFromCt(unix.NFT_META_MARK, unix.NFT_CT_MARK)
+	if err != nil {
+		t.Fatalf("SetMetaFromCt failed with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Ct{Key: expr.CtKeyMARK, Register: 1},
+		&expr.Meta{Key: expr.MetaKeyMARK, Register: 1, SourceRegister: true},
+	}
+	if got := getExprForMetaFromCt(ra.metaFromCt); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForMetaFromCt returned %+v want: %+v", got, want)
+	}
+	if _, err := SetMetaFromCt(unix.NFT_META_IIFNAME, unix.NFT_CT_MARK); err == nil {
+		t.Errorf("SetMetaFromCt succeeded with unsupported meta key but supposed to fail")
+	}
+	if _, err := SetMetaFromCt(unix.NFT_META_MARK, unix.NFT_CT_STATE); err == nil {
+		t.Errorf("SetMetaFromCt succeeded with unsupported conntrack key but supposed to fail")
+	}
+}
+
+func TestGetExprForCtZone(t *testing.T) {
+	ra, err := SetCtZone(5)
+	if err != nil {
+		t.Fatalf("SetCtZone failed with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Immediate{Register: 1, Data: binaryutil.NativeEndian.PutUint16(5)},
+		&expr.Ct{Key: expr.CtKeyZONE, Register: 1, SourceRegister: true},
+	}
+	if got := getExprForCtZone(*ra.ctZone); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForCtZone returned %+v want: %+v", got, want)
+	}
+	want = []expr.Any{
+		&expr.Ct{Key: expr.CtKeyZONE, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint16(5)},
+	}
+	got := getExprForConntracks([]*Conntrack{{Key: unix.NFT_CT_ZONE, Value: binaryutil.NativeEndian.PutUint16(5)}})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForConntracks returned %+v want: %+v", got, want)
+	}
+}
+
+func TestGetExprForSecmark(t *testing.T) {
+	want := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeySECMARK, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(12)},
+	}
+	got, err := getExprForMeta(nftables.TableFamilyIPv4, &Meta{Secmark: &MetaSecmark{Value: 12, RelOp: NEQ}})
+	if err != nil {
+		t.Fatalf("getExprForMeta failed with error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForMeta returned %+v want: %+v", got, want)
+	}
+	ra, err := SetSecmark(12)
+	if err != nil {
+		t.Fatalf("SetSecmark failed with error: %+v", err)
+	}
+	want = []expr.Any{
+		&expr.Immediate{Register: 1, Data: binaryutil.NativeEndian.PutUint32(12)},
+		&expr.Meta{Key: expr.MetaKeySECMARK, Register: 1, SourceRegister: true},
+	}
+	if got := getExprForSetSecmark(*ra.secmark); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForSetSecmark returned %+v want: %+v", got, want)
+	}
+	if _, err := SetSecmark(0); err == nil {
+		t.Errorf("SetSecmark succeeded with security id 0 but supposed to fail")
+	}
+}
+
+func TestGetExprForSetTrace(t *testing.T) {
+	ra, err := SetTrace(true)
+	if err != nil {
+		t.Fatalf("SetTrace failed with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Immediate{Register: 1, Data: []byte{0x1}},
+		&expr.Meta{Key: expr.MetaKeyNFTRACE, Register: 1, SourceRegister: true},
+	}
+	if got := getExprForSetTrace(*ra.trace); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForSetTrace returned %+v want: %+v", got, want)
+	}
+	b, err := json.Marshal(ra)
+	if err != nil {
+		t.Fatalf("Marshal failed with error: %+v", err)
+	}
+	var decoded RuleAction
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed with error: %+v", err)
+	}
+	if decoded.trace == nil || !*decoded.trace {
+		t.Errorf("trace action was not restored from %s", string(b))
+	}
+}
+
+func TestGetExprForFibReversePath(t *testing.T) {
+	f := SetFibReversePath(true)
+	if err := f.Validate(); err != nil {
+		t.Fatalf("strict reverse path fib failed validation with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Fib{Register: 1, ResultOIF: true, FlagSADDR: true, FlagIIF: true},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x0}},
+	}
+	if got := getExprForFib(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForFib returned %+v want: %+v", got, want)
+	}
+	if err := (&Fib{ResultOIF: true, ResultADDRTYPE: true, FlagDADDR: true}).Validate(); err == nil {
+		t.Errorf("fib with two results passed validation but supposed to fail")
+	}
+	if err := (&Fib{ResultOIF: true, FlagSADDR: true, FlagDADDR: true}).Validate(); err == nil {
+		t.Errorf("fib with both saddr and daddr flags passed validation but supposed to fail")
+	}
+}
+
+func TestGetExprForConntrackAccounting(t *testing.T) {
+	ct, err := SetConntrackAccounting(unix.NFT_CT_BYTES, 1000000, GT)
+	if err != nil {
+		t.Fatalf("SetConntrackAccounting failed with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Ct{Key: expr.CtKeyBYTES, Register: 1},
+		&expr.Byteorder{SourceRegister: 1, DestRegister: 1, Op: expr.ByteorderHton, Len: 8, Size: 8},
+		&expr.Cmp{Op: expr.CmpOpGt, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x0, 0x0, 0x0f, 0x42, 0x40}},
+	}
+	if got := getExprForConntracks([]*Conntrack{ct}); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForConntracks returned %+v want: %+v", got, want)
+	}
+	if _, err := SetConntrackAccounting(unix.NFT_CT_MARK, 1, GT); err == nil {
+		t.Errorf("SetConntrackAccounting succeeded with non accounting key but supposed to fail")
+	}
+}
+
+func TestGetExprForMetaBridgeName(t *testing.T) {
+	want := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyBRIIIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{'b', 'r', '0', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+	}
+	got, err := getExprForMeta(nftables.TableFamilyBridge, &Meta{IBrName: &MetaBridgeName{Name: "br0"}})
+	if err != nil {
+		t.Fatalf("getExprForMeta failed with error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForMeta returned %+v want: %+v", got, want)
+	}
+	if _, err := getExprForMeta(nftables.TableFamilyIPv4, &Meta{OBrName: &MetaBridgeName{Name: "br0"}}); err == nil {
+		t.Errorf("getExprForMeta succeeded with bridge name in ipv4 table but supposed to fail")
+	}
+	if _, err := getExprForMeta(nftables.TableFamilyBridge, &Meta{OBrName: &MetaBridgeName{Name: "bridge-name-too-long"}}); err == nil {
+		t.Errorf("getExprForMeta succeeded with too long bridge name but supposed to fail")
+	}
+}
+
+func TestGetExprForRateLimitedReject(t *testing.T) {
+	limit := LimitAttributes{Rate: 10, Unit: expr.LimitTimeSecond, Burst: 5}
+	tests := []struct {
+		name       string
+		family     nftables.TableFamily
+		rejectType uint32
+		expect     []expr.Any
+	}{
+		{
+			name:       "icmpx",
+			family:     nftables.TableFamilyINet,
+			rejectType: unix.NFT_REJECT_ICMPX_UNREACH,
+			expect: []expr.Any{
+				&expr.Limit{Type: expr.LimitTypePkts, Rate: 10, Unit: expr.LimitTimeSecond, Burst: 5},
+				&expr.Reject{Type: unix.NFT_REJECT_ICMPX_UNREACH, Code: unix.NFT_REJECT_ICMPX_PORT_UNREACH},
+			},
+		},
+		{
+			name:       "icmpv6",
+			family:     nftables.TableFamilyIPv6,
+			rejectType: unix.NFT_REJECT_ICMP_UNREACH,
+			expect: []expr.Any{
+				&expr.Limit{Type: expr.LimitTypePkts, Rate: 10, Unit: expr.LimitTimeSecond, Burst: 5},
+				&expr.Reject{Type: unix.NFT_REJECT_ICMP_UNREACH, Code: 4},
+			},
+		},
+	}
+	for _, tt := range tests {
+		ra, err := SetRateLimitedReject(limit, tt.rejectType)
+		if err != nil {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if e := getExprForRateLimitedReject(tt.family, ra.rateLimitedReject); !reflect.DeepEqual(e, tt.expect) {
+			t.Errorf("Test \"%s\" expressions do not match expected expressions", tt.name)
+		}
+	}
+	if _, err := SetRateLimitedReject(LimitAttributes{Unit: expr.LimitTimeSecond}, unix.NFT_REJECT_TCP_RST); err == nil {
+		t.Errorf("rate limited reject with 0 rate supposed to fail but succeeded")
+	}
+}
+
+func TestGetExprForMetaIfType(t *testing.T) {
+	loopback, err := IfTypeFromName("loopback")
+	if err != nil {
+		t.Fatalf("IfTypeFromName failed with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyIIFTYPE, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint16(unix.ARPHRD_LOOPBACK)},
+		&expr.Meta{Key: expr.MetaKeyOIFTYPE, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint16(unix.ARPHRD_IPGRE)},
+	}
+	got, err := getExprForMeta(nftables.TableFamilyIPv4, &Meta{
+		IIfType: &MetaIfType{Type: loopback},
+		OIfType: &MetaIfType{Type: unix.ARPHRD_IPGRE, RelOp: NEQ},
+	})
+	if err != nil {
+		t.Fatalf("getExprForMeta failed with error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForMeta returned %+v want: %+v", got, want)
+	}
+	if _, err := getExprForMeta(nftables.TableFamilyIPv4, &Meta{IIfType: &MetaIfType{Type: 0x1234}}); err == nil {
+		t.Errorf("getExprForMeta succeeded with unsupported interface type but supposed to fail")
+	}
+	if _, err := IfTypeFromName("unknown"); err == nil {
+		t.Errorf("IfTypeFromName succeeded with unknown name but supposed to fail")
+	}
+}
+
+func TestGetExprForMetaSDif(t *testing.T) {
+	name := make([]byte, unix.IFNAMSIZ)
+	copy(name, "vrf-blue")
+	want := []expr.Any{
+		&expr.Meta{Key: metaKeySDIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: name},
+	}
+	got, err := getExprForMeta(nftables.TableFamilyIPv4, &Meta{SDif: &MetaSDif{Name: "vrf-blue"}})
+	if err != nil {
+		t.Fatalf("getExprForMeta failed with error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForMeta returned %+v want: %+v", got, want)
+	}
+	want = []expr.Any{
+		&expr.Meta{Key: metaKeySDIF, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(5)},
+	}
+	got, err = getExprForMeta(nftables.TableFamilyIPv4, &Meta{SDif: &MetaSDif{Index: 5, RelOp: NEQ}})
+	if err != nil {
+		t.Fatalf("getExprForMeta failed with error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForMeta returned %+v want: %+v", got, want)
+	}
+	if _, err := getExprForMeta(nftables.TableFamilyIPv4, &Meta{SDif: &MetaSDif{Index: 5, Name: "vrf-blue"}}); err == nil {
+		t.Errorf("getExprForMeta succeeded with both index and name but supposed to fail")
+	}
+	rule := Rule{Meta: &Meta{SDif: &MetaSDif{Index: 5}}}
+	if err := rule.Validate(nftables.TableFamilyBridge, nil); err == nil {
+		t.Errorf("Validate succeeded in bridge table but supposed to fail")
+	}
+	output := &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookOutput, Priority: nftables.ChainPriorityFilter}
+	if err := rule.Validate(nftables.TableFamilyINet, output); err == nil {
+		t.Errorf("Validate succeeded in output chain but supposed to fail")
+	}
+	input := &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookInput, Priority: nftables.ChainPriorityFilter}
+	if err := rule.Validate(nftables.TableFamilyINet, input); err != nil {
+		t.Errorf("Validate failed with error: %+v but supposed to succeed", err)
+	}
+}
+
+func TestGetExprForMetaPriority(t *testing.T) {
+	major, minor, err := TCClassFromString("1:10")
+	if err != nil {
+		t.Fatalf("TCClassFromString failed with error: %+v", err)
+	}
+	if major != 1 || minor != 0x10 {
+		t.Errorf("TCClassFromString returned %x:%x want: 1:10", major, minor)
+	}
+	want := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyPRIORITY, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(0x00010010)},
+	}
+	got, err := getExprForMeta(nftables.TableFamilyIPv4, &Meta{Priority: &MetaPriority{Major: major, Minor: minor}})
+	if err != nil {
+		t.Fatalf("getExprForMeta failed with error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForMeta returned %+v want: %+v", got, want)
+	}
+	ra, err := SetMetaPriority(major, minor)
+	if err != nil {
+		t.Fatalf("SetMetaPriority failed with error: %+v", err)
+	}
+	want = []expr.Any{
+		&expr.Immediate{Register: 1, Data: binaryutil.NativeEndian.PutUint32(0x00010010)},
+		&expr.Meta{Key: expr.MetaKeyPRIORITY, Register: 1, SourceRegister: true},
+	}
+	if got := getExprForSetMetaPriority(*ra.priority); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForSetMetaPriority returned %+v want: %+v", got, want)
+	}
+	b, err := json.Marshal(ra)
+	if err != nil {
+		t.Fatalf("failed to marshal action with error: %+v", err)
+	}
+	var decoded RuleAction
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal action with error: %+v", err)
+	}
+	if decoded.priority == nil || *decoded.priority != 0x00010010 {
+		t.Errorf("priority was lost in json round trip, got %s", string(b))
+	}
+	for _, class := range []string{"0:10", "ffff:0", "1", "1:10000", "x:1"} {
+		if _, _, err := TCClassFromString(class); err == nil {
+			t.Errorf("TCClassFromString succeeded with %s but supposed to fail", class)
+		}
+	}
+	if _, err := SetMetaPriority(tcHandleRoot, 1); err == nil {
+		t.Errorf("SetMetaPriority succeeded with reserved major number but supposed to fail")
+	}
+	rule := Rule{Meta: &Meta{Priority: &MetaPriority{Major: 1, RelOp: GT}}}
+	if err := rule.Validate(nftables.TableFamilyIPv4, nil); err == nil {
+		t.Errorf("Validate succeeded with GT operation but supposed to fail")
+	}
+}
+
+func TestGetExprForConntrackLabel(t *testing.T) {
+	ct, err := SetConntrackLabel(37, EQ)
+	if err != nil {
+		t.Fatalf("SetConntrackLabel failed with error: %+v", err)
+	}
+	mask := make([]byte, 16)
+	copy(mask[4:], binaryutil.NativeEndian.PutUint32(1<<5))
+	want := []expr.Any{
+		&expr.Ct{Key: expr.CtKeyLABELS, Register: 1},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 16, Mask: mask, Xor: make([]byte, 16)},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: make([]byte, 16)},
+	}
+	if got := getExprForConntracks([]*Conntrack{ct}); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForConntracks returned %+v want: %+v", got, want)
+	}
+	ct, _ = SetConntrackLabel(37, NEQ)
+	if got := getExprForConntracks([]*Conntrack{ct}); got[2].(*expr.Cmp).Op != expr.CmpOpEq {
+		t.Errorf("label not set is not matched by comparison with 0, got %+v", got[2])
+	}
+	ra, err := SetCtLabel(37)
+	if err != nil {
+		t.Fatalf("SetCtLabel failed with error: %+v", err)
+	}
+	want = []expr.Any{
+		&expr.Immediate{Register: 1, Data: mask},
+		&expr.Ct{Key: expr.CtKeyLABELS, Register: 1, SourceRegister: true},
+	}
+	if got := getExprForCtLabel(*ra.ctLabel); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForCtLabel returned %+v want: %+v", got, want)
+	}
+	b, err := json.Marshal(ra)
+	if err != nil {
+		t.Fatalf("failed to marshal action with error: %+v", err)
+	}
+	var decoded RuleAction
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal action with error: %+v", err)
+	}
+	if decoded.ctLabel == nil || *decoded.ctLabel != 37 {
+		t.Errorf("ct label was lost in json round trip, got %s", string(b))
+	}
+	if _, err := SetCtLabel(128); err == nil {
+		t.Errorf("SetCtLabel succeeded with bit 128 but supposed to fail")
+	}
+	if _, err := SetConntrackLabel(1, GT); err == nil {
+		t.Errorf("SetConntrackLabel succeeded with GT operation but supposed to fail")
+	}
+}
+
+func TestGetExprForConntrackDirection(t *testing.T) {
+	ct, err := SetConntrackDirection(CTDirectionReply, EQ)
+	if err != nil {
+		t.Fatalf("SetConntrackDirection failed with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Ct{Key: expr.CtKeyDIRECTION, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x1}},
+	}
+	if got := getExprForConntracks([]*Conntrack{ct}); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForConntracks returned %+v want: %+v", got, want)
+	}
+	if _, err := SetConntrackDirection(2, EQ); err == nil {
+		t.Errorf("SetConntrackDirection succeeded with invalid direction but supposed to fail")
+	}
+	if _, err := SetConntrackDirection(CTDirectionOriginal, GT); err == nil {
+		t.Errorf("SetConntrackDirection succeeded with GT operation but supposed to fail")
+	}
+}
+
+func TestGetExprForCtExpirationAndTimeout(t *testing.T) {
+	ct, err := SetConntrackExpiration(10*time.Second, LT)
+	if err != nil {
+		t.Fatalf("SetConntrackExpiration failed with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Ct{Key: expr.CtKeyEXPIRATION, Register: 1},
+		&expr.Byteorder{SourceRegister: 1, DestRegister: 1, Op: expr.ByteorderHton, Len: 4, Size: 4},
+		&expr.Cmp{Op: expr.CmpOpLt, Register: 1, Data: []byte{0x0, 0x0, 0x27, 0x10}},
+	}
+	if got := getExprForConntracks([]*Conntrack{ct}); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForConntracks returned %+v want: %+v", got, want)
+	}
+	if _, err := SetConntrackExpiration(-time.Second, LT); err == nil {
+		t.Errorf("SetConntrackExpiration succeeded with negative expiration but supposed to fail")
+	}
+	ra, err := SetCtTimeout("db-timeout")
+	if err != nil {
+		t.Fatalf("SetCtTimeout failed with error: %+v", err)
+	}
+	want = []expr.Any{
+		&expr.Objref{Type: ObjTypeCtTimeout, Name: "db-timeout"},
+	}
+	if got := getExprForCtTimeout(*ra.ctTimeout); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForCtTimeout returned %+v want: %+v", got, want)
+	}
+	if _, err := SetCtTimeout(""); err == nil {
+		t.Errorf("SetCtTimeout succeeded with empty policy name but supposed to fail")
+	}
+}
+
+func TestGetExprForMetaMarkRange(t *testing.T) {
+	mark := &MetaMark{Range: [2]uint32{0x10, 0x1f}}
+	want := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyMARK, Register: 1},
+		&expr.Byteorder{SourceRegister: 1, DestRegister: 1, Op: expr.ByteorderHton, Len: 4, Size: 4},
+		&expr.Range{Op: expr.CmpOpEq, Register: 1, FromData: []byte{0x0, 0x0, 0x0, 0x10}, ToData: []byte{0x0, 0x0, 0x0, 0x1f}},
+	}
+	if got := getExprForMetaMark(mark); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForMetaMark returned %+v want: %+v", got, want)
+	}
+	if err := (&MetaMark{Range: [2]uint32{0x1f, 0x10}}).Validate(); err == nil {
+		t.Errorf("validation of reversed mark range supposed to fail but succeeded")
+	}
+	if err := (&MetaMark{Set: true, Range: [2]uint32{0x10, 0x1f}}).Validate(); err == nil {
+		t.Errorf("validation of mark range with Set supposed to fail but succeeded")
+	}
+}
+
+func TestExpressionOrderInlineAndNamedSet(t *testing.T) {
+	proto := uint32(unix.IPPROTO_TCP)
+	ports := SetPortList([]int{80, 443})
+	tests := []struct {
+		name   string
+		inline *Rule
+		named  *Rule
+	}{
+		{
+			name: "Port list",
+			inline: &Rule{L4: &L4Rule{
+				L4Proto: unix.IPPROTO_TCP,
+				Src:     &Port{List: SetPortList([]int{1024})},
+				Dst:     &Port{List: ports},
+			}},
+			named: &Rule{L4: &L4Rule{
+				L4Proto: unix.IPPROTO_TCP,
+				Src:     &Port{List: SetPortList([]int{1024})},
+				Dst:     &Port{SetRef: &SetRef{Name: "web", ID: 1}},
+			}},
+		},
+		{
+			name: "Address list",
+			inline: &Rule{L3: &L3Rule{
+				Protocol: &proto,
+				Src:      &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.0.2.1"), setIPAddr(t, "192.0.2.2")}},
+				Dst:      &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "198.51.100.1")}},
+			}},
+			named: &Rule{L3: &L3Rule{
+				Protocol: &proto,
+				Src:      &IPAddrSpec{SetRef: &SetRef{Name: "clients", ID: 2}},
+				Dst:      &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "198.51.100.1")}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		var inline, named []expr.Any
+		var err error
+		if tt.inline.L4 != nil {
+			if inline, _, err = createL4(nftables.TableFamilyIPv4, tt.inline); err == nil {
+				named, _, err = createL4(nftables.TableFamilyIPv4, tt.named)
+			}
+		} else {
+			if inline, _, err = createL3(nftables.TableFamilyIPv4, tt.inline); err == nil {
+				named, _, err = createL3(nftables.TableFamilyIPv4, tt.named)
+			}
+		}
+		if err != nil {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if len(inline) != len(named) {
+			t.Errorf("Test \"%s\" inline expressions %+v and named set expressions %+v differ in length", tt.name, inline, named)
+			continue
+		}
+		for i := range inline {
+			// Only the lookup refers to a different set, all other expressions must be identical
+			if _, ok := inline[i].(*expr.Lookup); ok {
+				if _, ok := named[i].(*expr.Lookup); !ok {
+					t.Errorf("Test \"%s\" expression %d is %+v, expected lookup", tt.name, i, named[i])
+				}
+				continue
+			}
+			if !reflect.DeepEqual(inline[i], named[i]) {
+				t.Errorf("Test \"%s\" expression %d inline %+v does not match named set %+v", tt.name, i, inline[i], named[i])
+			}
+		}
+	}
+}
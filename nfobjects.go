@@ -0,0 +1,290 @@
+package nftableslib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// Types of stateful objects, values match NFT_OBJECT_* of linux/netfilter/nf_tables.h
+const (
+	ObjTypeCounter = 1
+	ObjTypeQuota   = 2
+	ObjTypeLimit   = 4
+	// ObjTypeCtTimeout is referenced by SetCtTimeout action only
+	ObjTypeCtTimeout = 7
+	// ObjTypeSynproxy requires a rule matching tcp syn packets in input or forward chain
+	ObjTypeSynproxy = 10
+)
+
+// Flags of synproxy objects, values match NF_SYNPROXY_OPT_* of linux/netfilter/nf_synproxy.h
+const (
+	SynproxySackPerm  = 0x4
+	SynproxyTimestamp = 0x8
+)
+
+// ObjectsInterface defines third level interface operating with nftables stateful objects
+type ObjectsInterface interface {
+	Objects() ObjectFuncs
+}
+
+// ObjectFuncs defines functions to operate with named stateful objects of a table,
+// objects are referenced by rules with Rule's ObjRef.
+type ObjectFuncs interface {
+	CreateCounter(name string) error
+	CreateLimit(name string, limit LimitAttributes) error
+	CreateQuota(name string, bytes uint64, over bool) error
+	CreateCtTimeout(name string, l4proto uint8, policy map[uint16]time.Duration) error
+	CreateSynproxy(name string, mss uint16, wscale uint8, flags uint32) error
+	GetCounter(name string) (*nftables.CounterObj, error)
+	Delete(name string) error
+	Get() ([]nftables.Obj, error)
+}
+
+type nfObjects struct {
+	conn  NetNS
+	table *nftables.Table
+	opts  Options
+	sync.Mutex
+	objs map[string]nftables.Obj
+}
+
+// Objects return a list of methods available for Objects operations
+func (nfo *nfObjects) Objects() ObjectFuncs {
+	return nfo
+}
+
+// CreateCounter creates a named counter object, a single counter object referenced by several rules
+// accounts packets and bytes matched by all of them.
+func (nfo *nfObjects) CreateCounter(name string) error {
+	return nfo.create(name, &nftables.CounterObj{
+		Table: nfo.table,
+		Name:  name,
+	})
+}
+
+// CreateLimit creates a named limit object, a single limit object referenced by several rules enforces
+// the aggregate rate of packets matched by all of them, a rule referencing it matches only while
+// the packets are within the limit.
+func (nfo *nfObjects) CreateLimit(name string, limit LimitAttributes) error {
+	if err := limit.Validate(); err != nil {
+		return err
+	}
+
+	return nfo.create(name, &nftables.NamedObj{
+		Table: nfo.table,
+		Name:  name,
+		Type:  nftables.ObjTypeLimit,
+		Obj: &expr.Limit{
+			Type:  limit.Type,
+			Rate:  limit.Rate,
+			Unit:  limit.Unit,
+			Burst: limit.Burst,
+		},
+	})
+}
+
+// CreateQuota creates a named quota object of bytes, a rule referencing it matches until the bytes
+// matched by all rules referencing the object exceed the quota, or only once they exceed it when over is true.
+func (nfo *nfObjects) CreateQuota(name string, bytes uint64, over bool) error {
+	if bytes == 0 {
+		return fmt.Errorf("quota bytes cannot be 0")
+	}
+
+	return nfo.create(name, &nftables.NamedObj{
+		Table: nfo.table,
+		Name:  name,
+		Type:  nftables.ObjTypeQuota,
+		Obj: &expr.Quota{
+			Bytes: bytes,
+			Over:  over,
+		},
+	})
+}
+
+// ctTimeoutDefaults carry default timeouts of conntrack states of tcp and udp, github.com/google/nftables
+// merges a policy into its package level defaults, hence policies are always passed with all states
+// to keep policies of different objects apart.
+var ctTimeoutDefaults = map[uint8]expr.CtStatePolicyTimeout{
+	unix.IPPROTO_TCP: copyCtPolicy(expr.CtStateTCPTimeoutDefaults),
+	unix.IPPROTO_UDP: copyCtPolicy(expr.CtStateUDPTimeoutDefaults),
+}
+
+func copyCtPolicy(policy expr.CtStatePolicyTimeout) expr.CtStatePolicyTimeout {
+	c := make(expr.CtStatePolicyTimeout, len(policy))
+	for state, timeout := range policy {
+		c[state] = timeout
+	}
+
+	return c
+}
+
+// CreateCtTimeout creates a named ct timeout policy for connections of l4proto, unix.IPPROTO_TCP or
+// unix.IPPROTO_UDP. policy maps conntrack states, expr.CtStateTCP* or expr.CtStateUDP* constants, to their
+// timeouts, example: {expr.CtStateTCPESTABLISHED: 2 * time.Hour}, states missing in policy keep default
+// timeouts. Rules assign the policy to connections with SetCtTimeout.
+func (nfo *nfObjects) CreateCtTimeout(name string, l4proto uint8, policy map[uint16]time.Duration) error {
+	defaults, ok := ctTimeoutDefaults[l4proto]
+	if !ok {
+		return fmt.Errorf("ct timeout policy supports only tcp and udp protocols, got %d", l4proto)
+	}
+	p := copyCtPolicy(defaults)
+	for state, timeout := range policy {
+		if _, ok := p[state]; !ok {
+			return fmt.Errorf("unsupported conntrack state %d of protocol %d", state, l4proto)
+		}
+		if timeout < time.Second {
+			return fmt.Errorf("timeout of conntrack state %d must be at least 1 second", state)
+		}
+		// Netlink expects timeout in seconds
+		p[state] = uint32(timeout / time.Second)
+	}
+
+	return nfo.create(name, &nftables.NamedObj{
+		Table: nfo.table,
+		Name:  name,
+		Type:  nftables.ObjTypeCtTimeout,
+		Obj: &expr.CtTimeout{
+			L3Proto: uint16(nfo.table.Family),
+			L4Proto: l4proto,
+			Policy:  p,
+		},
+	})
+}
+
+// CreateSynproxy creates a named synproxy object answering tcp syn packets with syn cookies, mss and wscale
+// are the maximum segment size and the window scale announced to clients, flags is a combination of
+// SynproxySackPerm and SynproxyTimestamp. Rules reference the object with ObjRef of ObjTypeSynproxy.
+func (nfo *nfObjects) CreateSynproxy(name string, mss uint16, wscale uint8, flags uint32) error {
+	if mss == 0 {
+		return fmt.Errorf("synproxy mss cannot be 0")
+	}
+	if wscale > tcpMaxWscale {
+		return fmt.Errorf("synproxy wscale %d exceeds maximum of %d", wscale, tcpMaxWscale)
+	}
+	if flags&^(SynproxySackPerm|SynproxyTimestamp) != 0 {
+		return fmt.Errorf("unsupported synproxy flags 0x%x", flags)
+	}
+	if err := nfo.opts.caps.check("synproxy", func(c Capabilities) bool { return c.Synproxy }); err != nil {
+		return err
+	}
+
+	return nfo.create(name, &nftables.NamedObj{
+		Table: nfo.table,
+		Name:  name,
+		Type:  nftables.ObjTypeSynProxy,
+		Obj: &expr.SynProxy{
+			Mss:            mss,
+			Wscale:         wscale,
+			SackPerm:       flags&SynproxySackPerm != 0,
+			Timestamp:      flags&SynproxyTimestamp != 0,
+			MssValueSet:    true,
+			WscaleValueSet: true,
+		},
+	})
+}
+
+// tcpMaxWscale is the maximum window scale of tcp, RFC 7323
+const tcpMaxWscale = 14
+
+func (nfo *nfObjects) create(name string, obj nftables.Obj) error {
+	nfo.Lock()
+	defer nfo.Unlock()
+	if _, ok := nfo.objs[name]; ok {
+		return fmt.Errorf("object %s already exists in table %s", name, nfo.table.Name)
+	}
+	o := nfo.conn.AddObj(obj)
+	if err := nfo.conn.Flush(); err != nil {
+		return err
+	}
+	nfo.objs[name] = o
+
+	return nil
+}
+
+// GetCounter returns counter object programmed on the host with the current values of packets and bytes
+func (nfo *nfObjects) GetCounter(name string) (*nftables.CounterObj, error) {
+	objs, err := nfo.conn.GetObjects(nfo.table)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range objs {
+		if c, ok := o.(*nftables.CounterObj); ok && c.Name == name {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("counter object %s is not found", name)
+}
+
+// Delete deletes the object, the object must not be referenced by any rule
+func (nfo *nfObjects) Delete(name string) error {
+	nfo.Lock()
+	defer nfo.Unlock()
+	o, ok := nfo.objs[name]
+	if !ok {
+		return fmt.Errorf("object %s does not exist", name)
+	}
+	nfo.conn.DeleteObject(o)
+	if err := nfo.conn.Flush(); err != nil {
+		return err
+	}
+	delete(nfo.objs, name)
+
+	return nil
+}
+
+// Get returns objects programmed on the host for the table
+func (nfo *nfObjects) Get() ([]nftables.Obj, error) {
+	return nfo.conn.GetObjects(nfo.table)
+}
+
+func newObjects(conn NetNS, t *nftables.Table, opts Options) ObjectsInterface {
+	return &nfObjects{
+		conn:  conn,
+		table: t,
+		opts:  opts,
+		objs:  make(map[string]nftables.Obj),
+	}
+}
+
+// ObjRefSpec defines a reference to a named stateful object, Type is one of ObjType* constants.
+// A rule referencing a limit object matches only while the packets are within the limit.
+type ObjRefSpec struct {
+	Type int
+	Name string
+}
+
+// Validate checks ObjRefSpec parameters
+func (o *ObjRefSpec) Validate() error {
+	switch o.Type {
+	case ObjTypeCounter:
+	case ObjTypeQuota:
+	case ObjTypeLimit:
+	case ObjTypeSynproxy:
+	default:
+		return fmt.Errorf("unsupported object type %d", o.Type)
+	}
+	if o.Name == "" {
+		return fmt.Errorf("object name cannot be empty")
+	}
+
+	return nil
+}
+
+func getExprForObjRef(o *ObjRefSpec) ([]expr.Any, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	// [ objref type 1 name fwded ]
+	return []expr.Any{
+		&expr.Objref{
+			Type: o.Type,
+			Name: o.Name,
+		},
+	}, nil
+}
@@ -0,0 +1,254 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetExprForObjRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *ObjRefSpec
+		expect  []expr.Any
+		success bool
+	}{
+		{
+			name:    "Counter object",
+			spec:    &ObjRefSpec{Type: ObjTypeCounter, Name: "fwded"},
+			expect:  []expr.Any{&expr.Objref{Type: 1, Name: "fwded"}},
+			success: true,
+		},
+		{
+			name:    "Limit object",
+			spec:    &ObjRefSpec{Type: ObjTypeLimit, Name: "aggregate"},
+			expect:  []expr.Any{&expr.Objref{Type: 4, Name: "aggregate"}},
+			success: true,
+		},
+		{
+			name:    "Synproxy object",
+			spec:    &ObjRefSpec{Type: ObjTypeSynproxy, Name: "https-synproxy"},
+			expect:  []expr.Any{&expr.Objref{Type: 10, Name: "https-synproxy"}},
+			success: true,
+		},
+		{
+			name:    "Unsupported object type",
+			spec:    &ObjRefSpec{Type: 100, Name: "unknown"},
+			success: false,
+		},
+		{
+			name:    "Empty name",
+			spec:    &ObjRefSpec{Type: ObjTypeQuota},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		e, err := getExprForObjRef(tt.spec)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(e, tt.expect) {
+			t.Errorf("Test \"%s\" expressions do not match expected expressions", tt.name)
+		}
+	}
+}
+
+// objConn records objects added to the connection
+type objConn struct {
+	NetNS
+	objs []nftables.Obj
+}
+
+func (o *objConn) AddObj(obj nftables.Obj) nftables.Obj {
+	o.objs = append(o.objs, obj)
+	return obj
+}
+
+func (o *objConn) Flush() error {
+	return nil
+}
+
+// ctPolicy returns a copy of defaults with timeout of state set to seconds
+func ctPolicy(defaults expr.CtStatePolicyTimeout, state uint16, seconds uint32) expr.CtStatePolicyTimeout {
+	policy := copyCtPolicy(defaults)
+	policy[state] = seconds
+	return policy
+}
+
+func TestCreateObjects(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	tests := []struct {
+		name    string
+		create  func(ObjectFuncs) error
+		expect  nftables.Obj
+		success bool
+	}{
+		{
+			name:    "Counter object",
+			create:  func(o ObjectFuncs) error { return o.CreateCounter("fwded") },
+			expect:  &nftables.CounterObj{Table: tbl, Name: "fwded"},
+			success: true,
+		},
+		{
+			name: "Limit object",
+			create: func(o ObjectFuncs) error {
+				return o.CreateLimit("aggregate", LimitAttributes{Rate: 100, Unit: expr.LimitTimeSecond, Burst: 10})
+			},
+			expect: &nftables.NamedObj{
+				Table: tbl,
+				Name:  "aggregate",
+				Type:  nftables.ObjTypeLimit,
+				Obj:   &expr.Limit{Type: expr.LimitTypePkts, Rate: 100, Unit: expr.LimitTimeSecond, Burst: 10},
+			},
+			success: true,
+		},
+		{
+			name: "Limit object with 0 rate",
+			create: func(o ObjectFuncs) error {
+				return o.CreateLimit("aggregate", LimitAttributes{Unit: expr.LimitTimeSecond})
+			},
+			success: false,
+		},
+		{
+			name:   "Quota object",
+			create: func(o ObjectFuncs) error { return o.CreateQuota("monthly", 1<<30, true) },
+			expect: &nftables.NamedObj{
+				Table: tbl,
+				Name:  "monthly",
+				Type:  nftables.ObjTypeQuota,
+				Obj:   &expr.Quota{Bytes: 1 << 30, Over: true},
+			},
+			success: true,
+		},
+		{
+			name: "Tcp ct timeout object",
+			create: func(o ObjectFuncs) error {
+				return o.CreateCtTimeout("db-timeout", unix.IPPROTO_TCP, map[uint16]time.Duration{expr.CtStateTCPESTABLISHED: 2 * time.Hour})
+			},
+			expect: &nftables.NamedObj{
+				Table: tbl,
+				Name:  "db-timeout",
+				Type:  nftables.ObjTypeCtTimeout,
+				Obj: &expr.CtTimeout{
+					L3Proto: uint16(nftables.TableFamilyIPv4),
+					L4Proto: unix.IPPROTO_TCP,
+					Policy:  ctPolicy(expr.CtStateTCPTimeoutDefaults, expr.CtStateTCPESTABLISHED, 7200),
+				},
+			},
+			success: true,
+		},
+		{
+			name: "Udp ct timeout object",
+			create: func(o ObjectFuncs) error {
+				return o.CreateCtTimeout("dns-timeout", unix.IPPROTO_UDP, map[uint16]time.Duration{expr.CtStateUDPREPLIED: 10 * time.Second})
+			},
+			expect: &nftables.NamedObj{
+				Table: tbl,
+				Name:  "dns-timeout",
+				Type:  nftables.ObjTypeCtTimeout,
+				Obj: &expr.CtTimeout{
+					L3Proto: uint16(nftables.TableFamilyIPv4),
+					L4Proto: unix.IPPROTO_UDP,
+					Policy:  ctPolicy(expr.CtStateUDPTimeoutDefaults, expr.CtStateUDPREPLIED, 10),
+				},
+			},
+			success: true,
+		},
+		{
+			name: "Ct timeout object of unsupported protocol",
+			create: func(o ObjectFuncs) error {
+				return o.CreateCtTimeout("icmp-timeout", unix.IPPROTO_ICMP, nil)
+			},
+			success: false,
+		},
+		{
+			name: "Ct timeout object with unsupported state",
+			create: func(o ObjectFuncs) error {
+				return o.CreateCtTimeout("dns-timeout", unix.IPPROTO_UDP, map[uint16]time.Duration{expr.CtStateTCPESTABLISHED: time.Hour})
+			},
+			success: false,
+		},
+		{
+			name: "Ct timeout object with timeout below a second",
+			create: func(o ObjectFuncs) error {
+				return o.CreateCtTimeout("db-timeout", unix.IPPROTO_TCP, map[uint16]time.Duration{expr.CtStateTCPCLOSE: time.Millisecond})
+			},
+			success: false,
+		},
+		{
+			name: "Synproxy object",
+			create: func(o ObjectFuncs) error {
+				return o.CreateSynproxy("https-synproxy", 1460, 7, SynproxySackPerm|SynproxyTimestamp)
+			},
+			expect: &nftables.NamedObj{
+				Table: tbl,
+				Name:  "https-synproxy",
+				Type:  nftables.ObjTypeSynProxy,
+				Obj: &expr.SynProxy{
+					Mss:            1460,
+					Wscale:         7,
+					SackPerm:       true,
+					Timestamp:      true,
+					MssValueSet:    true,
+					WscaleValueSet: true,
+				},
+			},
+			success: true,
+		},
+		{
+			name:    "Synproxy object with 0 mss",
+			create:  func(o ObjectFuncs) error { return o.CreateSynproxy("https-synproxy", 0, 7, 0) },
+			success: false,
+		},
+		{
+			name:    "Synproxy object with wscale over maximum",
+			create:  func(o ObjectFuncs) error { return o.CreateSynproxy("https-synproxy", 1460, 15, 0) },
+			success: false,
+		},
+		{
+			name:    "Synproxy object with unsupported flags",
+			create:  func(o ObjectFuncs) error { return o.CreateSynproxy("https-synproxy", 1460, 7, 0x10) },
+			success: false,
+		},
+		{
+			name:    "Quota object with 0 bytes",
+			create:  func(o ObjectFuncs) error { return o.CreateQuota("monthly", 0, false) },
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		conn := &objConn{}
+		objs := newObjects(conn, tbl, Options{}).Objects()
+		err := tt.create(objs)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if !tt.success {
+			if len(conn.objs) != 0 {
+				t.Errorf("Test \"%s\" failed but added objects to the connection", tt.name)
+			}
+			continue
+		}
+		if len(conn.objs) != 1 || !reflect.DeepEqual(conn.objs[0], tt.expect) {
+			t.Errorf("Test \"%s\" added objects %+v do not match expected object %+v", tt.name, conn.objs, tt.expect)
+		}
+		// Names of objects are unique in the table
+		if err := tt.create(objs); err == nil {
+			t.Errorf("Test \"%s\" created the object twice", tt.name)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package nftableslib
+
+import (
+	"fmt"
+)
+
+// TableDef defines a table with its chains and rules programmed by Provision, it is the same model
+// as a table of a Ruleset.
+type TableDef = TableSpec
+
+// ProvisionResult carries handles allocated by the kernel for rules programmed by Provision, the handles
+// are keyed by the chain name and follow the order of the chain's rules in TableDef.
+type ProvisionResult struct {
+	Handles map[string][]uint64
+}
+
+// Provision creates the table, its chains and rules in a single transaction and returns the handles of the rules.
+// The table must not exist, everything is validated and built before the host is changed, if the transaction
+// fails, nothing is programmed and the table is removed from the library's store. Unlike ApplyRuleset,
+// other tables are never touched.
+func (nft *nfTables) Provision(def *TableDef) (*ProvisionResult, error) {
+	if def == nil {
+		return nil, fmt.Errorf("table definition cannot be nil")
+	}
+	if err := (&Ruleset{Tables: []*TableSpec{def}}).Validate(); err != nil {
+		return nil, err
+	}
+	hostTables, err := nft.conn.ListTables()
+	if err != nil {
+		return nil, err
+	}
+	if findTable(hostTables, def.Name, def.Family) != nil {
+		return nil, fmt.Errorf("table %s of family %d already exists", def.Name, def.Family)
+	}
+	nft.Lock()
+	_, stored := nft.tables[def.Family][def.Name]
+	nft.Unlock()
+	if stored {
+		return nil, fmt.Errorf("table %s of family %d already exists", def.Name, def.Family)
+	}
+	// Table does not exist, all chains are new and rules are only built to validate them
+	tp, err := nft.planTable(def, hostTables, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := nft.prepareTable(tp); err != nil {
+		return nil, err
+	}
+	if err := nft.applyTable(tp, &Diff{}); err != nil {
+		return nil, err
+	}
+	if err := nft.conn.Flush(); err != nil {
+		nft.forget(def)
+		return nil, err
+	}
+
+	return nft.provisionHandles(def)
+}
+
+// forget removes the table from the library's store without touching the host
+func (nft *nfTables) forget(def *TableDef) {
+	nft.Lock()
+	defer nft.Unlock()
+	delete(nft.tables[def.Family], def.Name)
+}
+
+// provisionHandles reads back rules of provisioned chains and stores handles allocated by the kernel,
+// the chains were empty before the transaction, hence the kernel lists the rules in the order they were added.
+func (nft *nfTables) provisionHandles(def *TableDef) (*ProvisionResult, error) {
+	ci, err := nft.TableChains(def.Name, def.Family)
+	if err != nil {
+		return nil, err
+	}
+	result := &ProvisionResult{
+		Handles: make(map[string][]uint64),
+	}
+	for _, cs := range def.Chains {
+		ri, err := ci.Chains().Chain(cs.Name)
+		if err != nil {
+			return nil, err
+		}
+		nfr, ok := ri.(*nfRules)
+		if !ok {
+			return nil, fmt.Errorf("chain %s does not support provisioning", cs.Name)
+		}
+		rules, err := nft.conn.GetRule(nfr.table, nfr.chain)
+		if err != nil {
+			return nil, err
+		}
+		nfr.Lock()
+		// The store also carries rules dropping packets over the limit of rate limited reject actions
+		if n := nfr.countRules(); len(rules) != n {
+			nfr.Unlock()
+			return nil, fmt.Errorf("chain %s has %d rules, expected %d", cs.Name, len(rules), n)
+		}
+		handles := make([]uint64, 0, len(cs.Rules))
+		r := nfr.rules
+		for _, rule := range rules {
+			r.rule.Handle = rule.Handle
+			if r.limited == nil {
+				handles = append(handles, rule.Handle)
+			}
+			r = r.next
+		}
+		nfr.Unlock()
+		result.Handles[cs.Name] = handles
+	}
+
+	return result, nil
+}
@@ -1,6 +1,8 @@
 package nftableslib
 
 import (
+	"bytes"
+	"fmt"
 	"net"
 	"sort"
 
@@ -206,3 +208,71 @@ func addInverseMaskPlusOne(ip, mask []byte) []byte {
 
 	return r
 }
+
+// intervalBoundary is a start or an end of an interval element, top marks the end of an interval
+// which spans up to the last address, such end is carried by a key of all zeros.
+type intervalBoundary struct {
+	key     []byte
+	end     bool
+	top     bool
+	comment string
+}
+
+// mergeIntervals coalesces overlapping and adjacent intervals carried by elements of an interval set,
+// ends of the intervals are exclusive as programmed in the kernel. Elements can come in any order, as built
+// by the library or as listed by the kernel, an end of all zeros which does not close any interval is dropped.
+// A merged interval carries the comment of the element which opened it.
+func mergeIntervals(elements []nftables.SetElement) ([]nftables.SetElement, error) {
+	if len(elements) == 0 {
+		return elements, nil
+	}
+	l := len(elements[0].Key)
+	bs := make([]intervalBoundary, 0, len(elements))
+	for _, e := range elements {
+		if len(e.Key) != l {
+			return nil, fmt.Errorf("interval elements have keys of different length %d and %d", l, len(e.Key))
+		}
+		bs = append(bs, intervalBoundary{
+			key:     e.Key,
+			end:     e.IntervalEnd,
+			top:     e.IntervalEnd && bytes.Equal(e.Key, make([]byte, l)),
+			comment: e.Comment,
+		})
+	}
+	// Starts precede ends with the same key, so adjacent intervals are merged
+	sort.SliceStable(bs, func(i, j int) bool {
+		if bs[i].top != bs[j].top {
+			return bs[j].top
+		}
+		if c := bytes.Compare(bs[i].key, bs[j].key); c != 0 {
+			return c < 0
+		}
+		return !bs[i].end && bs[j].end
+	})
+	merged := make([]nftables.SetElement, 0, len(elements))
+	depth := 0
+	for _, b := range bs {
+		if !b.end {
+			if depth == 0 {
+				merged = append(merged, nftables.SetElement{Key: b.key, Comment: b.comment})
+			}
+			depth++
+			continue
+		}
+		if depth == 0 {
+			if b.top {
+				continue
+			}
+			return nil, fmt.Errorf("interval end %v does not have a start", b.key)
+		}
+		depth--
+		if depth == 0 {
+			merged = append(merged, nftables.SetElement{Key: b.key, IntervalEnd: true})
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("interval elements are missing %d ends", depth)
+	}
+
+	return merged, nil
+}
@@ -6,6 +6,8 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+
+	"github.com/google/nftables"
 )
 
 func TestGetMask(t *testing.T) {
@@ -255,3 +257,85 @@ func TestGetNetworks(t *testing.T) {
 		}
 	}
 }
+
+func TestMergeIntervals(t *testing.T) {
+	tests := []struct {
+		name     string
+		elements []nftables.SetElement
+		want     []nftables.SetElement
+		success  bool
+	}{
+		{
+			name: "adjacent and contained ranges",
+			elements: append(append(
+				buildElementRanges([]*IPAddr{setIPAddr(t, "10.0.0.0/24")}),
+				buildElementRanges([]*IPAddr{setIPAddr(t, "10.0.1.0/24")})...),
+				buildElementRanges([]*IPAddr{setIPAddr(t, "10.0.0.128/25")})...),
+			want: []nftables.SetElement{
+				{Key: []byte{10, 0, 0, 0}},
+				{Key: []byte{10, 0, 2, 0}, IntervalEnd: true},
+			},
+			success: true,
+		},
+		{
+			name: "disjoint ranges listed by the kernel in reverse order",
+			elements: []nftables.SetElement{
+				{Key: []byte{192, 0, 3, 0}, IntervalEnd: true},
+				{Key: []byte{192, 0, 2, 0}},
+				{Key: []byte{10, 0, 1, 0}, IntervalEnd: true},
+				{Key: []byte{10, 0, 0, 0}},
+				{Key: []byte{0, 0, 0, 0}, IntervalEnd: true},
+			},
+			want: []nftables.SetElement{
+				{Key: []byte{10, 0, 0, 0}},
+				{Key: []byte{10, 0, 1, 0}, IntervalEnd: true},
+				{Key: []byte{192, 0, 2, 0}},
+				{Key: []byte{192, 0, 3, 0}, IntervalEnd: true},
+			},
+			success: true,
+		},
+		{
+			name: "range up to the last address",
+			elements: []nftables.SetElement{
+				{Key: []byte{240, 0, 0, 0}},
+				{Key: []byte{0, 0, 0, 0}, IntervalEnd: true},
+				{Key: []byte{250, 0, 0, 0}},
+				{Key: []byte{251, 0, 0, 0}, IntervalEnd: true},
+			},
+			want: []nftables.SetElement{
+				{Key: []byte{240, 0, 0, 0}},
+				{Key: []byte{0, 0, 0, 0}, IntervalEnd: true},
+			},
+			success: true,
+		},
+		{
+			name: "missing end",
+			elements: []nftables.SetElement{
+				{Key: []byte{10, 0, 0, 0}},
+			},
+			success: false,
+		},
+		{
+			name: "mixed key length",
+			elements: []nftables.SetElement{
+				{Key: []byte{10, 0, 0, 0}},
+				{Key: net.ParseIP("2001:db8::").To16(), IntervalEnd: true},
+			},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		got, err := mergeIntervals(tt.elements)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Test \"%s\" failed, got: %+v want: %+v", tt.name, got, tt.want)
+		}
+	}
+}
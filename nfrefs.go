@@ -0,0 +1,123 @@
+package nftableslib
+
+import (
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// RuleRef identifies a rule programmed on the host by the chain it belongs to and its handle
+type RuleRef struct {
+	Chain  string
+	Handle uint64
+}
+
+// getRuleRefs scans rules of all chains of the table programmed on the host and returns references to rules
+// with at least one expression for which match returns true.
+func getRuleRefs(conn NetNS, table *nftables.Table, match func(expr.Any) bool) ([]RuleRef, error) {
+	chains, err := conn.ListChains()
+	if err != nil {
+		return nil, err
+	}
+	refs := []RuleRef{}
+	for _, chain := range chains {
+		if chain.Table.Name != table.Name || chain.Table.Family != table.Family {
+			continue
+		}
+		rules, err := conn.GetRule(table, chain)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			for _, e := range rule.Exprs {
+				if match(e) {
+					refs = append(refs, RuleRef{Chain: chain.Name, Handle: rule.Handle})
+					break
+				}
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// ReferencedBy returns rules of the table looking up or updating the set, the rules must be deleted before
+// the set can be deleted, otherwise the kernel rejects the deletion with EBUSY.
+func (nfs *nfSets) ReferencedBy(name string) ([]RuleRef, error) {
+	return getRuleRefs(nfs.conn, nfs.table, func(e expr.Any) bool {
+		switch exp := e.(type) {
+		case *expr.Lookup:
+			return exp.SetName == name
+		case *expr.Dynset:
+			return exp.SetName == name
+		}
+		return false
+	})
+}
+
+// ReferencedBy returns rules of the table jumping or going to the chain, the rules must be deleted before
+// the chain can be deleted, otherwise the kernel rejects the deletion with EBUSY. Jumps carried by elements
+// of verdict maps are not reported.
+func (nfc *nfChains) ReferencedBy(name string) ([]RuleRef, error) {
+	return getRuleRefs(nfc.conn, nfc.table, func(e expr.Any) bool {
+		v, ok := e.(*expr.Verdict)
+		return ok && (v.Kind == expr.VerdictJump || v.Kind == expr.VerdictGoto) && v.Chain == name
+	})
+}
+
+// ChainUseCount returns the number of references to the chain carried by rules of the table in the library's store,
+// jump and goto verdicts of the rules as well as chains of load balancing and verdict map actions are counted.
+// Rules programmed on the host bypassing the library are not counted, ReferencedBy lists them. Delete and
+// DeleteImm of a chain with a non zero use count fail without contacting the kernel.
+func (nfc *nfChains) ChainUseCount(name string) int {
+	nfc.Lock()
+	defer nfc.Unlock()
+
+	return nfc.useCount(name)
+}
+
+func (nfc *nfChains) useCount(name string) int {
+	count := 0
+	for _, ch := range nfc.chains {
+		nfr, ok := ch.RulesInterface.(*nfRules)
+		if !ok {
+			continue
+		}
+		nfr.Lock()
+		for r := nfr.rules; r != nil; r = r.next {
+			count += ruleChainUses(r, name)
+		}
+		nfr.Unlock()
+	}
+
+	return count
+}
+
+// ruleChainUses returns the number of references to the chain carried by the rule, a disabled rule
+// keeps its references.
+func ruleChainUses(r *nfRule, name string) int {
+	count := 0
+	for _, e := range r.rule.Exprs {
+		if v, ok := e.(*expr.Verdict); ok && (v.Kind == expr.VerdictJump || v.Kind == expr.VerdictGoto) && v.Chain == name {
+			count++
+		}
+	}
+	if r.spec == nil || r.spec.Action == nil {
+		return count
+	}
+	if lb := r.spec.Action.loadbalance; lb != nil {
+		for _, c := range lb.chains {
+			if c == name {
+				count++
+			}
+		}
+	}
+	if vm := r.spec.Action.vmap; vm != nil {
+		for _, e := range vm.Elements {
+			if e.Action != nil && e.Action.verdict != nil && e.Action.verdict.Chain == name {
+				count++
+			}
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,110 @@
+package nftableslib
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestReferencedBy(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	other := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv6}
+	conn := &listConn{
+		chains: []*nftables.Chain{
+			{Name: "input", Table: tbl},
+			{Name: "ssh", Table: tbl},
+			{Name: "forward", Table: other},
+		},
+		rules: map[string][]*nftables.Rule{
+			"input": {
+				{Handle: 1, Exprs: []expr.Any{&expr.Lookup{SourceRegister: 1, SetName: "blocked"}, &expr.Verdict{Kind: expr.VerdictDrop}}},
+				{Handle: 2, Exprs: []expr.Any{&expr.Verdict{Kind: expr.VerdictJump, Chain: "ssh"}}},
+				{Handle: 3, Exprs: []expr.Any{&expr.Verdict{Kind: expr.VerdictGoto, Chain: "ssh"}}},
+			},
+			"ssh": {
+				{Handle: 4, Exprs: []expr.Any{&expr.Dynset{SrcRegKey: 1, SetName: "blocked"}}},
+				{Handle: 5, Exprs: []expr.Any{&expr.Lookup{SourceRegister: 1, SetName: "allowed"}}},
+			},
+			"forward": {
+				{Handle: 6, Exprs: []expr.Any{&expr.Verdict{Kind: expr.VerdictJump, Chain: "ssh"}}},
+			},
+		},
+	}
+	refs, err := newSets(conn, tbl).Sets().ReferencedBy("blocked")
+	if err != nil {
+		t.Fatalf("Sets().ReferencedBy failed with error: %+v", err)
+	}
+	if want := []RuleRef{{Chain: "input", Handle: 1}, {Chain: "ssh", Handle: 4}}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("Sets().ReferencedBy returned %+v want: %+v", refs, want)
+	}
+	refs, err = newChains(conn, tbl, Options{}).Chains().ReferencedBy("ssh")
+	if err != nil {
+		t.Fatalf("Chains().ReferencedBy failed with error: %+v", err)
+	}
+	if want := []RuleRef{{Chain: "input", Handle: 2}, {Chain: "input", Handle: 3}}; !reflect.DeepEqual(refs, want) {
+		t.Errorf("Chains().ReferencedBy returned %+v want: %+v", refs, want)
+	}
+	refs, err = newChains(conn, tbl, Options{}).Chains().ReferencedBy("input")
+	if err != nil {
+		t.Fatalf("Chains().ReferencedBy failed with error: %+v", err)
+	}
+	if len(refs) != 0 {
+		t.Errorf("Chains().ReferencedBy returned %+v for a chain without references", refs)
+	}
+}
+
+func TestChainUseCount(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	conn := &applyConn{}
+	ci := newChains(conn, tbl, Options{})
+	if err := ci.Chains().Create("input", &ChainAttributes{
+		Type:     nftables.ChainTypeFilter,
+		Hook:     nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+	}); err != nil {
+		t.Fatalf("failed to create chain with error: %+v", err)
+	}
+	for _, name := range []string{"ssh", "web"} {
+		if err := ci.Chains().Create(name, nil); err != nil {
+			t.Fatalf("failed to create chain %s with error: %+v", name, err)
+		}
+	}
+	ri, err := ci.Chains().Chain("input")
+	if err != nil {
+		t.Fatalf("failed to get rules interface with error: %+v", err)
+	}
+	jump, err := ri.Rules().Create(&Rule{Action: setActionVerdict(t, unix.NFT_JUMP, "ssh")})
+	if err != nil {
+		t.Fatalf("failed to create jump rule with error: %+v", err)
+	}
+	lb, err := SetLoadbalance([]string{"ssh", "web"}, unix.NFT_GOTO, unix.NFT_NG_RANDOM)
+	if err != nil {
+		t.Fatalf("failed to SetLoadbalance with error: %+v", err)
+	}
+	balance, err := ri.Rules().Create(&Rule{Action: lb})
+	if err != nil {
+		t.Fatalf("failed to create load balancing rule with error: %+v", err)
+	}
+	if n := ci.Chains().ChainUseCount("ssh"); n != 2 {
+		t.Errorf("ChainUseCount of ssh returned %d want: 2", n)
+	}
+	if n := ci.Chains().ChainUseCount("input"); n != 0 {
+		t.Errorf("ChainUseCount of input returned %d want: 0", n)
+	}
+	if err := ci.Chains().Delete("ssh"); !errors.Is(err, unix.EBUSY) {
+		t.Errorf("Delete of referenced chain returned error %v, want: %v", err, unix.EBUSY)
+	}
+	if err := ri.Rules().Delete(jump); err != nil {
+		t.Fatalf("failed to delete jump rule with error: %+v", err)
+	}
+	if err := ri.Rules().Delete(balance); err != nil {
+		t.Fatalf("failed to delete load balancing rule with error: %+v", err)
+	}
+	if err := ci.Chains().Delete("ssh"); err != nil {
+		t.Errorf("Delete of unreferenced chain failed with error: %+v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package nftableslib
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+// AddAntiSpoof appends rules passing packets which source address is within localPrefixes or is reachable
+// through the interface the packet arrived on and dropping all other packets, a reverse path filter tolerating
+// local prefixes without a route back, example:
+// ip saddr @antispoof-1a2b3c4d5e6f return; fib saddr . iif oif missing drop.
+// The prefixes are kept in an interval set created with the rules, packets passing the check return to
+// the calling chain, so the chain should be dedicated to the check and reached by a jump from a filter chain
+// at prerouting hook. The set and the rules are programmed by a single Flush().
+func (nfr *nfRules) AddAntiSpoof(localPrefixes []*IPAddr) error {
+	if len(localPrefixes) == 0 {
+		return fmt.Errorf("at least one local prefix must be specified")
+	}
+	set := &nftables.Set{
+		Table:    nfr.table,
+		Name:     "antispoof-" + getSetName(),
+		ID:       uint32(rand.Intn(0xffff)),
+		Interval: true,
+		Comment:  generatedSetComment,
+	}
+	switch nfr.table.Family {
+	case nftables.TableFamilyIPv4:
+		set.KeyType = nftables.TypeIPAddr
+	case nftables.TableFamilyIPv6:
+		set.KeyType = nftables.TypeIP6Addr
+	default:
+		return fmt.Errorf("anti spoofing is supported only for ipv4 and ipv6 table families")
+	}
+	pass, err := SetVerdict(unix.NFT_RETURN)
+	if err != nil {
+		return err
+	}
+	drop, err := SetVerdict(NFT_DROP)
+	if err != nil {
+		return err
+	}
+	// Overlapping prefixes are merged the same way as prefixes of a map sharing a single verdict
+	pe := make([]*PrefixMapElement, len(localPrefixes))
+	for i, p := range localPrefixes {
+		pe[i] = &PrefixMapElement{Prefix: p, Action: pass}
+	}
+	elements, err := buildPrefixMapElements(pe, set.KeyType == nftables.TypeIP6Addr)
+	if err != nil {
+		return err
+	}
+	for i := range elements {
+		elements[i].VerdictData = nil
+	}
+	rules := []*Rule{
+		{
+			L3:     &L3Rule{Src: &IPAddrSpec{SetRef: &SetRef{Name: set.Name, ID: set.ID}}},
+			Action: pass,
+		},
+		{
+			Fib:    SetFibReversePath(true),
+			Action: drop,
+		},
+	}
+	nfr.Lock()
+	defer nfr.Unlock()
+	// Building all rules first, nothing is programmed if any of them fails
+	built := make([]*nfRule, len(rules))
+	for i, rule := range rules {
+		rr, err := nfr.buildRule(rule)
+		if err != nil {
+			return err
+		}
+		built[i] = rr
+	}
+	if err := nfr.conn.AddSet(set, elements); err != nil {
+		return err
+	}
+	built[0].sets = append(built[0].sets, &nfSet{set: set, elements: elements})
+	for i, rr := range built {
+		nfr.program(rr, rules[i], operationAdd)
+	}
+
+	return nfr.conn.Flush()
+}
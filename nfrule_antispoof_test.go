@@ -0,0 +1,81 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+func TestAddAntiSpoof(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "antispoof", Table: tbl}
+	conn := &applyConn{}
+	ri := newRules(conn, tbl, chain, Options{})
+	prefixes := []*IPAddr{setIPAddr(t, "10.0.0.0/8"), setIPAddr(t, "10.1.0.0/16"), setIPAddr(t, "192.0.2.0/24")}
+	if err := ri.Rules().AddAntiSpoof(prefixes); err != nil {
+		t.Fatalf("AddAntiSpoof failed with error: %+v", err)
+	}
+	if len(conn.addedRules) != 2 || len(conn.sets) != 1 || conn.flushes != 1 {
+		t.Fatalf("expected 2 rules and a set programmed in a single batch, got %d rules, %d sets and %d flushes",
+			len(conn.addedRules), len(conn.sets), conn.flushes)
+	}
+	set := conn.sets[0]
+	if !set.Interval || set.KeyType != nftables.TypeIPAddr {
+		t.Errorf("unexpected set %+v", set)
+	}
+	// Nested prefix is merged into the covering one
+	stored := ri.(*nfRules).rules
+	if len(stored.sets) != 1 {
+		t.Fatalf("set was not added to the store")
+	}
+	want := []nftables.SetElement{
+		{Key: []byte{10, 0, 0, 0}},
+		{Key: []byte{11, 0, 0, 0}, IntervalEnd: true},
+		{Key: []byte{192, 0, 2, 0}},
+		{Key: []byte{192, 0, 3, 0}, IntervalEnd: true},
+	}
+	if !reflect.DeepEqual(stored.sets[0].elements, want) {
+		t.Errorf("set elements %+v do not match expected %+v", stored.sets[0].elements, want)
+	}
+	// Local source returns to the calling chain, failing reverse path check drops
+	var lookup *expr.Lookup
+	var pass *expr.Verdict
+	for _, e := range conn.addedRules[0].Exprs {
+		switch e := e.(type) {
+		case *expr.Lookup:
+			lookup = e
+		case *expr.Verdict:
+			pass = e
+		}
+	}
+	if lookup == nil || lookup.SetName != set.Name || lookup.SetID != set.ID {
+		t.Errorf("first rule does not look up the set, got %+v", conn.addedRules[0].Exprs)
+	}
+	if pass == nil || pass.Kind != expr.VerdictReturn {
+		t.Errorf("first rule does not return, got %+v", conn.addedRules[0].Exprs)
+	}
+	var fib *expr.Fib
+	var drop *expr.Verdict
+	for _, e := range conn.addedRules[1].Exprs {
+		switch e := e.(type) {
+		case *expr.Fib:
+			fib = e
+		case *expr.Verdict:
+			drop = e
+		}
+	}
+	if fib == nil || !fib.FlagSADDR || !fib.FlagIIF || !fib.ResultOIF {
+		t.Errorf("second rule does not check reverse path, got %+v", conn.addedRules[1].Exprs)
+	}
+	if drop == nil || drop.Kind != expr.VerdictDrop {
+		t.Errorf("second rule does not drop, got %+v", conn.addedRules[1].Exprs)
+	}
+	if err := ri.Rules().AddAntiSpoof(nil); err == nil {
+		t.Errorf("AddAntiSpoof without prefixes supposed to fail but succeeded")
+	}
+	if err := ri.Rules().AddAntiSpoof([]*IPAddr{setIPAddr(t, "2001:db8::/32")}); err == nil {
+		t.Errorf("AddAntiSpoof with prefix of different family supposed to fail but succeeded")
+	}
+}
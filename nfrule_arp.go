@@ -0,0 +1,159 @@
+package nftableslib
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+)
+
+// ARP operations
+const (
+	ARPOpRequest = 1
+	ARPOpReply   = 2
+)
+
+// ARPSpec defines a match against fields of ARP header, it can only be used in tables of arp family.
+// Addresses are located assuming Ethernet hardware addresses and IPv4 protocol addresses, the only
+// combination the kernel's arp family handles. Only specified fields are matched and RelOp applies
+// to all of them. Sender's spoofing is prevented by a rule accepting the expected SenderIP and
+// SenderHW pair followed by a rule dropping SenderIP.
+type ARPSpec struct {
+	HType     *uint16          `json:"htype,omitempty"`
+	PType     *uint16          `json:"ptype,omitempty"`
+	Operation *uint16          `json:"operation,omitempty"`
+	SenderHW  net.HardwareAddr `json:"senderHW,omitempty"`
+	SenderIP  *IPAddr          `json:"senderIP,omitempty"`
+	TargetHW  net.HardwareAddr `json:"targetHW,omitempty"`
+	TargetIP  *IPAddr          `json:"targetIP,omitempty"`
+	RelOp     Operator         `json:"relOp,omitempty"`
+}
+
+// Validate checks ARPSpec parameters
+func (a *ARPSpec) Validate() error {
+	if a.HType == nil && a.PType == nil && a.Operation == nil && a.SenderHW == nil && a.SenderIP == nil &&
+		a.TargetHW == nil && a.TargetIP == nil {
+		return fmt.Errorf("at least one arp field must be specified")
+	}
+	for _, hw := range []net.HardwareAddr{a.SenderHW, a.TargetHW} {
+		if hw != nil && len(hw) != 6 {
+			return fmt.Errorf("arp hardware address %s is not an ethernet address", hw.String())
+		}
+	}
+	for _, ip := range []*IPAddr{a.SenderIP, a.TargetIP} {
+		if ip == nil {
+			continue
+		}
+		if ip.IPAddr == nil || ip.IsIPv6() {
+			return fmt.Errorf("arp protocol address must be ipv4 address")
+		}
+	}
+
+	return nil
+}
+
+// ARP header offsets for Ethernet and IPv4 addresses
+const (
+	arpHTypeOffset     = 0
+	arpPTypeOffset     = 2
+	arpOperationOffset = 6
+	arpSenderHWOffset  = 8
+	arpSenderIPOffset  = 14
+	arpTargetHWOffset  = 18
+	arpTargetIPOffset  = 24
+)
+
+func getExprForARP(family nftables.TableFamily, a *ARPSpec) ([]expr.Any, error) {
+	if family != nftables.TableFamilyARP {
+		return nil, fmt.Errorf("arp match is supported only for arp table family")
+	}
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	specs := []*PayloadSpec{}
+	for _, f := range []struct {
+		value  *uint16
+		offset uint32
+	}{
+		{a.HType, arpHTypeOffset},
+		{a.PType, arpPTypeOffset},
+		{a.Operation, arpOperationOffset},
+	} {
+		if f.value == nil {
+			continue
+		}
+		specs = append(specs, &PayloadSpec{Offset: f.offset, Len: 2, Value: binaryutil.BigEndian.PutUint16(*f.value)})
+	}
+	if a.SenderHW != nil {
+		specs = append(specs, &PayloadSpec{Offset: arpSenderHWOffset, Len: 6, Value: []byte(a.SenderHW)})
+	}
+	if a.SenderIP != nil {
+		specs = append(specs, arpIPPayload(arpSenderIPOffset, a.SenderIP))
+	}
+	if a.TargetHW != nil {
+		specs = append(specs, &PayloadSpec{Offset: arpTargetHWOffset, Len: 6, Value: []byte(a.TargetHW)})
+	}
+	if a.TargetIP != nil {
+		specs = append(specs, arpIPPayload(arpTargetIPOffset, a.TargetIP))
+	}
+	re := []expr.Any{}
+	for _, p := range specs {
+		// Payload base network header points to ARP header in arp family
+		// [ payload load 2b @ network header + 6 => reg 1 ]
+		// [ cmp eq reg 1 0x00000100 ]
+		p.Base = expr.PayloadBaseNetworkHeader
+		p.RelOp = a.RelOp
+		e, err := getExprForPayload(p)
+		if err != nil {
+			return nil, err
+		}
+		re = append(re, e...)
+	}
+
+	return re, nil
+}
+
+// arpIPPayload returns PayloadSpec matching ipv4 address or prefix at offset of ARP header
+func arpIPPayload(offset uint32, ip *IPAddr) *PayloadSpec {
+	addr := ip.IP.To4()
+	p := &PayloadSpec{Offset: offset, Len: 4, Value: addr}
+	if ip.CIDR && ip.Mask != nil && *ip.Mask < 32 {
+		p.Mask = getMask(*ip.Mask, 4)
+		value := make([]byte, 4)
+		for i := range value {
+			value[i] = addr[i] & p.Mask[i]
+		}
+		p.Value = value
+	}
+
+	return p
+}
+
+func (a *ARPSpec) clone() *ARPSpec {
+	if a == nil {
+		return nil
+	}
+	n := &ARPSpec{
+		SenderHW: net.HardwareAddr(cloneBytes(a.SenderHW)),
+		SenderIP: a.SenderIP.clone(),
+		TargetHW: net.HardwareAddr(cloneBytes(a.TargetHW)),
+		TargetIP: a.TargetIP.clone(),
+		RelOp:    a.RelOp,
+	}
+	if a.HType != nil {
+		v := *a.HType
+		n.HType = &v
+	}
+	if a.PType != nil {
+		v := *a.PType
+		n.PType = &v
+	}
+	if a.Operation != nil {
+		v := *a.Operation
+		n.Operation = &v
+	}
+
+	return n
+}
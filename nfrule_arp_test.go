@@ -0,0 +1,116 @@
+package nftableslib
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+func TestGetExprForARP(t *testing.T) {
+	reply := uint16(ARPOpReply)
+	hw, _ := net.ParseMAC("02:00:00:00:00:01")
+	tests := []struct {
+		name    string
+		family  nftables.TableFamily
+		spec    *ARPSpec
+		expect  []expr.Any
+		success bool
+	}{
+		{
+			name:   "reply from sender ip and hw",
+			family: nftables.TableFamilyARP,
+			spec:   &ARPSpec{Operation: &reply, SenderHW: hw, SenderIP: setIPAddr(t, "192.0.2.1")},
+			expect: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 6, Len: 2},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x0, 0x2}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 8, Len: 6},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x2, 0x0, 0x0, 0x0, 0x0, 0x1}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 14, Len: 4},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{192, 0, 2, 1}},
+			},
+			success: true,
+		},
+		{
+			name:   "target ip not in prefix",
+			family: nftables.TableFamilyARP,
+			spec:   &ARPSpec{TargetIP: setIPAddr(t, "192.0.2.0/24"), RelOp: NEQ},
+			expect: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 24, Len: 4},
+				&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: []byte{0xff, 0xff, 0xff, 0x0}, Xor: []byte{0x0, 0x0, 0x0, 0x0}},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{192, 0, 2, 0}},
+			},
+			success: true,
+		},
+		{
+			name:    "ipv4 table family",
+			family:  nftables.TableFamilyIPv4,
+			spec:    &ARPSpec{Operation: &reply},
+			success: false,
+		},
+		{
+			name:    "ipv6 sender address",
+			family:  nftables.TableFamilyARP,
+			spec:    &ARPSpec{SenderIP: setIPAddr(t, "2001:db8::1")},
+			success: false,
+		},
+		{
+			name:    "no fields",
+			family:  nftables.TableFamilyARP,
+			spec:    &ARPSpec{},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		e, err := getExprForARP(tt.family, tt.spec)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(e, tt.expect) {
+			t.Errorf("Test \"%s\" expressions do not match expected expressions", tt.name)
+		}
+	}
+	r := &Rule{ARP: tests[0].spec, Action: setActionVerdict(t, NFT_DROP)}
+	if err := r.Validate(nftables.TableFamilyIPv4, nil); err == nil {
+		t.Errorf("validation of arp rule in ipv4 table supposed to fail but succeeded")
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("failed to marshal arp rule with error: %+v", err)
+	}
+	n := &Rule{}
+	if err := json.Unmarshal(b, n); err != nil {
+		t.Fatalf("failed to unmarshal arp rule with error: %+v", err)
+	}
+	if !reflect.DeepEqual(r, n) || !reflect.DeepEqual(r, r.Clone()) {
+		t.Errorf("arp rule does not survive json round trip or clone, json: %s", string(b))
+	}
+}
+
+func TestARPChain(t *testing.T) {
+	tbl := &nftables.Table{Name: "arpfilter", Family: nftables.TableFamilyARP}
+	conn := &applyConn{}
+	ci := newChains(conn, tbl, Options{})
+	if err := ci.Chains().Create("input", &ChainAttributes{
+		Type:     nftables.ChainTypeFilter,
+		Hook:     nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+	}); err != nil {
+		t.Errorf("creation of arp input chain failed with error: %+v", err)
+	}
+	if err := ci.Chains().Create("forward", &ChainAttributes{
+		Type:     nftables.ChainTypeFilter,
+		Hook:     nftables.ChainHookForward,
+		Priority: nftables.ChainPriorityFilter,
+	}); err == nil {
+		t.Errorf("creation of arp forward chain supposed to fail but succeeded")
+	}
+}
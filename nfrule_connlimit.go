@@ -0,0 +1,86 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// AddPerSourceConnLimit appends a rule applying action to new connections from a source address which already
+// has more than limit connections tracked, example:
+// ct state new add @connlimit-1a2b3c4d5e6f { ip saddr ct count over 3 } reject.
+// A dynamic set keyed by the source address, which carries the connection count of each source, is created
+// with the rule, both are programmed by Flush(). The rule usually follows a match of the protected service,
+// for example in a regular chain jumped to by tcp dport 22 rule.
+func (nfr *nfRules) AddPerSourceConnLimit(limit uint32, action *RuleAction) error {
+	if limit == 0 {
+		return fmt.Errorf("connection limit must be greater than 0")
+	}
+	if err := nfr.opts.caps.check("connlimit", func(c Capabilities) bool { return c.Connlimit }); err != nil {
+		return err
+	}
+	if action == nil {
+		return fmt.Errorf("action cannot be nil")
+	}
+	if action.rateLimitedReject != nil {
+		return fmt.Errorf("rate limited reject cannot be used with per source connection limit")
+	}
+	var offset, l uint32
+	set := &nftables.Set{
+		Table:   nfr.table,
+		Name:    "connlimit-" + getSetName(),
+		Dynamic: true,
+		Comment: generatedSetComment,
+	}
+	switch nfr.table.Family {
+	case nftables.TableFamilyIPv4:
+		offset, l = 12, 4
+		set.KeyType = nftables.TypeIPAddr
+	case nftables.TableFamilyIPv6:
+		offset, l = 8, 16
+		set.KeyType = nftables.TypeIP6Addr
+	default:
+		return fmt.Errorf("per source connection limit is supported only for ipv4 and ipv6 table families")
+	}
+	nfr.Lock()
+	defer nfr.Unlock()
+	rule := &Rule{Action: action}
+	rr, err := nfr.buildRule(rule)
+	if err != nil {
+		return err
+	}
+	if err := nfr.conn.AddSet(set, nil); err != nil {
+		return err
+	}
+	re := getExprForConntracks([]*Conntrack{
+		{Key: unix.NFT_CT_STATE, Value: binaryutil.BigEndian.PutUint32(CTStateNew)},
+	})
+	// [ payload load 4b @ network header + 12 => reg 1 ]
+	re = append(re, &expr.Payload{
+		DestRegister: 1,
+		Base:         expr.PayloadBaseNetworkHeader,
+		Offset:       offset,
+		Len:          l,
+	})
+	// [ dynset add reg_key 1 set connlimit-1a2b3c4d5e6f [ connlimit count 3 flags 1 ] ]
+	re = append(re, &expr.Dynset{
+		SrcRegKey: 1,
+		Operation: unix.NFT_DYNSET_OP_ADD,
+		SetID:     set.ID,
+		SetName:   set.Name,
+		Exprs: []expr.Any{
+			&expr.Connlimit{
+				Count: limit,
+				Flags: expr.NFT_CONNLIMIT_F_INV,
+			},
+		},
+	})
+	rr.rule.Exprs = append(re, rr.rule.Exprs...)
+	rr.sets = append(rr.sets, &nfSet{set: set})
+	nfr.program(rr, rule, operationAdd)
+
+	return nil
+}
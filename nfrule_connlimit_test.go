@@ -0,0 +1,48 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestAddPerSourceConnLimit(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	conn := &applyConn{}
+	ri := newRules(conn, tbl, &nftables.Chain{Name: "ssh", Table: tbl}, Options{})
+	if err := ri.Rules().AddPerSourceConnLimit(3, setActionVerdict(t, NFT_DROP)); err != nil {
+		t.Fatalf("AddPerSourceConnLimit failed with error: %+v", err)
+	}
+	if len(conn.sets) != 1 || len(conn.addedRules) != 1 {
+		t.Fatalf("expected 1 set and 1 rule, got %d sets and %d rules", len(conn.sets), len(conn.addedRules))
+	}
+	set := conn.sets[0]
+	if !set.Dynamic || set.KeyType != nftables.TypeIPAddr {
+		t.Errorf("set %+v is not dynamic set of ipv4 addresses", set)
+	}
+	if set.Comment != generatedSetComment {
+		t.Errorf("set %+v is not marked as generated", set)
+	}
+	want := []expr.Any{
+		&expr.Ct{Key: unix.NFT_CT_STATE, Register: 1},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: []byte{0x08, 0x0, 0x0, 0x0}, Xor: []byte{0x0, 0x0, 0x0, 0x0}},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x0}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+		&expr.Dynset{
+			SrcRegKey: 1,
+			Operation: unix.NFT_DYNSET_OP_ADD,
+			SetName:   set.Name,
+			Exprs:     []expr.Any{&expr.Connlimit{Count: 3, Flags: expr.NFT_CONNLIMIT_F_INV}},
+		},
+		&expr.Verdict{Kind: expr.VerdictDrop},
+	}
+	if got := conn.addedRules[0].Exprs; !reflect.DeepEqual(got, want) {
+		t.Errorf("AddPerSourceConnLimit programmed %+v want: %+v", got, want)
+	}
+	if err := ri.Rules().AddPerSourceConnLimit(0, setActionVerdict(t, NFT_DROP)); err == nil {
+		t.Errorf("AddPerSourceConnLimit with 0 limit supposed to fail but succeeded")
+	}
+}
@@ -0,0 +1,33 @@
+package nftableslib
+
+import (
+	"fmt"
+)
+
+// AddConnmarkRestore appends a rule restoring packet's mark from the conntrack mark and applying action
+// when the restored mark matches mark, example: meta mark set ct mark meta mark 0x10 accept. The rule is
+// the same as a rule with Meta Mark having Restore set, the restored mark stays in the register, hence
+// the match does not load it again and restore with match cost a single rule on the hot path.
+func (nfr *nfRules) AddConnmarkRestore(mark *MetaMark, action *RuleAction) error {
+	if mark == nil {
+		return fmt.Errorf("mark cannot be nil")
+	}
+	if mark.Set {
+		return fmt.Errorf("mark to match cannot be used to set a mark")
+	}
+	if action == nil {
+		return fmt.Errorf("action cannot be nil")
+	}
+	restore := *mark
+	restore.Restore = true
+	if err := restore.Validate(); err != nil {
+		return err
+	}
+	nfr.Lock()
+	defer nfr.Unlock()
+	if _, err := nfr.create(&Rule{Meta: &Meta{Mark: &restore}, Action: action}, operationAdd); err != nil {
+		return err
+	}
+
+	return nfr.conn.Flush()
+}
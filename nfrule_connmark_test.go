@@ -0,0 +1,52 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestAddConnmarkRestore(t *testing.T) {
+	tbl := &nftables.Table{Name: "mangle", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "prerouting", Table: tbl}
+	conn := &applyConn{}
+	ri := newRules(conn, tbl, chain, Options{})
+	if err := ri.Rules().AddConnmarkRestore(&MetaMark{Value: 0x10, Mask: 0xff}, setActionVerdict(t, NFT_ACCEPT)); err != nil {
+		t.Fatalf("AddConnmarkRestore failed with error: %+v", err)
+	}
+	if len(conn.addedRules) != 1 || conn.flushes != 1 {
+		t.Fatalf("expected a single rule programmed in a single batch, got %d rules and %d flushes", len(conn.addedRules), conn.flushes)
+	}
+	want := []expr.Any{
+		&expr.Ct{Key: unix.NFT_CT_MARK, Register: 1},
+		&expr.Meta{Key: expr.MetaKeyMARK, Register: 1, SourceRegister: true},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(0xff),
+			Xor:            []byte{0x0, 0x0, 0x0, 0x0},
+		},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(0x10)},
+		&expr.Verdict{Kind: expr.VerdictAccept},
+	}
+	if got := conn.addedRules[0].Exprs; !reflect.DeepEqual(got, want) {
+		t.Errorf("rule expressions %+v do not match expected %+v", got, want)
+	}
+	if spec := ri.Rules().(*nfRules).rules.spec; spec.Meta == nil || spec.Meta.Mark == nil || !spec.Meta.Mark.Restore || spec.Meta.Mark.Value != 0x10 {
+		t.Errorf("stored rule %+v does not carry the restored mark", spec)
+	}
+	if err := ri.Rules().AddConnmarkRestore(&MetaMark{Set: true, Value: 0x10}, setActionVerdict(t, NFT_ACCEPT)); err == nil {
+		t.Errorf("AddConnmarkRestore with mark to set supposed to fail but succeeded")
+	}
+	if err := ri.Rules().AddConnmarkRestore(&MetaMark{Range: [2]uint32{0x1f, 0x10}}, setActionVerdict(t, NFT_ACCEPT)); err == nil {
+		t.Errorf("AddConnmarkRestore with invalid range supposed to fail but succeeded")
+	}
+	if err := (&MetaMark{Set: true, Restore: true}).Validate(); err == nil {
+		t.Errorf("validation of mark restored and set supposed to fail but succeeded")
+	}
+}
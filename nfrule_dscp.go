@@ -0,0 +1,147 @@
+package nftableslib
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+)
+
+// DSCP values of class selector, assured forwarding and expedited forwarding per hop behaviors
+const (
+	DSCPCS0  = 0x00
+	DSCPCS1  = 0x08
+	DSCPCS2  = 0x10
+	DSCPCS3  = 0x18
+	DSCPCS4  = 0x20
+	DSCPCS5  = 0x28
+	DSCPCS6  = 0x30
+	DSCPCS7  = 0x38
+	DSCPAF11 = 0x0a
+	DSCPAF12 = 0x0c
+	DSCPAF13 = 0x0e
+	DSCPAF21 = 0x12
+	DSCPAF22 = 0x14
+	DSCPAF23 = 0x16
+	DSCPAF31 = 0x1a
+	DSCPAF32 = 0x1c
+	DSCPAF33 = 0x1e
+	DSCPAF41 = 0x22
+	DSCPAF42 = 0x24
+	DSCPAF43 = 0x26
+	DSCPEF   = 0x2e
+)
+
+// DSCPSpec defines a match against DSCP field of IP header, it can be used in tables of ipv4 and ipv6 families.
+// More than one value in List is matched by a lookup into a constant named set the library generates for the rule,
+// the set outlives the rule until it is deleted by GC of Sets(), example:
+// DSCPSpec{List: []uint8{DSCPCS0, DSCPCS1, DSCPAF11}} matches ip dscp { cs0, cs1, af11 }. RelOp supports EQ and NEQ.
+type DSCPSpec struct {
+	List  []uint8
+	RelOp Operator
+}
+
+// Validate checks that DSCPSpec carries at least one value and all values fit into 6 bits
+func (d *DSCPSpec) Validate() error {
+	if len(d.List) == 0 {
+		return fmt.Errorf("dscp list cannot be empty")
+	}
+	for _, v := range d.List {
+		if v > 0x3f {
+			return fmt.Errorf("dscp value %#x exceeds maximum value of 0x3f", v)
+		}
+	}
+	if d.RelOp != EQ && d.RelOp != NEQ {
+		return fmt.Errorf("dscp supports only EQ and NEQ operators")
+	}
+
+	return nil
+}
+
+// getDSCPPayload returns PayloadSpec locating DSCP bits of IP header of the family, value is the DSCP value.
+// DSCP occupies high 6 bits of 2nd byte of IPv4 header and spans 1st and 2nd bytes of IPv6 header, the value
+// is kept in place of the field, the set's elements are compared against the masked field.
+func getDSCPPayload(family nftables.TableFamily, value uint8) (*PayloadSpec, error) {
+	switch family {
+	case nftables.TableFamilyIPv4:
+		return &PayloadSpec{
+			Base:   expr.PayloadBaseNetworkHeader,
+			Offset: 1,
+			Len:    1,
+			Value:  []byte{value << 2},
+			Mask:   []byte{0xfc},
+		}, nil
+	case nftables.TableFamilyIPv6:
+		return &PayloadSpec{
+			Base:   expr.PayloadBaseNetworkHeader,
+			Offset: 0,
+			Len:    2,
+			Value:  binaryutil.BigEndian.PutUint16(uint16(value) << 6),
+			Mask:   []byte{0x0f, 0xc0},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("dscp is supported only for ipv4 and ipv6 table families")
+}
+
+// getExprForDSCP returns expressions matching DSCP and a set carrying the values when more than one
+// value is specified.
+func getExprForDSCP(family nftables.TableFamily, d *DSCPSpec) ([]expr.Any, *nfSet, error) {
+	if err := d.Validate(); err != nil {
+		return nil, nil, err
+	}
+	p, err := getDSCPPayload(family, d.List[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(d.List) == 1 {
+		// [ payload load 1b @ network header + 1 => reg 1 ]
+		// [ bitwise reg 1 = (reg=1 & 0x000000fc ) ^ 0x00000000 ]
+		// [ cmp eq reg 1 0x00000028 ]
+		p.RelOp = d.RelOp
+		re, err := getExprForPayload(p)
+		return re, nil, err
+	}
+	// [ payload load 1b @ network header + 1 => reg 1 ]
+	// [ bitwise reg 1 = (reg=1 & 0x000000fc ) ^ 0x00000000 ]
+	// [ lookup reg 1 set __set%d ]
+	re := []expr.Any{
+		&expr.Payload{
+			DestRegister: 1,
+			Base:         p.Base,
+			Offset:       p.Offset,
+			Len:          p.Len,
+		},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            p.Len,
+			Mask:           p.Mask,
+			Xor:            make([]byte, p.Len),
+		},
+	}
+	keyType := nftables.TypeDSCP
+	keyType.Bytes = p.Len
+	set := &nftables.Set{
+		Anonymous: false,
+		Constant:  true,
+		Name:      getSetName(),
+		ID:        uint32(rand.Intn(0xffff)),
+		KeyType:   keyType,
+	}
+	se := make([]nftables.SetElement, len(d.List))
+	for i, v := range d.List {
+		p, _ := getDSCPPayload(family, v)
+		se[i].Key = p.Value
+	}
+	re = append(re, &expr.Lookup{
+		SourceRegister: 1,
+		Invert:         d.RelOp == NEQ,
+		SetID:          set.ID,
+		SetName:        set.Name,
+	})
+
+	return re, &nfSet{set: set, elements: se}, nil
+}
@@ -0,0 +1,103 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+func TestGetExprForDSCP(t *testing.T) {
+	tests := []struct {
+		name     string
+		family   nftables.TableFamily
+		dscp     *DSCPSpec
+		expect   []expr.Any
+		elements []nftables.SetElement
+		success  bool
+	}{
+		{
+			name:   "IPv4 single value",
+			family: nftables.TableFamilyIPv4,
+			dscp:   &DSCPSpec{List: []uint8{DSCPEF}},
+			expect: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 1, Len: 1},
+				&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 1, Mask: []byte{0xfc}, Xor: []byte{0x00}},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0xb8}},
+			},
+			success: true,
+		},
+		{
+			name:   "IPv4 set of values",
+			family: nftables.TableFamilyIPv4,
+			dscp:   &DSCPSpec{List: []uint8{DSCPCS0, DSCPCS1, DSCPAF11}},
+			expect: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 1, Len: 1},
+				&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 1, Mask: []byte{0xfc}, Xor: []byte{0x00}},
+			},
+			elements: []nftables.SetElement{{Key: []byte{0x00}}, {Key: []byte{0x20}}, {Key: []byte{0x28}}},
+			success:  true,
+		},
+		{
+			name:   "IPv6 set of values",
+			family: nftables.TableFamilyIPv6,
+			dscp:   &DSCPSpec{List: []uint8{DSCPAF41, DSCPEF}, RelOp: NEQ},
+			expect: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 0, Len: 2},
+				&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 2, Mask: []byte{0x0f, 0xc0}, Xor: []byte{0x00, 0x00}},
+			},
+			elements: []nftables.SetElement{{Key: []byte{0x08, 0x80}}, {Key: []byte{0x0b, 0x80}}},
+			success:  true,
+		},
+		{
+			name:    "Inet family",
+			family:  nftables.TableFamilyINet,
+			dscp:    &DSCPSpec{List: []uint8{DSCPEF}},
+			success: false,
+		},
+		{
+			name:    "Value out of range",
+			family:  nftables.TableFamilyIPv4,
+			dscp:    &DSCPSpec{List: []uint8{0x40}},
+			success: false,
+		},
+		{
+			name:    "Empty list",
+			family:  nftables.TableFamilyIPv4,
+			dscp:    &DSCPSpec{},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		e, set, err := getExprForDSCP(tt.family, tt.dscp)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if !tt.success {
+			continue
+		}
+		if tt.elements == nil {
+			if set != nil || !reflect.DeepEqual(e, tt.expect) {
+				t.Errorf("Test \"%s\" expressions %+v do not match expected %+v", tt.name, e, tt.expect)
+			}
+			continue
+		}
+		if set == nil || !reflect.DeepEqual(set.elements, tt.elements) || set.set.KeyType.Bytes != uint32(len(tt.elements[0].Key)) {
+			t.Errorf("Test \"%s\" did not produce expected set", tt.name)
+			continue
+		}
+		if !reflect.DeepEqual(e[:len(tt.expect)], tt.expect) {
+			t.Errorf("Test \"%s\" expressions %+v do not match expected %+v", tt.name, e, tt.expect)
+		}
+		lookup, ok := e[len(e)-1].(*expr.Lookup)
+		if !ok || lookup.SetName != set.set.Name || lookup.Invert != (tt.dscp.RelOp == NEQ) {
+			t.Errorf("Test \"%s\" does not end with lookup of the set", tt.name)
+		}
+	}
+}
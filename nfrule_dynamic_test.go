@@ -0,0 +1,95 @@
+package nftableslib
+
+import (
+	"testing"
+
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+// dynSetConn returns sets programmed on the host by name
+type dynSetConn struct {
+	NetNS
+	sets map[string]*nftables.Set
+	err  error
+}
+
+func (d *dynSetConn) GetSetByName(_ *nftables.Table, name string) (*nftables.Set, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if s, ok := d.sets[name]; ok {
+		return s, nil
+	}
+	return nil, unix.ENOENT
+}
+
+func TestValidateDynamicSet(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	tests := []struct {
+		name    string
+		set     string
+		err     error
+		success bool
+	}{
+		{
+			name:    "dynamic set of the store",
+			set:     "stored-dynamic",
+			success: true,
+		},
+		{
+			name:    "non dynamic set of the store",
+			set:     "stored",
+			success: false,
+		},
+		{
+			name:    "dynamic set of the host",
+			set:     "host-dynamic",
+			success: true,
+		},
+		{
+			name:    "non dynamic set of the host",
+			set:     "host",
+			success: false,
+		},
+		{
+			name:    "constant set of the host",
+			set:     "host-constant",
+			success: false,
+		},
+		{
+			name:    "set queued in the same batch",
+			set:     "queued",
+			success: true,
+		},
+		{
+			name:    "failure to get the set",
+			set:     "host-dynamic",
+			err:     unix.EPERM,
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		conn := &dynSetConn{
+			sets: map[string]*nftables.Set{
+				"stored":        {Name: "stored", Dynamic: true},
+				"host-dynamic":  {Name: "host-dynamic", Dynamic: true},
+				"host":          {Name: "host"},
+				"host-constant": {Name: "host-constant", Constant: true},
+			},
+			err: tt.err,
+		}
+		nfc := newChains(conn, tbl, Options{}).(*nfChains)
+		nfc.sets = newSets(conn, tbl).(*nfSets)
+		nfc.sets.sets["stored"] = &nftables.Set{Name: "stored"}
+		nfc.sets.sets["stored-dynamic"] = &nftables.Set{Name: "stored-dynamic", Dynamic: true}
+		nfr := nfc.newRules(&nftables.Chain{Name: "input", Table: tbl}).(*nfRules)
+		err := nfr.validateDynamicSet(&SetRef{Name: tt.set})
+		if tt.success && err != nil {
+			t.Errorf("test %q failed with error: %+v", tt.name, err)
+		}
+		if !tt.success && err == nil {
+			t.Errorf("test %q succeeded but supposed to fail", tt.name)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// ECN codepoints carried by the low 2 bits of IPv4 ToS and IPv6 traffic class
+const (
+	ECNNotECT = 0x0
+	ECNECT1   = 0x1
+	ECNECT0   = 0x2
+	ECNCE     = 0x3
+)
+
+// ECNSpec defines a match against ECN codepoint of IP header, it can be used in tables of ipv4 and ipv6 families,
+// example: ECNSpec{Value: ECNCE} matches packets marked Congestion Experienced.
+type ECNSpec struct {
+	Value uint8
+	RelOp Operator
+}
+
+// Validate checks that the codepoint fits into 2 bits
+func (e *ECNSpec) Validate() error {
+	if e.Value > ECNCE {
+		return fmt.Errorf("ecn codepoint %#x exceeds maximum value of %#x", e.Value, ECNCE)
+	}
+
+	return nil
+}
+
+// getECNPayload returns PayloadSpec locating ECN bits of IP header of the family, value is the codepoint.
+// ECN occupies low 2 bits of 2nd byte of IPv4 header and bits 2 and 3 of 2nd byte of IPv6 header.
+func getECNPayload(family nftables.TableFamily, value uint8) (*PayloadSpec, error) {
+	switch family {
+	case nftables.TableFamilyIPv4:
+		return &PayloadSpec{
+			Base:   expr.PayloadBaseNetworkHeader,
+			Offset: 1,
+			Len:    1,
+			Value:  []byte{value},
+			Mask:   []byte{0x03},
+		}, nil
+	case nftables.TableFamilyIPv6:
+		return &PayloadSpec{
+			Base:   expr.PayloadBaseNetworkHeader,
+			Offset: 1,
+			Len:    1,
+			Value:  []byte{value << 4},
+			Mask:   []byte{0x30},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("ecn is supported only for ipv4 and ipv6 table families")
+}
+
+func getExprForECN(family nftables.TableFamily, e *ECNSpec) ([]expr.Any, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	p, err := getECNPayload(family, e.Value)
+	if err != nil {
+		return nil, err
+	}
+	// [ payload load 1b @ network header + 1 => reg 1 ]
+	// [ bitwise reg 1 = (reg=1 & 0x00000003 ) ^ 0x00000000 ]
+	// [ cmp eq reg 1 0x00000003 ]
+	p.RelOp = e.RelOp
+
+	return getExprForPayload(p)
+}
+
+// SetECN builds RuleAction struct for an action setting ECN codepoint of IP header to value, other bits of
+// IPv4 ToS or IPv6 traffic class are preserved and IPv4 header checksum is updated.
+func SetECN(value uint8) (*RuleAction, error) {
+	if value > ECNCE {
+		return nil, fmt.Errorf("ecn codepoint %#x exceeds maximum value of %#x", value, ECNCE)
+	}
+
+	return &RuleAction{
+		ecn: &value,
+	}, nil
+}
+
+func getExprForSetECN(family nftables.TableFamily, value uint8) ([]expr.Any, error) {
+	p, err := getECNPayload(family, value)
+	if err != nil {
+		return nil, err
+	}
+	// [ payload load 1b @ network header + 1 => reg 1 ]
+	// [ bitwise reg 1 = (reg=1 & 0x000000fc ) ^ 0x00000001 ]
+	// [ payload write reg 1 => 1b @ network header + 1 csum_type 1 csum_off 10 csum_flags 0x0 ]
+	return getExprForPayloadWrite(family, &payloadWrite{spec: p}), nil
+}
@@ -0,0 +1,146 @@
+package nftableslib
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+func TestGetExprForECN(t *testing.T) {
+	tests := []struct {
+		name    string
+		family  nftables.TableFamily
+		ecn     *ECNSpec
+		expect  []expr.Any
+		success bool
+	}{
+		{
+			name:   "IPv4 congestion experienced",
+			family: nftables.TableFamilyIPv4,
+			ecn:    &ECNSpec{Value: ECNCE},
+			expect: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 1, Len: 1},
+				&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 1, Mask: []byte{0x03}, Xor: []byte{0x00}},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x03}},
+			},
+			success: true,
+		},
+		{
+			name:   "IPv6 not ecn capable",
+			family: nftables.TableFamilyIPv6,
+			ecn:    &ECNSpec{Value: ECNECT0, RelOp: NEQ},
+			expect: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 1, Len: 1},
+				&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 1, Mask: []byte{0x30}, Xor: []byte{0x00}},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x20}},
+			},
+			success: true,
+		},
+		{
+			name:    "Inet family",
+			family:  nftables.TableFamilyINet,
+			ecn:     &ECNSpec{Value: ECNCE},
+			success: false,
+		},
+		{
+			name:    "Codepoint out of range",
+			family:  nftables.TableFamilyIPv4,
+			ecn:     &ECNSpec{Value: 4},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		e, err := getExprForECN(tt.family, tt.ecn)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(e, tt.expect) {
+			t.Errorf("Test \"%s\" expressions %+v do not match expected %+v", tt.name, e, tt.expect)
+		}
+	}
+}
+
+func TestSetECN(t *testing.T) {
+	if _, err := SetECN(4); err == nil {
+		t.Errorf("SetECN with codepoint out of range supposed to fail but succeeded")
+	}
+	ra, err := SetECN(ECNECT1)
+	if err != nil {
+		t.Fatalf("SetECN failed with error: %+v", err)
+	}
+	tests := []struct {
+		name     string
+		family   nftables.TableFamily
+		mask     []byte
+		xor      []byte
+		csumType expr.PayloadCsumType
+		success  bool
+	}{
+		{
+			name:     "IPv4 with header checksum update",
+			family:   nftables.TableFamilyIPv4,
+			mask:     []byte{0xfc},
+			xor:      []byte{0x01},
+			csumType: expr.CsumTypeInet,
+			success:  true,
+		},
+		{
+			name:     "IPv6 without checksum",
+			family:   nftables.TableFamilyIPv6,
+			mask:     []byte{0xcf},
+			xor:      []byte{0x10},
+			csumType: expr.CsumTypeNone,
+			success:  true,
+		},
+		{
+			name:    "Bridge family",
+			family:  nftables.TableFamilyBridge,
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		e, err := Rule{Action: ra}.Expressions(tt.family)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if !tt.success {
+			continue
+		}
+		if len(e) != 3 {
+			t.Errorf("Test \"%s\" expected 3 expressions, got %+v", tt.name, e)
+			continue
+		}
+		b, ok := e[1].(*expr.Bitwise)
+		if !ok || !reflect.DeepEqual(b.Mask, tt.mask) || !reflect.DeepEqual(b.Xor, tt.xor) {
+			t.Errorf("Test \"%s\" unexpected bitwise %+v", tt.name, e[1])
+		}
+		w, ok := e[2].(*expr.Payload)
+		if !ok || w.OperationType != expr.PayloadWrite || w.CsumType != tt.csumType {
+			t.Errorf("Test \"%s\" unexpected payload write %+v", tt.name, e[2])
+		}
+	}
+	b, err := json.Marshal(ra)
+	if err != nil {
+		t.Fatalf("failed to marshal action with error: %+v", err)
+	}
+	var decoded RuleAction
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal action %s with error: %+v", string(b), err)
+	}
+	if !reflect.DeepEqual(&decoded, ra) {
+		t.Errorf("decoded action %+v does not match original %+v", decoded, ra)
+	}
+}
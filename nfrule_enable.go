@@ -0,0 +1,65 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// SetRuleEnabled enables or disables the rule identified by handle without removing it from the chain, for example
+// to toggle a feature flag. nftables has no notion of a disabled rule, the rule is disabled by prepending a break
+// verdict to its expressions, packets proceed to the next rule of the chain as if the rule did not match. The rule
+// keeps its handle, position, user data and references to its sets and chains, hence sets generated for the rule
+// are neither destroyed by the kernel nor deleted by GC. The rule dropping packets over the limit of rate limited
+// reject action is enabled and disabled together with its rule. Enabling an enabled rule or disabling a disabled
+// rule is a no-op, Update enables a disabled rule.
+func (nfr *nfRules) SetRuleEnabled(handle uint64, enabled bool) error {
+	nfr.Lock()
+	defer nfr.Unlock()
+	nfrule, err := getRuleByHandle(nfr.rules, handle)
+	if err != nil {
+		return err
+	}
+	if nfrule.limited != nil {
+		return fmt.Errorf("rule with handle %d drops packets over the limit of rate limited reject, the rule it follows must be enabled or disabled", handle)
+	}
+	if enabled == (nfrule.disabled == nil) {
+		return nil
+	}
+	toggled := []*nfRule{nfrule}
+	if over := nfrule.overLimit; over != nil && over.rule.Handle != 0 {
+		toggled = append(toggled, over)
+	}
+	rules := make([]*nftables.Rule, len(toggled))
+	for i, rr := range toggled {
+		r := &nftables.Rule{
+			Table:    rr.rule.Table,
+			Chain:    rr.rule.Chain,
+			Handle:   rr.rule.Handle,
+			UserData: rr.rule.UserData,
+		}
+		if enabled {
+			r.Exprs = rr.disabled
+		} else {
+			// [ immediate reg 0 break ]
+			r.Exprs = append([]expr.Any{&expr.Verdict{Kind: expr.VerdictBreak}}, rr.rule.Exprs...)
+		}
+		// Rule with a handle replaces the rule programmed on the host
+		nfr.conn.AddRule(r)
+		rules[i] = r
+	}
+	if err := nfr.conn.Flush(); err != nil {
+		return err
+	}
+	for i, rr := range toggled {
+		if enabled {
+			rr.disabled = nil
+		} else {
+			rr.disabled = rr.rule.Exprs
+		}
+		rr.rule = rules[i]
+	}
+
+	return nil
+}
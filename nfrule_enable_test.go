@@ -0,0 +1,105 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestSetRuleEnabled(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	conn := &applyConn{}
+	ri := newRules(conn, tbl, chain, Options{})
+	if _, err := ri.Rules().Create(&Rule{
+		L4: &L4Rule{
+			L4Proto: unix.IPPROTO_TCP,
+			Dst:     &Port{List: SetPortList([]int{8080, 8443})},
+		},
+		Action: setActionVerdict(t, NFT_DROP),
+	}); err != nil {
+		t.Fatalf("Create failed with error: %+v", err)
+	}
+	stored := ri.(*nfRules).rules
+	stored.rule.Handle = 7
+	original := stored.rule.Exprs
+	userData := stored.rule.UserData
+
+	if err := ri.Rules().SetRuleEnabled(7, false); err != nil {
+		t.Fatalf("SetRuleEnabled failed with error: %+v", err)
+	}
+	if len(conn.addedRules) != 2 {
+		t.Fatalf("expected rule to be replaced, got %+v", conn.addedRules)
+	}
+	disabled := conn.addedRules[1]
+	if disabled.Handle != 7 || !reflect.DeepEqual(disabled.UserData, userData) {
+		t.Errorf("disabled rule must keep its handle and user data, got %+v", disabled)
+	}
+	// The disabled rule keeps the lookup into the set generated for the port list
+	want := append([]expr.Any{&expr.Verdict{Kind: expr.VerdictBreak}}, original...)
+	if !reflect.DeepEqual(disabled.Exprs, want) {
+		t.Errorf("disabled rule must carry break verdict followed by its expressions, got %+v", disabled.Exprs)
+	}
+	// Disabling disabled rule does not touch the host
+	if err := ri.Rules().SetRuleEnabled(7, false); err != nil {
+		t.Fatalf("SetRuleEnabled failed with error: %+v", err)
+	}
+	if len(conn.addedRules) != 2 {
+		t.Errorf("disabling disabled rule must be no-op, got %+v", conn.addedRules)
+	}
+	if err := ri.Rules().SetRuleEnabled(7, true); err != nil {
+		t.Fatalf("SetRuleEnabled failed with error: %+v", err)
+	}
+	if len(conn.addedRules) != 3 || conn.addedRules[2].Handle != 7 || !reflect.DeepEqual(conn.addedRules[2].Exprs, original) {
+		t.Errorf("enabled rule must carry original expressions, got %+v", conn.addedRules)
+	}
+	if err := ri.Rules().SetRuleEnabled(70, false); err == nil {
+		t.Errorf("SetRuleEnabled of unknown handle supposed to fail but succeeded")
+	}
+}
+
+func TestSetRuleEnabledRateLimitedReject(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	conn := &applyConn{}
+	ri := newRules(conn, tbl, chain, Options{})
+	reject, err := SetRateLimitedReject(LimitAttributes{Rate: 10, Unit: expr.LimitTimeSecond, Burst: 5}, unix.NFT_REJECT_ICMPX_UNREACH)
+	if err != nil {
+		t.Fatalf("SetRateLimitedReject failed with error: %+v", err)
+	}
+	if _, err := ri.Rules().Create(&Rule{
+		L4:     &L4Rule{L4Proto: unix.IPPROTO_TCP, Dst: &Port{List: SetPortList([]int{23})}},
+		Action: reject,
+	}); err != nil {
+		t.Fatalf("Create failed with error: %+v", err)
+	}
+	stored := ri.(*nfRules).rules
+	stored.rule.Handle = 7
+	stored.overLimit.rule.Handle = 8
+	if err := ri.Rules().SetRuleEnabled(8, false); err == nil {
+		t.Errorf("SetRuleEnabled of the rule dropping packets over the limit supposed to fail but succeeded")
+	}
+	n := len(conn.addedRules)
+	if err := ri.Rules().SetRuleEnabled(7, false); err != nil {
+		t.Fatalf("SetRuleEnabled failed with error: %+v", err)
+	}
+	// The rule dropping packets over the limit is disabled together with the rule
+	replaced := conn.addedRules[n:]
+	if len(replaced) != 2 || replaced[0].Handle != 7 || replaced[1].Handle != 8 {
+		t.Fatalf("expected rules with handles 7 and 8 to be replaced, got %+v", replaced)
+	}
+	for _, r := range replaced {
+		if v, ok := r.Exprs[0].(*expr.Verdict); !ok || v.Kind != expr.VerdictBreak {
+			t.Errorf("disabled rule with handle %d must start with break verdict, got %+v", r.Handle, r.Exprs)
+		}
+	}
+	if err := ri.Rules().SetRuleEnabled(7, true); err != nil {
+		t.Fatalf("SetRuleEnabled failed with error: %+v", err)
+	}
+	if stored.disabled != nil || stored.overLimit.disabled != nil {
+		t.Errorf("enabled rule and the rule dropping packets over the limit must not be disabled")
+	}
+}
@@ -0,0 +1,120 @@
+package nftableslib
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// dryRunConn wraps NetNS and records sets the rule builder would add instead of adding them,
+// it is used to build rule's expressions without changing anything on the host.
+type dryRunConn struct {
+	NetNS
+	sets map[string]bool
+}
+
+func (d *dryRunConn) AddSet(s *nftables.Set, _ []nftables.SetElement) error {
+	// The same check as the connection does, the rule must fail to build the same way as it would for real
+	if s.Anonymous && !s.Constant {
+		return fmt.Errorf("anonymous structs must be constant")
+	}
+	d.sets[s.Name] = true
+	return nil
+}
+
+func (d *dryRunConn) GetSetByName(t *nftables.Table, name string) (*nftables.Set, error) {
+	if d.NetNS == nil {
+		return nil, fmt.Errorf("set %s cannot be looked up without connection", name)
+	}
+	return d.NetNS.GetSetByName(t, name)
+}
+
+// Expressions returns expressions the library would program for the rule in a regular chain of a table
+// of the family, nothing is sent to the kernel. Sets generated for the rule are not created, lookups into
+// such sets carry empty set name and id. It helps to find out why a rule does not match as expected.
+func (r Rule) Expressions(family nftables.TableFamily) ([]expr.Any, error) {
+	t := &nftables.Table{Family: family}
+	b := &nfRules{
+		conn: &dryRunConn{
+			sets: make(map[string]bool),
+		},
+		table: t,
+		chain: &nftables.Chain{Table: t},
+	}
+	rr, err := b.buildRule(&r)
+	if err != nil {
+		return nil, err
+	}
+
+	return rr.rule.Exprs, nil
+}
+
+// RuleExists checks if a rule with the same expressions as the rule would produce is already programmed
+// in the chain. Automatically generated sets get random names, hence lookups into such sets are matched
+// regardless of the set name, elements of the sets are not compared.
+func (nfr *nfRules) RuleExists(rule *Rule) (bool, error) {
+	nfr.Lock()
+	defer nfr.Unlock()
+	dry := &dryRunConn{
+		NetNS: nfr.conn,
+		sets:  make(map[string]bool),
+	}
+	b := &nfRules{
+		conn:  dry,
+		opts:  nfr.opts,
+		table: nfr.table,
+		chain: nfr.chain,
+	}
+	rr, err := b.buildRule(rule)
+	if err != nil {
+		return false, err
+	}
+	rules, err := nfr.conn.GetRule(nfr.table, nfr.chain)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range rules {
+		if isEqualExprs(rr.rule.Exprs, r.Exprs, dry.sets) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// isEqualExprs compares desired expressions with expressions of the programmed rule, generated
+// carries names of automatically generated sets.
+func isEqualExprs(want, got []expr.Any, generated map[string]bool) bool {
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		switch w := want[i].(type) {
+		case *expr.Counter:
+			// Counter's values change with traffic
+			if _, ok := got[i].(*expr.Counter); !ok {
+				return false
+			}
+		case *expr.Lookup:
+			g, ok := got[i].(*expr.Lookup)
+			if !ok {
+				return false
+			}
+			if w.SourceRegister != g.SourceRegister || w.DestRegister != g.DestRegister ||
+				w.IsDestRegSet != g.IsDestRegSet || w.Invert != g.Invert {
+				return false
+			}
+			if !generated[w.SetName] && w.SetName != g.SetName {
+				return false
+			}
+		default:
+			if !reflect.DeepEqual(want[i], got[i]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
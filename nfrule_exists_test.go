@@ -0,0 +1,130 @@
+package nftableslib
+
+import (
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+func TestIsEqualExprs(t *testing.T) {
+	tests := []struct {
+		name      string
+		want      []expr.Any
+		got       []expr.Any
+		generated map[string]bool
+		equal     bool
+	}{
+		{
+			name:  "Equal expressions with counter values",
+			want:  []expr.Any{&expr.Counter{}, &expr.Verdict{Kind: expr.VerdictAccept}},
+			got:   []expr.Any{&expr.Counter{Bytes: 100, Packets: 1}, &expr.Verdict{Kind: expr.VerdictAccept}},
+			equal: true,
+		},
+		{
+			name:  "Different verdicts",
+			want:  []expr.Any{&expr.Verdict{Kind: expr.VerdictAccept}},
+			got:   []expr.Any{&expr.Verdict{Kind: expr.VerdictDrop}},
+			equal: false,
+		},
+		{
+			name:      "Lookup into generated set",
+			want:      []expr.Any{&expr.Lookup{SourceRegister: 1, SetName: "a1b2c3", SetID: 5}},
+			got:       []expr.Any{&expr.Lookup{SourceRegister: 1, SetName: "d4e5f6"}},
+			generated: map[string]bool{"a1b2c3": true},
+			equal:     true,
+		},
+		{
+			name:  "Lookup into different named sets",
+			want:  []expr.Any{&expr.Lookup{SourceRegister: 1, SetName: "allowed"}},
+			got:   []expr.Any{&expr.Lookup{SourceRegister: 1, SetName: "blocked"}},
+			equal: false,
+		},
+		{
+			name:  "Different number of expressions",
+			want:  []expr.Any{&expr.Counter{}},
+			got:   []expr.Any{&expr.Counter{}, &expr.Verdict{Kind: expr.VerdictAccept}},
+			equal: false,
+		},
+	}
+	for _, tt := range tests {
+		if equal := isEqualExprs(tt.want, tt.got, tt.generated); equal != tt.equal {
+			t.Errorf("Test \"%s\" failed, got: %t want: %t", tt.name, equal, tt.equal)
+		}
+	}
+}
+
+func TestRuleExists(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	single := &Rule{
+		L3: &L3Rule{
+			Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.0.2.1")}},
+		},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}
+	multiple := &Rule{
+		L3: &L3Rule{
+			Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.0.2.1"), setIPAddr(t, "192.0.2.2")}},
+		},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}
+	singleExprs, err := single.Expressions(tbl.Family)
+	if err != nil {
+		t.Fatalf("Expressions failed with error: %+v", err)
+	}
+	multipleExprs, err := multiple.Expressions(tbl.Family)
+	if err != nil {
+		t.Fatalf("Expressions failed with error: %+v", err)
+	}
+	// The host carries the rule with a lookup into a generated set of another name
+	var hostExprs []expr.Any
+	lookups := 0
+	for _, e := range multipleExprs {
+		if l, ok := e.(*expr.Lookup); ok {
+			e = &expr.Lookup{SourceRegister: l.SourceRegister, SetName: "a1b2c3d4e5f6", SetID: 7}
+			lookups++
+		}
+		hostExprs = append(hostExprs, e)
+	}
+	if lookups != 1 {
+		t.Fatalf("expected a lookup into a generated set, got %d lookups", lookups)
+	}
+	tests := []struct {
+		name   string
+		host   []*nftables.Rule
+		rule   *Rule
+		exists bool
+	}{
+		{
+			name:   "Programmed rule",
+			host:   []*nftables.Rule{{Handle: 1, Exprs: singleExprs}},
+			rule:   single,
+			exists: true,
+		},
+		{
+			name:   "Missing rule",
+			host:   []*nftables.Rule{{Handle: 1, Exprs: hostExprs}},
+			rule:   single,
+			exists: false,
+		},
+		{
+			name:   "Generated set of another name",
+			host:   []*nftables.Rule{{Handle: 1, Exprs: singleExprs}, {Handle: 2, Exprs: hostExprs}},
+			rule:   multiple,
+			exists: true,
+		},
+	}
+	for _, tt := range tests {
+		conn := &listConn{rules: map[string][]*nftables.Rule{chain.Name: tt.host}}
+		ri := newRules(conn, tbl, chain, Options{})
+		exists, err := ri.Rules().RuleExists(tt.rule)
+		if err != nil {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if exists != tt.exists {
+			t.Errorf("Test \"%s\" failed, got: %t want: %t", tt.name, exists, tt.exists)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables/expr"
+)
+
+// consistentHash defines action dispatching packets by jhash of packet's fields through a named vmap
+type consistentHash struct {
+	fields  []PayloadSpec
+	mapName string
+	modulus uint32
+	seed    uint32
+}
+
+// SetConsistentHash builds RuleAction struct for an action computing jhash over the packet's fields
+// modulo modulus and using the result as a key into a named vmap { integer : verdict }, example:
+// jhash ip saddr . tcp sport mod 4 vmap @backends. Only Base, Offset and Len of fields are used.
+// The hash seed is 0, hence the same packet's fields hash to the same key when the rule is
+// reprogrammed, as long as modulus does not change, backends can be replaced by updating the map's elements.
+func SetConsistentHash(fields []PayloadSpec, mapName string, modulus uint32) (*RuleAction, error) {
+	return SetConsistentHashWithSeed(fields, mapName, modulus, 0)
+}
+
+// SetConsistentHashWithSeed is SetConsistentHash with an explicit hash seed, example:
+// jhash ip saddr mod 4 seed 0xdeadbeef vmap @backends. Nodes programmed with the same seed and modulus
+// dispatch the same flow to the same key.
+func SetConsistentHashWithSeed(fields []PayloadSpec, mapName string, modulus uint32, seed uint32) (*RuleAction, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("at least one field must be specified for hashing")
+	}
+	if mapName == "" {
+		return nil, fmt.Errorf("map name cannot be empty")
+	}
+	if modulus == 0 {
+		return nil, fmt.Errorf("modulus cannot be 0")
+	}
+	var words uint32
+	for _, f := range fields {
+		switch f.Base {
+		case expr.PayloadBaseLLHeader:
+		case expr.PayloadBaseNetworkHeader:
+		case expr.PayloadBaseTransportHeader:
+		default:
+			return nil, fmt.Errorf("unsupported payload base %d", f.Base)
+		}
+		if f.Len == 0 {
+			return nil, fmt.Errorf("payload length cannot be 0")
+		}
+		words += (f.Len + 3) / 4
+	}
+	// Fields are loaded into consecutive 32 bit registers, maximum 16 registers are available
+	if words > 16 {
+		return nil, fmt.Errorf("total length of fields exceeds 64 bytes")
+	}
+	ra := &RuleAction{
+		consistentHash: &consistentHash{
+			fields:  fields,
+			mapName: mapName,
+			modulus: modulus,
+			seed:    seed,
+		},
+	}
+
+	return ra, nil
+}
+
+func getExprForConsistentHash(h *consistentHash) []expr.Any {
+	re := []expr.Any{}
+	var words uint32
+	for _, f := range h.fields {
+		// First field goes to the register 1, following fields go to consecutive 32 bit registers
+		// starting with the register 8, which overlays the register 1.
+		register := uint32(1)
+		if words != 0 {
+			register = 8 + words
+		}
+		// [ payload load 4b @ network header + 12 => reg 1 ]
+		re = append(re, &expr.Payload{
+			DestRegister: register,
+			Base:         f.Base,
+			Offset:       f.Offset,
+			Len:          f.Len,
+		})
+		words += (f.Len + 3) / 4
+	}
+	// [ hash reg 1 = jhash(reg 1, 8, 0xdeadbeef) % mod 4 ]
+	re = append(re, &expr.Hash{
+		SourceRegister: 1,
+		DestRegister:   1,
+		Length:         words * 4,
+		Modulus:        h.modulus,
+		Seed:           h.seed,
+		Type:           expr.HashTypeJenkins,
+	})
+	// [ lookup reg 1 set backends dreg 0 ]
+	re = append(re, &expr.Lookup{
+		SourceRegister: 1,
+		DestRegister:   0,
+		IsDestRegSet:   true,
+		SetName:        h.mapName,
+	})
+
+	return re
+}
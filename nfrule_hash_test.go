@@ -0,0 +1,55 @@
+package nftableslib
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables/expr"
+)
+
+func TestGetExprForConsistentHash(t *testing.T) {
+	fields := []PayloadSpec{
+		{Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+		{Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 2},
+	}
+	ra, err := SetConsistentHash(fields, "backends", 4)
+	if err != nil {
+		t.Fatalf("SetConsistentHash failed with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+		&expr.Payload{DestRegister: 9, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 2},
+		&expr.Hash{SourceRegister: 1, DestRegister: 1, Length: 8, Modulus: 4, Type: expr.HashTypeJenkins},
+		&expr.Lookup{SourceRegister: 1, DestRegister: 0, IsDestRegSet: true, SetName: "backends"},
+	}
+	if got := getExprForConsistentHash(ra.consistentHash); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForConsistentHash returned %+v want: %+v", got, want)
+	}
+	if _, err := SetConsistentHash(fields, "backends", 0); err == nil {
+		t.Errorf("SetConsistentHash succeeded with modulus 0 but supposed to fail")
+	}
+	if _, err := SetConsistentHash(nil, "backends", 4); err == nil {
+		t.Errorf("SetConsistentHash succeeded without fields but supposed to fail")
+	}
+
+	ra, err = SetConsistentHashWithSeed(fields[:1], "backends", 4, 0xdeadbeef)
+	if err != nil {
+		t.Fatalf("SetConsistentHashWithSeed failed with error: %+v", err)
+	}
+	got := getExprForConsistentHash(ra.consistentHash)
+	if h, ok := got[1].(*expr.Hash); !ok || h.Seed != 0xdeadbeef {
+		t.Errorf("getExprForConsistentHash did not carry the seed, got %+v", got)
+	}
+	b, err := json.Marshal(ra)
+	if err != nil {
+		t.Fatalf("failed to marshal action with error: %+v", err)
+	}
+	var ua RuleAction
+	if err := json.Unmarshal(b, &ua); err != nil {
+		t.Fatalf("failed to unmarshal action with error: %+v", err)
+	}
+	if ua.consistentHash == nil || ua.consistentHash.seed != 0xdeadbeef {
+		t.Errorf("seed was lost in json round trip, got %s", string(b))
+	}
+}
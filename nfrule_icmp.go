@@ -0,0 +1,123 @@
+package nftableslib
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// ICMPv6 neighbor discovery message types, RFC 4861
+const (
+	ICMPv6RouterSolicitation    = 133
+	ICMPv6RouterAdvertisement   = 134
+	ICMPv6NeighborSolicitation  = 135
+	ICMPv6NeighborAdvertisement = 136
+	ICMPv6Redirect              = 137
+)
+
+// ICMPTypeSpec defines a match of icmp or icmpv6 type, L4Rule's L4Proto selects which one.
+// More than one type in List is matched by a lookup into a constant named set the library generates for the rule,
+// the set outlives the rule until it is deleted by GC of Sets(), example:
+// icmpv6 type { nd-router-solicit, nd-neighbor-solicit }. RelOp supports EQ and NEQ.
+type ICMPTypeSpec struct {
+	List  []uint8
+	RelOp Operator
+}
+
+// Validate checks parameters of ICMPTypeSpec struct
+func (i *ICMPTypeSpec) Validate() error {
+	if len(i.List) == 0 {
+		return fmt.Errorf("icmp type list cannot be empty")
+	}
+	if i.RelOp != EQ && i.RelOp != NEQ {
+		return fmt.Errorf("icmp type supports only EQ and NEQ operators")
+	}
+
+	return nil
+}
+
+// getExprForICMPType returns expressions matching icmp type and a set carrying the types
+// when more than one type is specified.
+func getExprForICMPType(l4proto uint8, spec *ICMPTypeSpec) ([]expr.Any, *nfSet) {
+	// [ meta load l4proto => reg 1 ]
+	// [ cmp eq reg 1 0x0000003a ]
+	// [ payload load 1b @ transport header + 0 => reg 1 ]
+	// [ lookup reg 1 set __set%d ]
+	re := getExprForL4Proto(l4proto)
+	re = append(re, &expr.Payload{
+		DestRegister: 1,
+		Base:         expr.PayloadBaseTransportHeader,
+		Offset:       0, // Type is the first byte of icmp header
+		Len:          1,
+	})
+	if len(spec.List) == 1 {
+		re = append(re, &expr.Cmp{
+			Op:       cmpOp(spec.RelOp),
+			Register: 1,
+			Data:     []byte{spec.List[0]},
+		})
+		return re, nil
+	}
+	set := &nftables.Set{
+		Anonymous: false,
+		Constant:  true,
+		Name:      getSetName(),
+		ID:        uint32(rand.Intn(0xffff)),
+		KeyType:   nftables.TypeICMPType,
+	}
+	if l4proto == unix.IPPROTO_ICMPV6 {
+		set.KeyType = nftables.TypeICMP6Type
+	}
+	se := make([]nftables.SetElement, len(spec.List))
+	for i, t := range spec.List {
+		se[i].Key = []byte{t}
+	}
+	re = append(re, &expr.Lookup{
+		SourceRegister: 1,
+		Invert:         spec.RelOp == NEQ,
+		SetID:          set.ID,
+		SetName:        set.Name,
+	})
+
+	return re, &nfSet{set: set, elements: se}
+}
+
+// AllowIPv6NDP appends a rule accepting icmpv6 neighbor discovery messages: router and neighbor
+// solicitations and advertisements and redirects, example:
+// icmpv6 type { nd-router-solicit, nd-router-advert, nd-neighbor-solicit, nd-neighbor-advert, nd-redirect } accept.
+// The rule should precede a rule dropping icmpv6, otherwise IPv6 address resolution fails.
+// The rule and its set are programmed in a single batch, the rule is stored with the handle allocated by the kernel.
+func (nfr *nfRules) AllowIPv6NDP() error {
+	if nfr.table.Family != nftables.TableFamilyIPv6 && nfr.table.Family != nftables.TableFamilyINet {
+		return fmt.Errorf("neighbor discovery is supported only for ipv6 and inet table families")
+	}
+	accept, err := SetVerdict(NFT_ACCEPT)
+	if err != nil {
+		return err
+	}
+	rule := &Rule{
+		L4: &L4Rule{
+			L4Proto: unix.IPPROTO_ICMPV6,
+			ICMPType: &ICMPTypeSpec{
+				List: []uint8{
+					ICMPv6RouterSolicitation,
+					ICMPv6RouterAdvertisement,
+					ICMPv6NeighborSolicitation,
+					ICMPv6NeighborAdvertisement,
+					ICMPv6Redirect,
+				},
+			},
+		},
+		Action: accept,
+	}
+	nfr.Lock()
+	defer nfr.Unlock()
+	if _, err := nfr.createImm(rule); err != nil {
+		return err
+	}
+
+	return nil
+}
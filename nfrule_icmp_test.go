@@ -0,0 +1,124 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetExprForICMPType(t *testing.T) {
+	tests := []struct {
+		name    string
+		l4      *L4Rule
+		expect  []expr.Any
+		set     bool
+		success bool
+	}{
+		{
+			name: "Single icmpv6 type",
+			l4:   &L4Rule{L4Proto: unix.IPPROTO_ICMPV6, ICMPType: &ICMPTypeSpec{List: []uint8{ICMPv6NeighborSolicitation}}},
+			expect: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_ICMPV6}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{ICMPv6NeighborSolicitation}},
+			},
+			success: true,
+		},
+		{
+			name:    "Multiple icmp types",
+			l4:      &L4Rule{L4Proto: unix.IPPROTO_ICMP, ICMPType: &ICMPTypeSpec{List: []uint8{0, 8}, RelOp: NEQ}},
+			set:     true,
+			success: true,
+		},
+		{
+			name:    "Icmp type with tcp",
+			l4:      &L4Rule{L4Proto: unix.IPPROTO_TCP, ICMPType: &ICMPTypeSpec{List: []uint8{8}}},
+			success: false,
+		},
+		{
+			name:    "Empty icmp type list",
+			l4:      &L4Rule{L4Proto: unix.IPPROTO_ICMP, ICMPType: &ICMPTypeSpec{}},
+			success: false,
+		},
+		{
+			name:    "Unsupported operator",
+			l4:      &L4Rule{L4Proto: unix.IPPROTO_ICMP, ICMPType: &ICMPTypeSpec{List: []uint8{8}, RelOp: GT}},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		err := tt.l4.Validate()
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if !tt.success {
+			continue
+		}
+		e, set := getExprForICMPType(tt.l4.L4Proto, tt.l4.ICMPType)
+		if !tt.set {
+			if set != nil || !reflect.DeepEqual(e, tt.expect) {
+				t.Errorf("Test \"%s\" expressions do not match expected expressions", tt.name)
+			}
+			continue
+		}
+		if set == nil || set.set.KeyType != nftables.TypeICMPType || len(set.elements) != len(tt.l4.ICMPType.List) {
+			t.Errorf("Test \"%s\" did not produce expected set", tt.name)
+			continue
+		}
+		lookup, ok := e[len(e)-1].(*expr.Lookup)
+		if !ok || !lookup.Invert || lookup.SetName != set.set.Name {
+			t.Errorf("Test \"%s\" does not end with inverted lookup of the set", tt.name)
+		}
+	}
+}
+
+func TestAllowIPv6NDP(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv6}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	conn := &jumpConn{}
+	ri := newRules(conn, tbl, chain, Options{})
+	if err := ri.Rules().AllowIPv6NDP(); err != nil {
+		t.Fatalf("AllowIPv6NDP failed with error: %+v", err)
+	}
+	if handle := ri.(*nfRules).rules.rule.Handle; handle != 1 {
+		t.Errorf("rule is stored with handle %d, want: 1", handle)
+	}
+	if len(conn.addedRules) != 1 || len(conn.sets) != 1 || conn.flushes != 1 {
+		t.Fatalf("expected a rule and a set programmed in a single batch, got %d rules, %d sets and %d flushes",
+			len(conn.addedRules), len(conn.sets), conn.flushes)
+	}
+	if conn.sets[0].KeyType != nftables.TypeICMP6Type {
+		t.Errorf("unexpected set key type %+v", conn.sets[0].KeyType)
+	}
+	var lookup *expr.Lookup
+	var accept *expr.Verdict
+	for _, e := range conn.addedRules[0].Exprs {
+		switch e := e.(type) {
+		case *expr.Lookup:
+			lookup = e
+		case *expr.Verdict:
+			accept = e
+		}
+	}
+	if lookup == nil || lookup.Invert || lookup.SetName != conn.sets[0].Name {
+		t.Errorf("rule does not look up neighbor discovery types, got %+v", conn.addedRules[0].Exprs)
+	}
+	if accept == nil || accept.Kind != expr.VerdictAccept {
+		t.Errorf("rule does not accept, got %+v", conn.addedRules[0].Exprs)
+	}
+
+	tbl4 := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	ri = newRules(&applyConn{}, tbl4, &nftables.Chain{Name: "input", Table: tbl4}, Options{})
+	if err := ri.Rules().AllowIPv6NDP(); err == nil {
+		t.Errorf("AllowIPv6NDP in ipv4 table supposed to fail but succeeded")
+	}
+}
@@ -0,0 +1,72 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+)
+
+// IPIDSpec defines a match against identification field of IPv4 header, it can be used in tables of ipv4 family,
+// example: IPIDSpec{Value: 0} matches ip id 0. RelOp supports EQ and NEQ.
+type IPIDSpec struct {
+	Value uint16
+	RelOp Operator
+}
+
+// Validate checks that the operator is supported
+func (i *IPIDSpec) Validate() error {
+	if i.RelOp != EQ && i.RelOp != NEQ {
+		return fmt.Errorf("ip id supports only EQ and NEQ operators")
+	}
+
+	return nil
+}
+
+// getIPIDPayload returns PayloadSpec locating identification field at offset 4 of IPv4 header
+func getIPIDPayload(family nftables.TableFamily, value uint16) (*PayloadSpec, error) {
+	if family != nftables.TableFamilyIPv4 {
+		return nil, fmt.Errorf("ip id is supported only for ipv4 table family")
+	}
+
+	return &PayloadSpec{
+		Base:   expr.PayloadBaseNetworkHeader,
+		Offset: 4,
+		Len:    2,
+		Value:  binaryutil.BigEndian.PutUint16(value),
+	}, nil
+}
+
+func getExprForIPID(family nftables.TableFamily, i *IPIDSpec) ([]expr.Any, error) {
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+	p, err := getIPIDPayload(family, i.Value)
+	if err != nil {
+		return nil, err
+	}
+	// [ payload load 2b @ network header + 4 => reg 1 ]
+	// [ cmp eq reg 1 0x00000000 ]
+	p.RelOp = i.RelOp
+
+	return getExprForPayload(p)
+}
+
+// SetIPID builds RuleAction struct for an action setting identification field of IPv4 header to value,
+// example: ip id set 0. IPv4 header checksum is updated.
+func SetIPID(value uint16) (*RuleAction, error) {
+	return &RuleAction{
+		ipid: &value,
+	}, nil
+}
+
+func getExprForSetIPID(family nftables.TableFamily, value uint16) ([]expr.Any, error) {
+	p, err := getIPIDPayload(family, value)
+	if err != nil {
+		return nil, err
+	}
+	// [ immediate reg 1 0x00000000 ]
+	// [ payload write reg 1 => 2b @ network header + 4 csum_type 1 csum_off 10 csum_flags 0x0 ]
+	return getExprForPayloadWrite(family, &payloadWrite{spec: p}), nil
+}
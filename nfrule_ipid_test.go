@@ -0,0 +1,107 @@
+package nftableslib
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+func TestGetExprForIPID(t *testing.T) {
+	tests := []struct {
+		name    string
+		family  nftables.TableFamily
+		ipid    *IPIDSpec
+		expect  []expr.Any
+		success bool
+	}{
+		{
+			name:   "Zero ip id",
+			family: nftables.TableFamilyIPv4,
+			ipid:   &IPIDSpec{Value: 0},
+			expect: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 4, Len: 2},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x00, 0x00}},
+			},
+			success: true,
+		},
+		{
+			name:   "Not equal ip id",
+			family: nftables.TableFamilyIPv4,
+			ipid:   &IPIDSpec{Value: 0x1234, RelOp: NEQ},
+			expect: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 4, Len: 2},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x12, 0x34}},
+			},
+			success: true,
+		},
+		{
+			name:    "IPv6 family",
+			family:  nftables.TableFamilyIPv6,
+			ipid:    &IPIDSpec{Value: 0},
+			success: false,
+		},
+		{
+			name:    "Unsupported operator",
+			family:  nftables.TableFamilyIPv4,
+			ipid:    &IPIDSpec{Value: 0, RelOp: GT},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		e, err := getExprForIPID(tt.family, tt.ipid)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(e, tt.expect) {
+			t.Errorf("Test \"%s\" expressions %+v do not match expected %+v", tt.name, e, tt.expect)
+		}
+	}
+}
+
+func TestSetIPID(t *testing.T) {
+	ra, err := SetIPID(0)
+	if err != nil {
+		t.Fatalf("SetIPID failed with error: %+v", err)
+	}
+	e, err := Rule{Action: ra}.Expressions(nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("failed to build expressions with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Immediate{Register: 1, Data: []byte{0x00, 0x00}},
+		&expr.Payload{
+			OperationType:  expr.PayloadWrite,
+			SourceRegister: 1,
+			Base:           expr.PayloadBaseNetworkHeader,
+			Offset:         4,
+			Len:            2,
+			CsumType:       expr.CsumTypeInet,
+			CsumOffset:     10,
+		},
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("expressions %+v do not match expected %+v", e, want)
+	}
+	if _, err := (Rule{Action: ra}).Expressions(nftables.TableFamilyINet); err == nil {
+		t.Errorf("setting ip id in inet table supposed to fail but succeeded")
+	}
+	b, err := json.Marshal(ra)
+	if err != nil {
+		t.Fatalf("failed to marshal action with error: %+v", err)
+	}
+	var decoded RuleAction
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal action with error: %+v", err)
+	}
+	if decoded.ipid == nil || *decoded.ipid != 0 {
+		t.Errorf("ip id was lost in json round trip, got %s", string(b))
+	}
+}
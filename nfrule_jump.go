@@ -0,0 +1,49 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// AddJumpChain creates a regular chain named after category in the chain's table and appends to the chain
+// a rule jumping into it, both are programmed in a single batch. When the regular chain finishes without
+// a terminal verdict, or a rule of it returns, the packet continues with the rule following the jump rule
+// of the calling chain, unlike goto which does not return. The regular chain is added to the store of the
+// table's chains, its rules are added through Chains().Chain(category), the jump rule is stored with the handle
+// allocated by the kernel. If the regular chain already exists, it is kept with its rules.
+func (nfr *nfRules) AddJumpChain(category string) error {
+	if category == "" {
+		return fmt.Errorf("category cannot be empty")
+	}
+	if category == nfr.chain.Name {
+		return fmt.Errorf("chain %s cannot jump to itself", category)
+	}
+	jump, err := SetVerdict(unix.NFT_JUMP, category)
+	if err != nil {
+		return err
+	}
+	nfc := nfr.chains
+	if nfc == nil {
+		return fmt.Errorf("chain %s is not in the store of chains of table %s", nfr.chain.Name, nfr.table.Name)
+	}
+	// Exist brings the regular chain existing only on the host into the store with its rules
+	nfc.Exist(category)
+	nfc.Lock()
+	defer nfc.Unlock()
+	_, exists := nfc.chains[category]
+	if err := nfc.create(category, nil); err != nil {
+		return err
+	}
+	nfr.Lock()
+	defer nfr.Unlock()
+	if _, err := nfr.createImm(&Rule{Action: jump}); err != nil {
+		// The regular chain created by this call is not programmed, it must not stay in the store
+		if !exists {
+			delete(nfc.chains, category)
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package nftableslib
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// jumpConn allocates handles to added rules
+type jumpConn struct {
+	applyConn
+}
+
+func (c *jumpConn) GetRule(_ *nftables.Table, _ *nftables.Chain) ([]*nftables.Rule, error) {
+	for i, r := range c.addedRules {
+		r.Handle = uint64(i + 1)
+	}
+	return c.addedRules, nil
+}
+
+func TestAddJumpChain(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	conn := &jumpConn{}
+	ci := newChains(conn, tbl, Options{})
+	if err := ci.Chains().Create("input", nil); err != nil {
+		t.Fatalf("Create failed with error: %+v", err)
+	}
+	ri, err := ci.Chains().Chain("input")
+	if err != nil {
+		t.Fatalf("Chain failed with error: %+v", err)
+	}
+	if err := ri.Rules().AddJumpChain("ssh"); err != nil {
+		t.Fatalf("AddJumpChain failed with error: %+v", err)
+	}
+	if !reflect.DeepEqual(conn.addChains, []string{"input", "ssh"}) || len(conn.addedRules) != 1 || conn.flushes != 1 {
+		t.Fatalf("expected a chain and a rule programmed in a single batch, got chains %v, %d rules and %d flushes",
+			conn.addChains, len(conn.addedRules), conn.flushes)
+	}
+	// Jump, unlike goto, returns to the calling chain
+	want := []expr.Any{&expr.Verdict{Kind: expr.VerdictJump, Chain: "ssh"}}
+	if got := conn.addedRules[0].Exprs; !reflect.DeepEqual(got, want) {
+		t.Errorf("jump rule expressions %+v do not match expected %+v", got, want)
+	}
+	// The regular chain is in the store and the jump rule carries its handle
+	if _, err := ci.Chains().Chain("ssh"); err != nil {
+		t.Errorf("regular chain ssh is not in the store: %+v", err)
+	}
+	if handle := ri.(*nfRules).rules.rule.Handle; handle != 1 {
+		t.Errorf("jump rule is stored with handle %d, want: 1", handle)
+	}
+	if n := ci.Chains().ChainUseCount("ssh"); n != 1 {
+		t.Errorf("regular chain ssh is referenced by %d rules, want: 1", n)
+	}
+	if err := ri.Rules().AddJumpChain(""); err == nil {
+		t.Errorf("AddJumpChain with empty category supposed to fail but succeeded")
+	}
+	if err := ri.Rules().AddJumpChain("input"); err == nil {
+		t.Errorf("AddJumpChain into the calling chain supposed to fail but succeeded")
+	}
+	// Rules of a chain outside of the store cannot create chains
+	standalone := newRules(conn, tbl, &nftables.Chain{Name: "output", Table: tbl}, Options{})
+	if err := standalone.Rules().AddJumpChain("ssh"); err == nil {
+		t.Errorf("AddJumpChain of a chain outside of the store supposed to fail but succeeded")
+	}
+}
+
+// failedJumpConn fails to program the batch
+type failedJumpConn struct {
+	jumpConn
+}
+
+func (c *failedJumpConn) Flush() error {
+	return fmt.Errorf("conn.Receive: %w", unix.EINVAL)
+}
+
+func TestAddJumpChainFailure(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	ci := newChains(&failedJumpConn{}, tbl, Options{})
+	if err := ci.Chains().Create("input", nil); err != nil {
+		t.Fatalf("Create failed with error: %+v", err)
+	}
+	ri, err := ci.Chains().Chain("input")
+	if err != nil {
+		t.Fatalf("Chain failed with error: %+v", err)
+	}
+	if err := ri.Rules().AddJumpChain("ssh"); err == nil {
+		t.Fatalf("AddJumpChain supposed to fail but succeeded")
+	}
+	// The regular chain was not programmed, it is not kept in the store
+	if _, err := ci.Chains().Chain("ssh"); err == nil {
+		t.Errorf("regular chain ssh is in the store after failed AddJumpChain")
+	}
+	if err := ri.Rules().AddJumpChain("ssh"); err == nil {
+		t.Errorf("repeated AddJumpChain supposed to fail but succeeded")
+	}
+	// A chain existing before the call is kept
+	if err := ci.Chains().Create("http", nil); err != nil {
+		t.Fatalf("Create failed with error: %+v", err)
+	}
+	if err := ri.Rules().AddJumpChain("http"); err == nil {
+		t.Fatalf("AddJumpChain supposed to fail but succeeded")
+	}
+	if _, err := ci.Chains().Chain("http"); err != nil {
+		t.Errorf("regular chain http existing before AddJumpChain is not in the store: %+v", err)
+	}
+}
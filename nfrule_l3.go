@@ -3,9 +3,12 @@ package nftableslib
 import (
 	"fmt"
 	"math/rand"
+	"strconv"
 
 	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
 	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
 )
 
 func createL3(l3proto nftables.TableFamily, rule *Rule) ([]expr.Any, []*nfSet, error) {
@@ -38,6 +41,9 @@ func createL3(l3proto nftables.TableFamily, rule *Rule) ([]expr.Any, []*nfSet, e
 			sets = append(sets, set...)
 		}
 		re = append(re, e...)
+		if zone := rule.L3.Src.zone(); zone != "" {
+			re = append(re, getExprForZone(expr.MetaKeyIIF, expr.MetaKeyIIFNAME, zone)...)
+		}
 	}
 
 	if rule.L3.Dst != nil {
@@ -48,6 +54,9 @@ func createL3(l3proto nftables.TableFamily, rule *Rule) ([]expr.Any, []*nfSet, e
 			sets = append(sets, set...)
 		}
 		re = append(re, e...)
+		if zone := rule.L3.Dst.zone(); zone != "" {
+			re = append(re, getExprForZone(expr.MetaKeyOIF, expr.MetaKeyOIFNAME, zone)...)
+		}
 	}
 	if rule.L3.Counter != nil {
 		re = append(re, getExprForCounter()...)
@@ -55,6 +64,25 @@ func createL3(l3proto nftables.TableFamily, rule *Rule) ([]expr.Any, []*nfSet, e
 	return re, sets, nil
 }
 
+// getExprForZone returns expressions matching the interface identified by the zone of a link-local address,
+// a numeric zone is the interface index, otherwise the zone is the interface name.
+func getExprForZone(indexKey, nameKey expr.MetaKey, zone string) []expr.Any {
+	key := nameKey
+	// Interface name is compared as a zero padded string of IFNAMSIZ length
+	data := make([]byte, unix.IFNAMSIZ)
+	copy(data, zone)
+	if index, err := strconv.ParseUint(zone, 10, 32); err == nil {
+		key = indexKey
+		data = binaryutil.NativeEndian.PutUint32(uint32(index))
+	}
+	// [ meta load iifname => reg 1 ]
+	// [ cmp eq reg 1 0x30687465 0x00000000 0x00000000 0x00000000 ]
+	return []expr.Any{
+		&expr.Meta{Key: key, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: data},
+	}
+}
+
 func processAddrList(l3proto nftables.TableFamily, offset uint32, list []*IPAddr,
 	op Operator) ([]expr.Any, *nfSet, error) {
 
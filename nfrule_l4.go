@@ -36,6 +36,20 @@ func createL4(family nftables.TableFamily, rule *Rule) ([]expr.Any, []*nfSet, er
 		}
 		re = append(re, e...)
 	}
+	if l4.UDPLength != nil || l4.TCPDataOffset != nil {
+		if l4.Src == nil && l4.Dst == nil {
+			// Without ports nothing has matched transport protocol yet
+			re = append(re, getExprForL4Proto(l4.L4Proto)...)
+		}
+		re = append(re, getExprForL4Fields(l4)...)
+	}
+	if l4.ICMPType != nil {
+		e, set := getExprForICMPType(l4.L4Proto, l4.ICMPType)
+		if set != nil {
+			sets = append(sets, set)
+		}
+		re = append(re, e...)
+	}
 	if rule.L4.Counter != nil {
 		re = append(re, getExprForCounter()...)
 	}
@@ -43,6 +57,54 @@ func createL4(family nftables.TableFamily, rule *Rule) ([]expr.Any, []*nfSet, er
 	return re, sets, nil
 }
 
+// getExprForL4Fields returns expressions comparing UDP length and TCP data offset
+func getExprForL4Fields(l4 *L4Rule) []expr.Any {
+	re := []expr.Any{}
+	if l4.UDPLength != nil {
+		// Length is located at offset 4 of UDP header
+		// [ payload load 2b @ transport header + 4 => reg 1 ]
+		// [ cmp neq reg 1 0x00001c00 ]
+		re = append(re, &expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseTransportHeader,
+			Offset:       4,
+			Len:          2,
+		})
+		re = append(re, &expr.Cmp{
+			Op:       cmpOp(l4.UDPLength.RelOp),
+			Register: 1,
+			Data:     binaryutil.BigEndian.PutUint16(l4.UDPLength.Value),
+		})
+	}
+	if l4.TCPDataOffset != nil {
+		// Data offset occupies high 4 bits of byte 12 of TCP header, low bits are masked out
+		// so ordering comparisons of the shifted value remain valid.
+		// [ payload load 1b @ transport header + 12 => reg 1 ]
+		// [ bitwise reg 1 = (reg=1 & 0x000000f0 ) ^ 0x00000000 ]
+		// [ cmp gt reg 1 0x00000050 ]
+		re = append(re, &expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseTransportHeader,
+			Offset:       12,
+			Len:          1,
+		})
+		re = append(re, &expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            1,
+			Mask:           []byte{0xf0},
+			Xor:            []byte{0x00},
+		})
+		re = append(re, &expr.Cmp{
+			Op:       cmpOp(l4.TCPDataOffset.RelOp),
+			Register: 1,
+			Data:     []byte{byte(l4.TCPDataOffset.Value << 4)},
+		})
+	}
+
+	return re
+}
+
 // processPort process one of the possible port sources and returns required expressions,
 // dynamically generated set or error.
 func processPort(proto uint8, offset uint32, port *Port) ([]expr.Any, *nfSet, error) {
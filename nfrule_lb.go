@@ -0,0 +1,74 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Backend defines a destination of a load balanced service, Port 0 keeps the destination port of the packet
+type Backend struct {
+	Addr *IPAddr
+	Port uint16
+}
+
+// AddLoadBalancer programs the chain as a load balancer of a TCP service, packets sent to vip and port are
+// destination NATed to one of the backends chosen at random with equal probability. A DNAT rule is added
+// per backend, the rule of i-th backend out of n matches one of n-i packets (numgen random mod n-i == 0),
+// and the last rule matches the rest, which results in even distribution without a shared state. The chain
+// should be dedicated to the service, for example a regular chain reached by a jump from a nat chain at
+// prerouting hook. All rules are programmed in a single batch.
+func (nfr *nfRules) AddLoadBalancer(vip *IPAddr, port uint16, backends []Backend) error {
+	if vip == nil || vip.IPAddr == nil {
+		return fmt.Errorf("virtual ip address cannot be nil")
+	}
+	if port == 0 {
+		return fmt.Errorf("service port cannot be 0")
+	}
+	if len(backends) == 0 {
+		return fmt.Errorf("at least one backend must be specified")
+	}
+	rules := make([]*Rule, 0, len(backends))
+	for i, b := range backends {
+		if b.Addr == nil || b.Addr.IPAddr == nil {
+			return fmt.Errorf("backend %d address cannot be nil", i)
+		}
+		if b.Addr.IsIPv6() != vip.IsIPv6() {
+			return fmt.Errorf("backend %d address does not match the family of virtual ip address", i)
+		}
+		dnat, err := SetDNAT(&NATAttributes{L3Addr: [2]*IPAddr{b.Addr}, Port: [2]uint16{b.Port}})
+		if err != nil {
+			return err
+		}
+		svcPort := port
+		rule := &Rule{
+			L3: &L3Rule{
+				Dst: &IPAddrSpec{List: []*IPAddr{vip}},
+			},
+			L4: &L4Rule{
+				L4Proto: unix.IPPROTO_TCP,
+				Dst:     &Port{List: []*uint16{&svcPort}},
+			},
+			Action: dnat,
+		}
+		if n := len(backends) - i; n > 1 {
+			rule.Probability = &ProbabilitySpec{OneIn: uint32(n)}
+		}
+		rules = append(rules, rule)
+	}
+	nfr.Lock()
+	defer nfr.Unlock()
+	built := make([]*nfRule, 0, len(rules))
+	for _, rule := range rules {
+		rr, err := nfr.buildRule(rule)
+		if err != nil {
+			return err
+		}
+		built = append(built, rr)
+	}
+	for i, rr := range built {
+		nfr.program(rr, rules[i], operationAdd)
+	}
+
+	return nfr.conn.Flush()
+}
@@ -0,0 +1,54 @@
+package nftableslib
+
+import (
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+func TestAddLoadBalancer(t *testing.T) {
+	tbl := &nftables.Table{Name: "nat", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "svc-web", Table: tbl}
+	conn := &applyConn{}
+	ri := newRules(conn, tbl, chain, Options{})
+	backends := []Backend{
+		{Addr: setIPAddr(t, "10.0.0.1"), Port: 8080},
+		{Addr: setIPAddr(t, "10.0.0.2"), Port: 8080},
+		{Addr: setIPAddr(t, "10.0.0.3")},
+	}
+	if err := ri.Rules().AddLoadBalancer(setIPAddr(t, "192.0.2.10"), 80, backends); err != nil {
+		t.Fatalf("AddLoadBalancer failed with error: %+v", err)
+	}
+	if len(conn.addedRules) != 3 || conn.flushes != 1 {
+		t.Fatalf("expected 3 rules programmed in a single batch, got %d rules and %d flushes", len(conn.addedRules), conn.flushes)
+	}
+	// Rule of i-th backend matches one of n-i packets, the last rule matches the rest
+	for i, want := range []uint32{3, 2, 0} {
+		var modulus uint32
+		var nat *expr.NAT
+		for _, e := range conn.addedRules[i].Exprs {
+			switch e := e.(type) {
+			case *expr.Numgen:
+				modulus = e.Modulus
+			case *expr.NAT:
+				nat = e
+			}
+		}
+		if modulus != want {
+			t.Errorf("rule %d numgen modulus %d want: %d", i, modulus, want)
+		}
+		if nat == nil || nat.Type != expr.NATTypeDestNAT {
+			t.Errorf("rule %d does not carry dnat", i)
+		}
+	}
+	if ri.(*nfRules).countRules() != 3 {
+		t.Errorf("load balancer rules were not added to the store")
+	}
+	if err := ri.Rules().AddLoadBalancer(setIPAddr(t, "192.0.2.10"), 80, nil); err == nil {
+		t.Errorf("AddLoadBalancer without backends supposed to fail but succeeded")
+	}
+	if err := ri.Rules().AddLoadBalancer(setIPAddr(t, "192.0.2.10"), 80, []Backend{{Addr: setIPAddr(t, "2001:db8::1")}}); err == nil {
+		t.Errorf("AddLoadBalancer with backend of different family supposed to fail but succeeded")
+	}
+}
@@ -0,0 +1,31 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// nfLogPrefixLen is the maximum length of log prefix including terminating zero
+const nfLogPrefixLen = 128
+
+// AddRateLimitedLog appends a rule logging packets with prefix while they are within the limit, example:
+// limit rate 10/minute burst 5 packets log prefix "dropped: ". Packets exceeding the limit are not logged,
+// the log statement does not terminate evaluation, hence the rule is usually placed right before the rule
+// dropping the logged packets. The rule is built from Rule's Limit and Log, it is stored as such.
+func (nfr *nfRules) AddRateLimitedLog(prefix string, rate LimitAttributes) error {
+	if len(prefix) >= nfLogPrefixLen {
+		return fmt.Errorf("log prefix exceeds maximum length of %d", nfLogPrefixLen-1)
+	}
+	log, err := SetLog(unix.NFTA_LOG_PREFIX, []byte(prefix))
+	if err != nil {
+		return err
+	}
+	nfr.Lock()
+	defer nfr.Unlock()
+	if _, err := nfr.create(&Rule{Limit: &rate, Log: log}, operationAdd); err != nil {
+		return err
+	}
+
+	return nfr.conn.Flush()
+}
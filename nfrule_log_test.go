@@ -0,0 +1,73 @@
+package nftableslib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestAddRateLimitedLog(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	conn := &applyConn{}
+	ri := newRules(conn, tbl, chain, Options{})
+	rate := LimitAttributes{Rate: 10, Unit: expr.LimitTimeMinute, Burst: 5}
+	if err := ri.Rules().AddRateLimitedLog("dropped: ", rate); err != nil {
+		t.Fatalf("AddRateLimitedLog failed with error: %+v", err)
+	}
+	if len(conn.addedRules) != 1 || conn.flushes != 1 {
+		t.Fatalf("expected a rule programmed in a single batch, got %d rules and %d flushes", len(conn.addedRules), conn.flushes)
+	}
+	want := []expr.Any{
+		&expr.Limit{Type: expr.LimitTypePkts, Rate: 10, Unit: expr.LimitTimeMinute, Burst: 5},
+		&expr.Log{Key: 1 << unix.NFTA_LOG_PREFIX, Data: []byte("dropped: ")},
+	}
+	if got := conn.addedRules[0].Exprs; !reflect.DeepEqual(got, want) {
+		t.Errorf("rule expressions %+v do not match expected %+v", got, want)
+	}
+	// The store describes what was programmed
+	if spec := ri.(*nfRules).rules.spec; spec.Limit == nil || *spec.Limit != rate || spec.Log == nil {
+		t.Errorf("stored rule %+v does not carry the limit and the log", spec)
+	}
+	if err := ri.Rules().AddRateLimitedLog("dropped: ", LimitAttributes{Unit: expr.LimitTimeSecond}); err == nil {
+		t.Errorf("AddRateLimitedLog with zero rate supposed to fail but succeeded")
+	}
+	if err := ri.Rules().AddRateLimitedLog(strings.Repeat("x", 128), rate); err == nil {
+		t.Errorf("AddRateLimitedLog with too long prefix supposed to fail but succeeded")
+	}
+}
+
+func TestGetExprForLog(t *testing.T) {
+	want := []expr.Any{&expr.Log{Key: 1 << unix.NFTA_LOG_GROUP, Group: 2}}
+	if got := getExprForLog(&Log{Key: unix.NFTA_LOG_GROUP, Value: []byte{0x00, 0x02}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForLog returned %+v want: %+v", got, want)
+	}
+}
+
+func TestRuleLimit(t *testing.T) {
+	rule := Rule{
+		L4:     &L4Rule{L4Proto: unix.IPPROTO_TCP, Dst: &Port{List: SetPortList([]int{22})}},
+		Limit:  &LimitAttributes{Rate: 5, Unit: expr.LimitTimeSecond},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}
+	got, err := rule.Expressions(nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("Expressions failed with error: %+v", err)
+	}
+	// The limit follows the matches, packets which do not match do not consume the rate
+	want := []expr.Any{
+		&expr.Limit{Type: expr.LimitTypePkts, Rate: 5, Unit: expr.LimitTimeSecond},
+		&expr.Verdict{Kind: expr.VerdictAccept},
+	}
+	if len(got) < 3 || !reflect.DeepEqual(got[len(got)-2:], want) {
+		t.Errorf("rule expressions %+v do not end with %+v", got, want)
+	}
+	rule.Limit = &LimitAttributes{Unit: expr.LimitTimeSecond}
+	if _, err := rule.Expressions(nftables.TableFamilyIPv4); err == nil {
+		t.Errorf("rule with zero rate limit supposed to fail but succeeded")
+	}
+}
@@ -0,0 +1,88 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// netmap defines stateless 1:1 mapping of a subnet to the prefix, snat selects the source address
+type netmap struct {
+	prefix *IPAddr
+	snat   bool
+}
+
+// SetNetmap builds RuleAction struct for an action mapping a subnet 1:1 to the prefix preserving the host part
+// of the address, example: ip saddr set ip saddr & 0.0.0.255 | 10.0.0.0 maps 192.168.1.0/24 to 10.0.0.0/24.
+// When snat is true, source address is rewritten, otherwise destination address is rewritten. The rule must match
+// the original subnet by L3Rule's Src for snat or Dst otherwise, the subnet's prefix length must match prefix's.
+// The mapping is stateless, connection tracking is not involved and the reverse direction requires a rule mapping
+// the addresses back, checksums are updated. Only IPv4 prefixes are supported, see SetPayloadWrite.
+func SetNetmap(prefix *IPAddr, snat bool) (*RuleAction, error) {
+	if prefix == nil || prefix.IPAddr == nil {
+		return nil, fmt.Errorf("netmap prefix cannot be nil")
+	}
+	if err := prefix.Validate(); err != nil {
+		return nil, err
+	}
+	if prefix.IsIPv6() {
+		return nil, fmt.Errorf("netmap prefix %s is ipv6, rewriting ipv6 addresses is not supported", prefix.IP.String())
+	}
+	if !prefix.CIDR {
+		return nil, fmt.Errorf("netmap prefix %s must be a network address", prefix.IP.String())
+	}
+
+	return &RuleAction{
+		netmap: &netmap{
+			prefix: prefix.clone(),
+			snat:   snat,
+		},
+	}, nil
+}
+
+// validateNetmap checks that the rule matches a single subnet of the same family and length as netmap's prefix
+func (r Rule) validateNetmap(family nftables.TableFamily) error {
+	nm := r.Action.netmap
+	if family != nftables.TableFamilyIPv4 {
+		return fmt.Errorf("netmap prefix %s does not match the table family", nm.prefix.IP.String())
+	}
+	dir := "destination"
+	if nm.snat {
+		dir = "source"
+	}
+	var spec *IPAddrSpec
+	if r.L3 != nil {
+		spec = r.L3.Dst
+		if nm.snat {
+			spec = r.L3.Src
+		}
+	}
+	if spec == nil || len(spec.List) != 1 || spec.RelOp != EQ || !spec.List[0].CIDR {
+		return fmt.Errorf("netmap requires the rule to match a single subnet by %s address", dir)
+	}
+	if *spec.List[0].Mask != *nm.prefix.Mask {
+		return fmt.Errorf("netmap prefix length /%d does not match the length /%d of matched subnet", *nm.prefix.Mask, *spec.List[0].Mask)
+	}
+
+	return nil
+}
+
+func getExprForNetmap(nm *netmap) []expr.Any {
+	ip := nm.prefix.IP.To4()
+	offset := uint32(16)
+	if nm.snat {
+		offset = 12
+	}
+	p := &PayloadSpec{
+		Base:   expr.PayloadBaseNetworkHeader,
+		Offset: offset,
+		Len:    uint32(len(ip)),
+		Value:  ip,
+		Mask:   getMask(*nm.prefix.Mask, len(ip)),
+	}
+	// [ payload load 4b @ network header + 12 => reg 1 ]
+	// [ bitwise reg 1 = (reg=1 & 0xff000000 ) ^ 0x0000000a ]
+	// [ payload write reg 1 => 4b @ network header + 12 csum_type 1 csum_off 10 csum_flags 0x1 ]
+	return getExprForPayloadWrite(nftables.TableFamilyIPv4, &payloadWrite{spec: p})
+}
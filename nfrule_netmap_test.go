@@ -0,0 +1,112 @@
+package nftableslib
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestSetNetmap(t *testing.T) {
+	snat, err := SetNetmap(setIPAddr(t, "10.0.0.0/24"), true)
+	if err != nil {
+		t.Fatalf("SetNetmap failed with error: %+v", err)
+	}
+	tests := []struct {
+		name    string
+		family  nftables.TableFamily
+		rule    Rule
+		want    []expr.Any
+		success bool
+	}{
+		{
+			name:   "snat ipv4 /24",
+			family: nftables.TableFamilyIPv4,
+			rule: Rule{
+				L3:     &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.168.1.0/24")}}},
+				Action: snat,
+			},
+			want: []expr.Any{
+				&expr.Payload{OperationType: expr.PayloadWrite, SourceRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4,
+					CsumType: expr.CsumTypeInet, CsumOffset: 10, CsumFlags: unix.NFT_PAYLOAD_L4CSUM_PSEUDOHDR},
+			},
+			success: true,
+		},
+		{
+			name:   "prefix length mismatch",
+			family: nftables.TableFamilyIPv4,
+			rule: Rule{
+				L3:     &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.168.0.0/16")}}},
+				Action: snat,
+			},
+			success: false,
+		},
+		{
+			name:   "destination matched for snat",
+			family: nftables.TableFamilyIPv4,
+			rule: Rule{
+				L3:     &L3Rule{Dst: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.168.1.0/24")}}},
+				Action: snat,
+			},
+			success: false,
+		},
+		{
+			name:   "ipv4 prefix in ipv6 table",
+			family: nftables.TableFamilyIPv6,
+			rule: Rule{
+				L3:     &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.168.1.0/24")}}},
+				Action: snat,
+			},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.rule.Expressions(tt.family)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if !tt.success {
+			continue
+		}
+		if len(got) < 3 || !reflect.DeepEqual(got[len(got)-1:], tt.want) {
+			t.Errorf("Test \"%s\" failed, got: %+v want payload write: %+v", tt.name, got, tt.want)
+		}
+	}
+	// Host part is preserved, network part is replaced by the prefix
+	e := getExprForNetmap(snat.netmap)
+	want := &expr.Bitwise{
+		SourceRegister: 1,
+		DestRegister:   1,
+		Len:            4,
+		Mask:           []byte{0x0, 0x0, 0x0, 0xff},
+		Xor:            []byte{10, 0, 0, 0},
+	}
+	if !reflect.DeepEqual(e[1], want) {
+		t.Errorf("netmap bitwise %+v want: %+v", e[1], want)
+	}
+	if _, err := SetNetmap(&IPAddr{IPAddr: setIPAddr(t, "10.0.0.1").IPAddr}, true); err == nil {
+		t.Errorf("SetNetmap with host address supposed to fail but succeeded")
+	}
+	if _, err := SetNetmap(setIPAddr(t, "2001:db8:1::/64"), false); err == nil {
+		t.Errorf("SetNetmap with ipv6 prefix supposed to fail but succeeded")
+	}
+	b, err := json.Marshal(snat)
+	if err != nil {
+		t.Fatalf("failed to marshal action with error: %+v", err)
+	}
+	decoded := &RuleAction{}
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatalf("failed to unmarshal action with error: %+v", err)
+	}
+	if !reflect.DeepEqual(getExprForNetmap(decoded.netmap), e) {
+		t.Errorf("decoded action %s does not build the same expressions", string(b))
+	}
+}
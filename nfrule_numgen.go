@@ -0,0 +1,77 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// ProbabilitySpec defines a match against a random number generated per packet, Percent is the percentage
+// of packets the rule matches, example: numgen random mod 100 < 5 matches 5% of packets. Alternatively
+// OneIn matches exactly one of OneIn packets, example: numgen random mod 3 == 0 matches a third of packets.
+type ProbabilitySpec struct {
+	Percent uint32
+	OneIn   uint32
+}
+
+// Validate checks that either the percentage within 1 and 100 or OneIn is specified
+func (p *ProbabilitySpec) Validate() error {
+	if p.OneIn != 0 {
+		if p.Percent != 0 {
+			return fmt.Errorf("probability percent and one in are mutually exclusive")
+		}
+		return nil
+	}
+	if p.Percent == 0 || p.Percent > 100 {
+		return fmt.Errorf("probability percent %d must be within 1 and 100", p.Percent)
+	}
+
+	return nil
+}
+
+func getExprForProbability(p *ProbabilitySpec) ([]expr.Any, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	re := []expr.Any{}
+	if p.OneIn != 0 {
+		// [ numgen reg 1 = random mod 3 ]
+		// [ cmp eq reg 1 0x00000000 ]
+		re = append(re, &expr.Numgen{
+			Register: 1,
+			Modulus:  p.OneIn,
+			Type:     unix.NFT_NG_RANDOM,
+		})
+		re = append(re, &expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     []byte{0, 0, 0, 0},
+		})
+		return re, nil
+	}
+	// [ numgen reg 1 = random mod 100 ]
+	re = append(re, &expr.Numgen{
+		Register: 1,
+		Modulus:  100,
+		Type:     unix.NFT_NG_RANDOM,
+	})
+	// Numgen stores the number in host byte order, cmp lt compares bytes, hence converting to network byte order
+	// [ byteorder reg 1 = hton(reg 1, 4, 4) ]
+	re = append(re, &expr.Byteorder{
+		SourceRegister: 1,
+		DestRegister:   1,
+		Op:             expr.ByteorderHton,
+		Len:            4,
+		Size:           4,
+	})
+	// [ cmp lt reg 1 0x00000005 ]
+	re = append(re, &expr.Cmp{
+		Op:       expr.CmpOpLt,
+		Register: 1,
+		Data:     binaryutil.BigEndian.PutUint32(p.Percent),
+	})
+
+	return re, nil
+}
@@ -0,0 +1,67 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetExprForProbability(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *ProbabilitySpec
+		expect  []expr.Any
+		success bool
+	}{
+		{
+			name: "5 percent",
+			spec: &ProbabilitySpec{Percent: 5},
+			expect: []expr.Any{
+				&expr.Numgen{Register: 1, Modulus: 100, Type: unix.NFT_NG_RANDOM},
+				&expr.Byteorder{SourceRegister: 1, DestRegister: 1, Op: expr.ByteorderHton, Len: 4, Size: 4},
+				&expr.Cmp{Op: expr.CmpOpLt, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x5}},
+			},
+			success: true,
+		},
+		{
+			name:    "0 percent",
+			spec:    &ProbabilitySpec{Percent: 0},
+			success: false,
+		},
+		{
+			name:    "101 percent",
+			spec:    &ProbabilitySpec{Percent: 101},
+			success: false,
+		},
+		{
+			name: "one in 3",
+			spec: &ProbabilitySpec{OneIn: 3},
+			expect: []expr.Any{
+				&expr.Numgen{Register: 1, Modulus: 3, Type: unix.NFT_NG_RANDOM},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x0}},
+			},
+			success: true,
+		},
+		{
+			name:    "percent and one in",
+			spec:    &ProbabilitySpec{Percent: 5, OneIn: 3},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		e, err := getExprForProbability(tt.spec)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(e, tt.expect) {
+			t.Errorf("Test \"%s\" expressions do not match expected expressions", tt.name)
+		}
+	}
+}
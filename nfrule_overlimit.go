@@ -0,0 +1,112 @@
+package nftableslib
+
+import (
+	"fmt"
+)
+
+// overLimitRule returns the rule dropping packets which match the rule but exceed the limit of its rate limited
+// reject action, nil is returned for other rules. The limit statement stops evaluation of the rule for packets
+// over the limit, hence they are dropped by a separate rule carrying the same matches. Statements of the rule,
+// log and counter, are not repeated.
+func overLimitRule(rule *Rule) *Rule {
+	if rule.Action == nil || rule.Action.rateLimitedReject == nil {
+		return nil
+	}
+	over := rule.Clone()
+	over.Log = nil
+	over.Counter = nil
+	over.UserData = nil
+	over.Action = &RuleAction{}
+	// Drop verdict is always valid
+	_ = over.Action.setVerdict(NFT_DROP)
+
+	return over
+}
+
+// validateOverLimit checks that matches of the rule with rate limited reject action can be evaluated twice,
+// once by the rule and once by the rule dropping packets over the limit.
+func (r Rule) validateOverLimit() error {
+	if r.Probability != nil || r.Limit != nil || r.ObjRef != nil || r.Dynamic != nil {
+		return fmt.Errorf("rate limited reject cannot be combined with probability, limit, object reference or dynamic set")
+	}
+
+	return nil
+}
+
+// programOverLimit programs the rule followed by the rule dropping packets over the limit of its rate limited
+// reject action and returns the rule's ID.
+func (nfr *nfRules) programOverLimit(rr *nfRule, rule *Rule, ruleOp ruleOperation) uint32 {
+	over := rr.overLimit
+	spec := over.spec
+	over.limited = rr
+	switch {
+	case rr.rule.Handle != 0:
+		// The rule replaces a programmed rule, the rule dropping packets over the limit is added right after it
+		id := nfr.programRule(rr, rule, ruleOp)
+		spec.Position = int(rr.rule.Handle)
+		nfr.programRule(over, spec, operationAdd)
+		return id
+	case ruleOp == operationAdd && rule.Position == 0, ruleOp == operationInsert && rule.Position != 0:
+		id := nfr.programRule(rr, rule, ruleOp)
+		nfr.programRule(over, spec, ruleOp)
+		return id
+	}
+	// Adding after a rule and inserting at the beginning of the chain place both rules at the same position,
+	// the rule dropping packets over the limit is queued first to end up after the rule.
+	nfr.programRule(over, spec, ruleOp)
+
+	return nfr.programRule(rr, rule, ruleOp)
+}
+
+// updateOverLimitHandle updates the handle of the rule dropping packets over the limit of the rule with id
+func (nfr *nfRules) updateOverLimitHandle(id uint32) error {
+	r, err := getRuleByID(nfr.rules, id)
+	if err != nil || r.overLimit == nil {
+		return err
+	}
+	handle, err := nfr.GetRuleHandle(r.overLimit.id)
+	if err != nil {
+		return err
+	}
+	r.overLimit.rule.Handle = handle
+
+	return nil
+}
+
+// updateOverLimit makes the rule dropping packets over the limit follow the updated rule, over is the rule built
+// for the updated rule, it is nil when the updated rule has no rate limited reject action.
+func (nfr *nfRules) updateOverLimit(rr *nfRule, over *nfRule) error {
+	old := rr.overLimit
+	if old != nil {
+		if _, err := getRuleByID(nfr.rules, old.id); err != nil {
+			old = nil
+		}
+	}
+	switch {
+	case over == nil && old == nil:
+	case over == nil:
+		rr.overLimit = nil
+		return nfr.delete(old.id)
+	case old != nil && old.rule.Handle != 0:
+		// AddRule with non zero handle replaces the rule with the handle
+		over.rule.Handle = old.rule.Handle
+		over.rule.UserData = withRuleID(nil, old.id)
+		old.rule = over.rule
+		old.sets = over.sets
+		old.spec = over.spec
+		old.disabled = nil
+		nfr.conn.AddRule(old.rule)
+	default:
+		if old != nil {
+			if err := nfr.delete(old.id); err != nil {
+				return err
+			}
+		}
+		over.limited = rr
+		over.spec.Position = int(rr.rule.Handle)
+		nfr.programRule(over, over.spec, operationAdd)
+		rr.overLimit = over
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// PayloadSpec defines a generic match against arbitrary bytes of a packet, Offset and Len are
+// specified in bytes and counted from the beginning of the header selected by Base;
+// expr.PayloadBaseLLHeader, expr.PayloadBaseNetworkHeader or expr.PayloadBaseTransportHeader.
+// Value must be Len bytes long and in network byte order. Optional Mask is applied to the loaded
+// bytes before the comparison, when specified, it must also be Len bytes long.
+type PayloadSpec struct {
+	Base   expr.PayloadBase
+	Offset uint32
+	Len    uint32
+	Value  []byte
+	Mask   []byte
+	RelOp  Operator
+}
+
+// Validate checks PayloadSpec parameters for consistency
+func (p *PayloadSpec) Validate() error {
+	switch p.Base {
+	case expr.PayloadBaseLLHeader:
+	case expr.PayloadBaseNetworkHeader:
+	case expr.PayloadBaseTransportHeader:
+	default:
+		return fmt.Errorf("unsupported payload base %d", p.Base)
+	}
+	if p.Len == 0 {
+		return fmt.Errorf("payload length cannot be 0")
+	}
+	if len(p.Value) != int(p.Len) {
+		return fmt.Errorf("payload value length %d does not match payload length %d", len(p.Value), p.Len)
+	}
+	if p.Mask != nil && len(p.Mask) != int(p.Len) {
+		return fmt.Errorf("payload mask length %d does not match payload length %d", len(p.Mask), p.Len)
+	}
+
+	return nil
+}
+
+func getExprForPayload(p *PayloadSpec) ([]expr.Any, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	re := []expr.Any{}
+	// [ payload load 2b @ transport header + 2 => reg 1 ]
+	re = append(re, &expr.Payload{
+		DestRegister: 1,
+		Base:         p.Base,
+		Offset:       p.Offset,
+		Len:          p.Len,
+	})
+	if p.Mask != nil {
+		// [ bitwise reg 1 = (reg=1 & 0x0000ff0f ) ^ 0x00000000 ]
+		re = append(re, &expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            p.Len,
+			Mask:           p.Mask,
+			Xor:            make([]byte, p.Len),
+		})
+	}
+	op := expr.CmpOpEq
+	if p.RelOp == NEQ {
+		op = expr.CmpOpNeq
+	}
+	// [ cmp eq reg 1 0x00000050 ]
+	re = append(re, &expr.Cmp{
+		Op:       op,
+		Register: 1,
+		Data:     p.Value,
+	})
+
+	return re, nil
+}
+
+// TunnelInnerPayload is a helper function which builds PayloadSpec matching a field of the header
+// encapsulated into an IPv4 tunnel. encap is the outer header's protocol, supported values are
+// unix.IPPROTO_IPIP, unix.IPPROTO_IPV6 and unix.IPPROTO_GRE; offset is the field's offset within
+// the inner header. nftables cannot follow the tunnel encapsulation, hence the inner header's location
+// is computed assuming the outer IPv4 header carries no options and GRE header carries no optional
+// checksum, key or sequence fields. Rule using it should also match the outer protocol
+// with L3Rule.Protocol.
+func TunnelInnerPayload(encap int, offset uint32, length uint32, value []byte) (*PayloadSpec, error) {
+	// Length of the outer IPv4 header without options
+	innerOffset := uint32(20)
+	switch encap {
+	case unix.IPPROTO_IPIP:
+	case unix.IPPROTO_IPV6:
+	case unix.IPPROTO_GRE:
+		// Base GRE header, flags and protocol type
+		innerOffset += 4
+	default:
+		return nil, fmt.Errorf("unsupported tunnel encapsulation protocol %d", encap)
+	}
+	p := &PayloadSpec{
+		Base:   expr.PayloadBaseNetworkHeader,
+		Offset: innerOffset + offset,
+		Len:    length,
+		Value:  value,
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// RawPayload is a helper function which builds PayloadSpec matching bits of a packet the way nft raw payload
+// expression does, example: @th,16,16 2905 matches SCTP destination port 2905. bitOffset and bitLen are specified
+// in bits and counted from the beginning of the header selected by base, value is right aligned and must fit
+// into bitLen bits, bitLen is limited to 64. When the bits are not byte aligned, the bytes covering them are loaded
+// and the bits outside of the range are masked off.
+func RawPayload(base expr.PayloadBase, bitOffset uint32, bitLen uint32, value uint64) (*PayloadSpec, error) {
+	if bitLen == 0 || bitLen > 64 {
+		return nil, fmt.Errorf("raw payload length must be between 1 and 64 bits")
+	}
+	if bitLen < 64 && value>>bitLen != 0 {
+		return nil, fmt.Errorf("value 0x%x does not fit into %d bits", value, bitLen)
+	}
+	start := bitOffset / 8
+	end := (bitOffset + bitLen + 7) / 8
+	p := &PayloadSpec{
+		Base:   base,
+		Offset: start,
+		Len:    end - start,
+		Value:  make([]byte, end-start),
+		Mask:   make([]byte, end-start),
+	}
+	for i := uint32(0); i < bitLen; i++ {
+		// Position of the bit within the loaded bytes, the most significant bit of value comes first
+		pos := bitOffset - start*8 + i
+		p.Mask[pos/8] |= 0x80 >> (pos % 8)
+		if value&(1<<(bitLen-1-i)) != 0 {
+			p.Value[pos/8] |= 0x80 >> (pos % 8)
+		}
+	}
+	aligned := true
+	for _, m := range p.Mask {
+		if m != 0xff {
+			aligned = false
+		}
+	}
+	if aligned {
+		p.Mask = nil
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// payloadWrite defines action rewriting packet's bytes described by PayloadSpec
+type payloadWrite struct {
+	spec    *PayloadSpec
+	l4proto uint8
+}
+
+// SetPayloadWrite builds RuleAction struct for an action rewriting packet's bytes with PayloadSpec's Value,
+// example: tcp dport set 8080. When Mask is specified, only the bits set in Mask are rewritten.
+// l4proto must be specified when Base is expr.PayloadBaseTransportHeader, it is used to locate L4 checksum,
+// supported protocols are unix.IPPROTO_TCP and unix.IPPROTO_UDP. The kernel recalculates IPv4 header checksum
+// for network header rewrites and L4 checksum for transport header rewrites and IPv4 address rewrites.
+// Network header rewrites are supported only in ipv4 and ipv6 tables, the checksums to update depend on
+// the L3 protocol which is not known in inet, bridge and netdev tables. IPv6 address rewrites are not supported,
+// github.com/google/nftables does not pass L4 checksum flags without a checksum type and IPv6 header has none.
+func SetPayloadWrite(p *PayloadSpec, l4proto uint8) (*RuleAction, error) {
+	if p == nil {
+		return nil, fmt.Errorf("payload cannot be nil")
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if p.Base == expr.PayloadBaseTransportHeader {
+		switch l4proto {
+		case unix.IPPROTO_TCP:
+		case unix.IPPROTO_UDP:
+		default:
+			return nil, fmt.Errorf("unsupported l4 protocol %d for transport header payload write", l4proto)
+		}
+	}
+	ra := &RuleAction{
+		payloadWrite: &payloadWrite{
+			spec:    p,
+			l4proto: l4proto,
+		},
+	}
+
+	return ra, nil
+}
+
+// validatePayloadWrite checks that the checksums affected by the rewrite can be updated in a table of the family
+func validatePayloadWrite(family nftables.TableFamily, p *PayloadSpec) error {
+	if p.Base != expr.PayloadBaseNetworkHeader {
+		return nil
+	}
+	switch family {
+	case nftables.TableFamilyIPv4:
+	case nftables.TableFamilyIPv6:
+		// IPv6 source and destination addresses at offsets 8 to 40 are part of L4 pseudo header,
+		// L4 checksum update requires NFT_PAYLOAD_L4CSUM_PSEUDOHDR flag with csum_type none which
+		// github.com/google/nftables does not marshal.
+		if p.Offset < 40 && p.Offset+p.Len > 8 {
+			return fmt.Errorf("rewriting ipv6 addresses is not supported, l4 checksum cannot be updated")
+		}
+	default:
+		// Checksums fixed up after a network header rewrite depend on the L3 protocol, which is ambiguous
+		// in inet, bridge and netdev tables.
+		return fmt.Errorf("network header payload write is supported only for ipv4 and ipv6 table families")
+	}
+
+	return nil
+}
+
+// getPayloadCsum returns checksum type, offset and flags the kernel needs to fix up the checksum
+// after a payload rewrite, l3proto is the L3 protocol of rewritten packets.
+func getPayloadCsum(l3proto nftables.TableFamily, l4proto uint8, p *PayloadSpec) (expr.PayloadCsumType, uint32, uint32) {
+	switch p.Base {
+	case expr.PayloadBaseNetworkHeader:
+		if l3proto != nftables.TableFamilyIPv4 {
+			// IPv6 header does not carry a checksum, address rewrites are rejected by validatePayloadWrite.
+			return expr.CsumTypeNone, 0, 0
+		}
+		var flags uint32
+		// IPv4 source and destination addresses are part of L4 pseudo header,
+		// rewriting them requires L4 checksum update as well.
+		if p.Offset < 20 && p.Offset+p.Len > 12 {
+			flags = unix.NFT_PAYLOAD_L4CSUM_PSEUDOHDR
+		}
+		// IPv4 header checksum is located at offset 10
+		return expr.CsumTypeInet, 10, flags
+	case expr.PayloadBaseTransportHeader:
+		switch l4proto {
+		case unix.IPPROTO_TCP:
+			// TCP checksum is located at offset 16
+			return expr.CsumTypeInet, 16, 0
+		case unix.IPPROTO_UDP:
+			// UDP checksum is located at offset 6
+			return expr.CsumTypeInet, 6, 0
+		}
+	}
+
+	return expr.CsumTypeNone, 0, 0
+}
+
+func getExprForPayloadWrite(l3proto nftables.TableFamily, pw *payloadWrite) []expr.Any {
+	p := pw.spec
+	re := []expr.Any{}
+	if p.Mask != nil {
+		// Preserving bits not covered by the mask
+		// [ payload load 2b @ network header + 0 => reg 1 ]
+		re = append(re, &expr.Payload{
+			DestRegister: 1,
+			Base:         p.Base,
+			Offset:       p.Offset,
+			Len:          p.Len,
+		})
+		// [ bitwise reg 1 = (reg=1 & 0x000003ff ) ^ 0x0000b800 ]
+		mask := make([]byte, p.Len)
+		xor := make([]byte, p.Len)
+		for i := range mask {
+			mask[i] = ^p.Mask[i]
+			xor[i] = p.Value[i] & p.Mask[i]
+		}
+		re = append(re, &expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            p.Len,
+			Mask:           mask,
+			Xor:            xor,
+		})
+	} else {
+		// [ immediate reg 1 0x00001f90 ]
+		re = append(re, &expr.Immediate{Register: 1, Data: p.Value})
+	}
+	csumType, csumOffset, csumFlags := getPayloadCsum(l3proto, pw.l4proto, p)
+	// [ payload write reg 1 => 2b @ transport header + 2 csum_type 1 csum_off 16 csum_flags 0x0 ]
+	re = append(re, &expr.Payload{
+		OperationType:  expr.PayloadWrite,
+		SourceRegister: 1,
+		Base:           p.Base,
+		Offset:         p.Offset,
+		Len:            p.Len,
+		CsumType:       csumType,
+		CsumOffset:     csumOffset,
+		CsumFlags:      csumFlags,
+	})
+
+	return re
+}
+
+// FlowLabelSpec defines a match against 20 bits long IPv6 flow label, it can only be used
+// in tables of IPv6 family.
+type FlowLabelSpec struct {
+	Value uint32
+	RelOp Operator
+}
+
+// Validate checks that the flow label value fits into 20 bits
+func (f *FlowLabelSpec) Validate() error {
+	if f.Value > 0xfffff {
+		return fmt.Errorf("flow label %#x exceeds maximum value of 0xfffff", f.Value)
+	}
+
+	return nil
+}
+
+func getExprForFlowLabel(l3proto nftables.TableFamily, f *FlowLabelSpec) ([]expr.Any, error) {
+	if l3proto != nftables.TableFamilyIPv6 {
+		return nil, fmt.Errorf("flow label match is supported only for ipv6 table family")
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	// Flow label occupies low 4 bits of the 2nd byte and 3rd and 4th bytes of IPv6 header
+	// [ payload load 3b @ network header + 1 => reg 1 ]
+	// [ bitwise reg 1 = (reg=1 & 0x00ffff0f ) ^ 0x00000000 ]
+	// [ cmp eq reg 1 0x00393001 ]
+	return getExprForPayload(&PayloadSpec{
+		Base:   expr.PayloadBaseNetworkHeader,
+		Offset: 1,
+		Len:    3,
+		Value:  []byte{byte(f.Value >> 16), byte(f.Value >> 8), byte(f.Value)},
+		Mask:   []byte{0x0f, 0xff, 0xff},
+		RelOp:  f.RelOp,
+	})
+}
@@ -0,0 +1,429 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetExprForPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload *PayloadSpec
+		want    []expr.Any
+		success bool
+	}{
+		{
+			name: "SCTP destination port",
+			payload: &PayloadSpec{
+				Base:   expr.PayloadBaseTransportHeader,
+				Offset: 2,
+				Len:    2,
+				Value:  []byte{0x0b, 0x59},
+			},
+			want: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x0b, 0x59}},
+			},
+			success: true,
+		},
+		{
+			name: "Masked IPv4 DSCP not equal",
+			payload: &PayloadSpec{
+				Base:   expr.PayloadBaseNetworkHeader,
+				Offset: 1,
+				Len:    1,
+				Value:  []byte{0xb8},
+				Mask:   []byte{0xfc},
+				RelOp:  NEQ,
+			},
+			want: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 1, Len: 1},
+				&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 1, Mask: []byte{0xfc}, Xor: []byte{0x0}},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0xb8}},
+			},
+			success: true,
+		},
+		{
+			name: "Value length mismatch",
+			payload: &PayloadSpec{
+				Base:  expr.PayloadBaseNetworkHeader,
+				Len:   2,
+				Value: []byte{0x1},
+			},
+			success: false,
+		},
+		{
+			name: "Zero length",
+			payload: &PayloadSpec{
+				Base: expr.PayloadBaseNetworkHeader,
+			},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		got, err := getExprForPayload(tt.payload)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Test \"%s\" failed, got: %+v want: %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTunnelInnerPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		encap   int
+		offset  uint32
+		success bool
+	}{
+		{
+			name:    "IPIP inner source address",
+			encap:   unix.IPPROTO_IPIP,
+			offset:  32,
+			success: true,
+		},
+		{
+			name:    "GRE inner source address",
+			encap:   unix.IPPROTO_GRE,
+			offset:  36,
+			success: true,
+		},
+		{
+			name:    "Unsupported encapsulation",
+			encap:   unix.IPPROTO_UDP,
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		p, err := TunnelInnerPayload(tt.encap, 12, 4, []byte{192, 0, 2, 1})
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && p.Offset != tt.offset {
+			t.Errorf("Test \"%s\" failed, got offset: %d want: %d", tt.name, p.Offset, tt.offset)
+		}
+	}
+}
+
+func TestRawPayload(t *testing.T) {
+	tests := []struct {
+		name      string
+		bitOffset uint32
+		bitLen    uint32
+		value     uint64
+		want      []expr.Any
+		success   bool
+	}{
+		{
+			name:      "SCTP destination port @th,16,16",
+			bitOffset: 16,
+			bitLen:    16,
+			value:     2905,
+			want: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x0b, 0x59}},
+			},
+			success: true,
+		},
+		{
+			name:      "Non byte aligned bits @th,4,8",
+			bitOffset: 4,
+			bitLen:    8,
+			value:     0xab,
+			want: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 2},
+				&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 2, Mask: []byte{0x0f, 0xf0}, Xor: []byte{0x0, 0x0}},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x0a, 0xb0}},
+			},
+			success: true,
+		},
+		{
+			name:      "TCP SYN flag @th,110,1",
+			bitOffset: 110,
+			bitLen:    1,
+			value:     1,
+			want: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 13, Len: 1},
+				&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 1, Mask: []byte{0x02}, Xor: []byte{0x0}},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x02}},
+			},
+			success: true,
+		},
+		{
+			name:      "Value does not fit",
+			bitOffset: 0,
+			bitLen:    4,
+			value:     0x10,
+			success:   false,
+		},
+		{
+			name:      "Zero length",
+			bitOffset: 8,
+			success:   false,
+		},
+	}
+	for _, tt := range tests {
+		p, err := RawPayload(expr.PayloadBaseTransportHeader, tt.bitOffset, tt.bitLen, tt.value)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if !tt.success {
+			continue
+		}
+		got, err := getExprForPayload(p)
+		if err != nil {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Test \"%s\" failed, got: %+v want: %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetExprForPayloadWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		family  nftables.TableFamily
+		l4proto uint8
+		payload *PayloadSpec
+		want    []expr.Any
+	}{
+		{
+			name:    "TCP destination port rewrite",
+			family:  nftables.TableFamilyIPv4,
+			l4proto: unix.IPPROTO_TCP,
+			payload: &PayloadSpec{
+				Base:   expr.PayloadBaseTransportHeader,
+				Offset: 2,
+				Len:    2,
+				Value:  []byte{0x1f, 0x90},
+			},
+			want: []expr.Any{
+				&expr.Immediate{Register: 1, Data: []byte{0x1f, 0x90}},
+				&expr.Payload{
+					OperationType:  expr.PayloadWrite,
+					SourceRegister: 1,
+					Base:           expr.PayloadBaseTransportHeader,
+					Offset:         2,
+					Len:            2,
+					CsumType:       expr.CsumTypeInet,
+					CsumOffset:     16,
+				},
+			},
+		},
+		{
+			name:    "UDP source port rewrite",
+			family:  nftables.TableFamilyIPv6,
+			l4proto: unix.IPPROTO_UDP,
+			payload: &PayloadSpec{
+				Base:  expr.PayloadBaseTransportHeader,
+				Len:   2,
+				Value: []byte{0x00, 0x35},
+			},
+			want: []expr.Any{
+				&expr.Immediate{Register: 1, Data: []byte{0x00, 0x35}},
+				&expr.Payload{
+					OperationType:  expr.PayloadWrite,
+					SourceRegister: 1,
+					Base:           expr.PayloadBaseTransportHeader,
+					Len:            2,
+					CsumType:       expr.CsumTypeInet,
+					CsumOffset:     6,
+				},
+			},
+		},
+		{
+			name:   "IPv4 source address rewrite",
+			family: nftables.TableFamilyIPv4,
+			payload: &PayloadSpec{
+				Base:   expr.PayloadBaseNetworkHeader,
+				Offset: 12,
+				Len:    4,
+				Value:  []byte{192, 0, 2, 1},
+			},
+			want: []expr.Any{
+				&expr.Immediate{Register: 1, Data: []byte{192, 0, 2, 1}},
+				&expr.Payload{
+					OperationType:  expr.PayloadWrite,
+					SourceRegister: 1,
+					Base:           expr.PayloadBaseNetworkHeader,
+					Offset:         12,
+					Len:            4,
+					CsumType:       expr.CsumTypeInet,
+					CsumOffset:     10,
+					CsumFlags:      unix.NFT_PAYLOAD_L4CSUM_PSEUDOHDR,
+				},
+			},
+		},
+		{
+			name:   "IPv4 DSCP rewrite",
+			family: nftables.TableFamilyIPv4,
+			payload: &PayloadSpec{
+				Base:   expr.PayloadBaseNetworkHeader,
+				Offset: 1,
+				Len:    1,
+				Value:  []byte{0xb8},
+				Mask:   []byte{0xfc},
+			},
+			want: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 1, Len: 1},
+				&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 1, Mask: []byte{0x03}, Xor: []byte{0xb8}},
+				&expr.Payload{
+					OperationType:  expr.PayloadWrite,
+					SourceRegister: 1,
+					Base:           expr.PayloadBaseNetworkHeader,
+					Offset:         1,
+					Len:            1,
+					CsumType:       expr.CsumTypeInet,
+					CsumOffset:     10,
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		ra, err := SetPayloadWrite(tt.payload, tt.l4proto)
+		if err != nil {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		got := getExprForPayloadWrite(tt.family, ra.payloadWrite)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Test \"%s\" failed, got: %+v want: %+v", tt.name, got, tt.want)
+		}
+	}
+	if _, err := SetPayloadWrite(&PayloadSpec{
+		Base:  expr.PayloadBaseTransportHeader,
+		Len:   2,
+		Value: []byte{0x0, 0x35},
+	}, unix.IPPROTO_SCTP); err == nil {
+		t.Errorf("SetPayloadWrite succeeded for unsupported l4 protocol but supposed to fail")
+	}
+}
+
+func TestPayloadWriteFamily(t *testing.T) {
+	network := &PayloadSpec{
+		Base:   expr.PayloadBaseNetworkHeader,
+		Offset: 12,
+		Len:    4,
+		Value:  []byte{192, 0, 2, 1},
+	}
+	transport := &PayloadSpec{
+		Base:   expr.PayloadBaseTransportHeader,
+		Offset: 2,
+		Len:    2,
+		Value:  []byte{0x1f, 0x90},
+	}
+	tests := []struct {
+		name    string
+		family  nftables.TableFamily
+		payload *PayloadSpec
+		success bool
+	}{
+		{
+			name:    "Network header rewrite in ipv4 table",
+			family:  nftables.TableFamilyIPv4,
+			payload: network,
+			success: true,
+		},
+		{
+			name:    "Network header address rewrite in ipv6 table",
+			family:  nftables.TableFamilyIPv6,
+			payload: network,
+			success: false,
+		},
+		{
+			name:   "Network header hop limit rewrite in ipv6 table",
+			family: nftables.TableFamilyIPv6,
+			payload: &PayloadSpec{
+				Base:   expr.PayloadBaseNetworkHeader,
+				Offset: 7,
+				Len:    1,
+				Value:  []byte{64},
+			},
+			success: true,
+		},
+		{
+			name:    "Network header rewrite in inet table",
+			family:  nftables.TableFamilyINet,
+			payload: network,
+			success: false,
+		},
+		{
+			name:    "Network header rewrite in bridge table",
+			family:  nftables.TableFamilyBridge,
+			payload: network,
+			success: false,
+		},
+		{
+			name:    "Transport header rewrite in inet table",
+			family:  nftables.TableFamilyINet,
+			payload: transport,
+			success: true,
+		},
+		{
+			name:    "Transport header rewrite in bridge table",
+			family:  nftables.TableFamilyBridge,
+			payload: transport,
+			success: true,
+		},
+	}
+	for _, tt := range tests {
+		tbl := &nftables.Table{Name: "mangle", Family: tt.family}
+		ri := newRules(&applyConn{}, tbl, &nftables.Chain{Name: "rewrite", Table: tbl}, Options{})
+		ra, err := SetPayloadWrite(tt.payload, unix.IPPROTO_TCP)
+		if err != nil {
+			t.Fatalf("SetPayloadWrite failed with error: %+v", err)
+		}
+		_, err = ri.Rules().Create(&Rule{L4: &L4Rule{L4Proto: unix.IPPROTO_TCP}, Action: ra})
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" supposed to fail but succeeded", tt.name)
+		}
+	}
+}
+
+func TestGetExprForFlowLabel(t *testing.T) {
+	want := []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 1, Len: 3},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 3, Mask: []byte{0x0f, 0xff, 0xff}, Xor: []byte{0x0, 0x0, 0x0}},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x01, 0x30, 0x39}},
+	}
+	got, err := getExprForFlowLabel(nftables.TableFamilyIPv6, &FlowLabelSpec{Value: 0x13039})
+	if err != nil {
+		t.Fatalf("getExprForFlowLabel failed with error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForFlowLabel returned %+v want: %+v", got, want)
+	}
+	if _, err := getExprForFlowLabel(nftables.TableFamilyIPv4, &FlowLabelSpec{Value: 0x13039}); err == nil {
+		t.Errorf("getExprForFlowLabel succeeded for ipv4 table family but supposed to fail")
+	}
+	if _, err := getExprForFlowLabel(nftables.TableFamilyIPv6, &FlowLabelSpec{Value: 0x100000}); err == nil {
+		t.Errorf("getExprForFlowLabel succeeded for flow label exceeding 20 bits but supposed to fail")
+	}
+}
@@ -0,0 +1,169 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+)
+
+// Kinds of TCP options
+const (
+	TCPOptionKindMSS         = 2
+	TCPOptionKindWindowScale = 3
+)
+
+// TCPOptionSpec defines a match on a field of TCP option, Kind identifies the option, Offset and Len identify
+// the field within the option, the offset counts from the option's kind byte. Value is compared in network
+// byte order with the field using RelOp, example: tcp option maxseg size > 1400. The rule must match tcp
+// protocol either by L3 Protocol or by L4Proto.
+type TCPOptionSpec struct {
+	Kind   uint8
+	Offset uint32
+	Len    uint32
+	Value  []byte
+	RelOp  Operator
+}
+
+// SetTCPOptionMSS is a helper function returning TCPOptionSpec matching maximum segment size option
+func SetTCPOptionMSS(mss uint16, op Operator) (*TCPOptionSpec, error) {
+	if op > LTE {
+		return nil, fmt.Errorf("unsupported relational operation %d", op)
+	}
+
+	return &TCPOptionSpec{
+		Kind:   TCPOptionKindMSS,
+		Offset: 2,
+		Len:    2,
+		Value:  binaryutil.BigEndian.PutUint16(mss),
+		RelOp:  op,
+	}, nil
+}
+
+// SetTCPOptionWindowScale is a helper function returning TCPOptionSpec matching window scale option's shift count
+func SetTCPOptionWindowScale(shift uint8, op Operator) (*TCPOptionSpec, error) {
+	if op > LTE {
+		return nil, fmt.Errorf("unsupported relational operation %d", op)
+	}
+
+	return &TCPOptionSpec{
+		Kind:   TCPOptionKindWindowScale,
+		Offset: 2,
+		Len:    1,
+		Value:  []byte{shift},
+		RelOp:  op,
+	}, nil
+}
+
+// Validate checks TCPOptionSpec parameters
+func (t *TCPOptionSpec) Validate() error {
+	if t.Len == 0 || t.Len > 16 {
+		return fmt.Errorf("tcp option field length %d must be within 1 and 16", t.Len)
+	}
+	if uint32(len(t.Value)) != t.Len {
+		return fmt.Errorf("length of tcp option value %d does not match field length %d", len(t.Value), t.Len)
+	}
+	if t.RelOp > LTE {
+		return fmt.Errorf("unsupported relational operation %d", t.RelOp)
+	}
+
+	return nil
+}
+
+func getExprForTCPOption(t *TCPOptionSpec) ([]expr.Any, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	re := []expr.Any{}
+	// [ exthdr load tcpopt 2b @ 2 + 2 => reg 1 ]
+	re = append(re, &expr.Exthdr{
+		DestRegister: 1,
+		Type:         t.Kind,
+		Offset:       t.Offset,
+		Len:          t.Len,
+		Op:           expr.ExthdrOpTcpopt,
+	})
+	// [ cmp gt reg 1 0x00007805 ]
+	re = append(re, &expr.Cmp{
+		Op:       cmpOp(t.RelOp),
+		Register: 1,
+		Data:     t.Value,
+	})
+
+	return re, nil
+}
+
+type mssClamp struct {
+	mss  uint16
+	pmtu bool
+}
+
+// SetTCPMSSClamp builds RuleAction struct for an action clamping maximum segment size option of TCP
+// packets to mss, example: tcp option maxseg size set 1360. The kernel only lowers the option's value,
+// packets carrying smaller MSS are not changed. The rule must match tcp protocol, usually packets with
+// syn flag are matched.
+func SetTCPMSSClamp(mss uint16) (*RuleAction, error) {
+	if mss == 0 {
+		return nil, fmt.Errorf("mss cannot be 0")
+	}
+	ra := &RuleAction{
+		mssClamp: &mssClamp{mss: mss},
+	}
+
+	return ra, nil
+}
+
+// SetTCPMSSClampToPMTU builds RuleAction struct for an action clamping maximum segment size option of TCP
+// packets to the value computed from the route's MTU, example: tcp option maxseg size set rt mtu.
+// The route is known only in forward, output and postrouting hooks.
+func SetTCPMSSClampToPMTU() (*RuleAction, error) {
+	ra := &RuleAction{
+		mssClamp: &mssClamp{pmtu: true},
+	}
+
+	return ra, nil
+}
+
+func getExprForMSSClamp(m *mssClamp) []expr.Any {
+	re := []expr.Any{}
+	if m.pmtu {
+		// [ rt load tcpmss => reg 1 ]
+		re = append(re, &expr.Rt{Register: 1, Key: expr.RtTCPMSS})
+		// [ byteorder reg 1 = hton(reg 1, 2, 2) ]
+		re = append(re, &expr.Byteorder{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Op:             expr.ByteorderHton,
+			Len:            2,
+			Size:           2,
+		})
+	} else {
+		// [ immediate reg 1 0x00005005 ]
+		re = append(re, &expr.Immediate{Register: 1, Data: binaryutil.BigEndian.PutUint16(m.mss)})
+	}
+	// [ exthdr write tcpopt reg 1 => 2b @ 2 + 2 ]
+	re = append(re, &expr.Exthdr{
+		SourceRegister: 1,
+		Type:           TCPOptionKindMSS,
+		Offset:         2,
+		Len:            2,
+		Op:             expr.ExthdrOpTcpopt,
+	})
+
+	return re
+}
+
+// validateMSSClampChain checks that the route's MTU is available at the chain's hook
+func validateMSSClampChain(chainAttrs *ChainAttributes) error {
+	if chainAttrs == nil {
+		return nil
+	}
+	for _, h := range []*nftables.ChainHook{nftables.ChainHookForward, nftables.ChainHookOutput, nftables.ChainHookPostrouting} {
+		if isEqualHook(h, chainAttrs.Hook) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("mss clamping to path mtu is not supported in a chain attached to hook %d", hookNum(chainAttrs.Hook))
+}
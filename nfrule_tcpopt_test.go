@@ -0,0 +1,114 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetExprForTCPOption(t *testing.T) {
+	mss, err := SetTCPOptionMSS(1400, GT)
+	if err != nil {
+		t.Fatalf("SetTCPOptionMSS failed with error: %+v", err)
+	}
+	wscale, err := SetTCPOptionWindowScale(7, EQ)
+	if err != nil {
+		t.Fatalf("SetTCPOptionWindowScale failed with error: %+v", err)
+	}
+	tests := []struct {
+		name    string
+		spec    *TCPOptionSpec
+		expect  []expr.Any
+		success bool
+	}{
+		{
+			name: "mss greater than 1400",
+			spec: mss,
+			expect: []expr.Any{
+				&expr.Exthdr{DestRegister: 1, Type: 2, Offset: 2, Len: 2, Op: expr.ExthdrOpTcpopt},
+				&expr.Cmp{Op: expr.CmpOpGt, Register: 1, Data: []byte{0x05, 0x78}},
+			},
+			success: true,
+		},
+		{
+			name: "window scale 7",
+			spec: wscale,
+			expect: []expr.Any{
+				&expr.Exthdr{DestRegister: 1, Type: 3, Offset: 2, Len: 1, Op: expr.ExthdrOpTcpopt},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x7}},
+			},
+			success: true,
+		},
+		{
+			name:    "value length mismatch",
+			spec:    &TCPOptionSpec{Kind: TCPOptionKindMSS, Offset: 2, Len: 2, Value: []byte{0x1}},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		e, err := getExprForTCPOption(tt.spec)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(e, tt.expect) {
+			t.Errorf("Test \"%s\" expressions do not match expected expressions", tt.name)
+		}
+	}
+	// TCP option match requires the rule to match tcp
+	if err := (Rule{TCPOption: mss}).Validate(nftables.TableFamilyIPv4, nil); err == nil {
+		t.Errorf("validation of tcp option rule without tcp protocol supposed to fail but succeeded")
+	}
+	proto := L3Protocol(unix.IPPROTO_TCP)
+	if err := (Rule{L3: &L3Rule{Protocol: proto}, TCPOption: mss}).Validate(nftables.TableFamilyIPv4, nil); err != nil {
+		t.Errorf("validation of tcp option rule failed with error: %+v but supposed to succeed", err)
+	}
+}
+
+func TestGetExprForMSSClamp(t *testing.T) {
+	ra, err := SetTCPMSSClamp(1360)
+	if err != nil {
+		t.Fatalf("SetTCPMSSClamp failed with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Immediate{Register: 1, Data: []byte{0x05, 0x50}},
+		&expr.Exthdr{SourceRegister: 1, Type: 2, Offset: 2, Len: 2, Op: expr.ExthdrOpTcpopt},
+	}
+	if got := getExprForMSSClamp(ra.mssClamp); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForMSSClamp returned %+v want: %+v", got, want)
+	}
+	pmtu, err := SetTCPMSSClampToPMTU()
+	if err != nil {
+		t.Fatalf("SetTCPMSSClampToPMTU failed with error: %+v", err)
+	}
+	want = []expr.Any{
+		&expr.Rt{Register: 1, Key: expr.RtTCPMSS},
+		&expr.Byteorder{SourceRegister: 1, DestRegister: 1, Op: expr.ByteorderHton, Len: 2, Size: 2},
+		&expr.Exthdr{SourceRegister: 1, Type: 2, Offset: 2, Len: 2, Op: expr.ExthdrOpTcpopt},
+	}
+	if got := getExprForMSSClamp(pmtu.mssClamp); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExprForMSSClamp returned %+v want: %+v", got, want)
+	}
+	if _, err := SetTCPMSSClamp(0); err == nil {
+		t.Errorf("SetTCPMSSClamp succeeded with 0 mss but supposed to fail")
+	}
+	proto := L3Protocol(unix.IPPROTO_TCP)
+	forward := &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookForward, Priority: nftables.ChainPriorityMangle}
+	input := &ChainAttributes{Type: nftables.ChainTypeFilter, Hook: nftables.ChainHookInput, Priority: nftables.ChainPriorityMangle}
+	if err := (Rule{Action: ra}).Validate(nftables.TableFamilyIPv4, forward); err == nil {
+		t.Errorf("validation of mss clamping without tcp protocol supposed to fail but succeeded")
+	}
+	if err := (Rule{L3: &L3Rule{Protocol: proto}, Action: pmtu}).Validate(nftables.TableFamilyIPv4, forward); err != nil {
+		t.Errorf("validation of mss clamping in forward chain failed with error: %+v but supposed to succeed", err)
+	}
+	if err := (Rule{L3: &L3Rule{Protocol: proto}, Action: pmtu}).Validate(nftables.TableFamilyIPv4, input); err == nil {
+		t.Errorf("validation of mss clamping to path mtu in input chain supposed to fail but succeeded")
+	}
+}
@@ -0,0 +1,72 @@
+package nftableslib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/nftables"
+)
+
+// CreateWithTTL programs the rule immediately and removes it once ttl expires, for example for a temporary
+// block during an incident. The returned cancel function stops the removal and keeps the rule. The rule is
+// removed by its handle which is preserved by Sync, if the rule is no longer in the store it is removed from
+// the host directly. The result of the removal is passed to TTLExpired of Options, the rule stays in the store
+// when the removal fails. The removal is flushed from the timer's goroutine, as with other Imm operations
+// messages queued on the connection by other callers are sent with it, hence the connection must not be
+// shared with callers building a batch across several calls.
+func (nfr *nfRules) CreateWithTTL(rule *Rule, ttl time.Duration) (func(), error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl must be greater than 0")
+	}
+	handle, err := nfr.CreateImm(rule)
+	if err != nil {
+		return nil, err
+	}
+	timer := time.AfterFunc(ttl, func() {
+		err := nfr.expire(handle)
+		if nfr.opts.TTLExpired != nil {
+			nfr.opts.TTLExpired(nfr.table.Name, nfr.chain.Name, handle, err)
+		}
+	})
+
+	return func() { timer.Stop() }, nil
+}
+
+// expire removes the rule identified by handle from the host and then from the store,
+// the store is not changed if the removal fails.
+func (nfr *nfRules) expire(handle uint64) error {
+	nfr.Lock()
+	defer nfr.Unlock()
+	r, err := getRuleByHandle(nfr.rules, handle)
+	if err != nil {
+		if err := nfr.conn.DelRule(&nftables.Rule{Table: nfr.table, Chain: nfr.chain, Handle: handle}); err != nil {
+			return err
+		}
+		return nfr.conn.Flush()
+	}
+	// The rule dropping packets over the limit of rate limited reject action is removed with the rule
+	removed := []*nfRule{r}
+	if r.overLimit != nil {
+		if _, err := getRuleByID(nfr.rules, r.overLimit.id); err == nil {
+			removed = append(removed, r.overLimit)
+		}
+	}
+	for _, rr := range removed {
+		if rr.rule.Handle == 0 {
+			continue
+		}
+		if err := nfr.conn.DelRule(rr.rule); err != nil {
+			return err
+		}
+	}
+	if err := nfr.conn.Flush(); err != nil {
+		return err
+	}
+	for _, rr := range removed {
+		if err := nfr.removeRule(rr.id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package nftableslib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+// ttlConn allocates handles to added rules and reports deleted handles, flushErr fails the flush of deletions
+type ttlConn struct {
+	applyConn
+	deleted  chan uint64
+	deleting bool
+	flushErr error
+}
+
+func (c *ttlConn) Flush() error {
+	deleting := c.deleting
+	c.deleting = false
+	if deleting && c.flushErr != nil {
+		return c.flushErr
+	}
+	return c.applyConn.Flush()
+}
+
+func (c *ttlConn) GetRule(_ *nftables.Table, _ *nftables.Chain) ([]*nftables.Rule, error) {
+	for i, r := range c.addedRules {
+		r.Handle = uint64(i + 1)
+	}
+	return c.addedRules, nil
+}
+
+func (c *ttlConn) DelRule(r *nftables.Rule) error {
+	c.deleting = true
+	c.deleted <- r.Handle
+	return nil
+}
+
+func TestCreateWithTTL(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	conn := &ttlConn{deleted: make(chan uint64, 2)}
+	expired := make(chan error, 1)
+	ri := newRules(conn, tbl, chain, Options{
+		TTLExpired: func(table, chain string, _ uint64, err error) {
+			if table != "filter" || chain != "input" {
+				t.Errorf("removal of rule of chain %s of table %s was reported", chain, table)
+			}
+			expired <- err
+		},
+	})
+	rule := &Rule{
+		L3:     &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.0.2.1")}}},
+		Action: setActionVerdict(t, NFT_DROP),
+	}
+	if _, err := ri.Rules().CreateWithTTL(rule, 10*time.Millisecond); err != nil {
+		t.Fatalf("CreateWithTTL failed with error: %+v", err)
+	}
+	select {
+	case err := <-expired:
+		if err != nil {
+			t.Fatalf("removal of expired rule failed with error: %+v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("rule was not deleted after ttl expired")
+	}
+	if h := <-conn.deleted; h != 1 {
+		t.Errorf("expected rule with handle 1 to be deleted, got handle %d", h)
+	}
+	nfr := ri.(*nfRules)
+	nfr.Lock()
+	if nfr.rules != nil {
+		t.Errorf("expired rule is still in the store")
+	}
+	nfr.Unlock()
+
+	// Cancelled rule is kept
+	cancel, err := ri.Rules().CreateWithTTL(&Rule{
+		L4:     &L4Rule{L4Proto: unix.IPPROTO_TCP},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CreateWithTTL failed with error: %+v", err)
+	}
+	cancel()
+	select {
+	case h := <-conn.deleted:
+		t.Errorf("cancelled rule with handle %d was deleted", h)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Rule missing in the store is removed from the host by its handle
+	if err := nfr.expire(99); err != nil {
+		t.Fatalf("expire failed with error: %+v", err)
+	}
+	if h := <-conn.deleted; h != 99 {
+		t.Errorf("expected rule with handle 99 to be deleted, got handle %d", h)
+	}
+	// Failed removal is reported and the rule is kept in the store
+	conn.flushErr = unix.ENOBUFS
+	if _, err := ri.Rules().CreateWithTTL(rule, 10*time.Millisecond); err != nil {
+		t.Fatalf("CreateWithTTL failed with error: %+v", err)
+	}
+	select {
+	case err := <-expired:
+		if err == nil {
+			t.Errorf("failed removal of expired rule was not reported")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("removal of expired rule was not reported")
+	}
+	<-conn.deleted
+	nfr.Lock()
+	if _, err := getRuleByHandle(nfr.rules, 3); err != nil {
+		t.Errorf("rule which failed to expire was removed from the store")
+	}
+	nfr.Unlock()
+	if _, err := ri.Rules().CreateWithTTL(rule, 0); err == nil {
+		t.Errorf("CreateWithTTL with 0 ttl supposed to fail but succeeded")
+	}
+}
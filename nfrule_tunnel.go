@@ -0,0 +1,122 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// VXLANDefaultPort is IANA assigned UDP destination port of VXLAN
+const VXLANDefaultPort = 4789
+
+// VXLANSpec defines a match against VXLAN Network Identifier of a VXLAN encapsulated packet. nftables cannot
+// recognize VXLAN, a packet is assumed to carry VXLAN header right after UDP header when its UDP destination port
+// is Port, VXLANDefaultPort is used when Port is 0. RelOp applies to VNI comparison only.
+type VXLANSpec struct {
+	VNI   uint32
+	Port  uint16
+	RelOp Operator
+}
+
+// Validate checks that VNI fits into 24 bits
+func (v *VXLANSpec) Validate() error {
+	if v.VNI > 0xffffff {
+		return fmt.Errorf("vxlan vni %#x exceeds maximum value of 0xffffff", v.VNI)
+	}
+
+	return nil
+}
+
+func getExprForVXLAN(v *VXLANSpec) ([]expr.Any, error) {
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+	port := v.Port
+	if port == 0 {
+		port = VXLANDefaultPort
+	}
+	re := getExprForL4Proto(unix.IPPROTO_UDP)
+	// [ payload load 2b @ transport header + 2 => reg 1 ]
+	// [ cmp eq reg 1 0x0000b512 ]
+	re = append(re, &expr.Payload{
+		DestRegister: 1,
+		Base:         expr.PayloadBaseTransportHeader,
+		Offset:       2,
+		Len:          2,
+	})
+	re = append(re, &expr.Cmp{
+		Op:       expr.CmpOpEq,
+		Register: 1,
+		Data:     binaryutil.BigEndian.PutUint16(port),
+	})
+	// VNI occupies 3 bytes at offset 4 of VXLAN header which follows 8 bytes long UDP header
+	// [ payload load 3b @ transport header + 12 => reg 1 ]
+	// [ cmp eq reg 1 0x00640000 ]
+	e, err := getExprForPayload(&PayloadSpec{
+		Base:   expr.PayloadBaseTransportHeader,
+		Offset: 12,
+		Len:    3,
+		Value:  []byte{byte(v.VNI >> 16), byte(v.VNI >> 8), byte(v.VNI)},
+		RelOp:  v.RelOp,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(re, e...), nil
+}
+
+// GRESpec defines a match against the key of a GRE encapsulated packet. Only packets with Key Present flag
+// set and Checksum Present flag cleared match, as the key's offset in GRE header depends on presence of
+// the checksum. RelOp applies to the key comparison only.
+type GRESpec struct {
+	Key   uint32
+	RelOp Operator
+}
+
+func getExprForGRE(g *GRESpec) ([]expr.Any, error) {
+	re := getExprForL4Proto(unix.IPPROTO_GRE)
+	// Checksum Present (0x80) flag must be cleared and Key Present (0x20) flag must be set
+	// [ payload load 1b @ transport header + 0 => reg 1 ]
+	// [ bitwise reg 1 = (reg=1 & 0x000000a0 ) ^ 0x00000000 ]
+	// [ cmp eq reg 1 0x00000020 ]
+	e, err := getExprForPayload(&PayloadSpec{
+		Base:   expr.PayloadBaseTransportHeader,
+		Offset: 0,
+		Len:    1,
+		Value:  []byte{0x20},
+		Mask:   []byte{0xa0},
+	})
+	if err != nil {
+		return nil, err
+	}
+	re = append(re, e...)
+	// [ payload load 4b @ transport header + 4 => reg 1 ]
+	// [ cmp eq reg 1 0x64000000 ]
+	if e, err = getExprForPayload(&PayloadSpec{
+		Base:   expr.PayloadBaseTransportHeader,
+		Offset: 4,
+		Len:    4,
+		Value:  binaryutil.BigEndian.PutUint32(g.Key),
+		RelOp:  g.RelOp,
+	}); err != nil {
+		return nil, err
+	}
+
+	return append(re, e...), nil
+}
+
+func getExprForL4Proto(proto uint8) []expr.Any {
+	// [ meta load l4proto => reg 1 ]
+	// [ cmp eq reg 1 0x00000011 ]
+	return []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     []byte{proto},
+		},
+	}
+}
@@ -0,0 +1,125 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetExprForVXLAN(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    *VXLANSpec
+		expect  []expr.Any
+		success bool
+	}{
+		{
+			name: "vni 100 on default port",
+			spec: &VXLANSpec{VNI: 100},
+			expect: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_UDP}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x12, 0xb5}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 12, Len: 3},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x0, 0x0, 0x64}},
+			},
+			success: true,
+		},
+		{
+			name: "vni not 0x10203 on custom port",
+			spec: &VXLANSpec{VNI: 0x10203, Port: 8472, RelOp: NEQ},
+			expect: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_UDP}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x21, 0x18}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 12, Len: 3},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x1, 0x2, 0x3}},
+			},
+			success: true,
+		},
+		{
+			name:    "vni exceeding 24 bits",
+			spec:    &VXLANSpec{VNI: 0x1000000},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		e, err := getExprForVXLAN(tt.spec)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(e, tt.expect) {
+			t.Errorf("Test \"%s\" expressions do not match expected expressions", tt.name)
+		}
+	}
+	// VXLAN match cannot be combined with tcp match
+	l4 := &L4Rule{L4Proto: unix.IPPROTO_TCP, Dst: &Port{List: SetPortList([]int{80})}}
+	if err := (Rule{L4: l4, VXLAN: &VXLANSpec{VNI: 100}}).Validate(nftables.TableFamilyIPv4, nil); err == nil {
+		t.Errorf("validation of vxlan rule matching tcp supposed to fail but succeeded")
+	}
+}
+
+func TestGetExprForGRE(t *testing.T) {
+	e, err := getExprForGRE(&GRESpec{Key: 100})
+	if err != nil {
+		t.Fatalf("getExprForGRE failed with error: %+v", err)
+	}
+	expect := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_GRE}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 1},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 1, Mask: []byte{0xa0}, Xor: []byte{0x0}},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x20}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 4, Len: 4},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x0, 0x0, 0x0, 0x64}},
+	}
+	if !reflect.DeepEqual(e, expect) {
+		t.Errorf("gre key expressions do not match expected expressions")
+	}
+	if err := (Rule{VXLAN: &VXLANSpec{VNI: 100}, GRE: &GRESpec{Key: 100}}).Validate(nftables.TableFamilyIPv4, nil); err == nil {
+		t.Errorf("validation of rule with both vxlan and gre matches supposed to fail but succeeded")
+	}
+}
+
+func TestL3ProtocolRelOp(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      Operator
+		success bool
+	}{
+		{
+			name:    "ip protocol gre",
+			op:      EQ,
+			success: true,
+		},
+		{
+			name:    "ip protocol != gre",
+			op:      NEQ,
+			success: true,
+		},
+		{
+			name:    "ip protocol > gre",
+			op:      GT,
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		err := (&L3Rule{Protocol: L3Protocol(unix.IPPROTO_GRE), RelOp: tt.op}).Validate()
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// VMapSpec defines an anonymous verdict map looked up by a field of the packet, example:
+// tcp dport vmap { 22 : accept, 80 : jump web }. Match selects the field, for L4 matches L4Proto
+// selects the transport protocol, MatchTypeIIFName and MatchTypeOIFName dispatch by the name of
+// input or output interface, example: oifname vmap { "eth0" : jump a, "eth1" : jump b }. The map is created together with the rule and the kernel removes
+// it when the rule is deleted.
+type VMapSpec struct {
+	Match    MatchType      `json:"match"`
+	L4Proto  uint8          `json:"l4proto,omitempty"`
+	Elements []*VMapElement `json:"elements"`
+}
+
+// VMapElement defines a single element of VMapSpec, Addr is the key of L3 matches and must be a host
+// address, Port is the key of L4 matches and IfName is the key of interface name matches.
+// Action must carry a verdict.
+type VMapElement struct {
+	Addr   *IPAddr     `json:"addr,omitempty"`
+	Port   uint16      `json:"port,omitempty"`
+	IfName string      `json:"ifName,omitempty"`
+	Action *RuleAction `json:"action"`
+}
+
+// SetVMap builds RuleAction struct for an action dispatching packets with an anonymous verdict map
+func SetVMap(spec *VMapSpec) (*RuleAction, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	ra := &RuleAction{
+		vmap: spec,
+	}
+
+	return ra, nil
+}
+
+// Validate checks VMapSpec parameters
+func (v *VMapSpec) Validate() error {
+	if v == nil {
+		return fmt.Errorf("vmap cannot be nil")
+	}
+	if len(v.Elements) == 0 {
+		return fmt.Errorf("number of elements in vmap cannot be 0")
+	}
+	switch v.Match {
+	case MatchTypeL3Src, MatchTypeL3Dst:
+	case MatchTypeL4Src, MatchTypeL4Dst:
+		if v.L4Proto == 0 {
+			return fmt.Errorf("vmap matching ports requires l4 protocol")
+		}
+	case MatchTypeIIFName, MatchTypeOIFName:
+	default:
+		return fmt.Errorf("unsupported matching criteria %+v", v.Match)
+	}
+	keys := make(map[string]bool)
+	for i, e := range v.Elements {
+		if e == nil {
+			return fmt.Errorf("vmap element %d cannot be nil", i)
+		}
+		if e.Action == nil || e.Action.verdict == nil {
+			return fmt.Errorf("vmap element %d must carry a verdict", i)
+		}
+		var key string
+		switch v.Match {
+		case MatchTypeL3Src, MatchTypeL3Dst:
+			if e.Addr == nil || e.Addr.IPAddr == nil {
+				return fmt.Errorf("vmap element %d must carry an address", i)
+			}
+			full := uint8(32)
+			if e.Addr.IsIPv6() {
+				full = 128
+			}
+			if e.Addr.CIDR && (e.Addr.Mask == nil || *e.Addr.Mask != full) {
+				return fmt.Errorf("vmap element %d address must be a host address", i)
+			}
+			if e.Addr.IsIPv6() != v.Elements[0].Addr.IsIPv6() {
+				return fmt.Errorf("vmap elements must carry addresses of the same family")
+			}
+			key = e.Addr.IP.String()
+		case MatchTypeIIFName, MatchTypeOIFName:
+			if e.Addr != nil || e.Port != 0 {
+				return fmt.Errorf("vmap element %d can only carry an interface name when matching interfaces", i)
+			}
+			if e.IfName == "" || len(e.IfName) >= unix.IFNAMSIZ {
+				return fmt.Errorf("vmap element %d interface name must be 1 to %d characters long", i, unix.IFNAMSIZ-1)
+			}
+			key = e.IfName
+		default:
+			if e.Addr != nil || e.IfName != "" {
+				return fmt.Errorf("vmap element %d can only carry a port when matching ports", i)
+			}
+			key = fmt.Sprintf("%d", e.Port)
+		}
+		if keys[key] {
+			return fmt.Errorf("duplicate vmap key %s", key)
+		}
+		keys[key] = true
+	}
+
+	return nil
+}
+
+func getExprForVMap(nfr *nfRules, family nftables.TableFamily, v *VMapSpec) ([]expr.Any, error) {
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+	re := []expr.Any{}
+	set := &nftables.Set{
+		Table:     nfr.table,
+		Anonymous: true,
+		Constant:  true,
+		IsMap:     true,
+		DataType:  nftables.TypeVerdict,
+	}
+	var elements []nftables.SetElement
+	switch v.Match {
+	case MatchTypeL3Src, MatchTypeL3Dst:
+		ipv6 := v.Elements[0].Addr.IsIPv6()
+		switch {
+		case family == nftables.TableFamilyIPv4 && !ipv6:
+		case family == nftables.TableFamilyIPv6 && ipv6:
+		default:
+			return nil, fmt.Errorf("vmap addresses do not match table family %d", family)
+		}
+		offset, l := uint32(12), uint32(4)
+		set.KeyType = nftables.TypeIPAddr
+		if ipv6 {
+			offset, l = 8, 16
+			set.KeyType = nftables.TypeIP6Addr
+		}
+		if v.Match == MatchTypeL3Dst {
+			offset += l
+		}
+		// [ payload load 4b @ network header + 12 => reg 1 ]
+		re = append(re, &expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseNetworkHeader,
+			Offset:       offset,
+			Len:          l,
+		})
+		for _, e := range v.Elements {
+			key := e.Addr.IP.To4()
+			if ipv6 {
+				key = e.Addr.IP.To16()
+			}
+			elements = append(elements, nftables.SetElement{
+				Key:         key,
+				VerdictData: e.Action.verdict,
+			})
+		}
+	case MatchTypeIIFName, MatchTypeOIFName:
+		set.KeyType = nftables.TypeIFName
+		key := expr.MetaKeyIIFNAME
+		if v.Match == MatchTypeOIFName {
+			key = expr.MetaKeyOIFNAME
+		}
+		// [ meta load oifname => reg 1 ]
+		re = append(re, &expr.Meta{Key: key, Register: 1})
+		for _, e := range v.Elements {
+			elements = append(elements, nftables.SetElement{
+				Key:         ifname(e.IfName),
+				VerdictData: e.Action.verdict,
+			})
+		}
+	default:
+		set.KeyType = nftables.TypeInetService
+		offset := uint32(0)
+		if v.Match == MatchTypeL4Dst {
+			offset = 2
+		}
+		// [ meta load l4proto => reg 1 ]
+		// [ cmp eq reg 1 0x00000006 ]
+		re = append(re, &expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1})
+		re = append(re, &expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     []byte{v.L4Proto},
+		})
+		// [ payload load 2b @ transport header + 2 => reg 1 ]
+		re = append(re, &expr.Payload{
+			DestRegister: 1,
+			Base:         expr.PayloadBaseTransportHeader,
+			Offset:       offset,
+			Len:          2,
+		})
+		for _, e := range v.Elements {
+			elements = append(elements, nftables.SetElement{
+				Key:         binaryutil.BigEndian.PutUint16(e.Port),
+				VerdictData: e.Action.verdict,
+			})
+		}
+	}
+	if err := nfr.conn.AddSet(set, elements); err != nil {
+		return nil, err
+	}
+	// [ lookup reg 1 set __map%d dreg 0 ]
+	re = append(re, &expr.Lookup{
+		SourceRegister: 1,
+		DestRegister:   0,
+		IsDestRegSet:   true,
+		SetID:          set.ID,
+		SetName:        set.Name,
+	})
+
+	return re, nil
+}
+
+func (v *VMapSpec) clone() *VMapSpec {
+	if v == nil {
+		return nil
+	}
+	n := &VMapSpec{
+		Match:   v.Match,
+		L4Proto: v.L4Proto,
+	}
+	if v.Elements != nil {
+		n.Elements = make([]*VMapElement, len(v.Elements))
+		for i, e := range v.Elements {
+			if e == nil {
+				continue
+			}
+			n.Elements[i] = &VMapElement{
+				Addr:   e.Addr.clone(),
+				Port:   e.Port,
+				IfName: e.IfName,
+				Action: e.Action.Clone(),
+			}
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,155 @@
+package nftableslib
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestGetExprForVMap(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	nfr := &nfRules{
+		conn:  &dryRunConn{sets: make(map[string]bool)},
+		table: tbl,
+		chain: &nftables.Chain{Name: "input", Table: tbl},
+	}
+	accept := setActionVerdict(t, NFT_ACCEPT)
+	jump := setActionVerdict(t, unix.NFT_JUMP, "web")
+	tests := []struct {
+		name    string
+		spec    *VMapSpec
+		expect  []expr.Any
+		success bool
+	}{
+		{
+			name: "tcp dport vmap",
+			spec: &VMapSpec{
+				Match:   MatchTypeL4Dst,
+				L4Proto: unix.IPPROTO_TCP,
+				Elements: []*VMapElement{
+					{Port: 22, Action: accept},
+					{Port: 80, Action: jump},
+				},
+			},
+			expect: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+				&expr.Lookup{SourceRegister: 1, IsDestRegSet: true},
+			},
+			success: true,
+		},
+		{
+			name: "ip saddr vmap",
+			spec: &VMapSpec{
+				Match: MatchTypeL3Src,
+				Elements: []*VMapElement{
+					{Addr: setIPAddr(t, "192.0.2.1"), Action: accept},
+				},
+			},
+			expect: []expr.Any{
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+				&expr.Lookup{SourceRegister: 1, IsDestRegSet: true},
+			},
+			success: true,
+		},
+		{
+			name: "oifname vmap",
+			spec: &VMapSpec{
+				Match: MatchTypeOIFName,
+				Elements: []*VMapElement{
+					{IfName: "eth0", Action: jump},
+					{IfName: "eth1", Action: accept},
+				},
+			},
+			expect: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+				&expr.Lookup{SourceRegister: 1, IsDestRegSet: true},
+			},
+			success: true,
+		},
+		{
+			name: "interface name too long",
+			spec: &VMapSpec{
+				Match: MatchTypeIIFName,
+				Elements: []*VMapElement{
+					{IfName: "interface-name-17", Action: accept},
+				},
+			},
+			success: false,
+		},
+		{
+			name: "ipv6 address in ipv4 table",
+			spec: &VMapSpec{
+				Match: MatchTypeL3Dst,
+				Elements: []*VMapElement{
+					{Addr: setIPAddr(t, "2001:db8::1"), Action: accept},
+				},
+			},
+			success: false,
+		},
+		{
+			name: "duplicate port",
+			spec: &VMapSpec{
+				Match:   MatchTypeL4Src,
+				L4Proto: unix.IPPROTO_UDP,
+				Elements: []*VMapElement{
+					{Port: 53, Action: accept},
+					{Port: 53, Action: jump},
+				},
+			},
+			success: false,
+		},
+		{
+			name: "element without verdict",
+			spec: &VMapSpec{
+				Match:    MatchTypeL4Dst,
+				L4Proto:  unix.IPPROTO_TCP,
+				Elements: []*VMapElement{{Port: 22, Action: &RuleAction{}}},
+			},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		e, err := getExprForVMap(nfr, tbl.Family, tt.spec)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if tt.success && !reflect.DeepEqual(e, tt.expect) {
+			t.Errorf("Test \"%s\" expressions do not match expected expressions", tt.name)
+		}
+	}
+}
+
+func TestVMapJSON(t *testing.T) {
+	ra, err := SetVMap(&VMapSpec{
+		Match:   MatchTypeL4Dst,
+		L4Proto: unix.IPPROTO_TCP,
+		Elements: []*VMapElement{
+			{Port: 22, Action: setActionVerdict(t, NFT_ACCEPT)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetVMap failed with error: %+v", err)
+	}
+	b, err := json.Marshal(ra)
+	if err != nil {
+		t.Fatalf("failed to marshal vmap action with error: %+v", err)
+	}
+	n := &RuleAction{}
+	if err := json.Unmarshal(b, n); err != nil {
+		t.Fatalf("failed to unmarshal %s with error: %+v", string(b), err)
+	}
+	if !reflect.DeepEqual(ra, n) || !reflect.DeepEqual(ra, ra.Clone()) {
+		t.Errorf("unmarshaled or cloned vmap action does not match original action, json: %s", string(b))
+	}
+}
@@ -0,0 +1,46 @@
+package nftableslib
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// nlaHdrLen is the length of netlink attribute header, attributes are aligned to 4 bytes
+const nlaHdrLen = 4
+
+// MarshalExprs returns expressions of the rule serialized the way they are carried by NFTA_RULE_EXPRESSIONS
+// attribute of a netlink message programming the rule in a regular chain of a table of the family.
+// Like Expressions, nothing is sent to the kernel and lookups into generated sets carry empty set name and id,
+// so the bytes are stable and can be compared with golden outputs to catch changes of the wire format.
+func (r Rule) MarshalExprs(family nftables.TableFamily) ([]byte, error) {
+	exprs, err := r.Expressions(family)
+	if err != nil {
+		return nil, err
+	}
+	b := []byte{}
+	for i, e := range exprs {
+		data, err := expr.Marshal(byte(family), e)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal expression %d of type %T: %+v", i, e, err)
+		}
+		b = append(b, marshalAttr(unix.NLA_F_NESTED|unix.NFTA_LIST_ELEM, data)...)
+	}
+
+	return b, nil
+}
+
+// marshalAttr encodes a single netlink attribute, length and type are in host byte order,
+// data is padded to 4 bytes boundary.
+func marshalAttr(attrType uint16, data []byte) []byte {
+	l := nlaHdrLen + len(data)
+	b := make([]byte, 0, (l+3)&^3)
+	b = append(b, binaryutil.NativeEndian.PutUint16(uint16(l))...)
+	b = append(b, binaryutil.NativeEndian.PutUint16(attrType)...)
+	b = append(b, data...)
+
+	return append(b, make([]byte, cap(b)-len(b))...)
+}
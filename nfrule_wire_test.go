@@ -0,0 +1,145 @@
+package nftableslib
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// TestMarshalExprsGolden locks down the wire format of expressions built for L3, L4 and NAT rules,
+// a change of the golden output means rules programmed by the library changed on the host. Netlink attribute
+// headers are in host byte order, golden outputs are recorded on a little endian host.
+func TestMarshalExprsGolden(t *testing.T) {
+	if binaryutil.NativeEndian.Uint16([]byte{0x1, 0x0}) != 1 {
+		t.Skip("golden outputs are recorded on a little endian host")
+	}
+	snat, err := SetSNAT(&NATAttributes{L3Addr: [2]*IPAddr{setIPAddr(t, "198.51.100.1")}, Port: [2]uint16{1024, 2048}})
+	if err != nil {
+		t.Fatalf("failed to SetSNAT with error: %+v", err)
+	}
+	dnat, err := SetDNAT(&NATAttributes{L3Addr: [2]*IPAddr{setIPAddr(t, "2001:db8::1")}})
+	if err != nil {
+		t.Fatalf("failed to SetDNAT with error: %+v", err)
+	}
+	netmap, err := SetNetmap(setIPAddr(t, "10.0.0.0/24"), true)
+	if err != nil {
+		t.Fatalf("failed to SetNetmap with error: %+v", err)
+	}
+	saddr6, err := SetPayloadWrite(&PayloadSpec{
+		Base:   expr.PayloadBaseNetworkHeader,
+		Offset: 8,
+		Len:    16,
+		Value:  []byte{0x20, 0x01, 0x0d, 0xb8, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1},
+	}, 0)
+	if err != nil {
+		t.Fatalf("failed to SetPayloadWrite with error: %+v", err)
+	}
+	// When golden is empty, the output is only checked to contain the hex string, payload write attributes
+	// are checked for checksum type, offset and flags actually carried on the wire.
+	tests := []struct {
+		name     string
+		family   nftables.TableFamily
+		rule     Rule
+		golden   string
+		contains string
+		success  bool
+	}{
+		{
+			name:   "L3 ip saddr 192.0.2.0/24 accept",
+			family: nftables.TableFamilyIPv4,
+			rule: Rule{
+				L3:     &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.0.2.0/24")}}},
+				Action: setActionVerdict(t, NFT_ACCEPT),
+			},
+			success: true,
+			golden:  "340001800c0001007061796c6f6164002400028008000100000000010800020000000001080003000000000c0800040000000004440001800c0001006269747769736500340002800800010000000001080002000000000108000300000000040c00048008000100ffffff000c00058008000100000000002c00018008000100636d700020000280080001000000000108000200000000000c00038008000100c0000200300001800e000100696d6d6564696174650000001c0002800800010000000000100002800c0002800800010000000001",
+		},
+		{
+			name:   "L3 ip6 daddr != 2001:db8::1 drop",
+			family: nftables.TableFamilyIPv6,
+			rule: Rule{
+				L3:     &L3Rule{Dst: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "2001:db8::1")}, RelOp: NEQ}},
+				Action: setActionVerdict(t, NFT_DROP),
+			},
+			success: true,
+			golden:  "340001800c0001007061796c6f6164002400028008000100000000010800020000000001080003000000001808000400000000105c0001800c00010062697477697365004c0002800800010000000001080002000000000108000300000000101800048014000100ffffffffffffffffffffffffffffffff1800058014000100000000000000000000000000000000003800018008000100636d70002c00028008000100000000010800020000000001180003801400010020010db8000000000000000000000001300001800e000100696d6d6564696174650000001c0002800800010000000000100002800c0002800800010000000000",
+		},
+		{
+			name:   "L4 tcp dport 22 accept",
+			family: nftables.TableFamilyIPv4,
+			rule: Rule{
+				L4:     &L4Rule{L4Proto: unix.IPPROTO_TCP, Dst: &Port{List: SetPortList([]int{22})}},
+				Action: setActionVerdict(t, NFT_ACCEPT),
+			},
+			success: true,
+			golden:  "24000180090001006d6574610000000014000280080002000000001008000100000000012c00018008000100636d700020000280080001000000000108000200000000000c0003800500010006000000340001800c0001007061796c6f6164002400028008000100000000010800020000000002080003000000000208000400000000022c00018008000100636d700020000280080001000000000108000200000000000c0003800600010000160000300001800e000100696d6d6564696174650000001c0002800800010000000000100002800c0002800800010000000001",
+		},
+		{
+			name:   "L4 udp dport 1000-2000 jump",
+			family: nftables.TableFamilyIPv4,
+			rule: Rule{
+				L4:     &L4Rule{L4Proto: unix.IPPROTO_UDP, Dst: &Port{Range: SetPortRange([2]int{1000, 2000})}},
+				Action: setActionVerdict(t, unix.NFT_JUMP, "udp-chain"),
+			},
+			success: true,
+			golden:  "24000180090001006d6574610000000014000280080002000000001008000100000000012c00018008000100636d700020000280080001000000000108000200000000000c0003800500010011000000340001800c0001007061796c6f6164002400028008000100000000010800020000000002080003000000000208000400000000022c00018008000100636d700020000280080001000000000108000200000000050c0003800600010003e800002c00018008000100636d700020000280080001000000000108000200000000030c0003800600010007d00000400001800e000100696d6d6564696174650000002c0002800800010000000000200002801c00028008000100fffffffd0e0002007564702d636861696e000000",
+		},
+		{
+			name:    "NAT snat to 198.51.100.1:1024-2048",
+			family:  nftables.TableFamilyIPv4,
+			rule:    Rule{Action: snat},
+			success: true,
+			golden:  "2c0001800e000100696d6d6564696174650000001800028008000100000000010c00028008000100c63364012c0001800e000100696d6d6564696174650000001800028008000100000000020c00028006000100040000002c0001800e000100696d6d6564696174650000001800028008000100000000030c000280060001000800000038000180080001006e6174002c00028008000100000000000800020000000002080003000000000108000500000000020800060000000003",
+		},
+		{
+			name:    "NAT dnat to 2001:db8::1",
+			family:  nftables.TableFamilyIPv6,
+			rule:    Rule{Action: dnat},
+			success: true,
+			golden:  "380001800e000100696d6d656469617465000000240002800800010000000001180002801400010020010db800000000000000000000000128000180080001006e6174001c0002800800010000000001080002000000000a0800030000000001",
+		},
+		{
+			name:   "Netmap ip saddr set 10.0.0.0/24 updates L4 pseudo header checksum",
+			family: nftables.TableFamilyIPv4,
+			rule: Rule{
+				L3:     &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.168.1.0/24")}}},
+				Action: netmap,
+			},
+			success: true,
+			// payload write sreg 1 base network offset 12 len 4 csum_type inet csum_offset 10 csum_flags pseudohdr
+			contains: "08000500000000010800020000000001080003000000000c08000400000000040800060000000001080007000000000a0800080000000001",
+		},
+		{
+			name:    "Payload ip6 saddr set 2001:db8::1 is rejected, L4 checksum flags are not carried",
+			family:  nftables.TableFamilyIPv6,
+			rule:    Rule{Action: saddr6},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		b, err := tt.rule.MarshalExprs(tt.family)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if !tt.success {
+			continue
+		}
+		got := hex.EncodeToString(b)
+		if tt.golden != "" && got != tt.golden {
+			t.Errorf("Test \"%s\" failed, got:\n%s\nwant:\n%s", tt.name, got, tt.golden)
+		}
+		if tt.contains != "" && !strings.Contains(got, tt.contains) {
+			t.Errorf("Test \"%s\" failed, got:\n%s\ndoes not contain:\n%s", tt.name, got, tt.contains)
+		}
+	}
+}
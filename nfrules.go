@@ -1,9 +1,14 @@
 package nftableslib
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,6 +28,10 @@ const (
 	NFT_ACCEPT = 0x1
 )
 
+// ErrInvalidVerdict is returned by SetVerdict when the verdict key is not supported or the chain name
+// does not match the verdict, errors.Is can be used to distinguish it from other errors.
+var ErrInvalidVerdict = errors.New("invalid verdict")
+
 type ruleOperation uint32
 
 const (
@@ -40,8 +49,10 @@ type RulesInterface interface {
 type RuleFuncs interface {
 	Create(*Rule) (uint32, error)
 	CreateImm(*Rule) (uint64, error)
+	CreateWithTTL(*Rule, time.Duration) (func(), error)
 	Delete(uint32) error
 	DeleteImm(uint64) error
+	DeleteRulesWhere(func(*Rule) bool) (int, error)
 	Insert(*Rule) (uint32, error)
 	InsertImm(*Rule) (uint64, error)
 	Update(*Rule, uint64) error
@@ -50,12 +61,27 @@ type RuleFuncs interface {
 	UpdateRulesHandle() error
 	GetRuleHandle(id uint32) (uint64, error)
 	GetRulesUserData() (map[uint64][]byte, error)
+	GetRules() ([]*Rule, error)
+	RuleExists(*Rule) (bool, error)
+	InsertAfterTag(string, *Rule) (uint32, error)
+	UpsertRule(string, *Rule) error
+	AddPerSourceConnLimit(uint32, *RuleAction) error
+	AddLoadBalancer(*IPAddr, uint16, []Backend) error
+	AddAntiSpoof([]*IPAddr) error
+	AllowIPv6NDP() error
+	AddRateLimitedLog(string, LimitAttributes) error
+	AddConnmarkRestore(*MetaMark, *RuleAction) error
+	AddJumpChain(string) error
+	SetRuleEnabled(uint64, bool) error
 }
 
 type nfRules struct {
 	conn  NetNS
+	opts  Options
 	table *nftables.Table
 	chain *nftables.Chain
+	// chains is the store of the table's chains, it is nil for rules of a chain created outside of the store
+	chains *nfChains
 	sync.Mutex
 	currentID uint32
 	rules     *nfRule
@@ -69,7 +95,16 @@ type nfSet struct {
 type nfRule struct {
 	id   uint32
 	rule *nftables.Rule
+	// spec is the rule the nfRule was built from, it is nil for rules synced from the kernel
+	spec *Rule
 	sets []*nfSet
+	// disabled keeps expressions of the rule disabled by SetRuleEnabled, it is nil for enabled rules
+	disabled []expr.Any
+	// overLimit is the rule dropping packets which exceed the limit of the rule's rate limited reject action,
+	// it is programmed right after the rule and deleted with it.
+	overLimit *nfRule
+	// limited is the rule which rate limited reject action the rule complements, it is set on overLimit rules
+	limited *nfRule
 	sync.Mutex
 	next *nfRule
 	prev *nfRule
@@ -85,6 +120,9 @@ func (nfr *nfRules) Rules() RuleFuncs {
 }
 
 func (nfr *nfRules) buildRule(rule *Rule) (*nfRule, error) {
+	if err := rule.Validate(nfr.table.Family, getChainAttributes(nfr.chain)); err != nil {
+		return nil, err
+	}
 	r := &nftables.Rule{}
 	var err error
 	var sets []*nfSet
@@ -120,6 +158,9 @@ func (nfr *nfRules) buildRule(rule *Rule) (*nfRule, error) {
 		if e, set, err = createL3(nfr.table.Family, rule); err != nil {
 			return nil, err
 		}
+		if nfr.opts.RequireNamedSets && len(set) != 0 {
+			return nil, fmt.Errorf("automatic set creation is disabled, multi-element address list must reference a named set")
+		}
 		sets = append(sets, set...)
 		r.Exprs = append(r.Exprs, e...)
 	}
@@ -128,10 +169,90 @@ func (nfr *nfRules) buildRule(rule *Rule) (*nfRule, error) {
 		if e, set, err = createL4(nfr.table.Family, rule); err != nil {
 			return nil, err
 		}
+		if nfr.opts.RequireNamedSets && len(set) != 0 {
+			return nil, fmt.Errorf("automatic set creation is disabled, multi-element port or icmp type list must reference a named set")
+		}
 		sets = append(sets, set...)
 		r.Exprs = append(r.Exprs, e...)
 	}
 
+	if rule.Payload != nil {
+		if e, err = getExprForPayload(rule.Payload); err != nil {
+			return nil, err
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
+	if rule.FlowLabel != nil {
+		if e, err = getExprForFlowLabel(nfr.table.Family, rule.FlowLabel); err != nil {
+			return nil, err
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
+	if rule.ECN != nil {
+		if e, err = getExprForECN(nfr.table.Family, rule.ECN); err != nil {
+			return nil, err
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
+	if rule.DSCP != nil {
+		var set *nfSet
+		if e, set, err = getExprForDSCP(nfr.table.Family, rule.DSCP); err != nil {
+			return nil, err
+		}
+		if set != nil {
+			if nfr.opts.RequireNamedSets {
+				return nil, fmt.Errorf("automatic set creation is disabled, multi-element dscp list cannot be matched")
+			}
+			sets = append(sets, set)
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
+	if rule.IPID != nil {
+		if e, err = getExprForIPID(nfr.table.Family, rule.IPID); err != nil {
+			return nil, err
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
+	if rule.TCPOption != nil {
+		if e, err = getExprForTCPOption(rule.TCPOption); err != nil {
+			return nil, err
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
+	if rule.VXLAN != nil {
+		if e, err = getExprForVXLAN(rule.VXLAN); err != nil {
+			return nil, err
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
+	if rule.GRE != nil {
+		if e, err = getExprForGRE(rule.GRE); err != nil {
+			return nil, err
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
+	if rule.ARP != nil {
+		if e, err = getExprForARP(nfr.table.Family, rule.ARP); err != nil {
+			return nil, err
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
+	if rule.Probability != nil {
+		if e, err = getExprForProbability(rule.Probability); err != nil {
+			return nil, err
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
 	// If L3Rule or L4Rule did not produce a rule, initialize one to carry
 	// Rule's Action expression
 	if len(r.Exprs) == 0 {
@@ -139,12 +260,13 @@ func (nfr *nfRules) buildRule(rule *Rule) (*nfRule, error) {
 	}
 	// Check if Meta is specified appending to rule's list of expressions
 	if rule.Meta != nil {
-		switch {
-		case rule.Meta.Mark != nil:
-			r.Exprs = append(r.Exprs, getExprForMetaMark(rule.Meta.Mark)...)
-		case len(rule.Meta.Expr) != 0:
-			r.Exprs = append(r.Exprs, getExprForMetaExpr(rule.Meta.Expr)...)
+		if e, err = getExprForMeta(nfr.table.Family, rule.Meta); err != nil {
+			return nil, err
 		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+	if rule.Limit != nil {
+		r.Exprs = append(r.Exprs, getExprForLimit(rule.Limit)...)
 	}
 	// Check if Meta is specified appending to rule's list of expressions
 	if rule.Log != nil {
@@ -155,10 +277,20 @@ func (nfr *nfRules) buildRule(rule *Rule) (*nfRule, error) {
 		r.Exprs = append(r.Exprs, getExprForConntracks(rule.Conntracks)...)
 	}
 
+	if rule.ObjRef != nil {
+		if e, err = getExprForObjRef(rule.ObjRef); err != nil {
+			return nil, err
+		}
+		r.Exprs = append(r.Exprs, e...)
+	}
+
 	if rule.Action != nil && !skipAction {
 		switch {
 		case rule.Action.redirect != nil:
 			if rule.Action.redirect.tproxy {
+				if err := nfr.opts.caps.check("tproxy", func(c Capabilities) bool { return c.TProxy }); err != nil {
+					return nil, err
+				}
 				r.Exprs = append(r.Exprs, getExprForTProxyRedirect(rule.Action.redirect.port, nfr.table.Family)...)
 			} else {
 				r.Exprs = append(r.Exprs, getExprForRedirect(rule.Action.redirect.port, nfr.table.Family)...)
@@ -182,6 +314,48 @@ func (nfr *nfRules) buildRule(rule *Rule) (*nfRule, error) {
 				return nil, err
 			}
 			r.Exprs = append(r.Exprs, e...)
+		case rule.Action.metaFromCt != nil:
+			r.Exprs = append(r.Exprs, getExprForMetaFromCt(rule.Action.metaFromCt)...)
+		case rule.Action.ctZone != nil:
+			r.Exprs = append(r.Exprs, getExprForCtZone(*rule.Action.ctZone)...)
+		case rule.Action.ctLabel != nil:
+			r.Exprs = append(r.Exprs, getExprForCtLabel(*rule.Action.ctLabel)...)
+		case rule.Action.ctTimeout != nil:
+			r.Exprs = append(r.Exprs, getExprForCtTimeout(*rule.Action.ctTimeout)...)
+		case rule.Action.secmark != nil:
+			r.Exprs = append(r.Exprs, getExprForSetSecmark(*rule.Action.secmark)...)
+		case rule.Action.priority != nil:
+			r.Exprs = append(r.Exprs, getExprForSetMetaPriority(*rule.Action.priority)...)
+		case rule.Action.trace != nil:
+			r.Exprs = append(r.Exprs, getExprForSetTrace(*rule.Action.trace)...)
+		case rule.Action.payloadWrite != nil:
+			r.Exprs = append(r.Exprs, getExprForPayloadWrite(nfr.table.Family, rule.Action.payloadWrite)...)
+		case rule.Action.ecn != nil:
+			e, err = getExprForSetECN(nfr.table.Family, *rule.Action.ecn)
+			if err != nil {
+				return nil, err
+			}
+			r.Exprs = append(r.Exprs, e...)
+		case rule.Action.ipid != nil:
+			e, err = getExprForSetIPID(nfr.table.Family, *rule.Action.ipid)
+			if err != nil {
+				return nil, err
+			}
+			r.Exprs = append(r.Exprs, e...)
+		case rule.Action.netmap != nil:
+			r.Exprs = append(r.Exprs, getExprForNetmap(rule.Action.netmap)...)
+		case rule.Action.consistentHash != nil:
+			r.Exprs = append(r.Exprs, getExprForConsistentHash(rule.Action.consistentHash)...)
+		case rule.Action.rateLimitedReject != nil:
+			r.Exprs = append(r.Exprs, getExprForRateLimitedReject(nfr.table.Family, rule.Action.rateLimitedReject)...)
+		case rule.Action.mssClamp != nil:
+			r.Exprs = append(r.Exprs, getExprForMSSClamp(rule.Action.mssClamp)...)
+		case rule.Action.vmap != nil:
+			e, err = getExprForVMap(nfr, nfr.table.Family, rule.Action.vmap)
+			if err != nil {
+				return nil, err
+			}
+			r.Exprs = append(r.Exprs, e...)
 		}
 	}
 	if rule.Concat != nil {
@@ -192,6 +366,9 @@ func (nfr *nfRules) buildRule(rule *Rule) (*nfRule, error) {
 		r.Exprs = append(r.Exprs, e...)
 	}
 	if rule.Dynamic != nil {
+		if err := nfr.validateDynamicSet(rule.Dynamic.SetRef); err != nil {
+			return nil, err
+		}
 		e, err = getExprForDynamic(nfr.table.Family, rule.Dynamic)
 		if err != nil {
 			return nil, err
@@ -205,6 +382,11 @@ func (nfr *nfRules) buildRule(rule *Rule) (*nfRule, error) {
 		}
 		r.Exprs = append(r.Exprs, e...)
 	}
+	// Rule without Action continues to the next rule, but it must carry at least
+	// one match or statement.
+	if len(r.Exprs) == 0 {
+		return nil, fmt.Errorf("rule must have at least one match, statement or action")
+	}
 	r.Table = nfr.table
 	r.Chain = nfr.chain
 
@@ -212,16 +394,56 @@ func (nfr *nfRules) buildRule(rule *Rule) (*nfRule, error) {
 	rr.rule = r
 	for _, s := range sets {
 		s.set.Table = nfr.table
+		if !s.set.Anonymous {
+			s.set.Comment = generatedSetComment
+		}
 		if err := nfr.conn.AddSet(s.set, s.elements); err != nil {
 			return nil, err
 		}
 		//		s.set.DataLen = len(s.elements)
 		rr.sets = append(rr.sets, s)
 	}
+	if spec := overLimitRule(rule); spec != nil {
+		over, err := nfr.buildRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		over.spec = spec
+		rr.overLimit = over
+	}
 
 	return rr, nil
 }
 
+// validateDynamicSet checks that the set referenced by a dynset action can be updated from the packet path,
+// the set must be created with Dynamic attribute, otherwise the kernel would reject the rule. Sets of the library's
+// store are checked against their attributes, other sets are read from the kernel, a set which is not found
+// might be queued in the same batch, its validation is left to the kernel.
+func (nfr *nfRules) validateDynamicSet(ref *SetRef) error {
+	if ref == nil {
+		return fmt.Errorf("reference to set or map cannot be nil")
+	}
+	var set *nftables.Set
+	if nfr.chains != nil && nfr.chains.sets != nil {
+		set = nfr.chains.sets.storedSet(ref.Name)
+	}
+	if set == nil {
+		var err error
+		set, err = nfr.conn.GetSetByName(nfr.table, ref.Name)
+		if err != nil && !errors.Is(err, unix.ENOENT) {
+			return fmt.Errorf("failed to get set %s updated by dynset with error: %w", ref.Name, err)
+		}
+		if set == nil {
+			return nil
+		}
+	}
+	if !set.Dynamic {
+		return fmt.Errorf("set %s cannot be updated by dynset, it must be created with Dynamic attribute", ref.Name)
+	}
+
+	return nil
+}
+
 func (nfr *nfRules) Create(rule *Rule) (uint32, error) {
 	nfr.Lock()
 	defer nfr.Unlock()
@@ -235,23 +457,28 @@ func (nfr *nfRules) create(rule *Rule, ruleOp ruleOperation) (uint32, error) {
 	if err != nil {
 		return 0, err
 	}
+
+	return nfr.program(rr, rule, ruleOp), nil
+}
+
+// program adds built rule to the store and pushes it to netlink library, rule is the rule rr was built from.
+func (nfr *nfRules) program(rr *nfRule, rule *Rule, ruleOp ruleOperation) uint32 {
+	if rr.overLimit != nil {
+		return nfr.programOverLimit(rr, rule, ruleOp)
+	}
+
+	return nfr.programRule(rr, rule, ruleOp)
+}
+
+func (nfr *nfRules) programRule(rr *nfRule, rule *Rule, ruleOp ruleOperation) uint32 {
+	rr.spec = rule.Clone()
 	// Adding nfRule to the list
 	nfr.addRule(rr)
 	if rule.Position != 0 {
 		// Used by Insert call
 		rr.rule.Position = uint64(rule.Position)
 	}
-	ul := len(rule.UserData)
-	// Extra 4 bytes to keep rule ID in userdata during the rule programming interactions.
-	rr.rule.UserData = make([]byte, ul+4)
-
-	if rule.UserData != nil {
-		copy(rr.rule.UserData, rule.UserData)
-	}
-	// Adding rule ID into the last 4 bytes
-	rr.rule.UserData[ul] = 0x2
-	rr.rule.UserData[ul+1] = 2
-	copy(rr.rule.UserData[ul+2:], binaryutil.BigEndian.PutUint16(uint16(rr.id)))
+	rr.rule.UserData = withRuleID(rule.UserData, rr.id)
 	// Pushing rule to netlink library to be programmed by Flush()
 	switch ruleOp {
 	case operationAdd:
@@ -260,12 +487,18 @@ func (nfr *nfRules) create(rule *Rule, ruleOp ruleOperation) (uint32, error) {
 		nfr.conn.InsertRule(rr.rule)
 	}
 
-	return rr.id, nil
+	return rr.id
 }
 
 func (nfr *nfRules) CreateImm(rule *Rule) (uint64, error) {
 	nfr.Lock()
 	defer nfr.Unlock()
+
+	return nfr.createImm(rule)
+}
+
+// createImm programs the rule together with messages already queued on the connection and stores the rule's handle
+func (nfr *nfRules) createImm(rule *Rule) (uint64, error) {
 	id, err := nfr.create(rule, operationAdd)
 	if err != nil {
 		return 0, err
@@ -279,7 +512,10 @@ func (nfr *nfRules) CreateImm(rule *Rule) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
-	if err := nfr.UpdateRuleHandleByID(id, handle); err != nil {
+	if err := nfr.updateRuleHandleByID(id, handle); err != nil {
+		return 0, err
+	}
+	if err := nfr.updateOverLimitHandle(id); err != nil {
 		return 0, err
 	}
 
@@ -298,8 +534,17 @@ func (nfr *nfRules) delete(id uint32) error {
 			return err
 		}
 	}
+	if err := nfr.removeRule(r.id); err != nil {
+		return err
+	}
+	// The rule dropping packets over the limit of rate limited reject action is deleted with the rule
+	if over := r.overLimit; over != nil {
+		if _, err := getRuleByID(nfr.rules, over.id); err == nil {
+			return nfr.delete(over.id)
+		}
+	}
 
-	return nfr.removeRule(r.id)
+	return nil
 }
 
 func (nfr *nfRules) Delete(id uint32) error {
@@ -308,6 +553,128 @@ func (nfr *nfRules) Delete(id uint32) error {
 	return nfr.delete(id)
 }
 
+// DeleteRulesWhere deletes all rules of the chain for which pred returns true in a single batch and returns
+// the number of deleted rules. Rules created by the library are passed to pred as they were specified,
+// rules programmed by other means carry only UserData, for example to match the rule's comment.
+func (nfr *nfRules) DeleteRulesWhere(pred func(*Rule) bool) (int, error) {
+	if pred == nil {
+		return 0, fmt.Errorf("predicate cannot be nil")
+	}
+	nfr.Lock()
+	defer nfr.Unlock()
+	rules, err := nfr.conn.GetRule(nfr.table, nfr.chain)
+	if err != nil {
+		return 0, err
+	}
+	deleted := make([]*nfRule, 0)
+	// Rules dropping packets over the limit are not passed to pred, they are deleted with the rule they follow
+	deletedLimited := make(map[*nfRule]bool)
+	n := 0
+	for _, rule := range rules {
+		stored := nfr.storedRule(rule)
+		over := stored != nil && stored.limited != nil
+		if over && !deletedLimited[stored.limited] {
+			continue
+		}
+		if !over && !pred(ruleSpec(stored, rule)) {
+			continue
+		}
+		if err := nfr.conn.DelRule(&nftables.Rule{
+			Table:  nfr.table,
+			Chain:  nfr.chain,
+			Handle: rule.Handle,
+		}); err != nil {
+			return 0, err
+		}
+		if stored != nil {
+			deleted = append(deleted, stored)
+			deletedLimited[stored] = true
+		}
+		if !over {
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := nfr.conn.Flush(); err != nil {
+		return 0, err
+	}
+	for _, r := range deleted {
+		nfr.removeRule(r.id)
+	}
+
+	return n, nil
+}
+
+// storedRule returns the rule of the store programmed as the rule read from the host, nil is returned
+// for rules programmed by other means.
+func (nfr *nfRules) storedRule(rule *nftables.Rule) *nfRule {
+	if stored, err := getRuleByHandle(nfr.rules, rule.Handle); err == nil {
+		return stored
+	}
+	// Handle of the rule might not be updated yet, looking up by the rule ID
+	if id, ok := getRuleID(rule.UserData); ok {
+		if stored, err := getRuleByID(nfr.rules, id); err == nil {
+			return stored
+		}
+	}
+
+	return nil
+}
+
+// ruleSpec returns the rule read from the host in the library's model, rules created by the library are returned
+// as they were specified, rules programmed by other means carry only UserData. Stats and Handle are read from
+// the host rule.
+func ruleSpec(stored *nfRule, rule *nftables.Rule) *Rule {
+	spec := &Rule{UserData: stripRuleID(rule.UserData)}
+	if stored != nil && stored.spec != nil {
+		spec = stored.spec.Clone()
+	}
+	spec.Stats = getRuleStats(rule)
+	spec.Handle = rule.Handle
+
+	return spec
+}
+
+// hostRules returns rules read from the host in the library's model, rules dropping packets over the limit
+// of a rate limited reject action are part of the rule they follow and are not returned.
+func (nfr *nfRules) hostRules(rules []*nftables.Rule) []*Rule {
+	specs := make([]*Rule, 0, len(rules))
+	for _, rule := range rules {
+		stored := nfr.storedRule(rule)
+		if stored != nil && stored.limited != nil {
+			continue
+		}
+		specs = append(specs, ruleSpec(stored, rule))
+	}
+
+	return specs
+}
+
+// withRuleID returns a copy of user data with rule ID appended in the last 4 bytes, the library keeps rule ID
+// in user data during the rule programming interactions.
+func withRuleID(ud []byte, id uint32) []byte {
+	ul := len(ud)
+	b := make([]byte, ul+4)
+	copy(b, ud)
+	b[ul] = 0x2
+	b[ul+1] = 2
+	copy(b[ul+2:], binaryutil.BigEndian.PutUint16(uint16(id)))
+
+	return b
+}
+
+// getRuleID returns rule ID carried in the last 4 bytes of rule's user data
+func getRuleID(ud []byte) (uint32, bool) {
+	l := len(ud)
+	if l < 4 || ud[l-4] != 0x2 || ud[l-3] != 2 {
+		return 0, false
+	}
+
+	return uint32(binaryutil.BigEndian.Uint16(ud[l-2:])), true
+}
+
 func (nfr *nfRules) DeleteImm(rh uint64) error {
 	nfr.Lock()
 	defer nfr.Unlock()
@@ -330,11 +697,102 @@ func (nfr *nfRules) DeleteImm(rh uint64) error {
 // the value of position passed in Rule.Position.
 // Example: rule1 has handle of 5, you want to insert rule2 before rule1, then position for rule2 will be 5
 func (nfr *nfRules) Insert(rule *Rule) (uint32, error) {
+	nfr.Lock()
+	defer nfr.Unlock()
+
 	return nfr.create(rule, operationInsert)
 }
 
+// InsertAfterTag adds a rule passed as a parameter right after the programmed rule which comment,
+// built with MakeRuleComment and carried in rule's UserData, matches tag.
+func (nfr *nfRules) InsertAfterTag(tag string, rule *Rule) (uint32, error) {
+	nfr.Lock()
+	defer nfr.Unlock()
+	rules, err := nfr.conn.GetRule(nfr.table, nfr.chain)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range rules {
+		if getRuleComment(r.UserData) != tag {
+			continue
+		}
+		nr := *rule
+		// AddRule with position != 0, adds a rule right after the rule with specified position
+		nr.Position = int(r.Handle)
+		return nfr.create(&nr, operationAdd)
+	}
+
+	return 0, fmt.Errorf("rule with tag %s is not found", tag)
+}
+
+// UpsertRule makes sure the rule identified by tag is programmed as desired, the programmed rule which comment,
+// built with MakeRuleComment and carried in rule's UserData, matches tag is replaced in place preserving its
+// position, if no rule carries the tag, the rule is added to the end of the chain. If several rules carry
+// the tag, the first one is replaced. The comment with the tag is prepended to the rule's UserData unless
+// the rule already carries it.
+func (nfr *nfRules) UpsertRule(tag string, rule *Rule) error {
+	if tag == "" {
+		return fmt.Errorf("tag cannot be empty")
+	}
+	nr := *rule
+	nr.Position = 0
+	switch getRuleComment(rule.UserData) {
+	case tag:
+	case "":
+		nr.UserData = append(MakeRuleComment(tag), rule.UserData...)
+	default:
+		return fmt.Errorf("rule carries a comment different from tag %s", tag)
+	}
+	nfr.Lock()
+	defer nfr.Unlock()
+	rules, err := nfr.conn.GetRule(nfr.table, nfr.chain)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		if getRuleComment(r.UserData) != tag {
+			continue
+		}
+		if stored := nfr.getStoredRule(r); stored != nil {
+			stored.rule.Handle = r.Handle
+			return nfr.update(&nr, r.Handle)
+		}
+		// The rule is not in the store, for example it was programmed by another instance of the library
+		rr, err := nfr.buildRule(&nr)
+		if err != nil {
+			return err
+		}
+		// AddRule with non zero handle replaces the rule with the handle
+		rr.rule.Handle = r.Handle
+		nfr.program(rr, &nr, operationAdd)
+		return nfr.conn.Flush()
+	}
+	if _, err := nfr.create(&nr, operationAdd); err != nil {
+		return err
+	}
+
+	return nfr.conn.Flush()
+}
+
+// getStoredRule returns the store's rule for the rule programmed on the host, nil is returned
+// if the store does not carry it.
+func (nfr *nfRules) getStoredRule(r *nftables.Rule) *nfRule {
+	if id, ok := getRuleID(r.UserData); ok {
+		if stored, err := getRuleByID(nfr.rules, id); err == nil && (stored.rule.Handle == 0 || stored.rule.Handle == r.Handle) {
+			return stored
+		}
+	}
+	if stored, err := getRuleByHandle(nfr.rules, r.Handle); err == nil {
+		return stored
+	}
+
+	return nil
+}
+
 func (nfr *nfRules) InsertImm(rule *Rule) (uint64, error) {
-	id, err := nfr.Insert(rule)
+	nfr.Lock()
+	defer nfr.Unlock()
+	id, err := nfr.create(rule, operationInsert)
 	if err != nil {
 		return 0, err
 	}
@@ -347,7 +805,10 @@ func (nfr *nfRules) InsertImm(rule *Rule) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
-	if err := nfr.UpdateRuleHandleByID(id, handle); err != nil {
+	if err := nfr.updateRuleHandleByID(id, handle); err != nil {
+		return 0, err
+	}
+	if err := nfr.updateOverLimitHandle(id); err != nil {
 		return 0, err
 	}
 
@@ -355,10 +816,20 @@ func (nfr *nfRules) InsertImm(rule *Rule) (uint64, error) {
 }
 
 func (nfr *nfRules) Update(rule *Rule, handle uint64) error {
+	nfr.Lock()
+	defer nfr.Unlock()
+
+	return nfr.update(rule, handle)
+}
+
+func (nfr *nfRules) update(rule *Rule, handle uint64) error {
 	nfrule, err := getRuleByHandle(nfr.rules, handle)
 	if err != nil {
 		return err
 	}
+	if nfrule.limited != nil {
+		return fmt.Errorf("rule with handle %d drops packets over the limit of rate limited reject, the rule it follows must be updated", handle)
+	}
 	r, err := nfr.buildRule(rule)
 	if err != nil {
 		return err
@@ -378,9 +849,14 @@ func (nfr *nfRules) Update(rule *Rule, handle uint64) error {
 	// Updating rule expressions and sets but preserving pointers to prev and next
 	nfrule.rule = r.rule
 	nfrule.sets = r.sets
+	nfrule.spec = rule.Clone()
+	nfrule.disabled = nil
 
 	// Pushing rule to netlink library to be programmed by Flush()
 	nfr.conn.AddRule(nfrule.rule)
+	if err := nfr.updateOverLimit(nfrule, r.overLimit); err != nil {
+		return err
+	}
 	// Programming Update rule
 	if err := nfr.conn.Flush(); err != nil {
 		return err
@@ -395,6 +871,10 @@ func (nfr *nfRules) Dump() ([]byte, error) {
 	var data []byte
 
 	for _, r := range nfr.dumpRules() {
+		if r.limited != nil {
+			// Rule dropping packets over the limit is programmed with the rule it follows
+			continue
+		}
 		b, err := json.Marshal(&r)
 		if err != nil {
 			return nil, err
@@ -405,11 +885,20 @@ func (nfr *nfRules) Dump() ([]byte, error) {
 	return data, nil
 }
 
+// Sync refreshes the store with rules programmed on the host in the order of the chain. Rules already in the store
+// keep their identity, their IDs and the rules they were built from, and get handles allocated by the kernel. A rule
+// is recognized by its handle, by the rule ID the library carries in the rule's user data or, for rules which
+// handle is not known yet, by its expressions and user data. Rules of the store which are not programmed on
+// the host are removed from the store.
 func (nfr *nfRules) Sync() error {
+	nfr.Lock()
+	defer nfr.Unlock()
 	rules, err := nfr.conn.GetRule(nfr.table, nfr.chain)
 	if err != nil {
 		return err
 	}
+	synced := make([]*nfRule, 0, len(rules))
+	matched := make(map[*nfRule]bool)
 	for _, rule := range rules {
 		sets := make([]*nfSet, 0)
 		for _, e := range rule.Exprs {
@@ -429,12 +918,70 @@ func (nfr *nfRules) Sync() error {
 			sets = append(sets, &nfSet{set: set, elements: elements})
 
 		}
-		rr := &nfRule{}
+		rr := nfr.matchStoredRule(rule, matched)
+		if rr == nil {
+			rr = &nfRule{id: nfr.currentID}
+			nfr.currentID += ruleIDIncrement
+		}
+		matched[rr] = true
 		rr.rule = rule
+		rr.sets = nil
 		if len(sets) != 0 {
 			rr.sets = sets
 		}
-		nfr.addRule(rr)
+		synced = append(synced, rr)
+	}
+	// Rebuilding the list in the order of the chain
+	nfr.rules = nil
+	for i, rr := range synced {
+		rr.prev, rr.next = nil, nil
+		// Rules removed from the host do not link rules dropping packets over the limit any more
+		if rr.overLimit != nil && !matched[rr.overLimit] {
+			rr.overLimit = nil
+		}
+		if rr.limited != nil && !matched[rr.limited] {
+			rr.limited = nil
+		}
+		if i == 0 {
+			nfr.rules = rr
+			continue
+		}
+		rr.prev = synced[i-1]
+		synced[i-1].next = rr
+	}
+
+	return nil
+}
+
+// matchStoredRule returns the store's rule matching the rule programmed on the host, rules already matched
+// are skipped. Nil is returned if the store does not carry the rule.
+func (nfr *nfRules) matchStoredRule(rule *nftables.Rule, matched map[*nfRule]bool) *nfRule {
+	for e := nfr.rules; e != nil; e = e.next {
+		if !matched[e] && e.rule.Handle != 0 && e.rule.Handle == rule.Handle {
+			return e
+		}
+	}
+	id, ok := getRuleID(rule.UserData)
+	for e := nfr.rules; e != nil; e = e.next {
+		if matched[e] || e.rule.Handle != 0 {
+			continue
+		}
+		if ok && e.id == id {
+			return e
+		}
+	}
+	for e := nfr.rules; e != nil; e = e.next {
+		if matched[e] || e.rule.Handle != 0 {
+			continue
+		}
+		generated := make(map[string]bool)
+		for _, s := range e.sets {
+			generated[s.set.Name] = true
+		}
+		if isEqualExprs(e.rule.Exprs, rule.Exprs, generated) &&
+			bytes.Equal(stripRuleID(e.rule.UserData), stripRuleID(rule.UserData)) {
+			return e
+		}
 	}
 
 	return nil
@@ -467,6 +1014,8 @@ func (nfr *nfRules) getSetElements(set *nftables.Set) ([]nftables.SetElement, er
 // UpdateRulesHandle populates rule's handle information with handle value allocated by the kernel.
 // Handle information can be used for further rule's management.
 func (nfr *nfRules) UpdateRulesHandle() error {
+	nfr.Lock()
+	defer nfr.Unlock()
 	r := nfr.rules
 	for ; r != nil; r = r.next {
 		handle, err := nfr.GetRuleHandle(r.id)
@@ -480,11 +1029,16 @@ func (nfr *nfRules) UpdateRulesHandle() error {
 }
 
 func (nfr *nfRules) UpdateRuleHandleByID(id uint32, handle uint64) error {
+	nfr.Lock()
+	defer nfr.Unlock()
+
+	return nfr.updateRuleHandleByID(id, handle)
+}
+
+func (nfr *nfRules) updateRuleHandleByID(id uint32, handle uint64) error {
 	r := nfr.rules
 	for ; r != nil; r = r.next {
 		if r.id == id {
-			nfr.rules.Lock()
-			defer nfr.rules.Unlock()
 			r.rule.Handle = handle
 			return nil
 		}
@@ -538,20 +1092,67 @@ func (nfr *nfRules) GetRulesUserData() (map[uint64][]byte, error) {
 	return ud, nil
 }
 
-func newRules(conn NetNS, t *nftables.Table, c *nftables.Chain) RulesInterface {
-	return &nfRules{
-		conn:      conn,
-		table:     t,
-		chain:     c,
-		currentID: 10,
-		rules:     nil,
+// RuleStats carries the state of rule's stateful statements read from the host, Counter is nil if the rule
+// does not have a counter, Quota is nil if the rule does not have a quota and Limit is nil if the rule does
+// not have a limit. The kernel does not expose the tokens left of a limit, Limit carries its rate and burst.
+type RuleStats struct {
+	Counter *expr.Counter
+	Quota   *expr.Quota
+	Limit   *expr.Limit
+}
+
+// getRuleStats returns the state of stateful statements of the rule, nil is returned if the rule has none
+func getRuleStats(rule *nftables.Rule) *RuleStats {
+	s := &RuleStats{}
+	for _, e := range rule.Exprs {
+		switch v := e.(type) {
+		case *expr.Counter:
+			s.Counter = v
+		case *expr.Quota:
+			s.Quota = v
+		case *expr.Limit:
+			s.Limit = v
+		}
+	}
+	if s.Counter == nil && s.Quota == nil && s.Limit == nil {
+		return nil
 	}
+
+	return s
 }
 
-// IPAddr defines a type of ip address, if it is host address with mask of 32 for ipv4 and mask of 128 for ipv6
-// then CIDR should be false, if it is a network address, then CIDR should be true and Mask set to a number of bits
-// in the address' mask. Mask value is from 0 to 32 for ipv4 and from 0 to 128 for ipv6 addresses.
-type IPAddr struct {
+// GetRules returns rules of the chain programmed on the host read by a single request, rules created by
+// the library are returned as they were specified, rules programmed by other means carry only UserData.
+// Stats of the returned rules carry the current values of their counter and quota and their limit, Handle
+// carries the handle of the rule on the host.
+func (nfr *nfRules) GetRules() ([]*Rule, error) {
+	rules, err := nfr.conn.GetRule(nfr.table, nfr.chain)
+	if err != nil {
+		return nil, err
+	}
+	nfr.Lock()
+	defer nfr.Unlock()
+
+	return nfr.hostRules(rules), nil
+}
+
+func newRules(conn NetNS, t *nftables.Table, c *nftables.Chain, opts Options) RulesInterface {
+	return &nfRules{
+		conn:      conn,
+		opts:      opts,
+		table:     t,
+		chain:     c,
+		currentID: 10,
+		rules:     nil,
+	}
+}
+
+// IPAddr defines a type of ip address, if it is host address with mask of 32 for ipv4 and mask of 128 for ipv6
+// then CIDR should be false, if it is a network address, then CIDR should be true and Mask set to a number of bits
+// in the address' mask. Mask value is from 0 to 32 for ipv4 and from 0 to 128 for ipv6 addresses.
+// Zone of IPv6 link-local address identifies the interface, the address match is combined with a match of
+// the input interface for a source address and the output interface for a destination address.
+type IPAddr struct {
 	*net.IPAddr
 	CIDR bool
 	Mask *uint8
@@ -574,6 +1175,14 @@ func (ip *IPAddr) Validate() error {
 	if ip.CIDR && ip.Mask == nil {
 		return fmt.Errorf("mask length must be specified when CIDR is true")
 	}
+	if ip.IPAddr != nil && ip.Zone != "" {
+		if !ip.IP.IsLinkLocalUnicast() && !ip.IP.IsLinkLocalMulticast() || !ip.IsIPv6() {
+			return fmt.Errorf("zone %s can only be specified for ipv6 link-local address", ip.Zone)
+		}
+		if len(ip.Zone) >= unix.IFNAMSIZ {
+			return fmt.Errorf("zone %s exceeds maximum length of %d", ip.Zone, unix.IFNAMSIZ-1)
+		}
+	}
 
 	return nil
 }
@@ -582,11 +1191,33 @@ func (ip *IPAddr) Validate() error {
 type Operator byte
 
 // List of supported relational operations, starts with 0. if not specified, default 0 inidcates eq operator
+// GT, GTE, LT and LTE are supported only by Conntrack accounting keys.
 const (
 	EQ Operator = iota
 	NEQ
+	GT
+	GTE
+	LT
+	LTE
 )
 
+// cmpOp returns nftables compare operation for Operator
+func cmpOp(op Operator) expr.CmpOp {
+	switch op {
+	case NEQ:
+		return expr.CmpOpNeq
+	case GT:
+		return expr.CmpOpGt
+	case GTE:
+		return expr.CmpOpGte
+	case LT:
+		return expr.CmpOpLt
+	case LTE:
+		return expr.CmpOpLte
+	}
+	return expr.CmpOpEq
+}
+
 // IPAddrSpec lists possible flavours if specifying ip address, either List or Range can be specified
 type IPAddrSpec struct {
 	List   []*IPAddr
@@ -597,8 +1228,36 @@ type IPAddrSpec struct {
 
 // NewIPAddr is a helper function which converts ip address into IPAddr format
 // required by IPAddrSpec. If CIDR format is specified, Mask will be set to address'
-// subnet mask and CIDR will e set to true
+// subnet mask and CIDR will e set to true. IPv6 link-local address can carry a zone,
+// example: fe80::/10%eth0
 func NewIPAddr(addr string) (*IPAddr, error) {
+	var zone string
+	if i := strings.Index(addr, "%"); i != -1 {
+		s := addr
+		// Zone either follows the prefix length or precedes it, fe80::/10%eth0 and fe80::%eth0/10
+		zone = addr[i+1:]
+		addr = addr[:i]
+		if j := strings.Index(zone, "/"); j != -1 {
+			addr += zone[j:]
+			zone = zone[:j]
+		}
+		if zone == "" {
+			return nil, fmt.Errorf("%s has empty zone", s)
+		}
+	}
+	ip, err := newIPAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip.Zone = zone
+	if err := ip.Validate(); err != nil {
+		return nil, err
+	}
+
+	return ip, nil
+}
+
+func newIPAddr(addr string) (*IPAddr, error) {
 	if _, ipnet, err := net.ParseCIDR(addr); err == nil {
 		// Found a valid CIDR address
 		ones, _ := ipnet.Mask.Size()
@@ -635,6 +1294,12 @@ func NewIPAddr(addr string) (*IPAddr, error) {
 
 // Validate checks IPAddrSpec struct
 func (ip *IPAddrSpec) Validate() error {
+	if ip.SetRef != nil {
+		if len(ip.List) != 0 || ip.Range[0] != nil || ip.Range[1] != nil {
+			return fmt.Errorf("either List or Range or SetRef but not the combination of them can be specified")
+		}
+		return nil
+	}
 	if len(ip.List) != 0 && (ip.Range[0] != nil || ip.Range[1] != nil) {
 		return fmt.Errorf("either List or Range but not both can be specified")
 	}
@@ -655,10 +1320,32 @@ func (ip *IPAddrSpec) Validate() error {
 			}
 		}
 	}
+	zone := ip.zone()
+	for _, addr := range append([]*IPAddr{ip.Range[0], ip.Range[1]}, ip.List...) {
+		if addr != nil && addr.IPAddr != nil && addr.Zone != zone {
+			return fmt.Errorf("all addresses must have the same zone")
+		}
+	}
+	if zone != "" && ip.RelOp != EQ {
+		return fmt.Errorf("addresses with zone support only EQ operator")
+	}
 
 	return nil
 }
 
+// zone returns the zone of the first address of the spec, Validate ensures all addresses have the same zone
+func (ip *IPAddrSpec) zone() string {
+	first := ip.Range[0]
+	if len(ip.List) != 0 {
+		first = ip.List[0]
+	}
+	if first == nil || first.IPAddr == nil {
+		return ""
+	}
+
+	return first.Zone
+}
+
 // L3Rule contains parameters for L3 based rule, either Source or Destination can be specified
 type L3Rule struct {
 	Src      *IPAddrSpec
@@ -678,19 +1365,22 @@ func L3Protocol(proto int) *uint32 {
 
 // Validate checks parameters of L3Rule struct
 func (l3 *L3Rule) Validate() error {
-	switch {
-	case l3.Src != nil:
+	if l3.Src == nil && l3.Dst == nil && l3.Version == nil && l3.Protocol == nil {
+		return fmt.Errorf("invalid L3 rule as none of L3 parameters are provided")
+	}
+	// Src and Dst can be combined in a single rule, both must be valid
+	if l3.Src != nil {
 		if err := l3.Src.Validate(); err != nil {
 			return err
 		}
-	case l3.Dst != nil:
+	}
+	if l3.Dst != nil {
 		if err := l3.Dst.Validate(); err != nil {
 			return err
 		}
-	case l3.Version != nil:
-	case l3.Protocol != nil:
-	default:
-		return fmt.Errorf("invalid L3 rule as none of L3 parameters are provided")
+	}
+	if l3.Protocol != nil && l3.RelOp != EQ && l3.RelOp != NEQ {
+		return fmt.Errorf("protocol supports only EQ and NEQ operators")
 	}
 
 	return nil
@@ -759,11 +1449,22 @@ func (p *Port) Validate() error {
 
 // L4Rule contains parameters for L4 based rule
 type L4Rule struct {
-	L4Proto uint8
-	Src     *Port
-	Dst     *Port
-	RelOp   Operator
-	Counter *Counter
+	L4Proto       uint8
+	Src           *Port
+	Dst           *Port
+	RelOp         Operator
+	Counter       *Counter
+	UDPLength     *L4Value
+	TCPDataOffset *L4Value
+	ICMPType      *ICMPTypeSpec
+}
+
+// L4Value defines a comparison of a transport header field against Value. UDPLength is compared
+// in bytes, TCPDataOffset in 32 bit words, example: L4Value{Value: 5, RelOp: GT} matches tcp
+// segments carrying options.
+type L4Value struct {
+	Value uint16
+	RelOp Operator
 }
 
 // Validate checks parameters of L4Rule struct
@@ -781,6 +1482,28 @@ func (l4 *L4Rule) Validate() error {
 			return err
 		}
 	}
+	if l4.UDPLength != nil && l4.L4Proto != unix.IPPROTO_UDP {
+		return fmt.Errorf("udp length requires L4Proto udp")
+	}
+	if l4.TCPDataOffset != nil {
+		if l4.L4Proto != unix.IPPROTO_TCP {
+			return fmt.Errorf("tcp data offset requires L4Proto tcp")
+		}
+		if l4.TCPDataOffset.Value > 0xf {
+			return fmt.Errorf("tcp data offset %d exceeds maximum value of 15", l4.TCPDataOffset.Value)
+		}
+	}
+	if l4.ICMPType != nil {
+		if l4.L4Proto != unix.IPPROTO_ICMP && l4.L4Proto != unix.IPPROTO_ICMPV6 {
+			return fmt.Errorf("icmp type requires L4Proto icmp or icmpv6")
+		}
+		if l4.Src != nil || l4.Dst != nil {
+			return fmt.Errorf("icmp type cannot be combined with ports")
+		}
+		if err := l4.ICMPType.Validate(); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -824,16 +1547,50 @@ type loadbalance struct {
 	mode   int
 }
 
+// metaFromCt defines action which loads conntrack key's value and stores it in meta key
+type metaFromCt struct {
+	metaKey uint32
+	ctKey   uint32
+}
+
 // MetaMark defines Mark keyword of Meta key
 // Mark can be used either to Set or Match a mark.
 // If Set is true, then the Value will be used to mark a packet,
 // and if Set is false, then the Value will be used to match packet's mark against it.
 // Mask can be used to test for or to set only particular bits in mark.
 // If mask is 0, than it is not used at all.
+// Range matches marks within Range[0] and Range[1] inclusive instead of Value, example: meta mark 0x10-0x1f.
+// Range is used only for matching, if both ends are 0, it is not used at all.
+// Restore restores packet's mark from the conntrack mark before matching it, example: meta mark set ct mark meta mark 0x10.
+// Restore is used only for matching, the mark is restored for all packets evaluated by the rule, including not matching ones.
 type MetaMark struct {
-	Set   bool
-	Value uint32
-	Mask  uint32
+	Set     bool
+	Value   uint32
+	Mask    uint32
+	Range   [2]uint32
+	Restore bool
+}
+
+// Validate checks MetaMark parameters
+func (m *MetaMark) Validate() error {
+	if m.Set && m.Restore {
+		return fmt.Errorf("mark cannot be restored from conntrack mark when it is set")
+	}
+	if !m.isRange() {
+		return nil
+	}
+	if m.Set {
+		return fmt.Errorf("mark range cannot be used to set a mark")
+	}
+	if m.Range[0] > m.Range[1] {
+		return fmt.Errorf("start of mark range 0x%x is greater than its end 0x%x", m.Range[0], m.Range[1])
+	}
+
+	return nil
+}
+
+func (m *MetaMark) isRange() bool {
+	return m.Range[0] != 0 || m.Range[1] != 0
 }
 
 // MetaExpr allows specifing Meta expressions by meta key and its value,
@@ -844,20 +1601,250 @@ type MetaExpr struct {
 	RelOp Operator
 }
 
+// Packet types as defined in linux/if_packet.h, used along with MetaPktType
+const (
+	PacketTypeHost      uint8 = unix.PACKET_HOST
+	PacketTypeBroadcast uint8 = unix.PACKET_BROADCAST
+	PacketTypeMulticast uint8 = unix.PACKET_MULTICAST
+	PacketTypeOtherHost uint8 = unix.PACKET_OTHERHOST
+)
+
+// MetaPktType defines PktType keyword of Meta key, Value must be one of PacketType constants.
+// Example: to drop multicast traffic, Value is set to PacketTypeMulticast and the rule's action to drop.
+type MetaPktType struct {
+	Value uint8
+	RelOp Operator
+}
+
+// Validate checks that MetaPktType carries a known packet type
+func (p *MetaPktType) Validate() error {
+	switch p.Value {
+	case PacketTypeHost:
+	case PacketTypeBroadcast:
+	case PacketTypeMulticast:
+	case PacketTypeOtherHost:
+	default:
+		return fmt.Errorf("%d is unsupported packet type", p.Value)
+	}
+	return nil
+}
+
+// MetaSecmark defines Secmark keyword of Meta key, Value is the security id assigned to the packet.
+// Matching and setting of secmark requires kernel 4.20 or later built with CONFIG_NETWORK_SECMARK.
+type MetaSecmark struct {
+	Value uint32
+	RelOp Operator
+}
+
+// MetaBridgeName defines Ibrname and Obrname keywords of Meta key, Name is the name of the bridge
+// the input or output port belongs to. It can only be used in tables of bridge family.
+type MetaBridgeName struct {
+	Name  string
+	RelOp Operator
+}
+
+// Validate checks that bridge name is a valid interface name
+func (b *MetaBridgeName) Validate() error {
+	if b.Name == "" {
+		return fmt.Errorf("bridge name cannot be empty")
+	}
+	if len(b.Name) >= unix.IFNAMSIZ {
+		return fmt.Errorf("bridge name %s exceeds maximum length of %d", b.Name, unix.IFNAMSIZ-1)
+	}
+	return nil
+}
+
+// MetaIfType defines Iiftype and Oiftype keywords of Meta key, Type is the hardware type of the input
+// or output interface, one of unix.ARPHRD_* constants, IfTypeFromName maps nft names to the constants.
+type MetaIfType struct {
+	Type  uint16
+	RelOp Operator
+}
+
+// ifTypes maps interface hardware type names used by nft to ARPHRD constants
+var ifTypes = map[string]uint16{
+	"ether":      unix.ARPHRD_ETHER,
+	"ppp":        unix.ARPHRD_PPP,
+	"ipip":       unix.ARPHRD_TUNNEL,
+	"ipip6":      unix.ARPHRD_TUNNEL6,
+	"loopback":   unix.ARPHRD_LOOPBACK,
+	"sit":        unix.ARPHRD_SIT,
+	"ipgre":      unix.ARPHRD_IPGRE,
+	"ip6gre":     unix.ARPHRD_IP6GRE,
+	"infiniband": unix.ARPHRD_INFINIBAND,
+	"ieee80211":  unix.ARPHRD_IEEE80211,
+	"none":       unix.ARPHRD_NONE,
+}
+
+// IfTypeFromName returns ARPHRD constant for interface hardware type name, example: "loopback"
+func IfTypeFromName(name string) (uint16, error) {
+	t, ok := ifTypes[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported interface type %s", name)
+	}
+
+	return t, nil
+}
+
+// Validate checks that the interface hardware type is supported
+func (i *MetaIfType) Validate() error {
+	for _, t := range ifTypes {
+		if t == i.Type {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported interface type %d", i.Type)
+}
+
+// Meta keys of slave device, not defined by github.com/google/nftables
+const (
+	metaKeySDIF     expr.MetaKey = 33
+	metaKeySDIFNAME expr.MetaKey = 34
+)
+
+// MetaSDif defines Sdif and Sdifname keywords of Meta key, the slave device, for example a VRF or a bond
+// port, the packet arrived on. Either Index or Name is specified. Matching requires kernel 5.10 or later and
+// is supported only in ipv4, ipv6 and inet tables at prerouting, input and forward hooks.
+type MetaSDif struct {
+	Index uint32
+	Name  string
+	RelOp Operator
+}
+
+// Validate checks that either slave device index or name is specified
+func (s *MetaSDif) Validate() error {
+	if (s.Index == 0) == (s.Name == "") {
+		return fmt.Errorf("either slave device index or name must be specified")
+	}
+	if len(s.Name) >= unix.IFNAMSIZ {
+		return fmt.Errorf("slave device name %s exceeds maximum length of %d", s.Name, unix.IFNAMSIZ-1)
+	}
+	if s.RelOp != EQ && s.RelOp != NEQ {
+		return fmt.Errorf("slave device supports only EQ and NEQ operators")
+	}
+
+	return nil
+}
+
+// validateSDifChain checks that the table family and the chain's hook support slave device match
+func validateSDifChain(family nftables.TableFamily, chainAttrs *ChainAttributes) error {
+	switch family {
+	case nftables.TableFamilyIPv4, nftables.TableFamilyIPv6, nftables.TableFamilyINet:
+	default:
+		return fmt.Errorf("slave device match is supported only for ipv4, ipv6 and inet table families")
+	}
+	if chainAttrs == nil {
+		return nil
+	}
+	for _, h := range []*nftables.ChainHook{nftables.ChainHookPrerouting, nftables.ChainHookInput, nftables.ChainHookForward} {
+		if isEqualHook(h, chainAttrs.Hook) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("slave device match is not supported in a chain attached to hook %d", hookNum(chainAttrs.Hook))
+}
+
+// tcHandleRoot is the major number reserved by tc for root and ingress qdiscs
+const tcHandleRoot = 0xffff
+
+// MetaPriority defines Priority keyword of Meta key, the packet's tc class major:minor, for example
+// MetaPriority{Major: 1, Minor: 0x10} matches meta priority 1:10. TCClassFromString parses tc notation.
+type MetaPriority struct {
+	Major uint16
+	Minor uint16
+	RelOp Operator
+}
+
+// Validate checks the class encoding and the operator
+func (p *MetaPriority) Validate() error {
+	if err := validateTCClass(p.Major, p.Minor); err != nil {
+		return err
+	}
+	if p.RelOp != EQ && p.RelOp != NEQ {
+		return fmt.Errorf("priority supports only EQ and NEQ operators")
+	}
+
+	return nil
+}
+
+// validateTCClass checks that major:minor identifies a tc class, major 0 is only valid with minor 0,
+// which stands for no class, and major ffff is reserved for root and ingress qdiscs.
+func validateTCClass(major, minor uint16) error {
+	if major == 0 && minor != 0 {
+		return fmt.Errorf("tc class 0:%x has no major number", minor)
+	}
+	if major == tcHandleRoot {
+		return fmt.Errorf("tc class major number ffff is reserved")
+	}
+
+	return nil
+}
+
+// TCClassFromString parses tc class in major:minor notation where both numbers are hexadecimal, example: "1:10"
+func TCClassFromString(class string) (uint16, uint16, error) {
+	parts := strings.Split(class, ":")
+	if len(parts) != 2 || parts[0] == "" {
+		return 0, 0, fmt.Errorf("tc class %s is not in major:minor notation", class)
+	}
+	major, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid major number of tc class %s: %w", class, err)
+	}
+	var minor uint64
+	if parts[1] != "" {
+		if minor, err = strconv.ParseUint(parts[1], 16, 16); err != nil {
+			return 0, 0, fmt.Errorf("invalid minor number of tc class %s: %w", class, err)
+		}
+	}
+	if err := validateTCClass(uint16(major), uint16(minor)); err != nil {
+		return 0, 0, err
+	}
+
+	return uint16(major), uint16(minor), nil
+}
+
 // Meta defines parameters used to build nft meta expression
 type Meta struct {
-	Mark *MetaMark
-	Expr []MetaExpr
+	Mark     *MetaMark
+	Expr     []MetaExpr
+	PktType  *MetaPktType
+	Secmark  *MetaSecmark
+	IBrName  *MetaBridgeName
+	OBrName  *MetaBridgeName
+	IIfType  *MetaIfType
+	OIfType  *MetaIfType
+	SDif     *MetaSDif
+	Priority *MetaPriority
 }
 
 // RuleAction defines what action needs to be executed on the rule match
 type RuleAction struct {
-	verdict     *expr.Verdict
-	redirect    *redirect
-	masq        *masquerade
-	nat         *nat
-	reject      *reject
-	loadbalance *loadbalance
+	verdict        *expr.Verdict
+	redirect       *redirect
+	masq           *masquerade
+	nat            *nat
+	reject         *reject
+	loadbalance    *loadbalance
+	metaFromCt     *metaFromCt
+	ctZone         *uint16
+	ctLabel        *uint8
+	ctTimeout      *string
+	secmark        *uint32
+	trace          *bool
+	priority       *uint32
+	payloadWrite   *payloadWrite
+	ecn            *uint8
+	ipid           *uint16
+	netmap         *netmap
+	consistentHash *consistentHash
+	// rateLimitedReject carries limit statement followed by reject
+	rateLimitedReject *rateLimitedReject
+	mssClamp          *mssClamp
+	vmap              *VMapSpec
+	// regularChain restricts the verdict to regular chains, it is set by SetReturn
+	regularChain bool
 }
 
 // SetLoadbalance builds RuleAction struct for Verdict based actions,
@@ -888,6 +1875,17 @@ func SetVerdict(key int, chain ...string) (*RuleAction, error) {
 	return ra, nil
 }
 
+// SetReturn builds RuleAction struct for return verdict, example: ip saddr 192.0.2.0/24 return. Return pops
+// evaluation back to the rule following the jump in the calling chain, hence the action is permitted only
+// in regular chains, in a base chain return is equivalent to the chain's policy.
+func SetReturn() (*RuleAction, error) {
+	ra := &RuleAction{regularChain: true}
+	if err := ra.setVerdict(unix.NFT_RETURN); err != nil {
+		return nil, err
+	}
+	return ra, nil
+}
+
 // SetRedirect builds RuleAction struct for Redirect action
 func SetRedirect(port int, tproxy bool) (*RuleAction, error) {
 	ra := &RuleAction{}
@@ -1018,6 +2016,181 @@ func SetReject(rt int, rc int) (*RuleAction, error) {
 	return ra, nil
 }
 
+// LimitAttributes defines rate limit of a limit statement, Rate packets or bytes, depending on Type,
+// per Unit of time with optional Burst. Type is either expr.LimitTypePkts (default) or expr.LimitTypePktBytes.
+type LimitAttributes struct {
+	Rate  uint64
+	Unit  expr.LimitTime
+	Burst uint32
+	Type  expr.LimitType
+}
+
+// Validate checks LimitAttributes parameters
+func (l *LimitAttributes) Validate() error {
+	if l.Rate == 0 {
+		return fmt.Errorf("limit rate cannot be 0")
+	}
+	switch l.Unit {
+	case expr.LimitTimeSecond:
+	case expr.LimitTimeMinute:
+	case expr.LimitTimeHour:
+	case expr.LimitTimeDay:
+	case expr.LimitTimeWeek:
+	default:
+		return fmt.Errorf("unsupported limit unit %d", l.Unit)
+	}
+	switch l.Type {
+	case expr.LimitTypePkts:
+	case expr.LimitTypePktBytes:
+	default:
+		return fmt.Errorf("unsupported limit type %d", l.Type)
+	}
+
+	return nil
+}
+
+// rateLimitedReject defines reject action applied only while packets are within the limit
+type rateLimitedReject struct {
+	limit      LimitAttributes
+	rejectType uint32
+}
+
+// SetRateLimitedReject builds RuleAction struct for reject action limited by rate, example:
+// limit rate 10/second reject with icmpx type port-unreachable. It prevents the host from becoming
+// an amplifier of ICMP errors or tcp resets. Packets exceeding the limit are dropped, the limit statement
+// stops evaluation of the rule for them, hence the rule is followed by a rule carrying the same matches
+// and drop verdict, it is programmed and deleted with the rule. Matches are evaluated by both rules,
+// the rule cannot carry Probability, Limit, ObjRef or Dynamic.
+// Supported reject types are unix.NFT_REJECT_ICMP_UNREACH, unix.NFT_REJECT_ICMPX_UNREACH and
+// unix.NFT_REJECT_TCP_RST, icmp types are rejected with port unreachable code.
+func SetRateLimitedReject(limit LimitAttributes, rejectType uint32) (*RuleAction, error) {
+	if err := limit.Validate(); err != nil {
+		return nil, err
+	}
+	switch rejectType {
+	case unix.NFT_REJECT_ICMP_UNREACH:
+	case unix.NFT_REJECT_ICMPX_UNREACH:
+	case unix.NFT_REJECT_TCP_RST:
+	default:
+		return nil, fmt.Errorf("unsupported reject type %d", rejectType)
+	}
+	ra := &RuleAction{
+		rateLimitedReject: &rateLimitedReject{
+			limit:      limit,
+			rejectType: rejectType,
+		},
+	}
+
+	return ra, nil
+}
+
+// SetMetaFromCt builds RuleAction struct for an action setting meta key from the value of
+// conntrack key, example: meta mark set ct mark. Supported meta keys are unix.NFT_META_MARK,
+// unix.NFT_META_PRIORITY and unix.NFT_META_SECMARK, supported conntrack keys are unix.NFT_CT_MARK
+// and unix.NFT_CT_SECMARK.
+func SetMetaFromCt(metaKey int, ctKey int) (*RuleAction, error) {
+	switch metaKey {
+	case unix.NFT_META_MARK:
+	case unix.NFT_META_PRIORITY:
+	case unix.NFT_META_SECMARK:
+	default:
+		return nil, fmt.Errorf("meta key %d is not supported", metaKey)
+	}
+	switch ctKey {
+	case unix.NFT_CT_MARK:
+	case unix.NFT_CT_SECMARK:
+	default:
+		return nil, fmt.Errorf("conntrack key %d is not supported", ctKey)
+	}
+	ra := &RuleAction{
+		metaFromCt: &metaFromCt{
+			metaKey: uint32(metaKey),
+			ctKey:   uint32(ctKey),
+		},
+	}
+
+	return ra, nil
+}
+
+// SetCtZone builds RuleAction struct for an action setting conntrack zone, example: ct zone set 5.
+// The action must be used before the packet is tracked, in prerouting or output chains with priority
+// lower than conntrack's one.
+func SetCtZone(zone uint16) (*RuleAction, error) {
+	ra := &RuleAction{
+		ctZone: &zone,
+	}
+
+	return ra, nil
+}
+
+// SetCtLabel builds RuleAction struct for an action setting the label bit of the connection, example:
+// ct label set 5. Other label bits are preserved. Labels are matched by SetConntrackLabel.
+func SetCtLabel(bit uint8) (*RuleAction, error) {
+	if bit > ctLabelMax {
+		return nil, fmt.Errorf("conntrack label bit %d exceeds maximum of %d", bit, ctLabelMax)
+	}
+	ra := &RuleAction{
+		ctLabel: &bit,
+	}
+
+	return ra, nil
+}
+
+// SetCtTimeout builds RuleAction struct for an action assigning a named ct timeout policy to the connection,
+// example: ct timeout set "db-timeout". The policy overrides default conntrack timeouts of the flow, for example
+// to give long lived connections a longer idle timeout. The ct timeout object must exist in the table,
+// it is created by Objects().CreateCtTimeout.
+func SetCtTimeout(policyName string) (*RuleAction, error) {
+	if policyName == "" {
+		return nil, fmt.Errorf("ct timeout policy name cannot be empty")
+	}
+	ra := &RuleAction{
+		ctTimeout: &policyName,
+	}
+
+	return ra, nil
+}
+
+// SetSecmark builds RuleAction struct for an action setting packet's secmark to secid,
+// it requires kernel 4.20 or later built with CONFIG_NETWORK_SECMARK, otherwise the kernel
+// rejects the rule.
+func SetSecmark(secid uint32) (*RuleAction, error) {
+	if secid == 0 {
+		return nil, fmt.Errorf("secmark security id cannot be 0")
+	}
+	ra := &RuleAction{
+		secmark: &secid,
+	}
+
+	return ra, nil
+}
+
+// SetMetaPriority builds RuleAction struct for an action setting packet's tc class to major:minor, example:
+// meta priority set 1:10 classifies the packet into HTB class 1:10 of the egress interface.
+func SetMetaPriority(major, minor uint16) (*RuleAction, error) {
+	if err := validateTCClass(major, minor); err != nil {
+		return nil, err
+	}
+	priority := uint32(major)<<16 | uint32(minor)
+	ra := &RuleAction{
+		priority: &priority,
+	}
+
+	return ra, nil
+}
+
+// SetTrace builds RuleAction struct for an action setting nftrace meta key of packets matching the rule,
+// packets with nftrace set are reported by nft monitor trace while they traverse the ruleset.
+// Example: a rule matching the packets of interest with SetTrace(true) action placed in a chain of
+// raw priority at prerouting hook traces the packets through all following chains.
+func SetTrace(enable bool) (*RuleAction, error) {
+	ra := &RuleAction{
+		trace: &enable,
+	}
+
+	return ra, nil
+}
+
 // Validate method validates RuleAction parameters and returns error if inconsistency if found
 func (ra *RuleAction) Validate() error {
 	if ra.verdict == nil && ra.redirect == nil {
@@ -1047,22 +2220,27 @@ func (ra *RuleAction) setVerdict(key int, chain ...string) error {
 		fallthrough
 	case unix.NFT_GOTO:
 		if len(chain) > 1 {
-			return fmt.Errorf("only a single chain name can be specified")
+			return fmt.Errorf("%w: only a single chain name can be specified", ErrInvalidVerdict)
 		}
-		if len(chain) == 0 {
-			return fmt.Errorf("jump or goto verdicts must have a chain name specified")
+		if len(chain) == 0 || chain[0] == "" {
+			return fmt.Errorf("%w: jump or goto verdicts must have a chain name specified", ErrInvalidVerdict)
 		}
 		ra.verdict.Chain = chain[0]
-	case unix.NFT_RETURN:
-	case NFT_DROP:
-	case NFT_ACCEPT:
+	case unix.NFT_RETURN, unix.NFT_CONTINUE, NFT_DROP, NFT_ACCEPT:
+		if len(chain) != 0 {
+			return fmt.Errorf("%w: chain name can be specified only for jump or goto verdicts, verdict key %d", ErrInvalidVerdict, key)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported verdict key %d, supported keys are NFT_DROP, NFT_ACCEPT, unix.NFT_RETURN, "+
+			"unix.NFT_CONTINUE, unix.NFT_JUMP and unix.NFT_GOTO", ErrInvalidVerdict, key)
 	}
 	ra.verdict.Kind = expr.VerdictKind(int64(key))
 
 	return nil
 }
 
-// Log defines nftables logging parameters for a rule
+// Log defines nftables logging parameters for a rule, Key is one of unix.NFTA_LOG_* attributes. Value of
+// prefix is the prefix string, values of level, group, snaplen and queue threshold are numbers in network byte order.
 type Log struct {
 	Key   uint32
 	Value []byte
@@ -1072,7 +2250,8 @@ type Log struct {
 type Counter struct {
 }
 
-// Fib defines nftables Fib expression. Results and Flags can have multiple selections.
+// Fib defines nftables Fib expression. Only one Result can be selected, Flags can have multiple selections,
+// but either FlagSADDR or FlagDADDR must be set and FlagIIF and FlagOIF are mutually exclusive.
 // Data is a slice of bytes, its content depends up on Result and Flags combination.
 // Example: if fib expression specifies a particular address type, then Data would carry one of
 // constants defined in golang.org/x/sys/unix
@@ -1101,6 +2280,40 @@ type Fib struct {
 	Data           []byte
 }
 
+// Validate checks Fib's results and flags for consistency
+func (f *Fib) Validate() error {
+	results := 0
+	for _, r := range []bool{f.ResultOIF, f.ResultOIFNAME, f.ResultADDRTYPE} {
+		if r {
+			results++
+		}
+	}
+	if results != 1 {
+		return fmt.Errorf("fib must have exactly one result selected")
+	}
+	if f.FlagSADDR == f.FlagDADDR {
+		return fmt.Errorf("fib must have either saddr or daddr flag set")
+	}
+	if f.FlagIIF && f.FlagOIF {
+		return fmt.Errorf("fib cannot have both iif and oif flags set")
+	}
+
+	return nil
+}
+
+// SetFibReversePath is a helper function returning Fib matching packets failing reverse path check,
+// the rule's action is expected to drop such packets. In strict mode the route back to the packet's
+// source address must use the interface the packet arrived on (fib saddr . iif oif missing),
+// in loose mode any route back to the source is sufficient (fib saddr oif missing).
+func SetFibReversePath(strict bool) *Fib {
+	return &Fib{
+		ResultOIF: true,
+		FlagSADDR: true,
+		FlagIIF:   strict,
+		Data:      []byte{0x0, 0x0, 0x0, 0x0},
+	}
+}
+
 // SetLog is a helper function returning Log struct with validated values
 func SetLog(key int, value []byte) (*Log, error) {
 	switch key {
@@ -1123,10 +2336,112 @@ var (
 	CTStateInvalid     uint32 = 0x01000000
 )
 
-// Conntrack defines a key and  value for Ccnnection tracking
+// Conntrack defines a key and  value for Ccnnection tracking, supported keys are unix.NFT_CT_STATE,
+// unix.NFT_CT_ZONE, unix.NFT_CT_BYTES, unix.NFT_CT_PKTS, unix.NFT_CT_EXPIRATION, unix.NFT_CT_DIRECTION and
+// unix.NFT_CT_LABELS. The value of zone is 2 bytes in host byte order, the value of bytes and packets is 8 bytes
+// in network byte order, see SetConntrackAccounting, the value of expiration is 4 bytes of milliseconds in network
+// byte order, see SetConntrackExpiration, the value of direction is 1 byte, see SetConntrackDirection, the value
+// of labels is 16 bytes mask of label bits, see SetConntrackLabel.
+// RelOp is used only by bytes, packets, expiration, direction and labels keys.
 type Conntrack struct {
 	Key   uint32
 	Value []byte
+	RelOp Operator
+}
+
+// SetConntrackAccounting is a helper function returning Conntrack matching connection's accounting
+// counters, key is either unix.NFT_CT_BYTES or unix.NFT_CT_PKTS, counters of both directions are summed up.
+// Example: ct bytes > 1000000. Connection accounting must be enabled in the kernel with
+// net.netfilter.nf_conntrack_acct sysctl, otherwise the counters are always 0.
+func SetConntrackAccounting(key int, value uint64, op Operator) (*Conntrack, error) {
+	switch key {
+	case unix.NFT_CT_BYTES:
+	case unix.NFT_CT_PKTS:
+	default:
+		return nil, fmt.Errorf("conntrack key %d is not accounting key", key)
+	}
+	if op > LTE {
+		return nil, fmt.Errorf("unsupported relational operation %d", op)
+	}
+
+	return &Conntrack{
+		Key:   uint32(key),
+		Value: binaryutil.BigEndian.PutUint64(value),
+		RelOp: op,
+	}, nil
+}
+
+// SetConntrackExpiration is a helper function returning Conntrack matching time left until the connection
+// expires, for example to find connections nearing expiry: ct expiration < 10s. The expiration is
+// compared with millisecond precision.
+func SetConntrackExpiration(expiration time.Duration, op Operator) (*Conntrack, error) {
+	if op > LTE {
+		return nil, fmt.Errorf("unsupported relational operation %d", op)
+	}
+	ms := expiration.Milliseconds()
+	if ms < 0 || ms > math.MaxUint32 {
+		return nil, fmt.Errorf("expiration %v is out of range", expiration)
+	}
+
+	return &Conntrack{
+		Key:   unix.NFT_CT_EXPIRATION,
+		Value: binaryutil.BigEndian.PutUint32(uint32(ms)),
+		RelOp: op,
+	}, nil
+}
+
+// Directions of a packet within its connection
+const (
+	CTDirectionOriginal = 0
+	CTDirectionReply    = 1
+)
+
+// SetConntrackDirection is a helper function returning Conntrack matching the direction of the packet within
+// its connection, CTDirectionOriginal or CTDirectionReply, for example to count bytes of the reply direction
+// separately: ct direction reply counter. Only EQ and NEQ relational operations are supported.
+func SetConntrackDirection(direction uint8, op Operator) (*Conntrack, error) {
+	if direction != CTDirectionOriginal && direction != CTDirectionReply {
+		return nil, fmt.Errorf("invalid conntrack direction %d", direction)
+	}
+	if op != EQ && op != NEQ {
+		return nil, fmt.Errorf("unsupported relational operation %d", op)
+	}
+
+	return &Conntrack{
+		Key:   unix.NFT_CT_DIRECTION,
+		Value: []byte{direction},
+		RelOp: op,
+	}, nil
+}
+
+// ctLabelMax is the highest conntrack label bit, labels are a 128 bit bitfield
+const ctLabelMax = 127
+
+// ctLabelMask returns 16 bytes of conntrack labels with only the bit set, labels are kept by the kernel
+// as a bitmap in host byte order.
+func ctLabelMask(bit uint8) []byte {
+	mask := make([]byte, 16)
+	copy(mask[bit/32*4:], binaryutil.NativeEndian.PutUint32(1<<(bit%32)))
+
+	return mask
+}
+
+// SetConntrackLabel is a helper function returning Conntrack matching connections with the label bit set,
+// example: ct label 5, with NEQ connections without the bit set are matched. Labels are set by SetCtLabel,
+// for example by a rule tagging flows inspected by a userspace daemon.
+func SetConntrackLabel(bit uint8, op Operator) (*Conntrack, error) {
+	if bit > ctLabelMax {
+		return nil, fmt.Errorf("conntrack label bit %d exceeds maximum of %d", bit, ctLabelMax)
+	}
+	if op != EQ && op != NEQ {
+		return nil, fmt.Errorf("unsupported relational operation %d", op)
+	}
+
+	return &Conntrack{
+		Key:   unix.NFT_CT_LABELS,
+		Value: ctLabelMask(bit),
+		RelOp: op,
+	}, nil
 }
 
 // MatchType defines a matching criteria for an incoming packet. Only one of the criterias
@@ -1142,6 +2457,10 @@ const (
 	MatchTypeL4Src
 	// MatchTypeL4Dst match Layer 4 destination port
 	MatchTypeL4Dst
+	// MatchTypeIIFName match the name of input interface
+	MatchTypeIIFName
+	// MatchTypeOIFName match the name of output interface
+	MatchTypeOIFName
 )
 
 // Dynamic defines a rule which dynamically add or update a Set or Map based on
@@ -1172,13 +2491,40 @@ type MatchAct struct {
 }
 
 // Rule contains parameters for a rule to configure, only L3 OR L4 parameters can be specified
+// Matches are emitted in the order of the fields, L3 emits version, protocol, source and destination and
+// L4 emits source and destination ports. Each address emits the address load followed by the comparison or
+// the lookup and each port emits l4proto match and the port load followed by the comparison or the lookup,
+// the layout does not depend on whether the list is inline or references a named set.
 type Rule struct {
-	Concat     *Concat
-	Dynamic    *Dynamic
-	MatchAct   *MatchAct
-	Fib        *Fib
-	L3         *L3Rule
-	L4         *L4Rule
+	Concat    *Concat
+	Dynamic   *Dynamic
+	MatchAct  *MatchAct
+	Fib       *Fib
+	L3        *L3Rule
+	L4        *L4Rule
+	Payload   *PayloadSpec
+	FlowLabel *FlowLabelSpec
+	// ECN matches ECN codepoint of IP header, for example packets marked Congestion Experienced
+	ECN *ECNSpec
+	// DSCP matches DSCP field of IP header against one or more values, for example to classify a group of markings
+	DSCP *DSCPSpec
+	// IPID matches identification field of IPv4 header
+	IPID *IPIDSpec
+	// TCPOption matches a field of TCP option, for example maximum segment size
+	TCPOption *TCPOptionSpec
+	// VXLAN matches VNI of VXLAN encapsulated packets, for example to steer traffic by VNI in a gateway
+	VXLAN *VXLANSpec
+	// GRE matches the key of GRE encapsulated packets
+	GRE *GRESpec
+	// ARP matches fields of ARP header in tables of arp family
+	ARP *ARPSpec
+	// Probability matches specified percentage of packets, for example to sample traffic
+	Probability *ProbabilitySpec
+	// Limit matches packets within the rate, evaluation of the rule stops for packets over the rate,
+	// for example to rate limit logging
+	Limit *LimitAttributes
+	// ObjRef references a named stateful object, for example a counter shared by several rules
+	ObjRef     *ObjRefSpec
 	Conntracks []*Conntrack
 	Meta       *Meta
 	Log        *Log
@@ -1194,29 +2540,314 @@ type Rule struct {
 	// InsertRule with position != 0 will insert a rule right before the rule with specified position
 	// Replace operation with position 0 will fail.
 	Position int
-}
-
-// Validate checks parameters passed in struct and returns error if inconsistency is found
-func (r Rule) Validate() error {
-	switch {
-	case r.L3 != nil:
+	// Stats carries the state of counter, quota and limit of the rule read from the host, it is set on rules
+	// returned by GetRules and GetAllRules and it is ignored when the rule is programmed.
+	Stats *RuleStats
+	// Handle is the handle of the rule on the host, for example to delete the rule with DeleteImm, it is set
+	// on rules returned by GetRules and GetAllRules and it is ignored when the rule is programmed.
+	Handle uint64
+}
+
+// Validate checks parameters passed in struct and returns error if inconsistency is found, family is
+// the family of the table and chainAttrs are the attributes of the chain the rule is programmed into,
+// nil chainAttrs identifies a regular chain. Validate is invoked before the rule's expressions are built.
+func (r Rule) Validate(family nftables.TableFamily, chainAttrs *ChainAttributes) error {
+	if r.isEmpty() {
+		return fmt.Errorf("rule must have at least one match, statement or action")
+	}
+	if r.L3 != nil {
 		if err := r.L3.Validate(); err != nil {
 			return err
 		}
-	case r.L4 != nil:
+		if err := validateL3Family(family, r.L3); err != nil {
+			return err
+		}
+	}
+	if r.L4 != nil {
 		if err := r.L4.Validate(); err != nil {
 			return err
 		}
 	}
+	if r.Fib != nil {
+		if err := r.Fib.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Payload != nil {
+		if err := r.Payload.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.FlowLabel != nil {
+		if family != nftables.TableFamilyIPv6 {
+			return fmt.Errorf("flow label match is supported only for ipv6 table family")
+		}
+		if err := r.FlowLabel.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.ECN != nil {
+		if family != nftables.TableFamilyIPv4 && family != nftables.TableFamilyIPv6 {
+			return fmt.Errorf("ecn match is supported only for ipv4 and ipv6 table families")
+		}
+		if err := r.ECN.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.DSCP != nil {
+		if family != nftables.TableFamilyIPv4 && family != nftables.TableFamilyIPv6 {
+			return fmt.Errorf("dscp match is supported only for ipv4 and ipv6 table families")
+		}
+		if err := r.DSCP.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.IPID != nil {
+		if family != nftables.TableFamilyIPv4 {
+			return fmt.Errorf("ip id match is supported only for ipv4 table family")
+		}
+		if err := r.IPID.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.TCPOption != nil {
+		if !r.isTCP() {
+			return fmt.Errorf("tcp option match requires the rule to match tcp protocol")
+		}
+		if err := r.TCPOption.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.VXLAN != nil {
+		if r.GRE != nil {
+			return fmt.Errorf("vxlan and gre matches are mutually exclusive")
+		}
+		if r.L4 != nil && r.L4.L4Proto != unix.IPPROTO_UDP {
+			return fmt.Errorf("vxlan match requires the rule to match udp protocol")
+		}
+		if err := r.VXLAN.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.GRE != nil && r.L4 != nil {
+		return fmt.Errorf("gre match cannot be combined with l4 match")
+	}
+	if r.ARP != nil {
+		if family != nftables.TableFamilyARP {
+			return fmt.Errorf("arp match is supported only for arp table family")
+		}
+		if err := r.ARP.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Probability != nil {
+		if err := r.Probability.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Limit != nil {
+		if err := r.Limit.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.ObjRef != nil {
+		if err := r.ObjRef.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Meta != nil && r.Meta.Mark != nil {
+		if err := r.Meta.Mark.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Meta != nil && r.Meta.PktType != nil {
+		if err := r.Meta.PktType.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Meta != nil && r.Meta.IIfType != nil {
+		if err := r.Meta.IIfType.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Meta != nil && r.Meta.OIfType != nil {
+		if err := r.Meta.OIfType.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Meta != nil && r.Meta.SDif != nil {
+		if err := r.Meta.SDif.Validate(); err != nil {
+			return err
+		}
+		if err := validateSDifChain(family, chainAttrs); err != nil {
+			return err
+		}
+	}
+	if r.Meta != nil && r.Meta.Priority != nil {
+		if err := r.Meta.Priority.Validate(); err != nil {
+			return err
+		}
+	}
 	if r.Action == nil {
 		return nil
 	}
 	if r.L3 == nil && r.L4 == nil && r.Action.redirect != nil {
 		return fmt.Errorf("cannot redirect wihtout specifying L3 or L4 rule")
 	}
+	if r.Action.rateLimitedReject != nil && r.Action.rateLimitedReject.rejectType == unix.NFT_REJECT_TCP_RST && !r.isTCP() {
+		return fmt.Errorf("reject with tcp reset requires the rule to match tcp protocol")
+	}
+	if r.Action.rateLimitedReject != nil {
+		if err := r.validateOverLimit(); err != nil {
+			return err
+		}
+	}
+	if r.Action.reject != nil && r.Action.reject.rejectType == unix.NFT_REJECT_TCP_RST && !r.isTCP() {
+		return fmt.Errorf("reject with tcp reset requires the rule to match tcp protocol")
+	}
+	if r.Action.ecn != nil && family != nftables.TableFamilyIPv4 && family != nftables.TableFamilyIPv6 {
+		return fmt.Errorf("setting ecn is supported only for ipv4 and ipv6 table families")
+	}
+	if r.Action.payloadWrite != nil {
+		if err := validatePayloadWrite(family, r.Action.payloadWrite.spec); err != nil {
+			return err
+		}
+	}
+	if r.Action.ipid != nil && family != nftables.TableFamilyIPv4 {
+		return fmt.Errorf("setting ip id is supported only for ipv4 table family")
+	}
+	if r.Action.netmap != nil {
+		if err := r.validateNetmap(family); err != nil {
+			return err
+		}
+	}
+	if r.Action.mssClamp != nil {
+		if !r.isTCP() {
+			return fmt.Errorf("mss clamping requires the rule to match tcp protocol")
+		}
+		if r.Action.mssClamp.pmtu {
+			if err := validateMSSClampChain(chainAttrs); err != nil {
+				return err
+			}
+		}
+	}
+	if r.Action.regularChain && chainAttrs != nil {
+		return fmt.Errorf("%w: return can be used only in a regular chain", ErrInvalidVerdict)
+	}
+
+	return validateActionChain(r.Action, chainAttrs)
+}
+
+// isTCP returns true if the rule matches tcp protocol either by L4Proto or by L3 Protocol
+func (r Rule) isTCP() bool {
+	if r.L4 != nil && r.L4.L4Proto == unix.IPPROTO_TCP {
+		return true
+	}
+	if r.L3 != nil && r.L3.Protocol != nil && *r.L3.Protocol == unix.IPPROTO_TCP {
+		return true
+	}
+
+	return false
+}
+
+// validateL3Family checks that addresses of L3 rule match the table's family, inet and bridge
+// tables can carry addresses of both versions.
+func validateL3Family(family nftables.TableFamily, l3 *L3Rule) error {
+	var ipv6 bool
+	switch family {
+	case nftables.TableFamilyIPv4:
+	case nftables.TableFamilyIPv6:
+		ipv6 = true
+	default:
+		return nil
+	}
+	for _, spec := range []*IPAddrSpec{l3.Src, l3.Dst} {
+		if spec == nil {
+			continue
+		}
+		addrs := append([]*IPAddr{}, spec.List...)
+		addrs = append(addrs, spec.Range[0], spec.Range[1])
+		for _, addr := range addrs {
+			if addr == nil || addr.IPAddr == nil {
+				continue
+			}
+			if addr.IsIPv6() != ipv6 {
+				if ipv6 {
+					return fmt.Errorf("ipv4 address %s cannot be used in a table of ipv6 family", addr.IP.String())
+				}
+				return fmt.Errorf("ipv6 address %s cannot be used in a table of ipv4 family", addr.IP.String())
+			}
+		}
+	}
+
 	return nil
 }
 
+// validateActionChain checks that nat actions are used only in nat chains attached to the hooks
+// where the kernel permits them.
+func validateActionChain(ra *RuleAction, chainAttrs *ChainAttributes) error {
+	var action string
+	var hooks []*nftables.ChainHook
+	switch {
+	case ra.nat != nil && ra.nat.nattype == expr.NATTypeSourceNAT:
+		action = "snat"
+		hooks = []*nftables.ChainHook{nftables.ChainHookPostrouting, nftables.ChainHookInput}
+	case ra.nat != nil && ra.nat.nattype == expr.NATTypeDestNAT:
+		action = "dnat"
+		hooks = []*nftables.ChainHook{nftables.ChainHookPrerouting, nftables.ChainHookOutput}
+	case ra.masq != nil:
+		action = "masquerade"
+		hooks = []*nftables.ChainHook{nftables.ChainHookPostrouting}
+	case ra.redirect != nil && !ra.redirect.tproxy:
+		action = "redirect"
+		hooks = []*nftables.ChainHook{nftables.ChainHookPrerouting, nftables.ChainHookOutput}
+	default:
+		return nil
+	}
+	// Regular chain can be jumped to from any base chain, it cannot be validated
+	if chainAttrs == nil {
+		return nil
+	}
+	if chainAttrs.Type != nftables.ChainTypeNAT {
+		return fmt.Errorf("%s action requires a chain of nat type, chain type is %s", action, chainAttrs.Type)
+	}
+	for _, h := range hooks {
+		if isEqualHook(h, chainAttrs.Hook) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s action is not supported in a chain attached to hook %d", action, hookNum(chainAttrs.Hook))
+}
+
+func hookNum(h *nftables.ChainHook) uint32 {
+	if h == nil {
+		return 0
+	}
+
+	return uint32(*h)
+}
+
+// getChainAttributes returns attributes of a base chain, for a regular chain nil is returned
+func getChainAttributes(c *nftables.Chain) *ChainAttributes {
+	if c == nil || c.Hooknum == nil {
+		return nil
+	}
+
+	return &ChainAttributes{
+		Type:     c.Type,
+		Hook:     c.Hooknum,
+		Priority: c.Priority,
+	}
+}
+
+// isEmpty returns true if the rule carries neither matches nor statements nor action
+func (r Rule) isEmpty() bool {
+	return r.Concat == nil && r.Dynamic == nil && r.MatchAct == nil && r.Fib == nil &&
+		r.L3 == nil && r.L4 == nil && r.Payload == nil && r.FlowLabel == nil && r.ECN == nil && r.DSCP == nil && r.IPID == nil && r.TCPOption == nil && r.VXLAN == nil && r.GRE == nil && r.ARP == nil && r.Probability == nil && r.Limit == nil && r.ObjRef == nil &&
+		len(r.Conntracks) == 0 && r.Meta == nil && r.Log == nil && r.Counter == nil && r.Action == nil
+}
+
 func getSetName() string {
 	name := uuid.New().String()
 	return name[len(name)-12:]
@@ -1227,6 +2858,24 @@ const (
 	MaxCommentLength = 127
 )
 
+// getRuleComment returns the comment carried in NFTNL_UDATA_RULE_COMMENT TLV of rule's user data,
+// if user data does not carry comment TLV, empty string is returned.
+func getRuleComment(ud []byte) string {
+	for len(ud) >= 2 {
+		l := int(ud[1])
+		if len(ud) < 2+l {
+			break
+		}
+		if ud[0] == 0x0 && l > 0 {
+			// Comment is stored with trailing 0x0
+			return string(ud[2 : 2+l-1])
+		}
+		ud = ud[2+l:]
+	}
+
+	return ""
+}
+
 // MakeRuleComment makes NFTNL_UDATA_RULE_COMMENT TLV. Length of TLV is 1 bytes
 // as a result, the maximum comment length is 254 bytes.
 func MakeRuleComment(s string) []byte {
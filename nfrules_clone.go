@@ -0,0 +1,477 @@
+package nftableslib
+
+import (
+	"net"
+)
+
+// Clone returns a deep copy of the Rule, nested slices and pointers are copied as well,
+// as a result the returned Rule can be modified without affecting the original one.
+func (r *Rule) Clone() *Rule {
+	if r == nil {
+		return nil
+	}
+	n := &Rule{
+		RelOp:    r.RelOp,
+		Position: r.Position,
+	}
+	n.Concat = r.Concat.clone()
+	if r.Dynamic != nil {
+		d := *r.Dynamic
+		d.SetRef = r.Dynamic.SetRef.clone()
+		n.Dynamic = &d
+	}
+	if r.MatchAct != nil {
+		m := &MatchAct{
+			Match:    r.MatchAct.Match,
+			MatchRef: r.MatchAct.MatchRef.clone(),
+		}
+		if r.MatchAct.ActElement != nil {
+			m.ActElement = make(map[int]*RuleAction, len(r.MatchAct.ActElement))
+			for k, v := range r.MatchAct.ActElement {
+				m.ActElement[k] = v.Clone()
+			}
+		}
+		n.MatchAct = m
+	}
+	if r.Fib != nil {
+		f := *r.Fib
+		f.Data = cloneBytes(r.Fib.Data)
+		n.Fib = &f
+	}
+	n.L3 = r.L3.Clone()
+	n.L4 = r.L4.Clone()
+	if r.Payload != nil {
+		p := *r.Payload
+		p.Value = cloneBytes(r.Payload.Value)
+		p.Mask = cloneBytes(r.Payload.Mask)
+		n.Payload = &p
+	}
+	if r.FlowLabel != nil {
+		f := *r.FlowLabel
+		n.FlowLabel = &f
+	}
+	if r.ECN != nil {
+		e := *r.ECN
+		n.ECN = &e
+	}
+	if r.IPID != nil {
+		i := *r.IPID
+		n.IPID = &i
+	}
+	if r.DSCP != nil {
+		n.DSCP = &DSCPSpec{
+			List:  append([]uint8(nil), r.DSCP.List...),
+			RelOp: r.DSCP.RelOp,
+		}
+	}
+	if r.TCPOption != nil {
+		o := *r.TCPOption
+		o.Value = cloneBytes(r.TCPOption.Value)
+		n.TCPOption = &o
+	}
+	if r.VXLAN != nil {
+		v := *r.VXLAN
+		n.VXLAN = &v
+	}
+	if r.GRE != nil {
+		g := *r.GRE
+		n.GRE = &g
+	}
+	if r.ARP != nil {
+		n.ARP = r.ARP.clone()
+	}
+	if r.Probability != nil {
+		p := *r.Probability
+		n.Probability = &p
+	}
+	if r.Limit != nil {
+		l := *r.Limit
+		n.Limit = &l
+	}
+	if r.ObjRef != nil {
+		o := *r.ObjRef
+		n.ObjRef = &o
+	}
+	if r.Conntracks != nil {
+		n.Conntracks = make([]*Conntrack, len(r.Conntracks))
+		for i, ct := range r.Conntracks {
+			if ct == nil {
+				continue
+			}
+			n.Conntracks[i] = &Conntrack{Key: ct.Key, Value: cloneBytes(ct.Value), RelOp: ct.RelOp}
+		}
+	}
+	n.Meta = r.Meta.clone()
+	if r.Log != nil {
+		n.Log = &Log{Key: r.Log.Key, Value: cloneBytes(r.Log.Value)}
+	}
+	if r.Counter != nil {
+		n.Counter = &Counter{}
+	}
+	n.Action = r.Action.Clone()
+	n.UserData = cloneBytes(r.UserData)
+	n.Stats = r.Stats.clone()
+	n.Handle = r.Handle
+
+	return n
+}
+
+func (s *RuleStats) clone() *RuleStats {
+	if s == nil {
+		return nil
+	}
+	n := &RuleStats{}
+	if s.Counter != nil {
+		c := *s.Counter
+		n.Counter = &c
+	}
+	if s.Quota != nil {
+		q := *s.Quota
+		n.Quota = &q
+	}
+	if s.Limit != nil {
+		l := *s.Limit
+		n.Limit = &l
+	}
+
+	return n
+}
+
+// Clone returns a deep copy of L3Rule
+func (l3 *L3Rule) Clone() *L3Rule {
+	if l3 == nil {
+		return nil
+	}
+	n := &L3Rule{
+		Src:   l3.Src.clone(),
+		Dst:   l3.Dst.clone(),
+		RelOp: l3.RelOp,
+	}
+	if l3.Version != nil {
+		v := *l3.Version
+		n.Version = &v
+	}
+	if l3.Protocol != nil {
+		p := *l3.Protocol
+		n.Protocol = &p
+	}
+	if l3.Counter != nil {
+		n.Counter = &Counter{}
+	}
+
+	return n
+}
+
+// Clone returns a deep copy of L4Rule
+func (l4 *L4Rule) Clone() *L4Rule {
+	if l4 == nil {
+		return nil
+	}
+	n := &L4Rule{
+		L4Proto: l4.L4Proto,
+		Src:     l4.Src.clone(),
+		Dst:     l4.Dst.clone(),
+		RelOp:   l4.RelOp,
+	}
+	if l4.Counter != nil {
+		n.Counter = &Counter{}
+	}
+	if l4.UDPLength != nil {
+		v := *l4.UDPLength
+		n.UDPLength = &v
+	}
+	if l4.TCPDataOffset != nil {
+		v := *l4.TCPDataOffset
+		n.TCPDataOffset = &v
+	}
+	if l4.ICMPType != nil {
+		n.ICMPType = &ICMPTypeSpec{
+			List:  append([]uint8(nil), l4.ICMPType.List...),
+			RelOp: l4.ICMPType.RelOp,
+		}
+	}
+
+	return n
+}
+
+// Clone returns a deep copy of RuleAction
+func (ra *RuleAction) Clone() *RuleAction {
+	if ra == nil {
+		return nil
+	}
+	n := &RuleAction{regularChain: ra.regularChain}
+	if ra.verdict != nil {
+		v := *ra.verdict
+		n.verdict = &v
+	}
+	if ra.redirect != nil {
+		r := *ra.redirect
+		n.redirect = &r
+	}
+	if ra.masq != nil {
+		n.masq = &masquerade{
+			random:      cloneBool(ra.masq.random),
+			fullyRandom: cloneBool(ra.masq.fullyRandom),
+			persistent:  cloneBool(ra.masq.persistent),
+			toPort:      clonePortRange(ra.masq.toPort),
+		}
+	}
+	if ra.nat != nil {
+		n.nat = &nat{
+			nattype:     ra.nat.nattype,
+			random:      cloneBool(ra.nat.random),
+			fullyRandom: cloneBool(ra.nat.fullyRandom),
+			persistent:  cloneBool(ra.nat.persistent),
+			address:     ra.nat.address.clone(),
+			port:        ra.nat.port.clone(),
+		}
+	}
+	if ra.reject != nil {
+		r := *ra.reject
+		n.reject = &r
+	}
+	if ra.loadbalance != nil {
+		l := *ra.loadbalance
+		l.chains = append([]string(nil), ra.loadbalance.chains...)
+		n.loadbalance = &l
+	}
+	if ra.consistentHash != nil {
+		h := *ra.consistentHash
+		h.fields = make([]PayloadSpec, len(ra.consistentHash.fields))
+		for i, f := range ra.consistentHash.fields {
+			h.fields[i] = f
+			h.fields[i].Value = cloneBytes(f.Value)
+			h.fields[i].Mask = cloneBytes(f.Mask)
+		}
+		n.consistentHash = &h
+	}
+	if ra.payloadWrite != nil {
+		p := *ra.payloadWrite.spec
+		p.Value = cloneBytes(ra.payloadWrite.spec.Value)
+		p.Mask = cloneBytes(ra.payloadWrite.spec.Mask)
+		n.payloadWrite = &payloadWrite{spec: &p, l4proto: ra.payloadWrite.l4proto}
+	}
+	if ra.ecn != nil {
+		e := *ra.ecn
+		n.ecn = &e
+	}
+	if ra.ipid != nil {
+		i := *ra.ipid
+		n.ipid = &i
+	}
+	if ra.netmap != nil {
+		n.netmap = &netmap{prefix: ra.netmap.prefix.clone(), snat: ra.netmap.snat}
+	}
+	if ra.secmark != nil {
+		s := *ra.secmark
+		n.secmark = &s
+	}
+	if ra.priority != nil {
+		p := *ra.priority
+		n.priority = &p
+	}
+	if ra.trace != nil {
+		t := *ra.trace
+		n.trace = &t
+	}
+	if ra.rateLimitedReject != nil {
+		r := *ra.rateLimitedReject
+		n.rateLimitedReject = &r
+	}
+	if ra.mssClamp != nil {
+		m := *ra.mssClamp
+		n.mssClamp = &m
+	}
+	n.vmap = ra.vmap.clone()
+	if ra.ctZone != nil {
+		z := *ra.ctZone
+		n.ctZone = &z
+	}
+	if ra.ctLabel != nil {
+		l := *ra.ctLabel
+		n.ctLabel = &l
+	}
+	if ra.ctTimeout != nil {
+		c := *ra.ctTimeout
+		n.ctTimeout = &c
+	}
+	if ra.metaFromCt != nil {
+		m := *ra.metaFromCt
+		n.metaFromCt = &m
+	}
+
+	return n
+}
+
+func (c *Concat) clone() *Concat {
+	if c == nil {
+		return nil
+	}
+	n := &Concat{
+		VMap:   c.VMap,
+		SetRef: c.SetRef.clone(),
+	}
+	if c.Elements != nil {
+		n.Elements = make([]*ConcatElement, len(c.Elements))
+		for i, e := range c.Elements {
+			if e == nil {
+				continue
+			}
+			ce := *e
+			ce.EMask = cloneBytes(e.EMask)
+			n.Elements[i] = &ce
+		}
+	}
+
+	return n
+}
+
+func (m *Meta) clone() *Meta {
+	if m == nil {
+		return nil
+	}
+	n := &Meta{}
+	if m.Mark != nil {
+		mark := *m.Mark
+		n.Mark = &mark
+	}
+	if m.Expr != nil {
+		n.Expr = make([]MetaExpr, len(m.Expr))
+		for i, e := range m.Expr {
+			n.Expr[i] = MetaExpr{Key: e.Key, Value: cloneBytes(e.Value), RelOp: e.RelOp}
+		}
+	}
+	if m.PktType != nil {
+		p := *m.PktType
+		n.PktType = &p
+	}
+	if m.Secmark != nil {
+		s := *m.Secmark
+		n.Secmark = &s
+	}
+	if m.IBrName != nil {
+		b := *m.IBrName
+		n.IBrName = &b
+	}
+	if m.OBrName != nil {
+		b := *m.OBrName
+		n.OBrName = &b
+	}
+	if m.IIfType != nil {
+		i := *m.IIfType
+		n.IIfType = &i
+	}
+	if m.OIfType != nil {
+		i := *m.OIfType
+		n.OIfType = &i
+	}
+	if m.SDif != nil {
+		s := *m.SDif
+		n.SDif = &s
+	}
+	if m.Priority != nil {
+		p := *m.Priority
+		n.Priority = &p
+	}
+
+	return n
+}
+
+func (ip *IPAddrSpec) clone() *IPAddrSpec {
+	if ip == nil {
+		return nil
+	}
+	n := &IPAddrSpec{
+		SetRef: ip.SetRef.clone(),
+		RelOp:  ip.RelOp,
+	}
+	if ip.List != nil {
+		n.List = make([]*IPAddr, len(ip.List))
+		for i, addr := range ip.List {
+			n.List[i] = addr.clone()
+		}
+	}
+	n.Range[0] = ip.Range[0].clone()
+	n.Range[1] = ip.Range[1].clone()
+
+	return n
+}
+
+func (ip *IPAddr) clone() *IPAddr {
+	if ip == nil {
+		return nil
+	}
+	n := &IPAddr{CIDR: ip.CIDR}
+	if ip.IPAddr != nil {
+		n.IPAddr = &net.IPAddr{
+			IP:   net.IP(cloneBytes(ip.IPAddr.IP)),
+			Zone: ip.IPAddr.Zone,
+		}
+	}
+	if ip.Mask != nil {
+		m := *ip.Mask
+		n.Mask = &m
+	}
+
+	return n
+}
+
+func (p *Port) clone() *Port {
+	if p == nil {
+		return nil
+	}
+	n := &Port{
+		Range:  clonePortRange(p.Range),
+		RelOp:  p.RelOp,
+		SetRef: p.SetRef.clone(),
+	}
+	if p.List != nil {
+		n.List = make([]*uint16, len(p.List))
+		for i, port := range p.List {
+			if port == nil {
+				continue
+			}
+			pp := *port
+			n.List[i] = &pp
+		}
+	}
+
+	return n
+}
+
+func (s *SetRef) clone() *SetRef {
+	if s == nil {
+		return nil
+	}
+	n := *s
+	return &n
+}
+
+func clonePortRange(r [2]*uint16) [2]*uint16 {
+	n := [2]*uint16{}
+	for i, p := range r {
+		if p == nil {
+			continue
+		}
+		pp := *p
+		n[i] = &pp
+	}
+	return n
+}
+
+func cloneBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	n := *b
+	return &n
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	n := make([]byte, len(b))
+	copy(n, b)
+	return n
+}
@@ -0,0 +1,79 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+func TestRuleClone(t *testing.T) {
+	port1 := uint16(80)
+	port2 := uint16(443)
+	rule := &Rule{
+		L3: &L3Rule{
+			Src: &IPAddrSpec{
+				List: []*IPAddr{setIPAddr(t, "192.0.2.1"), setIPAddr(t, "192.0.2.0/24")},
+			},
+		},
+		L4: &L4Rule{
+			L4Proto: unix.IPPROTO_TCP,
+			Dst: &Port{
+				List: []*uint16{&port1, &port2},
+			},
+		},
+		Meta: &Meta{
+			Expr: []MetaExpr{{Key: unix.NFT_META_L4PROTO, Value: []byte{unix.IPPROTO_TCP}}},
+		},
+		Action:   setActionVerdict(t, unix.NFT_JUMP, "chain-1"),
+		UserData: []byte{1, 2, 3},
+	}
+	clone := rule.Clone()
+	if !reflect.DeepEqual(rule, clone) {
+		t.Fatalf("clone %+v does not match original rule %+v", clone, rule)
+	}
+	clone.L3.Src.List[0] = setIPAddr(t, "198.51.100.1")
+	clone.L3.Src.List[1].IPAddr.IP[0] = 10
+	*clone.L4.Dst.List[0] = 8080
+	clone.Meta.Expr[0].Value[0] = unix.IPPROTO_UDP
+	clone.Action.verdict.Chain = "chain-2"
+	clone.UserData[0] = 9
+	if rule.L3.Src.List[0].IPAddr.String() != "192.0.2.1" {
+		t.Errorf("original L3 address list was modified by the clone")
+	}
+	if rule.L3.Src.List[1].IPAddr.IP[0] != 192 {
+		t.Errorf("original L3 address was modified by the clone")
+	}
+	if *rule.L4.Dst.List[0] != 80 {
+		t.Errorf("original L4 port list was modified by the clone")
+	}
+	if rule.Meta.Expr[0].Value[0] != unix.IPPROTO_TCP {
+		t.Errorf("original meta expression was modified by the clone")
+	}
+	if rule.Action.verdict.Chain != "chain-1" {
+		t.Errorf("original action was modified by the clone")
+	}
+	if rule.UserData[0] != 1 {
+		t.Errorf("original user data was modified by the clone")
+	}
+}
+
+func TestChainAttributesClone(t *testing.T) {
+	policy := ChainPolicyAccept
+	attrs := &ChainAttributes{
+		Type:     nftables.ChainTypeFilter,
+		Hook:     nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityRef(0),
+		Policy:   &policy,
+	}
+	clone := attrs.Clone()
+	if !reflect.DeepEqual(attrs, clone) {
+		t.Fatalf("clone %+v does not match original attributes %+v", clone, attrs)
+	}
+	*clone.Policy = ChainPolicyDrop
+	*clone.Priority = 100
+	if *attrs.Policy != ChainPolicyAccept || *attrs.Priority != 0 {
+		t.Errorf("original attributes were modified by the clone")
+	}
+}
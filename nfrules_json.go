@@ -0,0 +1,581 @@
+package nftableslib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// ruleJSON mirrors Rule field by field, the conversion between the types fails to compile
+// when a field is added to Rule but not to ruleJSON.
+type ruleJSON struct {
+	Concat      *Concat          `json:"concat,omitempty"`
+	Dynamic     *Dynamic         `json:"dynamic,omitempty"`
+	MatchAct    *MatchAct        `json:"matchAct,omitempty"`
+	Fib         *Fib             `json:"fib,omitempty"`
+	L3          *L3Rule          `json:"l3,omitempty"`
+	L4          *L4Rule          `json:"l4,omitempty"`
+	Payload     *PayloadSpec     `json:"payload,omitempty"`
+	FlowLabel   *FlowLabelSpec   `json:"flowLabel,omitempty"`
+	ECN         *ECNSpec         `json:"ecn,omitempty"`
+	DSCP        *DSCPSpec        `json:"dscp,omitempty"`
+	IPID        *IPIDSpec        `json:"ipid,omitempty"`
+	TCPOption   *TCPOptionSpec   `json:"tcpOption,omitempty"`
+	VXLAN       *VXLANSpec       `json:"vxlan,omitempty"`
+	GRE         *GRESpec         `json:"gre,omitempty"`
+	ARP         *ARPSpec         `json:"arp,omitempty"`
+	Probability *ProbabilitySpec `json:"probability,omitempty"`
+	Limit       *LimitAttributes `json:"limit,omitempty"`
+	ObjRef      *ObjRefSpec      `json:"objRef,omitempty"`
+	Conntracks  []*Conntrack     `json:"conntracks,omitempty"`
+	Meta        *Meta            `json:"meta,omitempty"`
+	Log         *Log             `json:"log,omitempty"`
+	RelOp       Operator         `json:"relOp,omitempty"`
+	Counter     *Counter         `json:"counter,omitempty"`
+	Action      *RuleAction      `json:"action,omitempty"`
+	UserData    []byte           `json:"userData,omitempty"`
+	Position    int              `json:"position,omitempty"`
+	Stats       *RuleStats       `json:"-"`
+	Handle      uint64           `json:"-"`
+}
+
+// MarshalJSON encodes Rule, parameters which are not set and Stats and Handle read from the host are omitted
+func (r Rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ruleJSON(r))
+}
+
+// UnmarshalJSON decodes Rule encoded by MarshalJSON
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	var rj ruleJSON
+	if err := json.Unmarshal(data, &rj); err != nil {
+		return err
+	}
+	*r = Rule(rj)
+
+	return nil
+}
+
+type l3RuleJSON struct {
+	Src      *IPAddrSpec `json:"src,omitempty"`
+	Dst      *IPAddrSpec `json:"dst,omitempty"`
+	Version  *byte       `json:"version,omitempty"`
+	Protocol *uint32     `json:"protocol,omitempty"`
+	RelOp    Operator    `json:"relOp,omitempty"`
+	Counter  *Counter    `json:"counter,omitempty"`
+}
+
+// MarshalJSON encodes L3Rule, ip addresses are encoded as strings in CIDR format
+func (l3 L3Rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l3RuleJSON(l3))
+}
+
+// UnmarshalJSON decodes L3Rule encoded by MarshalJSON
+func (l3 *L3Rule) UnmarshalJSON(data []byte) error {
+	var lj l3RuleJSON
+	if err := json.Unmarshal(data, &lj); err != nil {
+		return err
+	}
+	*l3 = L3Rule(lj)
+
+	return nil
+}
+
+type l4RuleJSON struct {
+	L4Proto       uint8         `json:"l4proto"`
+	Src           *Port         `json:"src,omitempty"`
+	Dst           *Port         `json:"dst,omitempty"`
+	RelOp         Operator      `json:"relOp,omitempty"`
+	Counter       *Counter      `json:"counter,omitempty"`
+	UDPLength     *L4Value      `json:"udpLength,omitempty"`
+	TCPDataOffset *L4Value      `json:"tcpDataOffset,omitempty"`
+	ICMPType      *ICMPTypeSpec `json:"icmpType,omitempty"`
+}
+
+// MarshalJSON encodes L4Rule
+func (l4 L4Rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l4RuleJSON(l4))
+}
+
+// UnmarshalJSON decodes L4Rule encoded by MarshalJSON
+func (l4 *L4Rule) UnmarshalJSON(data []byte) error {
+	var lj l4RuleJSON
+	if err := json.Unmarshal(data, &lj); err != nil {
+		return err
+	}
+	*l4 = L4Rule(lj)
+
+	return nil
+}
+
+// MarshalJSON encodes IPAddr as a string, example: "192.0.2.0/24"
+func (ip IPAddr) MarshalJSON() ([]byte, error) {
+	if ip.IPAddr == nil {
+		return nil, fmt.Errorf("ip address is not set")
+	}
+	s := ip.IP.String()
+	if ip.CIDR && ip.Mask != nil {
+		s += "/" + strconv.Itoa(int(*ip.Mask))
+	}
+	if ip.Zone != "" {
+		s += "%" + ip.Zone
+	}
+
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON decodes IPAddr from a string with ip address either in CIDR or non CIDR format
+func (ip *IPAddr) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	addr, err := NewIPAddr(s)
+	if err != nil {
+		return err
+	}
+	*ip = *addr
+
+	return nil
+}
+
+type verdictJSON struct {
+	Kind         int64  `json:"kind"`
+	Chain        string `json:"chain,omitempty"`
+	RegularChain bool   `json:"regularChain,omitempty"`
+}
+
+type redirectJSON struct {
+	Port   uint16 `json:"port"`
+	TProxy bool   `json:"tproxy,omitempty"`
+}
+
+type masqueradeJSON struct {
+	Random      *bool      `json:"random,omitempty"`
+	FullyRandom *bool      `json:"fullyRandom,omitempty"`
+	Persistent  *bool      `json:"persistent,omitempty"`
+	ToPort      [2]*uint16 `json:"toPort,omitempty"`
+}
+
+type natJSON struct {
+	Type        string      `json:"type"`
+	Random      *bool       `json:"random,omitempty"`
+	FullyRandom *bool       `json:"fullyRandom,omitempty"`
+	Persistent  *bool       `json:"persistent,omitempty"`
+	Address     *IPAddrSpec `json:"address,omitempty"`
+	Port        *Port       `json:"port,omitempty"`
+}
+
+type rejectJSON struct {
+	Type uint32 `json:"type"`
+	Code uint8  `json:"code"`
+}
+
+type loadbalanceJSON struct {
+	Chains []string `json:"chains"`
+	Action int      `json:"action"`
+	Mode   int      `json:"mode"`
+}
+
+type metaFromCtJSON struct {
+	MetaKey uint32 `json:"metaKey"`
+	CtKey   uint32 `json:"ctKey"`
+}
+
+type payloadWriteJSON struct {
+	Spec    *PayloadSpec `json:"spec"`
+	L4Proto uint8        `json:"l4proto,omitempty"`
+}
+
+type rateLimitedRejectJSON struct {
+	Limit LimitAttributes `json:"limit"`
+	Type  uint32          `json:"type"`
+}
+
+type mssClampJSON struct {
+	MSS  uint16 `json:"mss,omitempty"`
+	PMTU bool   `json:"pmtu,omitempty"`
+}
+
+type netmapJSON struct {
+	Prefix *IPAddr `json:"prefix"`
+	SNAT   bool    `json:"snat,omitempty"`
+}
+
+type consistentHashJSON struct {
+	Fields  []PayloadSpec `json:"fields"`
+	MapName string        `json:"mapName"`
+	Modulus uint32        `json:"modulus"`
+	Seed    uint32        `json:"seed,omitempty"`
+}
+
+// ruleActionJSON carries exactly one of the actions
+type ruleActionJSON struct {
+	Verdict           *verdictJSON           `json:"verdict,omitempty"`
+	Redirect          *redirectJSON          `json:"redirect,omitempty"`
+	Masquerade        *masqueradeJSON        `json:"masquerade,omitempty"`
+	NAT               *natJSON               `json:"nat,omitempty"`
+	Reject            *rejectJSON            `json:"reject,omitempty"`
+	Loadbalance       *loadbalanceJSON       `json:"loadbalance,omitempty"`
+	MetaFromCt        *metaFromCtJSON        `json:"metaFromCt,omitempty"`
+	CtZone            *uint16                `json:"ctZone,omitempty"`
+	CtLabel           *uint8                 `json:"ctLabel,omitempty"`
+	CtTimeout         *string                `json:"ctTimeout,omitempty"`
+	Secmark           *uint32                `json:"secmark,omitempty"`
+	Priority          *uint32                `json:"priority,omitempty"`
+	Trace             *bool                  `json:"trace,omitempty"`
+	PayloadWrite      *payloadWriteJSON      `json:"payloadWrite,omitempty"`
+	ECN               *uint8                 `json:"ecn,omitempty"`
+	IPID              *uint16                `json:"ipid,omitempty"`
+	Netmap            *netmapJSON            `json:"netmap,omitempty"`
+	ConsistentHash    *consistentHashJSON    `json:"consistentHash,omitempty"`
+	RateLimitedReject *rateLimitedRejectJSON `json:"rateLimitedReject,omitempty"`
+	MSSClamp          *mssClampJSON          `json:"mssClamp,omitempty"`
+	VMap              *VMapSpec              `json:"vmap,omitempty"`
+}
+
+// MarshalJSON encodes RuleAction as an object with a single key identifying the action
+func (ra RuleAction) MarshalJSON() ([]byte, error) {
+	rj := ruleActionJSON{
+		CtZone:    ra.ctZone,
+		CtLabel:   ra.ctLabel,
+		CtTimeout: ra.ctTimeout,
+		Secmark:   ra.secmark,
+		Priority:  ra.priority,
+		Trace:     ra.trace,
+		ECN:       ra.ecn,
+		IPID:      ra.ipid,
+		VMap:      ra.vmap,
+	}
+	if ra.verdict != nil {
+		rj.Verdict = &verdictJSON{
+			Kind:         int64(ra.verdict.Kind),
+			Chain:        ra.verdict.Chain,
+			RegularChain: ra.regularChain,
+		}
+	}
+	if ra.redirect != nil {
+		rj.Redirect = &redirectJSON{
+			Port:   ra.redirect.port,
+			TProxy: ra.redirect.tproxy,
+		}
+	}
+	if ra.masq != nil {
+		rj.Masquerade = &masqueradeJSON{
+			Random:      ra.masq.random,
+			FullyRandom: ra.masq.fullyRandom,
+			Persistent:  ra.masq.persistent,
+			ToPort:      ra.masq.toPort,
+		}
+	}
+	if ra.nat != nil {
+		rj.NAT = &natJSON{
+			Random:      ra.nat.random,
+			FullyRandom: ra.nat.fullyRandom,
+			Persistent:  ra.nat.persistent,
+			Address:     ra.nat.address,
+			Port:        ra.nat.port,
+		}
+		switch ra.nat.nattype {
+		case expr.NATTypeSourceNAT:
+			rj.NAT.Type = "snat"
+		case expr.NATTypeDestNAT:
+			rj.NAT.Type = "dnat"
+		default:
+			return nil, fmt.Errorf("unsupported nat type %d", ra.nat.nattype)
+		}
+	}
+	if ra.reject != nil {
+		rj.Reject = &rejectJSON{
+			Type: ra.reject.rejectType,
+			Code: ra.reject.rejectCode,
+		}
+	}
+	if ra.loadbalance != nil {
+		rj.Loadbalance = &loadbalanceJSON{
+			Chains: ra.loadbalance.chains,
+			Action: ra.loadbalance.action,
+			Mode:   ra.loadbalance.mode,
+		}
+	}
+	if ra.metaFromCt != nil {
+		rj.MetaFromCt = &metaFromCtJSON{
+			MetaKey: ra.metaFromCt.metaKey,
+			CtKey:   ra.metaFromCt.ctKey,
+		}
+	}
+	if ra.payloadWrite != nil {
+		rj.PayloadWrite = &payloadWriteJSON{
+			Spec:    ra.payloadWrite.spec,
+			L4Proto: ra.payloadWrite.l4proto,
+		}
+	}
+	if ra.netmap != nil {
+		rj.Netmap = &netmapJSON{
+			Prefix: ra.netmap.prefix,
+			SNAT:   ra.netmap.snat,
+		}
+	}
+	if ra.consistentHash != nil {
+		rj.ConsistentHash = &consistentHashJSON{
+			Fields:  ra.consistentHash.fields,
+			MapName: ra.consistentHash.mapName,
+			Modulus: ra.consistentHash.modulus,
+			Seed:    ra.consistentHash.seed,
+		}
+	}
+
+	if ra.rateLimitedReject != nil {
+		rj.RateLimitedReject = &rateLimitedRejectJSON{
+			Limit: ra.rateLimitedReject.limit,
+			Type:  ra.rateLimitedReject.rejectType,
+		}
+	}
+	if ra.mssClamp != nil {
+		rj.MSSClamp = &mssClampJSON{
+			MSS:  ra.mssClamp.mss,
+			PMTU: ra.mssClamp.pmtu,
+		}
+	}
+
+	return json.Marshal(rj)
+}
+
+// UnmarshalJSON decodes RuleAction encoded by MarshalJSON, actions' parameters are validated
+// the same way as by Set* functions.
+func (ra *RuleAction) UnmarshalJSON(data []byte) error {
+	var rj ruleActionJSON
+	if err := json.Unmarshal(data, &rj); err != nil {
+		return err
+	}
+	var actions []*RuleAction
+	add := func(a *RuleAction, err error) error {
+		if err != nil {
+			return err
+		}
+		actions = append(actions, a)
+		return nil
+	}
+	var err error
+	switch {
+	case rj.Verdict != nil && rj.Verdict.RegularChain:
+		err = add(SetReturn())
+	case rj.Verdict != nil:
+		err = add(SetVerdict(int(rj.Verdict.Kind), verdictChain(rj.Verdict)...))
+	}
+	if err == nil && rj.Redirect != nil {
+		err = add(SetRedirect(int(rj.Redirect.Port), rj.Redirect.TProxy))
+	}
+	if err == nil && rj.Masquerade != nil {
+		err = add(&RuleAction{
+			masq: &masquerade{
+				random:      rj.Masquerade.Random,
+				fullyRandom: rj.Masquerade.FullyRandom,
+				persistent:  rj.Masquerade.Persistent,
+				toPort:      rj.Masquerade.ToPort,
+			},
+		}, nil)
+	}
+	if err == nil && rj.NAT != nil {
+		err = add(unmarshalNAT(rj.NAT))
+	}
+	if err == nil && rj.Reject != nil {
+		err = add(SetReject(int(rj.Reject.Type), int(rj.Reject.Code)))
+	}
+	if err == nil && rj.Loadbalance != nil {
+		if len(rj.Loadbalance.Chains) == 0 {
+			return fmt.Errorf("number of chains for loadbalancing cannot be 0")
+		}
+		err = add(&RuleAction{
+			loadbalance: &loadbalance{
+				chains: rj.Loadbalance.Chains,
+				action: rj.Loadbalance.Action,
+				mode:   rj.Loadbalance.Mode,
+			},
+		}, nil)
+	}
+	if err == nil && rj.MetaFromCt != nil {
+		err = add(SetMetaFromCt(int(rj.MetaFromCt.MetaKey), int(rj.MetaFromCt.CtKey)))
+	}
+	if err == nil && rj.CtZone != nil {
+		err = add(SetCtZone(*rj.CtZone))
+	}
+	if err == nil && rj.CtLabel != nil {
+		err = add(SetCtLabel(*rj.CtLabel))
+	}
+	if err == nil && rj.CtTimeout != nil {
+		err = add(SetCtTimeout(*rj.CtTimeout))
+	}
+	if err == nil && rj.Secmark != nil {
+		err = add(SetSecmark(*rj.Secmark))
+	}
+	if err == nil && rj.Priority != nil {
+		err = add(SetMetaPriority(uint16(*rj.Priority>>16), uint16(*rj.Priority)))
+	}
+	if err == nil && rj.Trace != nil {
+		err = add(SetTrace(*rj.Trace))
+	}
+	if err == nil && rj.PayloadWrite != nil {
+		err = add(SetPayloadWrite(rj.PayloadWrite.Spec, rj.PayloadWrite.L4Proto))
+	}
+	if err == nil && rj.ECN != nil {
+		err = add(SetECN(*rj.ECN))
+	}
+	if err == nil && rj.IPID != nil {
+		err = add(SetIPID(*rj.IPID))
+	}
+	if err == nil && rj.Netmap != nil {
+		err = add(SetNetmap(rj.Netmap.Prefix, rj.Netmap.SNAT))
+	}
+	if err == nil && rj.ConsistentHash != nil {
+		err = add(SetConsistentHashWithSeed(rj.ConsistentHash.Fields, rj.ConsistentHash.MapName,
+			rj.ConsistentHash.Modulus, rj.ConsistentHash.Seed))
+	}
+	if err == nil && rj.RateLimitedReject != nil {
+		err = add(SetRateLimitedReject(rj.RateLimitedReject.Limit, rj.RateLimitedReject.Type))
+	}
+	if err == nil && rj.VMap != nil {
+		err = add(SetVMap(rj.VMap))
+	}
+	if err == nil && rj.MSSClamp != nil {
+		if rj.MSSClamp.PMTU {
+			err = add(SetTCPMSSClampToPMTU())
+		} else {
+			err = add(SetTCPMSSClamp(rj.MSSClamp.MSS))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(actions) != 1 {
+		return fmt.Errorf("rule action must carry exactly one action, found %d", len(actions))
+	}
+	*ra = *actions[0]
+
+	return nil
+}
+
+func verdictChain(v *verdictJSON) []string {
+	if v.Chain == "" {
+		return nil
+	}
+
+	return []string{v.Chain}
+}
+
+func unmarshalNAT(nj *natJSON) (*RuleAction, error) {
+	n := &nat{
+		random:      nj.Random,
+		fullyRandom: nj.FullyRandom,
+		persistent:  nj.Persistent,
+		address:     nj.Address,
+		port:        nj.Port,
+	}
+	switch nj.Type {
+	case "snat":
+		n.nattype = expr.NATTypeSourceNAT
+	case "dnat":
+		n.nattype = expr.NATTypeDestNAT
+	default:
+		return nil, fmt.Errorf("unsupported nat type %s", nj.Type)
+	}
+	if n.address == nil && n.port == nil {
+		return nil, fmt.Errorf("either ip address or port must be specified")
+	}
+
+	return &RuleAction{nat: n}, nil
+}
+
+var chainHookNames = map[string]nftables.ChainHook{
+	"prerouting":  *nftables.ChainHookPrerouting,
+	"input":       *nftables.ChainHookInput,
+	"forward":     *nftables.ChainHookForward,
+	"output":      *nftables.ChainHookOutput,
+	"postrouting": *nftables.ChainHookPostrouting,
+	"ingress":     *nftables.ChainHookIngress,
+}
+
+type chainAttributesJSON struct {
+	Type     nftables.ChainType      `json:"type"`
+	Hook     string                  `json:"hook,omitempty"`
+	Priority *nftables.ChainPriority `json:"priority,omitempty"`
+	Device   string                  `json:"device,omitempty"`
+	Policy   string                  `json:"policy,omitempty"`
+}
+
+// MarshalJSON encodes ChainAttributes, hook and policy are encoded by their names,
+// example: {"type":"filter","hook":"input","priority":0,"policy":"drop"}
+func (cha ChainAttributes) MarshalJSON() ([]byte, error) {
+	cj := chainAttributesJSON{
+		Type:     cha.Type,
+		Priority: cha.Priority,
+		Device:   cha.Device,
+	}
+	if cha.Hook != nil {
+		switch {
+		case cha.Device != "" && *cha.Hook == *nftables.ChainHookIngress:
+			// Ingress hook of netdev family shares the value with prerouting hook
+			cj.Hook = "ingress"
+		default:
+			for name, h := range chainHookNames {
+				if h == *cha.Hook && name != "ingress" {
+					cj.Hook = name
+					break
+				}
+			}
+		}
+		if cj.Hook == "" {
+			return nil, fmt.Errorf("unsupported chain hook %d", *cha.Hook)
+		}
+	}
+	if cha.Policy != nil {
+		switch *cha.Policy {
+		case ChainPolicyAccept:
+			cj.Policy = "accept"
+		case ChainPolicyDrop:
+			cj.Policy = "drop"
+		default:
+			return nil, fmt.Errorf("unsupported chain policy %d", *cha.Policy)
+		}
+	}
+
+	return json.Marshal(cj)
+}
+
+// UnmarshalJSON decodes ChainAttributes encoded by MarshalJSON
+func (cha *ChainAttributes) UnmarshalJSON(data []byte) error {
+	var cj chainAttributesJSON
+	if err := json.Unmarshal(data, &cj); err != nil {
+		return err
+	}
+	n := ChainAttributes{
+		Type:     cj.Type,
+		Priority: cj.Priority,
+		Device:   cj.Device,
+	}
+	if cj.Hook != "" {
+		h, ok := chainHookNames[strings.ToLower(cj.Hook)]
+		if !ok {
+			return fmt.Errorf("unsupported chain hook %s", cj.Hook)
+		}
+		n.Hook = nftables.ChainHookRef(h)
+	}
+	if cj.Policy != "" {
+		var p ChainPolicy
+		switch strings.ToLower(cj.Policy) {
+		case "accept":
+			p = ChainPolicyAccept
+		case "drop":
+			p = ChainPolicyDrop
+		default:
+			return fmt.Errorf("unsupported chain policy %s", cj.Policy)
+		}
+		n.Policy = &p
+	}
+	*cha = n
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package nftableslib
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestRuleJSON(t *testing.T) {
+	ipv4Addr := setIPAddr(t, "192.0.2.0/24")
+	port := uint16(8080)
+	snat, err := SetSNAT(&NATAttributes{L3Addr: [2]*IPAddr{setIPAddr(t, "198.51.100.1")}, Port: [2]uint16{1024, 2048}})
+	if err != nil {
+		t.Fatalf("failed to SetSNAT with error: %+v", err)
+	}
+	masq, err := SetMasqToPort(1024, 2048)
+	if err != nil {
+		t.Fatalf("failed to SetMasqToPort with error: %+v", err)
+	}
+	pw, err := SetPayloadWrite(&PayloadSpec{
+		Base:   expr.PayloadBaseTransportHeader,
+		Offset: 2,
+		Len:    2,
+		Value:  []byte{0x1f, 0x90},
+	}, unix.IPPROTO_TCP)
+	if err != nil {
+		t.Fatalf("failed to SetPayloadWrite with error: %+v", err)
+	}
+	zone, err := SetCtZone(5)
+	if err != nil {
+		t.Fatalf("failed to SetCtZone with error: %+v", err)
+	}
+	tests := []struct {
+		name string
+		rule *Rule
+	}{
+		{
+			name: "L3 with jump",
+			rule: &Rule{
+				L3: &L3Rule{
+					Src: &IPAddrSpec{
+						List: []*IPAddr{ipv4Addr},
+					},
+					Protocol: L3Protocol(unix.IPPROTO_TCP),
+				},
+				Action: setActionVerdict(t, unix.NFT_JUMP, "fake-chain-1"),
+			},
+		},
+		{
+			name: "L4 with redirect",
+			rule: &Rule{
+				L4: &L4Rule{
+					L4Proto: unix.IPPROTO_TCP,
+					Dst: &Port{
+						List: []*uint16{&port},
+					},
+				},
+				Action: setActionRedirect(t, 15001, true),
+			},
+		},
+		{
+			name: "SNAT",
+			rule: &Rule{
+				L3: &L3Rule{
+					Src: &IPAddrSpec{
+						List: []*IPAddr{ipv4Addr},
+					},
+				},
+				Action: snat,
+			},
+		},
+		{
+			name: "Masquerade",
+			rule: &Rule{
+				Meta:   &Meta{Mark: &MetaMark{Value: 0x10}},
+				Action: masq,
+			},
+		},
+		{
+			name: "Payload write",
+			rule: &Rule{
+				Counter: &Counter{},
+				Action:  pw,
+			},
+		},
+		{
+			name: "Ct zone with comment",
+			rule: &Rule{
+				Probability: &ProbabilitySpec{Percent: 10},
+				Action:      zone,
+				UserData:    MakeRuleComment("zone-5"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.rule)
+		if err != nil {
+			t.Errorf("Test \"%s\" failed to marshal with error: %+v", tt.name, err)
+			continue
+		}
+		r := &Rule{}
+		if err := json.Unmarshal(b, r); err != nil {
+			t.Errorf("Test \"%s\" failed to unmarshal %s with error: %+v", tt.name, string(b), err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.rule, r) {
+			t.Errorf("Test \"%s\" unmarshaled rule does not match original rule, json: %s", tt.name, string(b))
+		}
+	}
+}
+
+func TestRuleActionJSONMultipleActions(t *testing.T) {
+	ra := &RuleAction{}
+	if err := json.Unmarshal([]byte(`{"verdict":{"kind":1},"reject":{"type":0,"code":0}}`), ra); err == nil {
+		t.Errorf("unmarshal of rule action with two actions supposed to fail but succeeded")
+	}
+}
+
+func TestLoadFromJSON(t *testing.T) {
+	data := []byte(`{"tables":[{"name":"filter","family":2,"chains":[
+		{"name":"input","attributes":{"type":"filter","hook":"input","priority":0,"policy":"drop"},
+		 "rules":[{"l3":{"src":{"List":["192.0.2.0/24"]}},"action":{"verdict":{"kind":1}}}]},
+		{"name":"regular"}]}]}`)
+	rs, err := LoadFromJSON(data)
+	if err != nil {
+		t.Fatalf("failed to load ruleset with error: %+v", err)
+	}
+	drop := ChainPolicyDrop
+	accept := setActionVerdict(t, NFT_ACCEPT)
+	expect := &Ruleset{
+		Tables: []*TableSpec{
+			{
+				Name:   "filter",
+				Family: nftables.TableFamilyIPv4,
+				Chains: []*ChainSpec{
+					{
+						Name: "input",
+						Attributes: &ChainAttributes{
+							Type:     nftables.ChainTypeFilter,
+							Hook:     nftables.ChainHookInput,
+							Priority: nftables.ChainPriorityFilter,
+							Policy:   &drop,
+						},
+						Rules: []*Rule{
+							{
+								L3: &L3Rule{
+									Src: &IPAddrSpec{
+										List: []*IPAddr{setIPAddr(t, "192.0.2.0/24")},
+									},
+								},
+								Action: accept,
+							},
+						},
+					},
+					{
+						Name: "regular",
+					},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(rs, expect) {
+		t.Errorf("loaded ruleset does not match expected ruleset")
+	}
+	b, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatalf("failed to marshal ruleset with error: %+v", err)
+	}
+	if _, err := LoadFromJSON(b); err != nil {
+		t.Errorf("failed to load marshaled ruleset with error: %+v", err)
+	}
+	// Rule with an ipv6 address in ipv4 table must fail validation
+	if _, err := LoadFromJSON([]byte(`{"tables":[{"name":"filter","family":2,"chains":[
+		{"name":"regular","rules":[{"l3":{"src":{"List":["2001:db8::1"]}}}]}]}]}`)); err == nil {
+		t.Errorf("load of invalid ruleset supposed to fail but succeeded")
+	}
+}
@@ -1,8 +1,15 @@
 package nftableslib
 
 import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
 	"golang.org/x/sys/unix"
 )
 
@@ -33,16 +40,51 @@ func setIPAddr(t *testing.T, addr string) *IPAddr {
 func TestRule(t *testing.T) {
 	//	ipv4Mask := uint8(24)
 	ipVersion := byte(4)
+	port := uint16(80)
+	snat, err := SetSNAT(&NATAttributes{L3Addr: [2]*IPAddr{setIPAddr(t, "192.0.2.1")}})
+	if err != nil {
+		t.Fatalf("failed to SetSNAT with error: %+v", err)
+	}
+	tcpReset, err := SetReject(unix.NFT_REJECT_TCP_RST, 0)
+	if err != nil {
+		t.Fatalf("failed to SetReject with error: %+v", err)
+	}
+	filterChain := &ChainAttributes{
+		Type:     nftables.ChainTypeFilter,
+		Hook:     nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityFilter,
+	}
+	natChain := &ChainAttributes{
+		Type:     nftables.ChainTypeNAT,
+		Hook:     nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+	}
 
 	tests := []struct {
-		name    string
-		rule    *Rule
-		success bool
+		name       string
+		rule       *Rule
+		family     nftables.TableFamily
+		chainAttrs *ChainAttributes
+		success    bool
 	}{
 		// TODO add more tests
 		{
 			name:    "Empty rule",
 			rule:    &Rule{},
+			success: false,
+		},
+		{
+			name: "Log only",
+			rule: &Rule{
+				Log: &Log{Key: unix.NFTA_LOG_PREFIX, Value: []byte("log-only")},
+			},
+			success: true,
+		},
+		{
+			name: "Counter only",
+			rule: &Rule{
+				Counter: &Counter{},
+			},
 			success: true,
 		},
 		{
@@ -101,10 +143,162 @@ func TestRule(t *testing.T) {
 			},
 			success: true,
 		},
+		{
+			name: "SNAT in nat chain",
+			rule: &Rule{
+				L3: &L3Rule{
+					Src: &IPAddrSpec{
+						List: []*IPAddr{setIPAddr(t, "10.0.0.0/8")},
+					},
+				},
+				Action: snat,
+			},
+			chainAttrs: natChain,
+			success:    true,
+		},
+		{
+			name: "SNAT in filter chain",
+			rule: &Rule{
+				L3: &L3Rule{
+					Src: &IPAddrSpec{
+						List: []*IPAddr{setIPAddr(t, "10.0.0.0/8")},
+					},
+				},
+				Action: snat,
+			},
+			chainAttrs: filterChain,
+			success:    false,
+		},
+		{
+			name: "TCP reset for tcp",
+			rule: &Rule{
+				L4: &L4Rule{
+					L4Proto: unix.IPPROTO_TCP,
+					Dst:     &Port{List: SetPortList([]int{int(port)})},
+				},
+				Action: tcpReset,
+			},
+			success: true,
+		},
+		{
+			name: "TCP reset for udp",
+			rule: &Rule{
+				L4: &L4Rule{
+					L4Proto: unix.IPPROTO_UDP,
+					Dst:     &Port{List: SetPortList([]int{int(port)})},
+				},
+				Action: tcpReset,
+			},
+			success: false,
+		},
+		{
+			name: "Port without L4Proto",
+			rule: &Rule{
+				L3: &L3Rule{
+					Version: &ipVersion,
+				},
+				L4: &L4Rule{
+					Dst: &Port{List: SetPortList([]int{int(port)})},
+				},
+				Action: setActionVerdict(t, NFT_ACCEPT),
+			},
+			success: false,
+		},
+		{
+			name: "IPv6 address in ipv4 table",
+			rule: &Rule{
+				L3: &L3Rule{
+					Src: &IPAddrSpec{
+						List: []*IPAddr{setIPAddr(t, "2001:db8::1")},
+					},
+				},
+				Action: setActionVerdict(t, NFT_ACCEPT),
+			},
+			success: false,
+		},
+		{
+			name: "IPv6 address in ipv6 table",
+			rule: &Rule{
+				L3: &L3Rule{
+					Src: &IPAddrSpec{
+						List: []*IPAddr{setIPAddr(t, "2001:db8::1")},
+					},
+				},
+				Action: setActionVerdict(t, NFT_ACCEPT),
+			},
+			family:  nftables.TableFamilyIPv6,
+			success: true,
+		},
+	}
+
+	for _, tt := range tests {
+		if tt.family == 0 {
+			tt.family = nftables.TableFamilyIPv4
+		}
+		err := tt.rule.Validate(tt.family, tt.chainAttrs)
+		if tt.success && err != nil {
+			t.Errorf("Test \"%s\" failed with error: \"%+v\" but supposed to succeed", tt.name, err)
+			continue
+		}
+		if !tt.success && err == nil {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+		}
 	}
+}
 
+func TestRequireNamedSets(t *testing.T) {
+	port1 := uint16(80)
+	port2 := uint16(443)
+	nfr := &nfRules{
+		opts:  Options{RequireNamedSets: true},
+		table: &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4},
+		chain: &nftables.Chain{Name: "input"},
+	}
+	tests := []struct {
+		name    string
+		rule    *Rule
+		success bool
+	}{
+		{
+			name: "Single address",
+			rule: &Rule{
+				L3: &L3Rule{
+					Src: &IPAddrSpec{
+						List: []*IPAddr{setIPAddr(t, "192.0.2.1")},
+					},
+				},
+				Action: setActionVerdict(t, NFT_ACCEPT),
+			},
+			success: true,
+		},
+		{
+			name: "Address list",
+			rule: &Rule{
+				L3: &L3Rule{
+					Src: &IPAddrSpec{
+						List: []*IPAddr{setIPAddr(t, "192.0.2.1"), setIPAddr(t, "192.0.2.2")},
+					},
+				},
+				Action: setActionVerdict(t, NFT_ACCEPT),
+			},
+			success: false,
+		},
+		{
+			name: "Port list",
+			rule: &Rule{
+				L4: &L4Rule{
+					L4Proto: unix.IPPROTO_TCP,
+					Dst: &Port{
+						List: []*uint16{&port1, &port2},
+					},
+				},
+				Action: setActionVerdict(t, NFT_ACCEPT),
+			},
+			success: false,
+		},
+	}
 	for _, tt := range tests {
-		err := tt.rule.Validate()
+		_, err := nfr.buildRule(tt.rule)
 		if tt.success && err != nil {
 			t.Errorf("Test \"%s\" failed with error: \"%+v\" but supposed to succeed", tt.name, err)
 			continue
@@ -114,3 +308,508 @@ func TestRule(t *testing.T) {
 		}
 	}
 }
+
+func TestGetRuleComment(t *testing.T) {
+	// Rule ID TLV is appended to user data when the rule is programmed
+	ud := append(MakeRuleComment("allow-dns"), 0x2, 0x2, 0x0, 0xb)
+	if c := getRuleComment(ud); c != "allow-dns" {
+		t.Errorf("getRuleComment returned \"%s\" want: \"allow-dns\"", c)
+	}
+	if c := getRuleComment([]byte{0x2, 0x2, 0x0, 0xb}); c != "" {
+		t.Errorf("getRuleComment returned \"%s\" for user data without comment", c)
+	}
+}
+
+func TestGetRules(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	conn := &applyConn{listConn: listConn{
+		rules: map[string][]*nftables.Rule{
+			"input": {
+				{Handle: 1, Exprs: []expr.Any{&expr.Counter{Packets: 10, Bytes: 1000}, &expr.Verdict{Kind: expr.VerdictAccept}}},
+				{Handle: 2, Exprs: []expr.Any{&expr.Quota{Bytes: 5000, Consumed: 200}}},
+				{Handle: 3, Exprs: []expr.Any{&expr.Limit{Type: expr.LimitTypePkts, Rate: 10, Unit: expr.LimitTimeSecond, Burst: 5}}},
+				{Handle: 4, Exprs: []expr.Any{&expr.Verdict{Kind: expr.VerdictDrop}}, UserData: []byte("drop")},
+			},
+		},
+	}}
+	ri := newRules(conn, tbl, chain, Options{})
+	rules, err := ri.Rules().GetRules()
+	if err != nil {
+		t.Fatalf("GetRules failed with error: %+v", err)
+	}
+	expect := []*Rule{
+		{Stats: &RuleStats{Counter: &expr.Counter{Packets: 10, Bytes: 1000}}, Handle: 1},
+		{Stats: &RuleStats{Quota: &expr.Quota{Bytes: 5000, Consumed: 200}}, Handle: 2},
+		{Stats: &RuleStats{Limit: &expr.Limit{Type: expr.LimitTypePkts, Rate: 10, Unit: expr.LimitTimeSecond, Burst: 5}}, Handle: 3},
+		{UserData: []byte("drop"), Handle: 4},
+	}
+	if !reflect.DeepEqual(rules, expect) {
+		t.Errorf("GetRules returned %+v want: %+v", rules, expect)
+	}
+	// Rules created by the library are returned as they were specified with the state read from the host
+	spec := &Rule{Counter: &Counter{}, Action: setActionVerdict(t, NFT_ACCEPT)}
+	id, err := ri.Rules().Create(spec)
+	if err != nil {
+		t.Fatalf("failed to create rule with error: %+v", err)
+	}
+	conn.rules["input"] = []*nftables.Rule{{Handle: 5, UserData: withRuleID(nil, id), Exprs: []expr.Any{&expr.Counter{Packets: 1, Bytes: 60}}}}
+	rules, err = ri.Rules().GetRules()
+	if err != nil {
+		t.Fatalf("GetRules failed with error: %+v", err)
+	}
+	want := spec.Clone()
+	want.Stats = &RuleStats{Counter: &expr.Counter{Packets: 1, Bytes: 60}}
+	want.Handle = 5
+	if len(rules) != 1 || !reflect.DeepEqual(rules[0], want) {
+		t.Errorf("GetRules returned %+v want: %+v", rules, want)
+	}
+	// Stats and Handle are not encoded
+	b, err := json.Marshal(rules[0])
+	if err != nil {
+		t.Fatalf("failed to marshal rule with error: %+v", err)
+	}
+	if strings.Contains(strings.ToLower(string(b)), "stats") || strings.Contains(strings.ToLower(string(b)), "handle") {
+		t.Errorf("encoded rule %s carries stats or handle", string(b))
+	}
+}
+
+func TestSetVerdict(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     int
+		chain   []string
+		success bool
+	}{
+		{name: "Accept", key: NFT_ACCEPT, success: true},
+		{name: "Continue", key: unix.NFT_CONTINUE, success: true},
+		{name: "Jump", key: unix.NFT_JUMP, chain: []string{"allowed"}, success: true},
+		{name: "Goto without chain", key: unix.NFT_GOTO, success: false},
+		{name: "Jump with empty chain", key: unix.NFT_JUMP, chain: []string{""}, success: false},
+		{name: "Jump with two chains", key: unix.NFT_JUMP, chain: []string{"a", "b"}, success: false},
+		{name: "Drop with chain", key: NFT_DROP, chain: []string{"allowed"}, success: false},
+		{name: "Unsupported key", key: 100, success: false},
+	}
+	for _, tt := range tests {
+		_, err := SetVerdict(tt.key, tt.chain...)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidVerdict) {
+			t.Errorf("Test \"%s\" error %+v is not ErrInvalidVerdict", tt.name, err)
+		}
+	}
+}
+
+func TestRuleExpressions(t *testing.T) {
+	port := uint16(22)
+	rule := Rule{
+		L4: &L4Rule{
+			L4Proto: unix.IPPROTO_TCP,
+			Dst:     &Port{List: []*uint16{&port}},
+		},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}
+	e, err := rule.Expressions(nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("Expressions failed with error: %+v", err)
+	}
+	if len(e) == 0 {
+		t.Fatalf("Expressions returned no expressions")
+	}
+	if v, ok := e[len(e)-1].(*expr.Verdict); !ok || v.Kind != expr.VerdictAccept {
+		t.Errorf("last expression %+v is not accept verdict", e[len(e)-1])
+	}
+	// Invalid rule must fail without touching the kernel
+	if _, err := (Rule{}).Expressions(nftables.TableFamilyIPv4); err == nil {
+		t.Errorf("Expressions of empty rule supposed to fail but succeeded")
+	}
+}
+
+func TestL3SrcAndDst(t *testing.T) {
+	rule := Rule{
+		L3: &L3Rule{
+			Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.0.2.1")}},
+			Dst: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "198.51.100.1")}},
+		},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}
+	e, err := rule.Expressions(nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("Expressions failed with error: %+v", err)
+	}
+	want := []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: []byte{0xff, 0xff, 0xff, 0xff}, Xor: []byte{0, 0, 0, 0}},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{192, 0, 2, 1}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 16, Len: 4},
+		&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: 4, Mask: []byte{0xff, 0xff, 0xff, 0xff}, Xor: []byte{0, 0, 0, 0}},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{198, 51, 100, 1}},
+	}
+	if len(e) != len(want)+1 || !reflect.DeepEqual(e[:len(want)], want) {
+		t.Errorf("expressions %+v do not match expected %+v", e, want)
+	}
+	// Invalid Dst must be detected even when Src is valid
+	rule.L3.Dst = &IPAddrSpec{}
+	if err := rule.Validate(nftables.TableFamilyIPv4, nil); err == nil {
+		t.Errorf("Validate of rule with invalid destination supposed to fail but succeeded")
+	}
+}
+
+func TestL3LinkLocalZone(t *testing.T) {
+	addr := setIPAddr(t, "fe80::/10%eth0")
+	if addr.Zone != "eth0" || *addr.Mask != 10 {
+		t.Fatalf("unexpected zone %s or mask %d of parsed address", addr.Zone, *addr.Mask)
+	}
+	rule := Rule{
+		L3:     &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{addr}}},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}
+	e, err := rule.Expressions(nftables.TableFamilyIPv6)
+	if err != nil {
+		t.Fatalf("Expressions failed with error: %+v", err)
+	}
+	name := make([]byte, unix.IFNAMSIZ)
+	copy(name, "eth0")
+	// ip6 saddr fe80::/10 iifname "eth0" accept
+	want := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: name},
+		&expr.Verdict{Kind: expr.VerdictAccept},
+	}
+	if len(e) < len(want) || !reflect.DeepEqual(e[len(e)-len(want):], want) {
+		t.Errorf("expressions %+v do not end with expected %+v", e, want)
+	}
+	// Numeric zone is the interface index, destination zone matches the output interface
+	rule.L3 = &L3Rule{Dst: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "fe80::1%2")}}}
+	if e, err = rule.Expressions(nftables.TableFamilyIPv6); err != nil {
+		t.Fatalf("Expressions failed with error: %+v", err)
+	}
+	want = []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyOIF, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(2)},
+		&expr.Verdict{Kind: expr.VerdictAccept},
+	}
+	if len(e) < len(want) || !reflect.DeepEqual(e[len(e)-len(want):], want) {
+		t.Errorf("expressions %+v do not end with expected %+v", e, want)
+	}
+	b, err := json.Marshal(addr)
+	if err != nil {
+		t.Fatalf("failed to marshal address with error: %+v", err)
+	}
+	if string(b) != `"fe80::/10%eth0"` {
+		t.Errorf("address marshaled to %s", string(b))
+	}
+	for _, a := range []string{"2001:db8::1%eth0", "192.168.1.1%eth0", "fe80::1%"} {
+		if _, err := NewIPAddr(a); err == nil {
+			t.Errorf("NewIPAddr succeeded with %s but supposed to fail", a)
+		}
+	}
+	mixed := &IPAddrSpec{List: []*IPAddr{addr, setIPAddr(t, "fe80::1")}}
+	if err := mixed.Validate(); err == nil {
+		t.Errorf("Validate succeeded with addresses of different zones but supposed to fail")
+	}
+}
+
+func TestL4SrcAndDst(t *testing.T) {
+	from, to, https := uint16(1024), uint16(65535), uint16(443)
+	rule := Rule{
+		L4: &L4Rule{
+			L4Proto: unix.IPPROTO_TCP,
+			Src:     &Port{Range: [2]*uint16{&from, &to}},
+			Dst:     &Port{List: []*uint16{&https}},
+		},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}
+	e, err := rule.Expressions(nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("Expressions failed with error: %+v", err)
+	}
+	// tcp sport 1024-65535 tcp dport 443 accept
+	want := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpGte, Register: 1, Data: []byte{0x04, 0x00}},
+		&expr.Cmp{Op: expr.CmpOpLte, Register: 1, Data: []byte{0xff, 0xff}},
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{0x01, 0xbb}},
+		&expr.Verdict{Kind: expr.VerdictAccept},
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("expressions %+v do not match expected %+v", e, want)
+	}
+}
+
+func TestL4HeaderFields(t *testing.T) {
+	port := uint16(53)
+	tests := []struct {
+		name    string
+		l4      *L4Rule
+		expect  []expr.Any
+		success bool
+	}{
+		{
+			name: "UDP length mismatch",
+			l4:   &L4Rule{L4Proto: unix.IPPROTO_UDP, UDPLength: &L4Value{Value: 28, RelOp: NEQ}},
+			expect: []expr.Any{
+				&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_UDP}},
+				&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 4, Len: 2},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: []byte{0x00, 0x1c}},
+			},
+			success: true,
+		},
+		{
+			name: "TCP data offset with port",
+			l4: &L4Rule{
+				L4Proto:       unix.IPPROTO_TCP,
+				Dst:           &Port{List: []*uint16{&port}},
+				TCPDataOffset: &L4Value{Value: 5, RelOp: GT},
+			},
+			success: true,
+		},
+		{
+			name:    "UDP length with tcp protocol",
+			l4:      &L4Rule{L4Proto: unix.IPPROTO_TCP, UDPLength: &L4Value{Value: 28}},
+			success: false,
+		},
+		{
+			name:    "TCP data offset with udp protocol",
+			l4:      &L4Rule{L4Proto: unix.IPPROTO_UDP, TCPDataOffset: &L4Value{Value: 5}},
+			success: false,
+		},
+		{
+			name:    "TCP data offset out of range",
+			l4:      &L4Rule{L4Proto: unix.IPPROTO_TCP, TCPDataOffset: &L4Value{Value: 16}},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		rule := Rule{L4: tt.l4, Action: setActionVerdict(t, NFT_DROP)}
+		e, err := rule.Expressions(nftables.TableFamilyIPv4)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if !tt.success {
+			continue
+		}
+		if tt.expect != nil && !reflect.DeepEqual(e[:len(tt.expect)], tt.expect) {
+			t.Errorf("Test \"%s\" expressions %+v do not match expected %+v", tt.name, e, tt.expect)
+		}
+		if tt.l4.TCPDataOffset != nil {
+			cmp, ok := e[len(e)-2].(*expr.Cmp)
+			if !ok || cmp.Op != expr.CmpOpGt || !reflect.DeepEqual(cmp.Data, []byte{0x50}) {
+				t.Errorf("Test \"%s\" unexpected data offset comparison %+v", tt.name, e[len(e)-2])
+			}
+		}
+	}
+}
+
+func TestDeleteRulesWhere(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	conn := &applyConn{}
+	ri := newRules(conn, tbl, chain, Options{})
+	port := uint16(22)
+	if _, err := ri.Rules().Create(&Rule{
+		L4: &L4Rule{
+			L4Proto: unix.IPPROTO_TCP,
+			Dst:     &Port{List: []*uint16{&port}},
+		},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}); err != nil {
+		t.Fatalf("failed to create rule with error: %+v", err)
+	}
+	// Rule created by the library is found by its ID, the other rules are programmed by other means
+	conn.rules = map[string][]*nftables.Rule{
+		"input": {
+			{Handle: 1, UserData: conn.addedRules[0].UserData},
+			{Handle: 2, UserData: MakeRuleComment("tenant-a")},
+			{Handle: 3, UserData: MakeRuleComment("infra")},
+		},
+	}
+	n, err := ri.Rules().DeleteRulesWhere(func(r *Rule) bool {
+		return r.L4 != nil || getRuleComment(r.UserData) == "tenant-a"
+	})
+	if err != nil {
+		t.Fatalf("DeleteRulesWhere failed with error: %+v", err)
+	}
+	if n != 2 || !reflect.DeepEqual(conn.delRules, []uint64{1, 2}) || conn.flushes != 1 {
+		t.Errorf("expected rules 1 and 2 deleted in a single batch, got %d rules %v and %d flushes", n, conn.delRules, conn.flushes)
+	}
+	if ri.(*nfRules).countRules() != 0 {
+		t.Errorf("deleted rule was not removed from the store")
+	}
+}
+
+func TestUpsertRule(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	conn := &applyConn{}
+	ri := newRules(conn, tbl, chain, Options{})
+	port := uint16(22)
+	rule := &Rule{
+		L4: &L4Rule{
+			L4Proto: unix.IPPROTO_TCP,
+			Dst:     &Port{List: []*uint16{&port}},
+		},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}
+	// No rule carries the tag, the rule is added and tagged
+	if err := ri.Rules().UpsertRule("ssh", rule); err != nil {
+		t.Fatalf("UpsertRule failed with error: %+v", err)
+	}
+	if len(conn.addedRules) != 1 || conn.addedRules[0].Handle != 0 || getRuleComment(conn.addedRules[0].UserData) != "ssh" {
+		t.Fatalf("expected tagged rule to be added, got %+v", conn.addedRules)
+	}
+	// Rule created by the library is replaced in place and the store keeps a single rule
+	conn.rules = map[string][]*nftables.Rule{
+		"input": {
+			{Handle: 5, UserData: MakeRuleComment("other")},
+			{Handle: 9, UserData: conn.addedRules[0].UserData},
+		},
+	}
+	port = 2222
+	if err := ri.Rules().UpsertRule("ssh", rule); err != nil {
+		t.Fatalf("UpsertRule failed with error: %+v", err)
+	}
+	if len(conn.addedRules) != 2 || conn.addedRules[1].Handle != 9 {
+		t.Errorf("expected rule with handle 9 to be replaced, got %+v", conn.addedRules)
+	}
+	if ri.(*nfRules).countRules() != 1 {
+		t.Errorf("replaced rule must not be duplicated in the store")
+	}
+	// Tagged rule programmed by other means is replaced by its handle
+	conn.rules = map[string][]*nftables.Rule{
+		"input": {{Handle: 12, UserData: MakeRuleComment("http")}},
+	}
+	if err := ri.Rules().UpsertRule("http", rule); err != nil {
+		t.Fatalf("UpsertRule failed with error: %+v", err)
+	}
+	if len(conn.addedRules) != 3 || conn.addedRules[2].Handle != 12 {
+		t.Errorf("expected rule with handle 12 to be replaced, got %+v", conn.addedRules)
+	}
+	// Rule carrying a different comment cannot be tagged
+	tagged := *rule
+	tagged.UserData = MakeRuleComment("dns")
+	if err := ri.Rules().UpsertRule("ssh", &tagged); err == nil {
+		t.Errorf("UpsertRule of rule with different comment supposed to fail but succeeded")
+	}
+}
+
+func TestSyncPreservesIdentity(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	chain := &nftables.Chain{Name: "input", Table: tbl}
+	conn := &applyConn{}
+	ri := newRules(conn, tbl, chain, Options{})
+	ports := []uint16{22, 80}
+	ids := []uint32{}
+	for i := range ports {
+		id, err := ri.Rules().Create(&Rule{
+			L4: &L4Rule{
+				L4Proto: unix.IPPROTO_TCP,
+				Dst:     &Port{List: []*uint16{&ports[i]}},
+			},
+			Action: setActionVerdict(t, NFT_ACCEPT),
+		})
+		if err != nil {
+			t.Fatalf("failed to create rule with error: %+v", err)
+		}
+		ids = append(ids, id)
+	}
+	// The first rule is recognized by the rule ID in user data, the second one by its expressions
+	// and the third one is programmed by other means
+	conn.rules = map[string][]*nftables.Rule{
+		"input": {
+			{Handle: 4, UserData: conn.addedRules[0].UserData, Exprs: conn.addedRules[0].Exprs},
+			{Handle: 5, Exprs: conn.addedRules[1].Exprs, UserData: stripRuleID(conn.addedRules[1].UserData)},
+			{Handle: 6, UserData: MakeRuleComment("other")},
+		},
+	}
+	nfr := ri.(*nfRules)
+	if err := nfr.Sync(); err != nil {
+		t.Fatalf("Sync failed with error: %+v", err)
+	}
+	rules := nfr.dumpRules()
+	if len(rules) != 3 {
+		t.Fatalf("expected 3 rules in the store after Sync, got %d", len(rules))
+	}
+	for i, id := range ids {
+		if rules[i].id != id || rules[i].spec == nil || rules[i].rule.Handle != uint64(4+i) {
+			t.Errorf("rule %d lost its identity after Sync, id: %d handle: %d", i, rules[i].id, rules[i].rule.Handle)
+		}
+	}
+	if rules[2].spec != nil || rules[2].id == ids[0] || rules[2].id == ids[1] {
+		t.Errorf("rule programmed by other means must get a new identity")
+	}
+	// Rules are recognized by handles, the rule removed from the host is removed from the store
+	conn.rules["input"] = []*nftables.Rule{conn.rules["input"][2], conn.rules["input"][0]}
+	if err := nfr.Sync(); err != nil {
+		t.Fatalf("Sync failed with error: %+v", err)
+	}
+	rules = nfr.dumpRules()
+	if len(rules) != 2 || rules[0].rule.Handle != 6 || rules[1].id != ids[0] || rules[1].prev != rules[0] {
+		t.Errorf("store does not follow the chain after Sync")
+	}
+}
+
+func TestSetReturn(t *testing.T) {
+	ra, err := SetReturn()
+	if err != nil {
+		t.Fatalf("SetReturn failed with error: %+v", err)
+	}
+	rule := Rule{
+		L3:     &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.0.2.0/24")}}},
+		Action: ra,
+	}
+	if err := rule.Validate(nftables.TableFamilyIPv4, nil); err != nil {
+		t.Errorf("return in a regular chain failed with error: %+v but supposed to succeed", err)
+	}
+	e, err := rule.Expressions(nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("Expressions failed with error: %+v", err)
+	}
+	if v, ok := e[len(e)-1].(*expr.Verdict); !ok || v.Kind != expr.VerdictReturn {
+		t.Errorf("last expression %+v is not return verdict", e[len(e)-1])
+	}
+	base := &ChainAttributes{
+		Type:     nftables.ChainTypeFilter,
+		Hook:     nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+	}
+	if err := rule.Validate(nftables.TableFamilyIPv4, base); !errors.Is(err, ErrInvalidVerdict) {
+		t.Errorf("return in a base chain returned error %v, want: %v", err, ErrInvalidVerdict)
+	}
+	if err := rule.Clone().Validate(nftables.TableFamilyIPv4, base); err == nil {
+		t.Errorf("clone of return in a base chain supposed to fail but succeeded")
+	}
+	b, err := json.Marshal(ra)
+	if err != nil {
+		t.Fatalf("failed to marshal action with error: %+v", err)
+	}
+	decoded := &RuleAction{}
+	if err := json.Unmarshal(b, decoded); err != nil {
+		t.Fatalf("failed to unmarshal action with error: %+v", err)
+	}
+	if !reflect.DeepEqual(decoded, ra) {
+		t.Errorf("decoded action %+v does not match %+v", decoded, ra)
+	}
+	// Return built by SetVerdict is not restricted
+	rule.Action = setActionVerdict(t, unix.NFT_RETURN)
+	if err := rule.Validate(nftables.TableFamilyIPv4, base); err != nil {
+		t.Errorf("return verdict in a base chain failed with error: %+v but supposed to succeed", err)
+	}
+}
@@ -0,0 +1,526 @@
+package nftableslib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// Ruleset defines a declarative model of tables, their chains and chains' rules, it can be
+// serialized to and loaded from JSON.
+type Ruleset struct {
+	Tables []*TableSpec `json:"tables"`
+}
+
+// TableSpec defines a table of a Ruleset
+type TableSpec struct {
+	Name   string               `json:"name"`
+	Family nftables.TableFamily `json:"family"`
+	Chains []*ChainSpec         `json:"chains,omitempty"`
+}
+
+// ChainSpec defines a chain of a TableSpec, nil Attributes define a regular chain
+type ChainSpec struct {
+	Name       string           `json:"name"`
+	Attributes *ChainAttributes `json:"attributes,omitempty"`
+	Rules      []*Rule          `json:"rules,omitempty"`
+}
+
+// Validate checks Ruleset for duplicate tables and chains and validates chains' rules
+func (rs *Ruleset) Validate() error {
+	tables := make(map[string]bool)
+	for _, t := range rs.Tables {
+		if t == nil {
+			return fmt.Errorf("table cannot be nil")
+		}
+		if t.Name == "" {
+			return fmt.Errorf("table name cannot be empty")
+		}
+		key := fmt.Sprintf("%s/%d", t.Name, t.Family)
+		if tables[key] {
+			return fmt.Errorf("duplicate table %s of family %d", t.Name, t.Family)
+		}
+		tables[key] = true
+		chains := make(map[string]bool)
+		for _, c := range t.Chains {
+			if c == nil {
+				return fmt.Errorf("chain in table %s cannot be nil", t.Name)
+			}
+			if c.Name == "" {
+				return fmt.Errorf("chain name in table %s cannot be empty", t.Name)
+			}
+			if chains[c.Name] {
+				return fmt.Errorf("duplicate chain %s in table %s", c.Name, t.Name)
+			}
+			chains[c.Name] = true
+			if c.Attributes != nil {
+				if err := c.Attributes.Validate(); err != nil {
+					return fmt.Errorf("chain %s in table %s: %+v", c.Name, t.Name, err)
+				}
+			}
+			for i, r := range c.Rules {
+				if r == nil {
+					return fmt.Errorf("rule %d of chain %s in table %s cannot be nil", i, c.Name, t.Name)
+				}
+				if err := r.Validate(t.Family, c.Attributes); err != nil {
+					return fmt.Errorf("rule %d of chain %s in table %s: %+v", i, c.Name, t.Name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// LoadFromJSON decodes and validates Ruleset serialized with json.Marshal
+func LoadFromJSON(data []byte) (*Ruleset, error) {
+	rs := &Ruleset{}
+	if err := json.Unmarshal(data, rs); err != nil {
+		return nil, err
+	}
+	if err := rs.Validate(); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// Diff describes changes made by ApplyRuleset. Tables are identified as "family table", chains as
+// "family table chain", added rules by the chain and the rule's index in the desired chain and deleted
+// rules by the chain and the rule's handle.
+type Diff struct {
+	AddedTables   []string
+	DeletedTables []string
+	AddedChains   []string
+	UpdatedChains []string
+	DeletedChains []string
+	AddedRules    []string
+	DeletedRules  []string
+}
+
+// IsEmpty returns true if ApplyRuleset did not change anything
+func (d *Diff) IsEmpty() bool {
+	return len(d.AddedTables) == 0 && len(d.DeletedTables) == 0 && len(d.AddedChains) == 0 &&
+		len(d.UpdatedChains) == 0 && len(d.DeletedChains) == 0 && len(d.AddedRules) == 0 && len(d.DeletedRules) == 0
+}
+
+type tablePlan struct {
+	spec      *TableSpec
+	add       bool
+	chains    []*chainPlan
+	delChains []*nftables.Chain
+	// delChainRules carries rules of chains to delete, rules are removed before chains
+	// to release references between deleted chains.
+	delChainRules []*nftables.Rule
+}
+
+type chainPlan struct {
+	spec   *ChainSpec
+	add    bool
+	update bool
+	// keep is the number of leading rules already programmed as desired
+	keep     int
+	delRules []*nftables.Rule
+}
+
+// ApplyRuleset reconciles tables managed by the library with the desired Ruleset in a single transaction.
+// Missing tables, chains and rules are added, policies of base chains are updated, chains of desired tables
+// and tables previously created by the library which are not in the desired Ruleset are deleted. Rules of
+// a chain are compared in order, leading rules matching the desired rules are kept, the rest are replaced.
+// Tables which were not created or synced by the library are never deleted. If type, hook or priority of
+// an existing chain differs from the desired one, an error is returned and nothing is changed. All chains and
+// rules are validated before anything is queued, an invalid chain or rule leaves the connection and the store as they were.
+// If the transaction fails, the library's store can be refreshed with Sync.
+func (nft *nfTables) ApplyRuleset(desired *Ruleset) (*Diff, error) {
+	if desired == nil {
+		return nil, fmt.Errorf("desired ruleset cannot be nil")
+	}
+	if err := desired.Validate(); err != nil {
+		return nil, err
+	}
+	hostTables, err := nft.conn.ListTables()
+	if err != nil {
+		return nil, err
+	}
+	hostChains, err := nft.conn.ListChains()
+	if err != nil {
+		return nil, err
+	}
+	// Computing the difference first, the host is not changed until everything is validated
+	plans := make([]*tablePlan, 0, len(desired.Tables))
+	wanted := make(map[nftables.TableFamily]map[string]bool)
+	for _, ts := range desired.Tables {
+		if _, ok := wanted[ts.Family]; !ok {
+			wanted[ts.Family] = make(map[string]bool)
+		}
+		wanted[ts.Family][ts.Name] = true
+		tp, err := nft.planTable(ts, hostTables, hostChains)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, tp)
+	}
+	delTables := make([]*nftables.Table, 0)
+	nft.Lock()
+	for family, tables := range nft.tables {
+		for name := range tables {
+			if !wanted[family][name] && findTable(hostTables, name, family) != nil {
+				delTables = append(delTables, &nftables.Table{Name: name, Family: family})
+			}
+		}
+	}
+	nft.Unlock()
+
+	// Validating chains of all tables before anything is queued on the connection or changed in the store
+	for _, tp := range plans {
+		if err := nft.prepareTable(tp); err != nil {
+			return nil, err
+		}
+	}
+	diff := &Diff{}
+	for _, tp := range plans {
+		if err := nft.applyTable(tp, diff); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range delTables {
+		if err := nft.Delete(t.Name, t.Family); err != nil {
+			return nil, err
+		}
+		diff.DeletedTables = append(diff.DeletedTables, tableID(t))
+	}
+	if diff.IsEmpty() {
+		return diff, nil
+	}
+	if err := nft.conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+func (nft *nfTables) planTable(ts *TableSpec, hostTables []*nftables.Table, hostChains []*nftables.Chain) (*tablePlan, error) {
+	tp := &tablePlan{spec: ts}
+	t := &nftables.Table{Name: ts.Name, Family: ts.Family}
+	if findTable(hostTables, ts.Name, ts.Family) == nil {
+		tp.add = true
+	}
+	existing := make(map[string]*nftables.Chain)
+	for _, c := range hostChains {
+		if c.Table != nil && c.Table.Name == ts.Name && c.Table.Family == ts.Family {
+			existing[c.Name] = c
+		}
+	}
+	for _, cs := range ts.Chains {
+		cp, err := nft.planChain(t, cs, existing[cs.Name])
+		if err != nil {
+			return nil, err
+		}
+		tp.chains = append(tp.chains, cp)
+		delete(existing, cs.Name)
+	}
+	for _, c := range existing {
+		rules, err := nft.conn.GetRule(t, c)
+		if err != nil {
+			return nil, err
+		}
+		tp.delChainRules = append(tp.delChainRules, rules...)
+		tp.delChains = append(tp.delChains, c)
+	}
+
+	return tp, nil
+}
+
+func (nft *nfTables) planChain(t *nftables.Table, cs *ChainSpec, host *nftables.Chain) (*chainPlan, error) {
+	cp := &chainPlan{spec: cs}
+	chain := host
+	if host == nil {
+		cp.add = true
+		chain = &nftables.Chain{Name: cs.Name, Table: t}
+		if cs.Attributes != nil {
+			chain.Type = cs.Attributes.Type
+			chain.Hooknum = cs.Attributes.Hook
+			chain.Priority = cs.Attributes.Priority
+		}
+	} else {
+		if (host.Hooknum == nil) != (cs.Attributes == nil) {
+			return nil, fmt.Errorf("chain %s in table %s cannot be converted between base and regular chain", cs.Name, t.Name)
+		}
+		if cs.Attributes != nil {
+			if !isEqualHook(cs.Attributes.Hook, host.Hooknum) ||
+				!isEqualPriority(cs.Attributes.Priority, host.Priority) ||
+				cs.Attributes.Type != host.Type {
+				return nil, fmt.Errorf("type, hook or priority of chain %s in table %s cannot be changed", cs.Name, t.Name)
+			}
+			policy := nftables.ChainPolicyAccept
+			if cs.Attributes.Policy != nil {
+				policy = nftables.ChainPolicy(*cs.Attributes.Policy)
+			}
+			if host.Policy == nil || *host.Policy != policy {
+				cp.update = true
+			}
+		}
+	}
+	// Building desired rules without changing anything on the host
+	dry := &dryRunConn{
+		NetNS: nft.conn,
+		sets:  make(map[string]bool),
+	}
+	b := &nfRules{
+		conn:  dry,
+		opts:  nft.opts,
+		table: t,
+		chain: chain,
+	}
+	// Desired rules as they are programmed on the host, a rule with rate limited reject action is followed
+	// by the rule dropping packets over the limit, owner carries the index of the desired rule.
+	var exprs [][]expr.Any
+	var userData [][]byte
+	var owner []int
+	for i, rule := range cs.Rules {
+		rr, err := b.buildRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d of chain %s in table %s: %+v", i, cs.Name, t.Name, err)
+		}
+		exprs = append(exprs, rr.rule.Exprs)
+		userData = append(userData, rule.UserData)
+		owner = append(owner, i)
+		if rr.overLimit != nil {
+			exprs = append(exprs, rr.overLimit.rule.Exprs)
+			userData = append(userData, nil)
+			owner = append(owner, i)
+		}
+	}
+	if host == nil {
+		return cp, nil
+	}
+	rules, err := nft.conn.GetRule(t, host)
+	if err != nil {
+		return nil, err
+	}
+	keep := 0
+	for keep < len(exprs) && keep < len(rules) {
+		if !isEqualExprs(exprs[keep], rules[keep].Exprs, dry.sets) ||
+			!bytes.Equal(userData[keep], stripRuleID(rules[keep].UserData)) {
+			break
+		}
+		keep++
+	}
+	// A rule is kept only together with the rule dropping packets over its limit
+	for keep > 0 && keep < len(exprs) && owner[keep] == owner[keep-1] {
+		keep--
+	}
+	cp.keep = len(cs.Rules)
+	if keep < len(exprs) {
+		cp.keep = owner[keep]
+	}
+	cp.delRules = rules[keep:]
+
+	return cp, nil
+}
+
+// prepareTable validates chains of the plan against chains of the library's store, rules are already validated
+// by planChain. Nothing is queued on the connection, the store is only synced with the table and chains which
+// already exist on the host, hence applyTable does not fail after the first message is queued.
+func (nft *nfTables) prepareTable(tp *tablePlan) error {
+	var t *nfTable
+	nft.Lock()
+	if tp.add {
+		t = nft.tables[tp.spec.Family][tp.spec.Name]
+	} else {
+		t = nft.create(tp.spec.Name, tp.spec.Family)
+	}
+	nft.Unlock()
+	// chains carries chains of the table as they would be after the plan is applied
+	chains := make(map[string]*nfChain)
+	var nfc *nfChains
+	if t != nil {
+		var ok bool
+		if nfc, ok = t.ChainsInterface.(*nfChains); !ok {
+			return fmt.Errorf("table %s does not support reconciliation", tp.spec.Name)
+		}
+		nfc.Lock()
+		for name, ch := range nfc.chains {
+			chains[name] = ch
+		}
+		nfc.Unlock()
+	}
+	for _, c := range tp.delChains {
+		delete(chains, c.Name)
+	}
+	table := &nftables.Table{Name: tp.spec.Name, Family: tp.spec.Family}
+	for _, cp := range tp.chains {
+		attrs := cp.spec.Attributes
+		if cp.add {
+			if ch, ok := chains[cp.spec.Name]; ok && !isEqualChain(ch, attrs) {
+				return fmt.Errorf("nftableslib: chain %s already exist in table %s", cp.spec.Name, tp.spec.Name)
+			}
+			if err := validateChain(table, chains, cp.spec.Name, attrs); err != nil {
+				return err
+			}
+			ch := &nfChain{chain: &nftables.Chain{Name: cp.spec.Name, Table: table}}
+			if attrs != nil {
+				ch.chain.Hooknum = attrs.Hook
+				ch.chain.Priority = attrs.Priority
+				ch.chain.Device = attrs.Device
+			}
+			chains[cp.spec.Name] = ch
+			continue
+		}
+		// The chain exists on the host, hence the table is in the store
+		if err := nfc.SyncChain(cp.spec.Name); err != nil {
+			return err
+		}
+		nfc.Lock()
+		ch := nfc.chains[cp.spec.Name]
+		nfc.Unlock()
+		if _, ok := ch.RulesInterface.(*nfRules); !ok {
+			return fmt.Errorf("chain %s does not support reconciliation", cp.spec.Name)
+		}
+		if cp.update {
+			if err := nfc.checkUpdate(ch, attrs); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyTable queues the changes of the plan and applies them to the store, the plan must be validated
+// by prepareTable.
+func (nft *nfTables) applyTable(tp *tablePlan, diff *Diff) error {
+	nft.Lock()
+	t := nft.create(tp.spec.Name, tp.spec.Family)
+	if tp.add {
+		nft.conn.AddTable(t.table)
+	}
+	nft.Unlock()
+	if tp.add {
+		diff.AddedTables = append(diff.AddedTables, tableID(t.table))
+	}
+	nfc := t.ChainsInterface.(*nfChains)
+	for _, r := range tp.delChainRules {
+		if err := nft.conn.DelRule(r); err != nil {
+			return err
+		}
+	}
+	for _, c := range tp.delChains {
+		nfc.Lock()
+		delete(nfc.chains, c.Name)
+		nfc.Unlock()
+		nft.conn.DelChain(c)
+		diff.DeletedChains = append(diff.DeletedChains, chainID(c.Table, c.Name))
+	}
+	for _, cp := range tp.chains {
+		id := chainID(t.table, cp.spec.Name)
+		if cp.add {
+			if err := nfc.Create(cp.spec.Name, cp.spec.Attributes); err != nil {
+				return err
+			}
+			diff.AddedChains = append(diff.AddedChains, id)
+		}
+		nfc.Lock()
+		ch := nfc.chains[cp.spec.Name]
+		var err error
+		if cp.update {
+			err = nfc.update(ch, cp.spec.Attributes)
+		}
+		nfc.Unlock()
+		if err != nil {
+			return err
+		}
+		if cp.update {
+			diff.UpdatedChains = append(diff.UpdatedChains, id)
+		}
+		nfr := ch.RulesInterface.(*nfRules)
+		handles := make([]uint64, 0, len(cp.delRules))
+		for _, r := range cp.delRules {
+			handles = append(handles, r.Handle)
+			diff.DeletedRules = append(diff.DeletedRules, fmt.Sprintf("%s handle %d", id, r.Handle))
+		}
+		if err := nfr.deleteByHandles(handles); err != nil {
+			return err
+		}
+		for i := cp.keep; i < len(cp.spec.Rules); i++ {
+			if _, err := nfr.Create(cp.spec.Rules[i]); err != nil {
+				return err
+			}
+			diff.AddedRules = append(diff.AddedRules, fmt.Sprintf("%s rule %d", id, i))
+		}
+	}
+
+	return nil
+}
+
+// deleteByHandles removes rules with the handles from the host and from the store if the store carries them,
+// a rule dropping packets over the limit of rate limited reject action is removed together with its rule.
+func (nfr *nfRules) deleteByHandles(handles []uint64) error {
+	nfr.Lock()
+	defer nfr.Unlock()
+	deleted := make(map[uint64]bool)
+	for _, handle := range handles {
+		if deleted[handle] {
+			continue
+		}
+		deleted[handle] = true
+		if r, err := getRuleByHandle(nfr.rules, handle); err == nil {
+			if r.overLimit != nil {
+				deleted[r.overLimit.rule.Handle] = true
+			}
+			if err := nfr.delete(r.id); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := nfr.conn.DelRule(&nftables.Rule{
+			Table:  nfr.table,
+			Chain:  nfr.chain,
+			Handle: handle,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stripRuleID removes rule ID the library appends to the rule's user data
+func stripRuleID(ud []byte) []byte {
+	l := len(ud)
+	if l >= 4 && ud[l-4] == 0x2 && ud[l-3] == 2 {
+		return ud[:l-4]
+	}
+
+	return ud
+}
+
+func findTable(tables []*nftables.Table, name string, family nftables.TableFamily) *nftables.Table {
+	for _, t := range tables {
+		if t.Name == name && t.Family == family {
+			return t
+		}
+	}
+
+	return nil
+}
+
+var familyNames = map[nftables.TableFamily]string{
+	nftables.TableFamilyINet:   "inet",
+	nftables.TableFamilyIPv4:   "ip",
+	nftables.TableFamilyIPv6:   "ip6",
+	nftables.TableFamilyARP:    "arp",
+	nftables.TableFamilyNetdev: "netdev",
+	nftables.TableFamilyBridge: "bridge",
+}
+
+func tableID(t *nftables.Table) string {
+	return fmt.Sprintf("%s %s", familyNames[t.Family], t.Name)
+}
+
+func chainID(t *nftables.Table, name string) string {
+	return fmt.Sprintf("%s %s", tableID(t), name)
+}
@@ -0,0 +1,204 @@
+package nftableslib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/google/nftables"
+)
+
+// RulesetDiff describes differences between two Rulesets. Tables are identified as "family table", chains as
+// "family table chain" and rules by the chain and the rule's index, removed rules by the index in the original
+// Ruleset and added rules by the index in the new Ruleset. Changed tables are tables present in both Rulesets
+// with different chains or rules, changed chains are chains present in both with different attributes or rules.
+type RulesetDiff struct {
+	AddedTables   []string
+	RemovedTables []string
+	ChangedTables []string
+	AddedChains   []string
+	RemovedChains []string
+	ChangedChains []string
+	AddedRules    []string
+	RemovedRules  []string
+}
+
+// IsEmpty returns true if Rulesets do not differ
+func (d *RulesetDiff) IsEmpty() bool {
+	return len(d.AddedTables) == 0 && len(d.RemovedTables) == 0 && len(d.ChangedTables) == 0 &&
+		len(d.AddedChains) == 0 && len(d.RemovedChains) == 0 && len(d.ChangedChains) == 0 &&
+		len(d.AddedRules) == 0 && len(d.RemovedRules) == 0
+}
+
+// DiffRulesets computes changes turning Ruleset a into Ruleset b without communicating with the host, it allows
+// to present changes before applying b with ApplyRuleset. Rules of a chain are compared as sequences, rules
+// present in both chains in the same relative order are not reported. Nil Ruleset is treated as empty.
+func DiffRulesets(a, b *Ruleset) *RulesetDiff {
+	diff := &RulesetDiff{}
+	if a == nil {
+		a = &Ruleset{}
+	}
+	if b == nil {
+		b = &Ruleset{}
+	}
+	for _, tb := range b.Tables {
+		t := &nftables.Table{Name: tb.Name, Family: tb.Family}
+		ta := findTableSpec(a.Tables, tb.Name, tb.Family)
+		if ta == nil {
+			diff.AddedTables = append(diff.AddedTables, tableID(t))
+			for _, cb := range tb.Chains {
+				diff.AddedChains = append(diff.AddedChains, chainID(t, cb.Name))
+				diff.AddedRules = append(diff.AddedRules, ruleIDs(t, cb.Name, len(cb.Rules))...)
+			}
+			continue
+		}
+		if diffTable(diff, t, ta, tb) {
+			diff.ChangedTables = append(diff.ChangedTables, tableID(t))
+		}
+	}
+	for _, ta := range a.Tables {
+		if findTableSpec(b.Tables, ta.Name, ta.Family) != nil {
+			continue
+		}
+		t := &nftables.Table{Name: ta.Name, Family: ta.Family}
+		diff.RemovedTables = append(diff.RemovedTables, tableID(t))
+		for _, ca := range ta.Chains {
+			diff.RemovedChains = append(diff.RemovedChains, chainID(t, ca.Name))
+			diff.RemovedRules = append(diff.RemovedRules, ruleIDs(t, ca.Name, len(ca.Rules))...)
+		}
+	}
+
+	return diff
+}
+
+// diffTable records differences between chains of a table present in both Rulesets,
+// true is returned if the table has changed.
+func diffTable(diff *RulesetDiff, t *nftables.Table, ta, tb *TableSpec) bool {
+	changed := false
+	for _, cb := range tb.Chains {
+		ca := findChainSpec(ta.Chains, cb.Name)
+		if ca == nil {
+			changed = true
+			diff.AddedChains = append(diff.AddedChains, chainID(t, cb.Name))
+			diff.AddedRules = append(diff.AddedRules, ruleIDs(t, cb.Name, len(cb.Rules))...)
+			continue
+		}
+		removed, added := diffRules(ca.Rules, cb.Rules)
+		if len(removed) == 0 && len(added) == 0 && reflect.DeepEqual(ca.Attributes, cb.Attributes) {
+			continue
+		}
+		changed = true
+		diff.ChangedChains = append(diff.ChangedChains, chainID(t, cb.Name))
+		for _, i := range removed {
+			diff.RemovedRules = append(diff.RemovedRules, fmt.Sprintf("%s #%d", chainID(t, cb.Name), i))
+		}
+		for _, i := range added {
+			diff.AddedRules = append(diff.AddedRules, fmt.Sprintf("%s #%d", chainID(t, cb.Name), i))
+		}
+	}
+	for _, ca := range ta.Chains {
+		if findChainSpec(tb.Chains, ca.Name) != nil {
+			continue
+		}
+		changed = true
+		diff.RemovedChains = append(diff.RemovedChains, chainID(t, ca.Name))
+		diff.RemovedRules = append(diff.RemovedRules, ruleIDs(t, ca.Name, len(ca.Rules))...)
+	}
+
+	return changed
+}
+
+// diffRules returns indexes of rules removed from a and indexes of rules added to b,
+// rules of the longest common subsequence are considered unchanged.
+func diffRules(a, b []*Rule) ([]int, []int) {
+	ka := make([][]byte, len(a))
+	for i, r := range a {
+		ka[i] = ruleKey(r)
+	}
+	kb := make([][]byte, len(b))
+	for i, r := range b {
+		kb[i] = ruleKey(r)
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case ka[i] != nil && bytes.Equal(ka[i], kb[j]):
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	removed, added := []int{}, []int{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case ka[i] != nil && bytes.Equal(ka[i], kb[j]):
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			removed = append(removed, i)
+			i++
+		default:
+			added = append(added, j)
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		removed = append(removed, i)
+	}
+	for ; j < len(b); j++ {
+		added = append(added, j)
+	}
+
+	return removed, added
+}
+
+// ruleKey returns JSON representation of the rule used to compare rules, rules built in code and
+// rules loaded from JSON compare equal. Nil is returned if the rule cannot be marshaled, such rule
+// never compares equal.
+func ruleKey(r *Rule) []byte {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
+
+func ruleIDs(t *nftables.Table, chain string, n int) []string {
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ids = append(ids, fmt.Sprintf("%s #%d", chainID(t, chain), i))
+	}
+
+	return ids
+}
+
+func findTableSpec(tables []*TableSpec, name string, family nftables.TableFamily) *TableSpec {
+	for _, t := range tables {
+		if t.Name == name && t.Family == family {
+			return t
+		}
+	}
+
+	return nil
+}
+
+func findChainSpec(chains []*ChainSpec, name string) *ChainSpec {
+	for _, c := range chains {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+func TestDiffRulesets(t *testing.T) {
+	accept := ChainPolicyAccept
+	drop := ChainPolicyDrop
+	r1 := &Rule{L3: &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "192.0.2.0/24")}}}, Action: setActionVerdict(t, NFT_ACCEPT)}
+	r2 := &Rule{L3: &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "198.51.100.0/24")}}}, Action: setActionVerdict(t, NFT_DROP)}
+	r3 := &Rule{L3: &L3Rule{Src: &IPAddrSpec{List: []*IPAddr{setIPAddr(t, "203.0.113.0/24")}}}, Action: setActionVerdict(t, unix.NFT_JUMP, "regular")}
+	input := func(policy *ChainPolicy, rules ...*Rule) *ChainSpec {
+		return &ChainSpec{
+			Name: "input",
+			Attributes: &ChainAttributes{
+				Type:     nftables.ChainTypeFilter,
+				Hook:     nftables.ChainHookInput,
+				Priority: nftables.ChainPriorityFilter,
+				Policy:   policy,
+			},
+			Rules: rules,
+		}
+	}
+	tests := []struct {
+		name   string
+		a      *Ruleset
+		b      *Ruleset
+		expect *RulesetDiff
+	}{
+		{
+			name:   "Identical rulesets",
+			a:      &Ruleset{Tables: []*TableSpec{{Name: "filter", Family: nftables.TableFamilyIPv4, Chains: []*ChainSpec{input(&accept, r1, r2)}}}},
+			b:      &Ruleset{Tables: []*TableSpec{{Name: "filter", Family: nftables.TableFamilyIPv4, Chains: []*ChainSpec{input(&accept, r1, r2)}}}},
+			expect: &RulesetDiff{},
+		},
+		{
+			name: "Added and removed tables",
+			a:    &Ruleset{Tables: []*TableSpec{{Name: "old", Family: nftables.TableFamilyIPv6, Chains: []*ChainSpec{{Name: "regular", Rules: []*Rule{r1}}}}}},
+			b:    &Ruleset{Tables: []*TableSpec{{Name: "filter", Family: nftables.TableFamilyIPv4, Chains: []*ChainSpec{input(&accept, r1, r2)}}}},
+			expect: &RulesetDiff{
+				AddedTables:   []string{"ip filter"},
+				RemovedTables: []string{"ip6 old"},
+				AddedChains:   []string{"ip filter input"},
+				RemovedChains: []string{"ip6 old regular"},
+				AddedRules:    []string{"ip filter input #0", "ip filter input #1"},
+				RemovedRules:  []string{"ip6 old regular #0"},
+			},
+		},
+		{
+			name: "Changed policy and rules",
+			a: &Ruleset{Tables: []*TableSpec{{Name: "filter", Family: nftables.TableFamilyIPv4, Chains: []*ChainSpec{
+				input(&accept, r1, r2),
+				{Name: "regular"},
+			}}}},
+			b: &Ruleset{Tables: []*TableSpec{{Name: "filter", Family: nftables.TableFamilyIPv4, Chains: []*ChainSpec{
+				input(&drop, r3, r1),
+			}}}},
+			expect: &RulesetDiff{
+				ChangedTables: []string{"ip filter"},
+				ChangedChains: []string{"ip filter input"},
+				RemovedChains: []string{"ip filter regular"},
+				AddedRules:    []string{"ip filter input #0"},
+				RemovedRules:  []string{"ip filter input #1"},
+			},
+		},
+		{
+			name: "Nil ruleset",
+			a:    nil,
+			b:    &Ruleset{Tables: []*TableSpec{{Name: "filter", Family: nftables.TableFamilyIPv4}}},
+			expect: &RulesetDiff{
+				AddedTables: []string{"ip filter"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		diff := DiffRulesets(tt.a, tt.b)
+		if !reflect.DeepEqual(diff, tt.expect) {
+			t.Errorf("Test \"%s\" failed, diff %+v does not match expected %+v", tt.name, diff, tt.expect)
+		}
+		if diff.IsEmpty() != reflect.DeepEqual(tt.expect, &RulesetDiff{}) {
+			t.Errorf("Test \"%s\" failed, IsEmpty returned %t", tt.name, diff.IsEmpty())
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"golang.org/x/sys/unix"
+)
+
+// applyConn serves preconfigured tables, chains and rules and records changes requested by ApplyRuleset
+type applyConn struct {
+	listConn
+	tables     []*nftables.Table
+	addedRules []*nftables.Rule
+	delRules   []uint64
+	addChains  []string
+	delChains  []string
+	sets       []*nftables.Set
+	flushes    int
+	flushed    []string
+}
+
+func (a *applyConn) AddSet(s *nftables.Set, _ []nftables.SetElement) error {
+	a.sets = append(a.sets, s)
+	return nil
+}
+
+func (a *applyConn) ListTables() ([]*nftables.Table, error) {
+	return a.tables, nil
+}
+
+func (a *applyConn) AddTable(t *nftables.Table) *nftables.Table {
+	return t
+}
+
+func (a *applyConn) FlushTable(t *nftables.Table) {
+	a.flushed = append(a.flushed, t.Name)
+}
+
+func (a *applyConn) AddChain(c *nftables.Chain) *nftables.Chain {
+	a.addChains = append(a.addChains, c.Name)
+	return c
+}
+
+func (a *applyConn) DelChain(c *nftables.Chain) {
+	a.delChains = append(a.delChains, c.Name)
+}
+
+func (a *applyConn) AddRule(r *nftables.Rule) *nftables.Rule {
+	a.addedRules = append(a.addedRules, r)
+	return r
+}
+
+func (a *applyConn) DelRule(r *nftables.Rule) error {
+	a.delRules = append(a.delRules, r.Handle)
+	return nil
+}
+
+func (a *applyConn) Flush() error {
+	a.flushes++
+	return nil
+}
+
+func TestApplyRuleset(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	accept := nftables.ChainPolicyAccept
+	input := &nftables.Chain{
+		Name:     "input",
+		Table:    tbl,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   &accept,
+	}
+	port := uint16(22)
+	ssh := &Rule{
+		L4: &L4Rule{
+			L4Proto: unix.IPPROTO_TCP,
+			Dst:     &Port{List: []*uint16{&port}},
+		},
+		Action: setActionVerdict(t, NFT_ACCEPT),
+	}
+	b := &nfRules{conn: &dryRunConn{sets: make(map[string]bool)}, table: tbl, chain: input}
+	rr, err := b.buildRule(ssh)
+	if err != nil {
+		t.Fatalf("failed to build rule with error: %+v", err)
+	}
+	conn := &applyConn{
+		listConn: listConn{
+			chains: []*nftables.Chain{
+				input,
+				{Name: "stale", Table: tbl},
+			},
+			rules: map[string][]*nftables.Rule{
+				"input": {
+					{Table: tbl, Chain: input, Handle: 1, Exprs: rr.rule.Exprs, UserData: []byte{0x2, 2, 0, 10}},
+					{Table: tbl, Chain: input, Handle: 2, Exprs: rr.rule.Exprs[:1]},
+				},
+				"stale": {{Handle: 3}},
+			},
+		},
+		tables: []*nftables.Table{tbl, {Name: "unmanaged", Family: nftables.TableFamilyIPv4}},
+	}
+	drop := ChainPolicyDrop
+	desired := &Ruleset{
+		Tables: []*TableSpec{
+			{
+				Name:   "filter",
+				Family: nftables.TableFamilyIPv4,
+				Chains: []*ChainSpec{
+					{
+						Name: "input",
+						Attributes: &ChainAttributes{
+							Type:     nftables.ChainTypeFilter,
+							Hook:     nftables.ChainHookInput,
+							Priority: nftables.ChainPriorityFilter,
+							Policy:   &drop,
+						},
+						Rules: []*Rule{ssh, {Counter: &Counter{}}},
+					},
+					{
+						Name:  "new",
+						Rules: []*Rule{{Counter: &Counter{}}},
+					},
+				},
+			},
+		},
+	}
+	nft := &nfTables{
+		conn:   conn,
+		tables: make(map[nftables.TableFamily]map[string]*nfTable),
+	}
+	diff, err := nft.ApplyRuleset(desired)
+	if err != nil {
+		t.Fatalf("ApplyRuleset failed with error: %+v", err)
+	}
+	expect := &Diff{
+		AddedChains:   []string{"ip filter new"},
+		UpdatedChains: []string{"ip filter input"},
+		DeletedChains: []string{"ip filter stale"},
+		AddedRules:    []string{"ip filter input rule 1", "ip filter new rule 0"},
+		DeletedRules:  []string{"ip filter input handle 2"},
+	}
+	if !reflect.DeepEqual(diff, expect) {
+		t.Errorf("unexpected diff %+v, expected %+v", diff, expect)
+	}
+	if !reflect.DeepEqual(conn.delRules, []uint64{3, 2}) {
+		t.Errorf("unexpected deleted rules %v", conn.delRules)
+	}
+	if len(conn.addedRules) != 2 || conn.flushes != 1 {
+		t.Errorf("expected 2 rules added in a single transaction, got %d rules and %d flushes", len(conn.addedRules), conn.flushes)
+	}
+
+	// Ruleset already programmed on the host must not produce any change, the first apply
+	// updated input's policy in place, restoring it.
+	input.Policy = &accept
+	conn = &applyConn{
+		listConn: listConn{
+			chains: []*nftables.Chain{input},
+			rules: map[string][]*nftables.Rule{
+				"input": {{Table: tbl, Chain: input, Handle: 1, Exprs: rr.rule.Exprs}},
+			},
+		},
+		tables: []*nftables.Table{tbl},
+	}
+	nft = &nfTables{
+		conn:   conn,
+		tables: make(map[nftables.TableFamily]map[string]*nfTable),
+	}
+	desired.Tables[0].Chains = []*ChainSpec{
+		{
+			Name: "input",
+			Attributes: &ChainAttributes{
+				Type:     nftables.ChainTypeFilter,
+				Hook:     nftables.ChainHookInput,
+				Priority: nftables.ChainPriorityFilter,
+			},
+			Rules: []*Rule{ssh},
+		},
+	}
+	diff, err = nft.ApplyRuleset(desired)
+	if err != nil {
+		t.Fatalf("ApplyRuleset failed with error: %+v", err)
+	}
+	if !diff.IsEmpty() || conn.flushes != 0 {
+		t.Errorf("expected no changes, got diff %+v and %d flushes", diff, conn.flushes)
+	}
+
+	// Hook of existing chain cannot be changed
+	desired.Tables[0].Chains[0].Attributes.Hook = nftables.ChainHookOutput
+	if _, err := nft.ApplyRuleset(desired); err == nil {
+		t.Errorf("ApplyRuleset changing chain's hook supposed to fail but succeeded")
+	}
+
+	// Chains of the ruleset bound to the same device at the same hook must have different priorities
+	conn = &applyConn{}
+	nft = &nfTables{
+		conn:   conn,
+		tables: make(map[nftables.TableFamily]map[string]*nfTable),
+	}
+	ingress := func(name string) *ChainSpec {
+		return &ChainSpec{
+			Name: name,
+			Attributes: &ChainAttributes{
+				Type:     nftables.ChainTypeFilter,
+				Hook:     nftables.ChainHookIngress,
+				Priority: nftables.ChainPriorityRef(-150),
+				Device:   "eth0",
+			},
+		}
+	}
+	desired = &Ruleset{
+		Tables: []*TableSpec{
+			{
+				Name:   "ingress",
+				Family: nftables.TableFamilyNetdev,
+				Chains: []*ChainSpec{ingress("first"), ingress("second")},
+			},
+		},
+	}
+	if _, err := nft.ApplyRuleset(desired); err == nil {
+		t.Errorf("ApplyRuleset with netdev chains of the same priority supposed to fail but succeeded")
+	}
+	if len(conn.addChains) != 0 || conn.flushes != 0 {
+		t.Errorf("expected nothing programmed, got chains %v and %d flushes", conn.addChains, conn.flushes)
+	}
+}
@@ -0,0 +1,233 @@
+package nftableslib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/nftables"
+)
+
+// lookupIPAddr resolves a hostname, it is a variable to allow substituting DNS resolution in tests
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// DomainSetHandle defines methods to operate with a set which elements are periodically refreshed
+// from DNS resolution of hostnames.
+type DomainSetHandle interface {
+	SetHandle
+	// Refresh resolves the hostnames and updates the set's elements immediately
+	Refresh() error
+	// LastError returns the error of the most recent refresh, nil if it succeeded
+	LastError() error
+	// Stop stops periodic refresh, the set and its elements are left intact
+	Stop()
+}
+
+type nfDomainSet struct {
+	SetHandle
+	sync.Mutex
+	sets      *nfSets
+	name      string
+	hostnames []string
+	ipv6      bool
+	// hosts carries addresses of each hostname resolved by the last successful lookup
+	hosts   map[string][]string
+	current map[string]bool
+	lastErr error
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// CreateDomainSet creates a named set of addresses resolved from hostnames and refreshes its elements
+// every refresh interval. Addresses of the table's family are used, AAAA records for ipv6 tables and
+// A records for the other families. If a hostname fails to resolve, its previously resolved addresses
+// are kept, the set is never wiped because of resolution failures. Each refresh replaces the set's elements
+// in a single batch sent by Flush of the connection shared by the table, messages queued on it by other
+// callers are sent with the refresh. Rules reference the set by DomainSetHandle's Ref().
+func (nfs *nfSets) CreateDomainSet(name string, hostnames []string, refresh time.Duration) (DomainSetHandle, error) {
+	if len(hostnames) == 0 {
+		return nil, fmt.Errorf("at least one hostname must be specified")
+	}
+	if refresh <= 0 {
+		return nil, fmt.Errorf("refresh interval must be greater than 0")
+	}
+	ipv6 := nfs.table.Family == nftables.TableFamilyIPv6
+	keyType := nftables.TypeIPAddr
+	if ipv6 {
+		keyType = nftables.TypeIP6Addr
+	}
+	ds := &nfDomainSet{
+		sets:      nfs,
+		name:      name,
+		hostnames: hostnames,
+		ipv6:      ipv6,
+		hosts:     make(map[string][]string),
+		current:   make(map[string]bool),
+		stop:      make(chan struct{}),
+	}
+	// Initial resolution, failures are recorded and retried on the next refresh
+	ds.lastErr = ds.resolve()
+	elements := []nftables.SetElement{}
+	for _, addr := range ds.desired() {
+		elements = append(elements, makeAddrElement(addr, ipv6))
+		ds.current[addr] = true
+	}
+	h, err := nfs.Create(&SetAttributes{
+		Name:    name,
+		KeyType: keyType,
+	}, elements)
+	if err != nil {
+		return nil, err
+	}
+	ds.SetHandle = h
+	go ds.run(refresh)
+
+	return ds, nil
+}
+
+func (ds *nfDomainSet) run(refresh time.Duration) {
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ds.stop:
+			return
+		case <-ticker.C:
+			ds.Refresh()
+		}
+	}
+}
+
+// Refresh resolves the hostnames and replaces the set's elements with resolved addresses in a single batch,
+// the packet path never observes the set partially updated. The batch is sent by Flush of the connection
+// shared by the table, messages queued on it by other callers are sent with it.
+func (ds *nfDomainSet) Refresh() error {
+	ds.Lock()
+	defer ds.Unlock()
+	err := ds.resolve()
+	add, del := ds.diff()
+	if len(add) == 0 && len(del) == 0 {
+		ds.lastErr = err
+		return err
+	}
+	desired := ds.desired()
+	elements := make([]nftables.SetElement, 0, len(desired))
+	for _, addr := range desired {
+		elements = append(elements, makeAddrElement(addr, ds.ipv6))
+	}
+	if rerr := ds.sets.ReplaceElements(ds.name, elements); rerr != nil {
+		ds.lastErr = rerr
+		return rerr
+	}
+	ds.current = make(map[string]bool)
+	for _, addr := range desired {
+		ds.current[addr] = true
+	}
+	ds.lastErr = err
+
+	return err
+}
+
+// LastError returns the error of the most recent refresh
+func (ds *nfDomainSet) LastError() error {
+	ds.Lock()
+	defer ds.Unlock()
+
+	return ds.lastErr
+}
+
+// Stop stops periodic refresh of the set
+func (ds *nfDomainSet) Stop() {
+	ds.once.Do(func() {
+		close(ds.stop)
+	})
+}
+
+// resolve looks up each hostname and records its addresses of the set's family, hostnames
+// which fail to resolve keep addresses of the previous lookup.
+func (ds *nfDomainSet) resolve() error {
+	var failed []string
+	for _, host := range ds.hostnames {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		addrs, err := lookupIPAddr(ctx, host)
+		cancel()
+		if err != nil {
+			failed = append(failed, host)
+			continue
+		}
+		ds.hosts[host] = filterAddrs(addrs, ds.ipv6)
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to resolve hostnames: %v", failed)
+	}
+
+	return nil
+}
+
+// desired returns deduplicated addresses of all hostnames
+func (ds *nfDomainSet) desired() []string {
+	seen := make(map[string]bool)
+	addrs := []string{}
+	for _, host := range ds.hostnames {
+		for _, addr := range ds.hosts[host] {
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// diff returns elements to add to and to delete from the set to match resolved addresses
+func (ds *nfDomainSet) diff() ([]nftables.SetElement, []nftables.SetElement) {
+	add := []nftables.SetElement{}
+	del := []nftables.SetElement{}
+	desired := make(map[string]bool)
+	for _, addr := range ds.desired() {
+		desired[addr] = true
+		if !ds.current[addr] {
+			add = append(add, makeAddrElement(addr, ds.ipv6))
+		}
+	}
+	for addr := range ds.current {
+		if !desired[addr] {
+			del = append(del, makeAddrElement(addr, ds.ipv6))
+		}
+	}
+
+	return add, del
+}
+
+// filterAddrs returns string representation of addresses of requested family, ipv4 addresses
+// are selected when ipv6 is false.
+func filterAddrs(addrs []net.IPAddr, ipv6 bool) []string {
+	seen := make(map[string]bool)
+	filtered := []string{}
+	for _, addr := range addrs {
+		if (addr.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		s := addr.IP.String()
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		filtered = append(filtered, s)
+	}
+
+	return filtered
+}
+
+func makeAddrElement(addr string, ipv6 bool) nftables.SetElement {
+	ip := net.ParseIP(addr)
+	if ipv6 {
+		return nftables.SetElement{Key: ip.To16()}
+	}
+
+	return nftables.SetElement{Key: ip.To4()}
+}
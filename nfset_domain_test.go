@@ -0,0 +1,112 @@
+package nftableslib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/nftables"
+)
+
+func TestDomainSetResolve(t *testing.T) {
+	records := map[string][]net.IPAddr{
+		"a.example.com": {
+			{IP: net.ParseIP("192.0.2.1")},
+			{IP: net.ParseIP("2001:db8::1")},
+			{IP: net.ParseIP("192.0.2.1")},
+		},
+		"b.example.com": {
+			{IP: net.ParseIP("192.0.2.1")},
+			{IP: net.ParseIP("192.0.2.2")},
+		},
+	}
+	fail := false
+	saved := lookupIPAddr
+	defer func() { lookupIPAddr = saved }()
+	lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		if fail && host == "b.example.com" {
+			return nil, fmt.Errorf("no such host")
+		}
+		return records[host], nil
+	}
+	ds := &nfDomainSet{
+		hostnames: []string{"a.example.com", "b.example.com"},
+		hosts:     make(map[string][]string),
+		current:   make(map[string]bool),
+	}
+	if err := ds.resolve(); err != nil {
+		t.Fatalf("resolve failed with error: %+v", err)
+	}
+	// A and AAAA records are deduplicated by family
+	if got, want := ds.desired(), []string{"192.0.2.1", "192.0.2.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resolved addresses %v do not match expected addresses %v", got, want)
+	}
+	ds.current = map[string]bool{"192.0.2.1": true, "192.0.2.9": true}
+	add, del := ds.diff()
+	if len(add) != 1 || net.IP(add[0].Key).String() != "192.0.2.2" {
+		t.Errorf("unexpected elements to add: %+v", add)
+	}
+	if len(del) != 1 || net.IP(del[0].Key).String() != "192.0.2.9" {
+		t.Errorf("unexpected elements to delete: %+v", del)
+	}
+	// Resolution failure must keep previously resolved addresses
+	fail = true
+	if err := ds.resolve(); err == nil {
+		t.Errorf("resolve supposed to fail but succeeded")
+	}
+	got := ds.desired()
+	sort.Strings(got)
+	if want := []string{"192.0.2.1", "192.0.2.2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("addresses %v after resolution failure do not match expected addresses %v", got, want)
+	}
+	// IPv6 set selects only AAAA records
+	ds6 := &nfDomainSet{
+		hostnames: []string{"a.example.com"},
+		ipv6:      true,
+		hosts:     make(map[string][]string),
+	}
+	ds6.resolve()
+	if got, want := ds6.desired(), []string{"2001:db8::1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resolved ipv6 addresses %v do not match expected addresses %v", got, want)
+	}
+}
+
+func TestDomainSetRefresh(t *testing.T) {
+	saved := lookupIPAddr
+	defer func() { lookupIPAddr = saved }()
+	lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}, {IP: net.ParseIP("192.0.2.2")}}, nil
+	}
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	conn := &setOpsConn{}
+	nfs := newSets(conn, tbl).(*nfSets)
+	nfs.sets["domains"] = &nftables.Set{Table: tbl, Name: "domains", KeyType: nftables.TypeIPAddr}
+	ds := &nfDomainSet{
+		sets:      nfs,
+		name:      "domains",
+		hostnames: []string{"a.example.com"},
+		hosts:     make(map[string][]string),
+		current:   map[string]bool{"192.0.2.1": true, "192.0.2.9": true},
+	}
+	if err := ds.Refresh(); err != nil {
+		t.Fatalf("Refresh failed with error: %+v", err)
+	}
+	// Elements must be replaced in a single batch
+	want := []string{"flush domains", "add 2 elements to domains", "commit"}
+	if !reflect.DeepEqual(conn.ops, want) {
+		t.Errorf("Refresh performed %v want: %v", conn.ops, want)
+	}
+	if want := map[string]bool{"192.0.2.1": true, "192.0.2.2": true}; !reflect.DeepEqual(ds.current, want) {
+		t.Errorf("addresses %v of the set after refresh do not match expected addresses %v", ds.current, want)
+	}
+	// Unchanged addresses must not send a batch
+	if err := ds.Refresh(); err != nil {
+		t.Fatalf("Refresh failed with error: %+v", err)
+	}
+	if len(conn.ops) != len(want) {
+		t.Errorf("Refresh of unchanged addresses performed %v", conn.ops[len(want):])
+	}
+}
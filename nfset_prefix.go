@@ -0,0 +1,172 @@
+package nftableslib
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+)
+
+// PrefixMapElement defines a prefix of a prefix map and the verdict applied to addresses matching it,
+// for example a jump to the chain of a tier.
+type PrefixMapElement struct {
+	Prefix *IPAddr
+	Action *RuleAction
+}
+
+// CreatePrefixMap creates a named interval verdict map of prefixes with the longest prefix match semantics,
+// an address matching several overlapping prefixes gets the verdict of the most specific one. The kernel
+// does not allow overlapping intervals, overlapping prefixes are split into disjoint intervals each
+// carrying the verdict of the most specific covering prefix. A rule looks up the map by L3 Src or Dst
+// with SetRef set to the returned handle's Ref(), addresses which do not match any prefix fall through.
+func (nfs *nfSets) CreatePrefixMap(name string, elements []*PrefixMapElement) (SetHandle, error) {
+	ipv6 := nfs.table.Family == nftables.TableFamilyIPv6
+	if nfs.table.Family != nftables.TableFamilyIPv4 && !ipv6 {
+		return nil, fmt.Errorf("prefix map is not supported in table family %d", nfs.table.Family)
+	}
+	se, err := buildPrefixMapElements(elements, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	keyType := nftables.TypeIPAddr
+	if ipv6 {
+		keyType = nftables.TypeIP6Addr
+	}
+
+	return nfs.Create(&SetAttributes{
+		Name:     name,
+		IsMap:    true,
+		Interval: true,
+		KeyType:  keyType,
+		DataType: nftables.TypeVerdict,
+	}, se)
+}
+
+// prefixInterval is a disjoint interval [start, end) of addresses carrying the verdict of
+// the most specific prefix covering it.
+type prefixInterval struct {
+	start   *big.Int
+	end     *big.Int
+	verdict *expr.Verdict
+}
+
+// buildPrefixMapElements flattens possibly overlapping prefixes into interval elements, each interval
+// starts with an element carrying the verdict and ends with IntervalEnd element. The end element is
+// omitted when the next interval starts right where the previous one ends and when the interval
+// reaches the end of the address space.
+func buildPrefixMapElements(elements []*PrefixMapElement, ipv6 bool) ([]nftables.SetElement, error) {
+	if len(elements) == 0 {
+		return nil, fmt.Errorf("at least one prefix must be specified")
+	}
+	l, bits := 4, uint8(32)
+	if ipv6 {
+		l, bits = 16, 128
+	}
+	type prefix struct {
+		start   *big.Int
+		end     *big.Int
+		mask    uint8
+		verdict *expr.Verdict
+	}
+	prefixes := make([]prefix, 0, len(elements))
+	bounds := []*big.Int{}
+	seen := make(map[string]bool)
+	for i, e := range elements {
+		if e == nil || e.Prefix == nil || e.Prefix.IPAddr == nil {
+			return nil, fmt.Errorf("prefix map element %d must carry a prefix", i)
+		}
+		if e.Action == nil || e.Action.verdict == nil {
+			return nil, fmt.Errorf("prefix map element %d must carry a verdict", i)
+		}
+		if e.Prefix.IsIPv6() != ipv6 {
+			return nil, fmt.Errorf("prefix %s does not match the family of the map", e.Prefix.IP.String())
+		}
+		mask := bits
+		if e.Prefix.CIDR && e.Prefix.Mask != nil {
+			mask = *e.Prefix.Mask
+		}
+		if mask > bits {
+			return nil, fmt.Errorf("invalid mask length %d of prefix %s", mask, e.Prefix.IP.String())
+		}
+		ip := getIP(e.Prefix)
+		network := make([]byte, l)
+		m := getMask(mask, l)
+		for j := range network {
+			network[j] = ip[j] & m[j]
+		}
+		key := fmt.Sprintf("%x/%d", network, mask)
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate prefix %s/%d", e.Prefix.IP.String(), mask)
+		}
+		seen[key] = true
+		start := new(big.Int).SetBytes(network)
+		end := new(big.Int).Add(start, new(big.Int).Lsh(big.NewInt(1), uint(bits-mask)))
+		prefixes = append(prefixes, prefix{start: start, end: end, mask: mask, verdict: e.Action.verdict})
+		bounds = append(bounds, start, end)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Cmp(bounds[j]) < 0 })
+	intervals := []*prefixInterval{}
+	for i := 0; i < len(bounds)-1; i++ {
+		if bounds[i].Cmp(bounds[i+1]) == 0 {
+			continue
+		}
+		// Looking for the most specific prefix covering the interval, prefixes either nest or
+		// do not overlap, so the prefix covering the start of the interval covers all of it.
+		var best *prefix
+		for j := range prefixes {
+			p := &prefixes[j]
+			if p.start.Cmp(bounds[i]) > 0 || p.end.Cmp(bounds[i]) <= 0 {
+				continue
+			}
+			if best == nil || p.mask > best.mask {
+				best = p
+			}
+		}
+		if best == nil {
+			continue
+		}
+		if n := len(intervals); n != 0 && intervals[n-1].end.Cmp(bounds[i]) == 0 &&
+			isEqualVerdict(intervals[n-1].verdict, best.verdict) {
+			intervals[n-1].end = bounds[i+1]
+			continue
+		}
+		intervals = append(intervals, &prefixInterval{start: bounds[i], end: bounds[i+1], verdict: best.verdict})
+	}
+	top := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	se := []nftables.SetElement{}
+	for i, in := range intervals {
+		se = append(se, nftables.SetElement{
+			Key:         bigToAddr(in.start, l),
+			VerdictData: in.verdict,
+		})
+		if in.end.Cmp(top) == 0 {
+			continue
+		}
+		if i < len(intervals)-1 && intervals[i+1].start.Cmp(in.end) == 0 {
+			continue
+		}
+		se = append(se, nftables.SetElement{
+			Key:         bigToAddr(in.end, l),
+			IntervalEnd: true,
+		})
+	}
+
+	return se, nil
+}
+
+func isEqualVerdict(v1, v2 *expr.Verdict) bool {
+	return v1.Kind == v2.Kind && v1.Chain == v2.Chain
+}
+
+// bigToAddr returns address of l bytes for the integer
+func bigToAddr(n *big.Int, l int) []byte {
+	b := n.Bytes()
+	if len(b) >= l {
+		return b[len(b)-l:]
+	}
+
+	return append(bytes.Repeat([]byte{0}, l-len(b)), b...)
+}
@@ -0,0 +1,145 @@
+package nftableslib
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+func TestBuildPrefixMapElements(t *testing.T) {
+	gold := setActionVerdict(t, unix.NFT_JUMP, "gold")
+	silver := setActionVerdict(t, unix.NFT_JUMP, "silver")
+	drop := setActionVerdict(t, NFT_DROP)
+	tests := []struct {
+		name    string
+		ipv6    bool
+		input   []*PrefixMapElement
+		expect  []nftables.SetElement
+		success bool
+	}{
+		{
+			name: "Disjoint prefixes",
+			input: []*PrefixMapElement{
+				{Prefix: setIPAddr(t, "10.1.0.0/16"), Action: gold},
+				{Prefix: setIPAddr(t, "192.0.2.0/24"), Action: silver},
+			},
+			expect: []nftables.SetElement{
+				{Key: []byte{10, 1, 0, 0}, VerdictData: gold.verdict},
+				{Key: []byte{10, 2, 0, 0}, IntervalEnd: true},
+				{Key: []byte{192, 0, 2, 0}, VerdictData: silver.verdict},
+				{Key: []byte{192, 0, 3, 0}, IntervalEnd: true},
+			},
+			success: true,
+		},
+		{
+			name: "Nested prefix wins",
+			input: []*PrefixMapElement{
+				{Prefix: setIPAddr(t, "10.0.0.0/8"), Action: silver},
+				{Prefix: setIPAddr(t, "10.1.0.0/16"), Action: gold},
+			},
+			expect: []nftables.SetElement{
+				{Key: []byte{10, 0, 0, 0}, VerdictData: silver.verdict},
+				{Key: []byte{10, 1, 0, 0}, VerdictData: gold.verdict},
+				{Key: []byte{10, 2, 0, 0}, VerdictData: silver.verdict},
+				{Key: []byte{11, 0, 0, 0}, IntervalEnd: true},
+			},
+			success: true,
+		},
+		{
+			name: "Default route with host exception",
+			input: []*PrefixMapElement{
+				{Prefix: setIPAddr(t, "0.0.0.0/0"), Action: drop},
+				{Prefix: setIPAddr(t, "192.0.2.1"), Action: gold},
+			},
+			expect: []nftables.SetElement{
+				{Key: []byte{0, 0, 0, 0}, VerdictData: drop.verdict},
+				{Key: []byte{192, 0, 2, 1}, VerdictData: gold.verdict},
+				{Key: []byte{192, 0, 2, 2}, VerdictData: drop.verdict},
+			},
+			success: true,
+		},
+		{
+			name: "Nested prefix with the same verdict is merged",
+			input: []*PrefixMapElement{
+				{Prefix: setIPAddr(t, "10.0.0.0/8"), Action: gold},
+				{Prefix: setIPAddr(t, "10.1.0.0/16"), Action: gold},
+			},
+			expect: []nftables.SetElement{
+				{Key: []byte{10, 0, 0, 0}, VerdictData: gold.verdict},
+				{Key: []byte{11, 0, 0, 0}, IntervalEnd: true},
+			},
+			success: true,
+		},
+		{
+			name: "IPv6 prefixes",
+			ipv6: true,
+			input: []*PrefixMapElement{
+				{Prefix: setIPAddr(t, "2001:db8::/32"), Action: silver},
+				{Prefix: setIPAddr(t, "2001:db8:1::/48"), Action: gold},
+			},
+			expect: []nftables.SetElement{
+				{Key: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, VerdictData: silver.verdict},
+				{Key: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, VerdictData: gold.verdict},
+				{Key: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, VerdictData: silver.verdict},
+				{Key: []byte{0x20, 0x01, 0x0d, 0xb9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, IntervalEnd: true},
+			},
+			success: true,
+		},
+		{
+			name: "Duplicate prefix",
+			input: []*PrefixMapElement{
+				{Prefix: setIPAddr(t, "10.0.0.0/8"), Action: gold},
+				{Prefix: setIPAddr(t, "10.0.0.0/8"), Action: silver},
+			},
+			success: false,
+		},
+		{
+			name: "Prefix of wrong family",
+			input: []*PrefixMapElement{
+				{Prefix: setIPAddr(t, "2001:db8::/32"), Action: gold},
+			},
+			success: false,
+		},
+		{
+			name: "Element without verdict",
+			input: []*PrefixMapElement{
+				{Prefix: setIPAddr(t, "10.0.0.0/8"), Action: &RuleAction{}},
+			},
+			success: false,
+		},
+	}
+	for _, tt := range tests {
+		se, err := buildPrefixMapElements(tt.input, tt.ipv6)
+		if err != nil && tt.success {
+			t.Errorf("Test \"%s\" failed with error: %+v but supposed to succeed", tt.name, err)
+			continue
+		}
+		if err == nil && !tt.success {
+			t.Errorf("Test \"%s\" succeeded but supposed to fail", tt.name)
+			continue
+		}
+		if !tt.success {
+			continue
+		}
+		if !reflect.DeepEqual(se, tt.expect) {
+			t.Errorf("Test \"%s\" elements %+v do not match expected %+v", tt.name, se, tt.expect)
+		}
+	}
+}
+
+func TestPrefixMapLookup(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	set := &nftables.Set{Table: tbl, Name: "tiers", ID: 10, IsMap: true, Interval: true}
+	re, err := getExprForAddrSet(nftables.TableFamilyIPv4, 12, &SetRef{Name: set.Name, ID: set.ID, IsMap: true}, EQ)
+	if err != nil {
+		t.Fatalf("failed to build lookup with error: %+v", err)
+	}
+	// Lookup of a verdict map must return the verdict into the verdict register
+	lookup, ok := re[len(re)-1].(*expr.Lookup)
+	if !ok || !lookup.IsDestRegSet || lookup.DestRegister != 0 || lookup.SetName != "tiers" {
+		t.Errorf("unexpected lookup expression %+v", re[len(re)-1])
+	}
+}
@@ -9,17 +9,30 @@ import (
 
 	"github.com/google/nftables"
 	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
 )
 
 // SetAttributes  defines parameters of a nftables Set
 type SetAttributes struct {
-	Name       string
-	Constant   bool
+	Name     string
+	Constant bool
+	// Dynamic flag must be set when the set is updated from the packet path by a dynset action,
+	// Constant and Dynamic flags are mutually exclusive.
+	Dynamic    bool
 	IsMap      bool
 	HasTimeout bool
 	Timeout    time.Duration
 	// Interval flag must be set only when the set elements are ranges, address ranges or port ranges
 	Interval bool
+	// AutoMerge coalesces overlapping and adjacent intervals of an interval set, like nft auto-merge, elements
+	// added to the set are merged with its existing elements, which are replaced in a single batch. The kernel
+	// does not merge intervals and rejects overlapping ones, hence merging is done by the library.
+	// AutoMerge requires Interval and cannot be used with maps.
+	AutoMerge bool
+	// Size is the maximum number of elements, the kernel uses it to allocate the set, for example to size
+	// buckets of a hash set, which speeds up loading of a large set. Adding elements beyond Size fails,
+	// 0 means no limit.
+	Size     uint32
 	KeyType  nftables.SetDatatype
 	DataType nftables.SetDatatype
 }
@@ -40,6 +53,8 @@ type ElementValue struct {
 	InetProto   *byte
 	InetService *uint16
 	Mark        *uint32
+	// Comment is stored by the kernel with the element, example: "blocked: brute-force on 2024-01-01"
+	Comment string
 }
 
 // SetsInterface defines third level interface operating with nf maps
@@ -48,7 +63,10 @@ type SetsInterface interface {
 }
 
 // SetFuncs defines funcations to operate with nftables Sets
+// Comment of nftables.SetElement is stored by the kernel with the element, it is returned by GetSetElements,
+// also for elements added by nft.
 type SetFuncs interface {
+	Create(*SetAttributes, []nftables.SetElement) (SetHandle, error)
 	CreateSet(*SetAttributes, []nftables.SetElement) (*nftables.Set, error)
 	DelSet(string) error
 	GetSets() ([]*nftables.Set, error)
@@ -56,6 +74,11 @@ type SetFuncs interface {
 	GetSetElements(string) ([]nftables.SetElement, error)
 	SetAddElements(string, []nftables.SetElement) error
 	SetDelElements(string, []nftables.SetElement) error
+	ReplaceElements(string, []nftables.SetElement) error
+	GC() ([]string, error)
+	CreateDomainSet(string, []string, time.Duration) (DomainSetHandle, error)
+	CreatePrefixMap(string, []*PrefixMapElement) (SetHandle, error)
+	ReferencedBy(string) ([]RuleRef, error)
 }
 
 type nfSets struct {
@@ -63,8 +86,15 @@ type nfSets struct {
 	table *nftables.Table
 	sync.Mutex
 	sets map[string]*nftables.Set
+	// autoMerge carries names of sets created with AutoMerge attribute
+	autoMerge map[string]bool
 }
 
+// generatedSetComment is the comment of sets the library generates for rules, for example for lists of addresses
+// and ports. The comment is stored by the kernel, it marks sets owned by the library, also sets generated by
+// earlier processes, which GC deletes once no rule references them.
+const generatedSetComment = "nftableslib: generated set"
+
 // Sets return a list of methods available for Sets operations
 func (nfs *nfSets) Sets() SetFuncs {
 	return nfs
@@ -73,6 +103,17 @@ func (nfs *nfSets) Sets() SetFuncs {
 func (nfs *nfSets) CreateSet(attrs *SetAttributes, elements []nftables.SetElement) (*nftables.Set, error) {
 	var err error
 	// TODO Add parameters validation
+	if attrs.Constant && attrs.Dynamic {
+		return nil, fmt.Errorf("set %s cannot be both constant and dynamic", attrs.Name)
+	}
+	if attrs.AutoMerge {
+		if !attrs.Interval || attrs.IsMap {
+			return nil, fmt.Errorf("auto-merge of set %s requires interval flag and cannot be used with maps", attrs.Name)
+		}
+		if elements, err = mergeIntervals(elements); err != nil {
+			return nil, err
+		}
+	}
 	se := []nftables.SetElement{}
 	if attrs.Interval {
 		if attrs.KeyType == nftables.TypeIPAddr || attrs.KeyType == nftables.TypeIP6Addr {
@@ -89,9 +130,11 @@ func (nfs *nfSets) CreateSet(attrs *SetAttributes, elements []nftables.SetElemen
 		Name:       attrs.Name,
 		Anonymous:  false,
 		Constant:   attrs.Constant,
+		Dynamic:    attrs.Dynamic,
 		Interval:   attrs.Interval,
 		IsMap:      attrs.IsMap,
 		HasTimeout: attrs.HasTimeout,
+		Size:       attrs.Size,
 		KeyType:    attrs.KeyType,
 		DataType:   attrs.DataType,
 	}
@@ -111,10 +154,26 @@ func (nfs *nfSets) CreateSet(attrs *SetAttributes, elements []nftables.SetElemen
 	nfs.Lock()
 	defer nfs.Unlock()
 	nfs.sets[attrs.Name] = s
+	if attrs.AutoMerge {
+		nfs.autoMerge[attrs.Name] = true
+	}
 
 	return s, nil
 }
 
+// Create creates a new set and returns SetHandle with methods bound to the created set
+func (nfs *nfSets) Create(attrs *SetAttributes, elements []nftables.SetElement) (SetHandle, error) {
+	s, err := nfs.CreateSet(attrs, elements)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nfSetHandle{
+		set:  s,
+		sets: nfs,
+	}, nil
+}
+
 // Exist check if the set with name exists in the store and programmed on the host,
 // if both checks succeed, true is returned, otherwise false is returned.
 func (nfs *nfSets) Exist(name string) bool {
@@ -147,15 +206,24 @@ func (nfs *nfSets) GetSetByName(name string) (*nftables.Set, error) {
 	return s, nil
 }
 
+// storedSet returns the set with name from the store, nil is returned if the store does not carry it
+func (nfs *nfSets) storedSet(name string) *nftables.Set {
+	nfs.Lock()
+	defer nfs.Unlock()
+
+	return nfs.sets[name]
+}
+
 func (nfs *nfSets) DelSet(name string) error {
 	if nfs.Exist(name) {
-		nfs.conn.DelSet(nfs.sets[name])
+		nfs.conn.DelSet(nfs.storedSet(name))
 		if err := nfs.conn.Flush(); err != nil {
 			return err
 		}
 		nfs.Lock()
 		defer nfs.Unlock()
 		delete(nfs.sets, name)
+		delete(nfs.autoMerge, name)
 	}
 
 	return nil
@@ -168,14 +236,17 @@ func (nfs *nfSets) GetSets() ([]*nftables.Set, error) {
 
 func (nfs *nfSets) GetSetElements(name string) ([]nftables.SetElement, error) {
 	if nfs.Exist(name) {
-		return nfs.conn.GetSetElements(nfs.sets[name])
+		return nfs.conn.GetSetElements(nfs.storedSet(name))
 	}
 	return nil, fmt.Errorf("set %s does not exist", name)
 }
 
 func (nfs *nfSets) SetAddElements(name string, elements []nftables.SetElement) error {
+	if nfs.isAutoMerge(name) {
+		return nfs.mergeElements(name, elements)
+	}
 	if nfs.Exist(name) {
-		if err := nfs.conn.SetAddElements(nfs.sets[name], elements); err != nil {
+		if err := nfs.conn.SetAddElements(nfs.storedSet(name), elements); err != nil {
 			return err
 		}
 		if err := nfs.conn.Flush(); err != nil {
@@ -189,7 +260,7 @@ func (nfs *nfSets) SetAddElements(name string, elements []nftables.SetElement) e
 
 func (nfs *nfSets) SetDelElements(name string, elements []nftables.SetElement) error {
 	if nfs.Exist(name) {
-		set := nfs.sets[name]
+		set := nfs.storedSet(name)
 		if err := nfs.conn.SetDeleteElements(set, elements); err != nil {
 			return err
 		}
@@ -202,11 +273,192 @@ func (nfs *nfSets) SetDelElements(name string, elements []nftables.SetElement) e
 	return fmt.Errorf("set %s does not exist", name)
 }
 
+// ReplaceElements replaces all elements of the set with elements in a single batch, the set is never
+// observed empty or partially updated by the packet path.
+func (nfs *nfSets) ReplaceElements(name string, elements []nftables.SetElement) error {
+	if !nfs.Exist(name) {
+		return fmt.Errorf("set %s does not exist", name)
+	}
+	set := nfs.storedSet(name)
+	if set == nil {
+		return fmt.Errorf("set %s is not in the store", name)
+	}
+	if nfs.isAutoMerge(name) {
+		var err error
+		if elements, err = mergeIntervals(elements); err != nil {
+			return err
+		}
+	}
+	// FlushSet must not be left queued without the new elements, elements are validated first
+	if err := validateElements(set, elements); err != nil {
+		return err
+	}
+	nfs.conn.FlushSet(set)
+	if len(elements) != 0 {
+		if err := nfs.conn.SetAddElements(set, elements); err != nil {
+			return err
+		}
+	}
+
+	return nfs.conn.Flush()
+}
+
+// dataValueMaxLen is the maximum length of a key of set's element, NFT_DATA_VALUE_MAXLEN of the kernel
+const dataValueMaxLen = 64
+
+// validateElements checks that elements can be added to the set, example: before the set is flushed
+// to be replaced by the elements.
+func validateElements(set *nftables.Set, elements []nftables.SetElement) error {
+	if set.Anonymous {
+		return fmt.Errorf("anonymous set %s cannot be updated", set.Name)
+	}
+	for i, e := range elements {
+		if len(e.Key) == 0 {
+			return fmt.Errorf("key of element %d cannot be empty", i)
+		}
+		if len(e.Key) > dataValueMaxLen || len(e.KeyEnd) > dataValueMaxLen {
+			return fmt.Errorf("key of element %d exceeds %d bytes", i, dataValueMaxLen)
+		}
+	}
+
+	return nil
+}
+
+// isAutoMerge returns true if the set was created with AutoMerge attribute
+func (nfs *nfSets) isAutoMerge(name string) bool {
+	nfs.Lock()
+	defer nfs.Unlock()
+
+	return nfs.autoMerge[name]
+}
+
+// mergeElements merges elements with the existing elements of the set and replaces them in a single batch
+func (nfs *nfSets) mergeElements(name string, elements []nftables.SetElement) error {
+	existing, err := nfs.GetSetElements(name)
+	if err != nil {
+		return err
+	}
+	all := make([]nftables.SetElement, 0, len(existing)+len(elements))
+	all = append(all, existing...)
+	all = append(all, elements...)
+
+	return nfs.ReplaceElements(name, all)
+}
+
+// GC deletes sets of the table which are not referenced by any rule and returns names of deleted sets.
+// Only anonymous sets and sets the library generated for rules are considered, generated sets are recognized
+// by their comment, hence also sets left by earlier processes are deleted, named sets created by the caller
+// are never deleted. The deletions are sent with Flush, as with other Imm
+// operations, messages queued on the connection by other callers are sent with them.
+func (nfs *nfSets) GC() ([]string, error) {
+	sets, err := nfs.conn.GetSets(nfs.table)
+	if err != nil {
+		return nil, err
+	}
+	chains, err := nfs.conn.ListChains()
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, chain := range chains {
+		if chain.Table.Name != nfs.table.Name || chain.Table.Family != nfs.table.Family {
+			continue
+		}
+		rules, err := nfs.conn.GetRule(nfs.table, chain)
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			for _, e := range rule.Exprs {
+				switch exp := e.(type) {
+				case *expr.Lookup:
+					referenced[exp.SetName] = true
+				case *expr.Dynset:
+					referenced[exp.SetName] = true
+				}
+			}
+		}
+	}
+	comments, err := setComments(nfs.conn, nfs.table, sets)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, set := range sets {
+		if referenced[set.Name] {
+			continue
+		}
+		if !set.Anonymous && comments[set.Name] != generatedSetComment {
+			continue
+		}
+		set.Table = nfs.table
+		nfs.conn.DelSet(set)
+		deleted = append(deleted, set.Name)
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+	if err := nfs.conn.Flush(); err != nil {
+		return nil, err
+	}
+	nfs.Lock()
+	defer nfs.Unlock()
+	for _, name := range deleted {
+		delete(nfs.sets, name)
+	}
+
+	return deleted, nil
+}
+
+// SetHandle defines methods to operate with a single nftables Set
+type SetHandle interface {
+	Set() *nftables.Set
+	Ref() *SetRef
+	AddElements([]nftables.SetElement) error
+	DeleteElements([]nftables.SetElement) error
+	Get() ([]nftables.SetElement, error)
+}
+
+type nfSetHandle struct {
+	set  *nftables.Set
+	sets *nfSets
+}
+
+// Set returns nftables Set the handle is bound to
+func (h *nfSetHandle) Set() *nftables.Set {
+	return h.set
+}
+
+// Ref returns a reference to the set to be used in rules
+func (h *nfSetHandle) Ref() *SetRef {
+	return &SetRef{
+		Name:  h.set.Name,
+		ID:    h.set.ID,
+		IsMap: h.set.IsMap,
+	}
+}
+
+// AddElements adds elements to the set
+func (h *nfSetHandle) AddElements(elements []nftables.SetElement) error {
+	return h.sets.SetAddElements(h.set.Name, elements)
+}
+
+// DeleteElements deletes elements from the set
+func (h *nfSetHandle) DeleteElements(elements []nftables.SetElement) error {
+	return h.sets.SetDelElements(h.set.Name, elements)
+}
+
+// Get returns elements of the set programmed on the host
+func (h *nfSetHandle) Get() ([]nftables.SetElement, error) {
+	return h.sets.GetSetElements(h.set.Name)
+}
+
 func newSets(conn NetNS, t *nftables.Table) SetsInterface {
 	return &nfSets{
-		conn:  conn,
-		table: t,
-		sets:  make(map[string]*nftables.Set),
+		conn:      conn,
+		table:     t,
+		sets:      make(map[string]*nftables.Set),
+		autoMerge: make(map[string]bool),
 	}
 }
 
@@ -246,6 +498,7 @@ func MakeElement(input *ElementValue) ([]nftables.SetElement, error) {
 	case input.Action != nil:
 		p.VerdictData = input.Action.verdict
 	}
+	p.Comment = input.Comment
 
 	return elements, nil
 }
@@ -1,9 +1,17 @@
 package nftableslib
 
 import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/google/nftables/userdata"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nltest"
+	"golang.org/x/sys/unix"
 )
 
 func TestGenSetKeyType(t *testing.T) {
@@ -71,3 +79,336 @@ func TestGenSetKeyType(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateSetConstantDynamic(t *testing.T) {
+	nfs := &nfSets{
+		table: &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4},
+		sets:  make(map[string]*nftables.Set),
+	}
+	attrs := &SetAttributes{
+		Name:     "set-1",
+		Constant: true,
+		Dynamic:  true,
+		KeyType:  nftables.TypeIPAddr,
+	}
+	if _, err := nfs.CreateSet(attrs, nil); err == nil {
+		t.Errorf("CreateSet succeeded for a set with both constant and dynamic flags but supposed to fail")
+	}
+}
+
+// setOpsConn records operations on set elements
+type setOpsConn struct {
+	NetNS
+	ops []string
+}
+
+func (s *setOpsConn) GetSetByName(_ *nftables.Table, name string) (*nftables.Set, error) {
+	return &nftables.Set{Name: name}, nil
+}
+
+func (s *setOpsConn) FlushSet(set *nftables.Set) {
+	s.ops = append(s.ops, "flush "+set.Name)
+}
+
+func (s *setOpsConn) SetAddElements(set *nftables.Set, elements []nftables.SetElement) error {
+	s.ops = append(s.ops, fmt.Sprintf("add %d elements to %s", len(elements), set.Name))
+	return nil
+}
+
+func (s *setOpsConn) Flush() error {
+	s.ops = append(s.ops, "commit")
+	return nil
+}
+
+func TestReplaceElements(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	conn := &setOpsConn{}
+	si := newSets(conn, tbl)
+	si.(*nfSets).sets["blocklist"] = &nftables.Set{Table: tbl, Name: "blocklist", KeyType: nftables.TypeIPAddr}
+	elements := []nftables.SetElement{
+		{Key: []byte{192, 0, 2, 1}},
+		{Key: []byte{192, 0, 2, 2}},
+	}
+	if err := si.Sets().ReplaceElements("blocklist", elements); err != nil {
+		t.Fatalf("ReplaceElements failed with error: %+v", err)
+	}
+	// Flush of the set and addition of new elements must be committed in a single batch
+	want := []string{"flush blocklist", "add 2 elements to blocklist", "commit"}
+	if !reflect.DeepEqual(conn.ops, want) {
+		t.Errorf("ReplaceElements performed %v want: %v", conn.ops, want)
+	}
+	if err := si.Sets().ReplaceElements("missing", elements); err == nil {
+		t.Errorf("ReplaceElements of missing set supposed to fail but succeeded")
+	}
+	// Invalid elements must not leave the set's flush queued on the connection
+	if err := si.Sets().ReplaceElements("blocklist", []nftables.SetElement{{Key: []byte{192, 0, 2, 3}}, {}}); err == nil {
+		t.Errorf("ReplaceElements with empty key supposed to fail but succeeded")
+	}
+	if !reflect.DeepEqual(conn.ops, want) {
+		t.Errorf("failed ReplaceElements performed %v", conn.ops[len(want):])
+	}
+}
+
+// mergeConn keeps elements of a single set
+type mergeConn struct {
+	setOpsConn
+	elements []nftables.SetElement
+}
+
+func (m *mergeConn) AddSet(_ *nftables.Set, elements []nftables.SetElement) error {
+	m.elements = elements
+	return nil
+}
+
+func (m *mergeConn) GetSetElements(_ *nftables.Set) ([]nftables.SetElement, error) {
+	return m.elements, nil
+}
+
+func (m *mergeConn) SetAddElements(set *nftables.Set, elements []nftables.SetElement) error {
+	m.elements = append(m.elements, elements...)
+	return m.setOpsConn.SetAddElements(set, elements)
+}
+
+func (m *mergeConn) FlushSet(set *nftables.Set) {
+	m.elements = nil
+	m.setOpsConn.FlushSet(set)
+}
+
+func TestSetAutoMerge(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	conn := &mergeConn{}
+	si := newSets(conn, tbl)
+	attrs := &SetAttributes{
+		Name:      "blocklist",
+		Interval:  true,
+		AutoMerge: true,
+		KeyType:   nftables.TypeIPAddr,
+	}
+	elements := append(buildElementRanges([]*IPAddr{setIPAddr(t, "10.0.0.0/24")}),
+		buildElementRanges([]*IPAddr{setIPAddr(t, "10.0.0.0/25")})...)
+	if _, err := si.Sets().CreateSet(attrs, elements); err != nil {
+		t.Fatalf("CreateSet failed with error: %+v", err)
+	}
+	if len(conn.elements) != 2 {
+		t.Fatalf("CreateSet programmed %d elements, contained range supposed to be merged", len(conn.elements))
+	}
+	conn.ops = nil
+	if err := si.Sets().SetAddElements("blocklist", buildElementRanges([]*IPAddr{setIPAddr(t, "10.0.1.0/24")})); err != nil {
+		t.Fatalf("SetAddElements failed with error: %+v", err)
+	}
+	want := []nftables.SetElement{
+		{Key: []byte{10, 0, 0, 0}},
+		{Key: []byte{10, 0, 2, 0}, IntervalEnd: true},
+	}
+	if !reflect.DeepEqual(conn.elements, want) {
+		t.Errorf("SetAddElements resulted in elements %+v want: %+v", conn.elements, want)
+	}
+	if wantOps := []string{"flush blocklist", "add 2 elements to blocklist", "commit"}; !reflect.DeepEqual(conn.ops, wantOps) {
+		t.Errorf("SetAddElements performed %v want: %v", conn.ops, wantOps)
+	}
+	attrs = &SetAttributes{Name: "map", Interval: true, IsMap: true, AutoMerge: true, KeyType: nftables.TypeIPAddr}
+	if _, err := si.Sets().CreateSet(attrs, nil); err == nil {
+		t.Errorf("CreateSet of a map with auto-merge supposed to fail but succeeded")
+	}
+}
+
+// gcConn returns preconfigured sets, chains and rules and records deleted sets
+type gcConn struct {
+	setOpsConn
+	sets   []*nftables.Set
+	chains []*nftables.Chain
+	rules  []*nftables.Rule
+}
+
+func (g *gcConn) GetSets(_ *nftables.Table) ([]*nftables.Set, error) {
+	return g.sets, nil
+}
+
+func (g *gcConn) ListChains() ([]*nftables.Chain, error) {
+	return g.chains, nil
+}
+
+func (g *gcConn) GetRule(_ *nftables.Table, _ *nftables.Chain) ([]*nftables.Rule, error) {
+	return g.rules, nil
+}
+
+func (g *gcConn) DelSet(set *nftables.Set) {
+	g.ops = append(g.ops, "delete "+set.Name)
+}
+
+func TestSetsGC(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	conn := &gcConn{
+		sets: []*nftables.Set{
+			{Name: "__set0", Anonymous: true},
+			{Name: "a1b2c3d4e5f6", Comment: generatedSetComment},
+			{Name: "0123456789ab", Comment: generatedSetComment},
+			{Name: "deadbeef0001"},
+		},
+		chains: []*nftables.Chain{{Name: "input", Table: tbl}},
+		rules: []*nftables.Rule{
+			{Exprs: []expr.Any{&expr.Lookup{SourceRegister: 1, SetName: "0123456789ab"}}},
+		},
+	}
+	// Generated sets are recognized by their comment, also when generated by an earlier process
+	si := newSets(conn, tbl)
+	deleted, err := si.Sets().GC()
+	if err != nil {
+		t.Fatalf("GC failed with error: %+v", err)
+	}
+	// Named set of the caller is kept even though its name looks like a generated one
+	if want := []string{"__set0", "a1b2c3d4e5f6"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("GC deleted %v want: %v", deleted, want)
+	}
+	if want := []string{"delete __set0", "delete a1b2c3d4e5f6", "commit"}; !reflect.DeepEqual(conn.ops, want) {
+		t.Errorf("GC performed %v want: %v", conn.ops, want)
+	}
+}
+
+func TestSetComments(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	set := func(name string, udata []byte) netlink.Message {
+		attrs := []netlink.Attribute{
+			{Type: unix.NFTA_SET_TABLE, Data: []byte(tbl.Name + "\x00")},
+			{Type: unix.NFTA_SET_NAME, Data: []byte(name + "\x00")},
+		}
+		if udata != nil {
+			attrs = append(attrs, netlink.Attribute{Type: unix.NFTA_SET_USERDATA, Data: udata})
+		}
+		return netlink.Message{
+			Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | unix.NFT_MSG_NEWSET)},
+			Data:   append([]byte{byte(tbl.Family), unix.NFNETLINK_V0, 0, 0}, nltest.MustMarshalAttributes(attrs)...),
+		}
+	}
+	conn, err := nftables.New(nftables.WithTestDial(func(req []netlink.Message) ([]netlink.Message, error) {
+		if len(req) != 1 || req[0].Header.Type != netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8|unix.NFT_MSG_GETSET) {
+			return nil, fmt.Errorf("unexpected request %+v", req)
+		}
+		return []netlink.Message{
+			set("generated", userdata.AppendString(nil, userdata.NFTNL_UDATA_SET_COMMENT, generatedSetComment)),
+			set("merged", userdata.AppendUint32(nil, userdata.NFTNL_UDATA_SET_MERGE_ELEMENTS, 1)),
+			set("plain", nil),
+		}, nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connection with error: %+v", err)
+	}
+	comments, err := setComments(conn, tbl, nil)
+	if err != nil {
+		t.Fatalf("setComments failed with error: %+v", err)
+	}
+	want := map[string]string{"generated": generatedSetComment, "merged": "", "plain": ""}
+	if !reflect.DeepEqual(comments, want) {
+		t.Errorf("expected comments %v, got %v", want, comments)
+	}
+}
+
+func TestCreateSetSize(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	var size uint32
+	conn, err := nftables.New(nftables.WithTestDial(func(req []netlink.Message) ([]netlink.Message, error) {
+		for _, msg := range req {
+			if msg.Header.Type != netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8|unix.NFT_MSG_NEWSET) {
+				continue
+			}
+			ad, err := netlink.NewAttributeDecoder(msg.Data[4:])
+			if err != nil {
+				return nil, err
+			}
+			for ad.Next() {
+				if ad.Type() != unix.NFTA_SET_DESC {
+					continue
+				}
+				ad.Nested(func(nad *netlink.AttributeDecoder) error {
+					for nad.Next() {
+						if nad.Type() == unix.NFTA_SET_DESC_SIZE {
+							size = binary.BigEndian.Uint32(nad.Bytes())
+						}
+					}
+					return nil
+				})
+			}
+		}
+		return req, nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connection with error: %+v", err)
+	}
+	nfs := newSets(conn, tbl).(*nfSets)
+	attrs := &SetAttributes{
+		Name:    "blocklist",
+		Size:    65536,
+		KeyType: nftables.TypeIPAddr,
+	}
+	if _, err := nfs.CreateSet(attrs, nil); err != nil {
+		t.Fatalf("CreateSet failed with error: %+v", err)
+	}
+	if size != attrs.Size {
+		t.Errorf("expected set size %d to be sent, got %d", attrs.Size, size)
+	}
+}
+
+func TestElementComments(t *testing.T) {
+	elements, err := MakeElement(&ElementValue{Addr: "192.0.2.1", Comment: "blocked: brute-force"})
+	if err != nil {
+		t.Fatalf("MakeElement failed with error: %+v", err)
+	}
+	if elements[0].Comment != "blocked: brute-force" {
+		t.Errorf("element %+v does not carry the comment", elements[0])
+	}
+	// Merged interval carries the comment of the element which opened it
+	ranges := append(buildElementRanges([]*IPAddr{setIPAddr(t, "10.0.0.0/24")}),
+		buildElementRanges([]*IPAddr{setIPAddr(t, "10.0.0.0/25")})...)
+	ranges[0].Comment = "first"
+	ranges[2].Comment = "second"
+	merged, err := mergeIntervals(ranges)
+	if err != nil {
+		t.Fatalf("mergeIntervals failed with error: %+v", err)
+	}
+	if len(merged) != 2 || merged[0].Comment != "first" {
+		t.Errorf("merged elements %+v do not carry the comment of the first interval", merged)
+	}
+	// Comments are stored with elements and returned by GetSetElements
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	newSetElem := netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | unix.NFT_MSG_NEWSETELEM)
+	var stored []netlink.Message
+	conn, err := nftables.New(nftables.WithTestDial(func(req []netlink.Message) ([]netlink.Message, error) {
+		if len(req) == 0 {
+			// Acknowledgement of the request
+			return []netlink.Message{{Header: netlink.Header{Type: netlink.Error}, Data: make([]byte, 4)}}, nil
+		}
+		switch req[0].Header.Type {
+		case netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | unix.NFT_MSG_GETSET):
+			return []netlink.Message{{
+				Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | unix.NFT_MSG_NEWSET)},
+				Data: append([]byte{byte(tbl.Family), unix.NFNETLINK_V0, 0, 0}, nltest.MustMarshalAttributes([]netlink.Attribute{
+					{Type: unix.NFTA_SET_TABLE, Data: []byte(tbl.Name + "\x00")},
+					{Type: unix.NFTA_SET_NAME, Data: []byte("blocklist\x00")},
+				})...),
+			}}, nil
+		case netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | unix.NFT_MSG_GETSETELEM):
+			return stored, nil
+		}
+		for _, msg := range req {
+			if msg.Header.Type == newSetElem {
+				stored = append(stored, netlink.Message{Header: netlink.Header{Type: newSetElem}, Data: msg.Data})
+			}
+		}
+		return req, nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connection with error: %+v", err)
+	}
+	nfs := newSets(conn, tbl).(*nfSets)
+	nfs.sets["blocklist"] = &nftables.Set{Table: tbl, Name: "blocklist", KeyType: nftables.TypeIPAddr}
+	if err := nfs.SetAddElements("blocklist", elements[:1]); err != nil {
+		t.Fatalf("SetAddElements failed with error: %+v", err)
+	}
+	got, err := nfs.GetSetElements("blocklist")
+	if err != nil {
+		t.Fatalf("GetSetElements failed with error: %+v", err)
+	}
+	if len(got) != 1 || got[0].Comment != "blocked: brute-force" {
+		t.Errorf("GetSetElements returned elements %+v without the comment", got)
+	}
+}
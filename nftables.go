@@ -13,6 +13,7 @@ import (
 // TablesInterface defines a top level interface
 type TablesInterface interface {
 	Tables() TableFuncs
+	Capabilities() (Capabilities, error)
 }
 
 // TableFuncs defines second level interface operating with nf tables
@@ -20,18 +21,27 @@ type TableFuncs interface {
 	Table(name string, familyType nftables.TableFamily) (ChainsInterface, error)
 	TableChains(name string, familyType nftables.TableFamily) (ChainsInterface, error)
 	TableSets(name string, familyType nftables.TableFamily) (SetsInterface, error)
+	TableObjects(name string, familyType nftables.TableFamily) (ObjectsInterface, error)
 	Create(name string, familyType nftables.TableFamily) error
+	CreateInetFilter(name string) (ChainsInterface, error)
 	Delete(name string, familyType nftables.TableFamily) error
 	CreateImm(name string, familyType nftables.TableFamily) error
 	DeleteImm(name string, familyType nftables.TableFamily) error
+	Flush(name string, familyType nftables.TableFamily) error
 	Exist(name string, familyType nftables.TableFamily) bool
+	GetAllRules(name string, familyType nftables.TableFamily) (map[string][]*Rule, error)
 	Get(familyType nftables.TableFamily) ([]string, error)
+	Handle(name string, familyType nftables.TableFamily) (uint64, error)
+	GetByHandle(handle uint64) (string, nftables.TableFamily, error)
 	Sync(familyType nftables.TableFamily) error
 	Dump() ([]byte, error)
+	ApplyRuleset(desired *Ruleset) (*Diff, error)
+	Provision(def *TableDef) (*ProvisionResult, error)
 }
 
 type nfTables struct {
 	conn NetNS
+	opts Options
 	sync.Mutex
 	// Two dimensional map, 1st key is table family, 2nd key is table name
 	tables map[nftables.TableFamily]map[string]*nfTable
@@ -42,6 +52,7 @@ type nfTable struct {
 	table *nftables.Table
 	ChainsInterface
 	SetsInterface
+	ObjectsInterface
 }
 
 // Tables returns methods available for managing nf tables
@@ -88,6 +99,18 @@ func (nft *nfTables) TableSets(name string, familyType nftables.TableFamily) (Se
 	return nil, fmt.Errorf("table %s of type %v does not exist", name, familyType)
 }
 
+// TableObjects returns Objects Interface for a specific table
+func (nft *nfTables) TableObjects(name string, familyType nftables.TableFamily) (ObjectsInterface, error) {
+	nft.Lock()
+	defer nft.Unlock()
+	// Check if nf table with the same family type and name  already exists
+	if t, ok := nft.tables[familyType][name]; ok {
+		return t.ObjectsInterface, nil
+	}
+
+	return nil, fmt.Errorf("table %s of type %v does not exist", name, familyType)
+}
+
 // Create appends a table into NF tables list
 func (nft *nfTables) Create(name string, familyType nftables.TableFamily) error {
 	nft.Lock()
@@ -97,13 +120,25 @@ func (nft *nfTables) Create(name string, familyType nftables.TableFamily) error
 	return nil
 }
 
+// CreateInetFilter appends a table of inet family into NF tables list and returns its Chains Interface,
+// base chains of the inet table see both ipv4 and ipv6 traffic. Standard input, forward and output chains
+// can be added with CreateStandardFilterChains.
+func (nft *nfTables) CreateInetFilter(name string) (ChainsInterface, error) {
+	if err := nft.Create(name, nftables.TableFamilyINet); err != nil {
+		return nil, err
+	}
+
+	return nft.Table(name, nftables.TableFamilyINet)
+}
+
 func (nft *nfTables) create(name string, familyType nftables.TableFamily) *nfTable {
 	// Check if tableFamily already allocated
 	if _, ok := nft.tables[familyType]; ok {
 		// Check if table  already exists
 		if _, ok := nft.tables[familyType][name]; ok {
-			// Check if table has ChainsInterface and SetsInterface instantiated
-			if nft.tables[familyType][name].ChainsInterface != nil && nft.tables[familyType][name].SetsInterface != nil {
+			// Check if table has ChainsInterface, SetsInterface and ObjectsInterface instantiated
+			if nft.tables[familyType][name].ChainsInterface != nil && nft.tables[familyType][name].SetsInterface != nil &&
+				nft.tables[familyType][name].ObjectsInterface != nil {
 				// Table already exists with proper interfaces, no need to do anything
 				return nft.tables[familyType][name]
 			}
@@ -117,10 +152,14 @@ func (nft *nfTables) create(name string, familyType nftables.TableFamily) *nfTab
 		Family: familyType,
 		Name:   name,
 	}
+	chains := newChains(nft.conn, t, nft.opts)
+	sets := newSets(nft.conn, t)
+	chains.(*nfChains).sets = sets.(*nfSets)
 	nft.tables[familyType][name] = &nfTable{
-		table:           t,
-		ChainsInterface: newChains(nft.conn, t),
-		SetsInterface:   newSets(nft.conn, t),
+		table:            t,
+		ChainsInterface:  chains,
+		SetsInterface:    sets,
+		ObjectsInterface: newObjects(nft.conn, t, nft.opts),
 	}
 
 	return nft.tables[familyType][name]
@@ -158,7 +197,7 @@ func (nft *nfTables) Delete(name string, familyType nftables.TableFamily) error
 		// Removing old table, at this point, this table should be removed from the kernel as well.
 		delete(nft.tables[familyType], name)
 	}
-	if nft.Tables().Exist(name, familyType) {
+	if nft.existOnHost(name, familyType) {
 		nft.conn.DelTable(&nftables.Table{
 			Name:   name,
 			Family: familyType,
@@ -172,13 +211,61 @@ func (nft *nfTables) Delete(name string, familyType nftables.TableFamily) error
 	return nil
 }
 
+// Flush removes all rules and chains of a table keeping the table with its sets and objects,
+// example: nft flush table ip filter followed by deletion of the table's chains. Rules and chains
+// are removed by a single batch, a chain referenced by a verdict map cannot be removed and fails the batch.
+func (nft *nfTables) Flush(name string, familyType nftables.TableFamily) error {
+	nft.Lock()
+	defer nft.Unlock()
+	nt, ok := nft.tables[familyType][name]
+	if !ok && !nft.existOnHost(name, familyType) {
+		return fmt.Errorf("table %s of type %v does not exist", name, familyType)
+	}
+	t := &nftables.Table{Name: name, Family: familyType}
+	if ok {
+		t = nt.table
+	}
+	chains, err := nft.conn.ListChains()
+	if err != nil {
+		return err
+	}
+	// Rules are flushed first, jumps to regular chains are gone by the time chains are deleted
+	nft.conn.FlushTable(t)
+	for _, c := range chains {
+		if c.Table.Name == name && c.Table.Family == familyType {
+			nft.conn.DelChain(c)
+		}
+	}
+	if err := nft.conn.Flush(); err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	// Chains are removed from the existing store, ChainsInterface returned by Table() remains valid
+	if nfc, ok := nt.ChainsInterface.(*nfChains); ok {
+		nfc.Lock()
+		nfc.chains = make(map[string]*nfChain)
+		nfc.Unlock()
+	}
+
+	return nil
+}
+
 // Exist checks is the table already defined
 func (nft *nfTables) Exist(name string, familyType nftables.TableFamily) bool {
 	// Check if Table exists in the store
-	if _, ok := nft.tables[familyType][name]; ok {
+	nft.Lock()
+	_, ok := nft.tables[familyType][name]
+	nft.Unlock()
+	if ok {
 		return true
 	}
 	// It is not in the store, let's double check if it exists on the host
+	return nft.existOnHost(name, familyType)
+}
+
+func (nft *nfTables) existOnHost(name string, familyType nftables.TableFamily) bool {
 	tables, err := nft.get(familyType)
 	if err != nil {
 		return false
@@ -192,6 +279,20 @@ func (nft *nfTables) Exist(name string, familyType nftables.TableFamily) bool {
 	return false
 }
 
+// GetAllRules returns rules programmed on the host for every chain of the table keyed by the chain name,
+// rules created by the library are returned as they were specified, rules programmed by other means carry
+// only UserData.
+func (nft *nfTables) GetAllRules(name string, familyType nftables.TableFamily) (map[string][]*Rule, error) {
+	nft.Lock()
+	t, ok := nft.tables[familyType][name]
+	nft.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("table %s of type %v does not exist", name, familyType)
+	}
+
+	return t.ChainsInterface.(*nfChains).getAllRules()
+}
+
 // Get returns all tables defined for a specific TableFamily
 func (nft *nfTables) Get(familyType nftables.TableFamily) ([]string, error) {
 	nft.Lock()
@@ -216,21 +317,58 @@ func (nft *nfTables) get(familyType nftables.TableFamily) ([]string, error) {
 	return tables, nil
 }
 
+// Handle returns the handle the kernel allocated to the table programmed on the host, the handle changes
+// when the table is deleted and created again, hence agents managing tables on the same host can detect
+// that the table was replaced by another agent.
+func (nft *nfTables) Handle(name string, familyType nftables.TableFamily) (uint64, error) {
+	handles, err := tableHandles(nft.conn)
+	if err != nil {
+		return 0, err
+	}
+	for _, th := range handles {
+		if th.name == name && th.family == familyType {
+			return th.handle, nil
+		}
+	}
+
+	return 0, fmt.Errorf("table %s of type %v is not programmed on the host", name, familyType)
+}
+
+// GetByHandle returns the name and the family of the table programmed on the host with the handle,
+// handles are unique across families of the network namespace.
+func (nft *nfTables) GetByHandle(handle uint64) (string, nftables.TableFamily, error) {
+	handles, err := tableHandles(nft.conn)
+	if err != nil {
+		return "", nftables.TableFamilyUnspecified, err
+	}
+	for _, th := range handles {
+		if th.handle == handle {
+			return th.name, th.family, nil
+		}
+	}
+
+	return "", nftables.TableFamilyUnspecified, fmt.Errorf("table with handle %d is not programmed on the host", handle)
+}
+
 // Sync synchronizes tables defined on the host with tables store, newly discovered
 // tables will be added, stale will be removed fomr the store.
 func (nft *nfTables) Sync(familyType nftables.TableFamily) error {
-	nft.Lock()
 	nftables, err := nft.conn.ListTables()
 	if err != nil {
 		return err
 	}
-	nft.Unlock()
 
 	// Getting  list of tables defined on the host
 	for _, t := range nftables {
 		if t.Family == familyType {
-			if _, ok := nft.tables[familyType][t.Name]; !ok {
-				nt := nft.create(t.Name, t.Family)
+			nft.Lock()
+			_, ok := nft.tables[familyType][t.Name]
+			var nt *nfTable
+			if !ok {
+				nt = nft.create(t.Name, t.Family)
+			}
+			nft.Unlock()
+			if !ok {
 				// Sync synchronizes all chains discovered in the table
 				if err := nt.Chains().Sync(); err != nil {
 					return err
@@ -10,6 +10,7 @@ type NetNS interface {
 	FlushRuleset()
 	AddTable(*nftables.Table) *nftables.Table
 	DelTable(*nftables.Table)
+	FlushTable(*nftables.Table)
 	ListTables() ([]*nftables.Table, error)
 	AddChain(*nftables.Chain) *nftables.Chain
 	DelChain(*nftables.Chain)
@@ -26,4 +27,8 @@ type NetNS interface {
 	GetSetElements(*nftables.Set) ([]nftables.SetElement, error)
 	SetAddElements(*nftables.Set, []nftables.SetElement) error
 	SetDeleteElements(*nftables.Set, []nftables.SetElement) error
+	FlushSet(*nftables.Set)
+	AddObj(nftables.Obj) nftables.Obj
+	DeleteObject(nftables.Obj)
+	GetObjects(*nftables.Table) ([]nftables.Obj, error)
 }
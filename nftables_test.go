@@ -1,9 +1,14 @@
 package nftableslib
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nltest"
+	"golang.org/x/sys/unix"
 )
 
 func TestCreateTable(t *testing.T) {
@@ -73,3 +78,97 @@ func BenchmarkCreateTable(b *testing.B) {
 		}
 	}
 }
+
+func TestFlushTable(t *testing.T) {
+	tbl := &nftables.Table{Name: "filter", Family: nftables.TableFamilyIPv4}
+	other := &nftables.Table{Name: "nat", Family: nftables.TableFamilyIPv4}
+	conn := &applyConn{
+		listConn: listConn{
+			chains: []*nftables.Chain{
+				{Name: "input", Table: tbl},
+				{Name: "regular", Table: tbl},
+				{Name: "postrouting", Table: other},
+			},
+		},
+		tables: []*nftables.Table{tbl, other},
+	}
+	nft := InitNFTables(conn)
+	if err := nft.Tables().Create("filter", nftables.TableFamilyIPv4); err != nil {
+		t.Fatalf("Create failed with error: %+v", err)
+	}
+	ci, err := nft.Tables().Table("filter", nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatalf("Table failed with error: %+v", err)
+	}
+	if err := ci.Chains().Create("regular", nil); err != nil {
+		t.Fatalf("Create chain failed with error: %+v", err)
+	}
+	flushes := conn.flushes
+	if err := nft.Tables().Flush("filter", nftables.TableFamilyIPv4); err != nil {
+		t.Fatalf("Flush failed with error: %+v", err)
+	}
+	if conn.flushes != flushes+1 {
+		t.Errorf("expected a single batch, got %d", conn.flushes-flushes)
+	}
+	if len(conn.flushed) != 1 || conn.flushed[0] != "filter" {
+		t.Errorf("expected rules of table filter to be flushed, got %+v", conn.flushed)
+	}
+	if len(conn.delChains) != 2 || conn.delChains[0] != "input" || conn.delChains[1] != "regular" {
+		t.Errorf("expected chains input and regular to be deleted, got %+v", conn.delChains)
+	}
+	if _, err := ci.Chains().Chain("regular"); err == nil {
+		t.Errorf("chain regular is still in the store after Flush")
+	}
+	if !nft.Tables().Exist("filter", nftables.TableFamilyIPv4) {
+		t.Errorf("table filter was removed by Flush")
+	}
+	if err := nft.Tables().Flush("raw", nftables.TableFamilyIPv4); err == nil {
+		t.Errorf("Flush of non existing table supposed to fail but succeeded")
+	}
+}
+
+func TestTableHandles(t *testing.T) {
+	table := func(family nftables.TableFamily, name string, handle uint64) netlink.Message {
+		return netlink.Message{
+			Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | unix.NFT_MSG_NEWTABLE)},
+			Data: append([]byte{byte(family), unix.NFNETLINK_V0, 0, 0}, nltest.MustMarshalAttributes([]netlink.Attribute{
+				{Type: unix.NFTA_TABLE_NAME, Data: []byte(name + "\x00")},
+				{Type: unix.NFTA_TABLE_FLAGS, Data: binaryutil.BigEndian.PutUint32(0)},
+				{Type: nftaTableHandle, Data: binaryutil.BigEndian.PutUint64(handle)},
+			})...),
+		}
+	}
+	conn, err := nftables.New(nftables.WithTestDial(func(req []netlink.Message) ([]netlink.Message, error) {
+		if len(req) != 1 || req[0].Header.Type != netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8|unix.NFT_MSG_GETTABLE) {
+			return nil, fmt.Errorf("unexpected request %+v", req)
+		}
+		return []netlink.Message{
+			table(nftables.TableFamilyIPv4, "filter", 3),
+			table(nftables.TableFamilyIPv6, "filter", 7),
+		}, nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create connection with error: %+v", err)
+	}
+	ti := InitNFTables(conn)
+	handle, err := ti.Tables().Handle("filter", nftables.TableFamilyIPv6)
+	if err != nil {
+		t.Fatalf("Handle failed with error: %+v", err)
+	}
+	if handle != 7 {
+		t.Errorf("expected handle 7, got %d", handle)
+	}
+	if _, err := ti.Tables().Handle("nat", nftables.TableFamilyIPv4); err == nil {
+		t.Errorf("Handle of missing table supposed to fail but succeeded")
+	}
+	name, family, err := ti.Tables().GetByHandle(3)
+	if err != nil {
+		t.Fatalf("GetByHandle failed with error: %+v", err)
+	}
+	if name != "filter" || family != nftables.TableFamilyIPv4 {
+		t.Errorf("expected table filter of type %v, got table %s of type %v", nftables.TableFamilyIPv4, name, family)
+	}
+	if _, _, err := ti.Tables().GetByHandle(5); err == nil {
+		t.Errorf("GetByHandle of missing handle supposed to fail but succeeded")
+	}
+}
@@ -590,11 +590,22 @@ func NFTablesSet(ti nftableslib.TablesInterface, version nftables.TableFamily,
 	} else {
 		tn = "t" + uuid.New().String()[:8]
 	}
-	if err := ti.Tables().CreateImm(tn, version); err != nil {
-		return nil, fmt.Errorf("failed to create table with error: %+v", err)
+	def := &nftableslib.TableDef{
+		Name:   tn,
+		Family: version,
 	}
-	if err := ProgramTestRules(ti, tn, version, nfrules); err != nil {
-		return nil, err
+	for _, chain := range nfrules {
+		cs := &nftableslib.ChainSpec{
+			Name:       chain.Name,
+			Attributes: chain.Attr,
+		}
+		for i := range chain.Rules {
+			cs.Rules = append(cs.Rules, &chain.Rules[i])
+		}
+		def.Chains = append(def.Chains, cs)
+	}
+	if _, err := ti.Tables().Provision(def); err != nil {
+		return nil, fmt.Errorf("failed to provision table with error: %+v", err)
 	}
 	if debug {
 		b, _ := ti.Tables().Dump()